@@ -0,0 +1,330 @@
+package library
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "time"
+  "regexp"
+  "strings"
+  "io/fs"
+  "path/filepath"
+  "archive/tar"
+  "compress/gzip"
+  "encoding/json"
+)
+
+// Portable library archives (tar.gz): records as JSON, plus artwork; media files aren't included.
+// Import recreates records in an empty library; paths derive from the importing library's media_path.
+
+const ArchiveFormatVersion int64 = 1
+
+type ArchiveManifest struct {
+  Format         int64  `json:"format"`
+  MigrationLevel uint32 `json:"migration_level"` // importing library must be at the same level
+  TimeCreated    int64  `json:"time_created"`
+  MediaPath      string `json:"media_path"`      // of exporting library; input file paths within it are remapped on import
+  Categories     int    `json:"categories"`
+  Metadata       int    `json:"metadata"`
+  InputFiles     int    `json:"input_files"`
+}
+
+type ArchiveImportOptions struct {
+  SourcePathFrom string // input file source locations starting with this prefix...
+  SourcePathTo   string // ...are moved to this prefix (scanner source directory moved, for example)
+}
+
+var ErrArchiveInvalid = fmt.Errorf("invalid library archive")
+
+// record ids (uuid, as created by dbRecordCreate); they name staged artwork, so nothing else is accepted
+var archiveIdValid *regexp.Regexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// host specific, or secret; never exported
+var archiveExcludedProperties = map[string]bool {
+  "backup_path" : true,
+}
+
+// ============================================================================
+// Public Interface
+
+// Write the library (categories, metadata, input files, properties, artwork) as an archive.
+func (lib *Library) ArchiveExport(writer io.Writer) (*ArchiveManifest, error) {
+  err := lib.Ready()
+  if err != nil { return nil, err }
+
+  categories, err := lib.CategoryList()
+  if err != nil { return nil, err }
+  records, err := lib.dbRecordWhere(&Metadata{}, `(id <> '') ORDER BY id ASC`)
+  if err != nil { return nil, ErrQueryFailed }
+  metadata := make([]Metadata, len(records))
+  for index, record := range records { metadata[index] = *(record.(*Metadata)) }
+  inputs, err := lib.InputFileList()
+  if err != nil { return nil, err }
+  properties, err := lib.PropertyList()
+  if err != nil { return nil, err }
+  for key := range properties {
+    if archiveExcludedProperties[key] || archivePropertyIsSecret(key) { delete(properties, key) }
+  }
+
+  manifest := ArchiveManifest {
+    Format:ArchiveFormatVersion, MigrationLevel:lib.MigrationLevelGet(), TimeCreated:time.Now().Unix(), MediaPath:lib.mediaRoot(),
+    Categories:len(categories), Metadata:len(metadata), InputFiles:len(inputs),
+  }
+
+  gzip_writer := gzip.NewWriter(writer)
+  tar_writer  := tar.NewWriter(gzip_writer)
+  for _, entry := range []struct { name string ; value any } {
+    { "manifest.json",    manifest   },
+    { "properties.json",  properties },
+    { "categories.json",  categories },
+    { "metadata.json",    metadata   },
+    { "input_files.json", inputs     },
+  } {
+    err = archiveWriteJson(tar_writer, entry.name, entry.value)
+    if err != nil { return nil, err }
+  }
+
+  // artwork; stored by record id, as paths differ between libraries
+  for index := range categories {
+    err = archiveWritePath(tar_writer, categories[index].ArtworkPath(), "artwork/categories/" + categories[index].Id)
+    if err != nil { return nil, err }
+  }
+  for index := range metadata {
+    for _, path_type := range archiveArtworkPathTypes {
+      disk_path, err := metadata[index].DiskPath(path_type)
+      if err != nil { return nil, err }
+      err = archiveWritePath(tar_writer, disk_path, "artwork/metadata/" + metadata[index].Id + metadataPathSuffix(&metadata[index], path_type))
+      if err != nil { return nil, err }
+    }
+  }
+
+  err = tar_writer.Close()
+  if err != nil { return nil, err }
+  err = gzip_writer.Close()
+  if err != nil { return nil, err }
+  return &manifest, nil
+}
+
+// Recreate an exported library's records (and artwork, and directories) in this library, which must be empty.
+func (lib *Library) ArchiveImport(reader io.Reader, options ArchiveImportOptions) (*ArchiveManifest, error) {
+  err := lib.Ready()
+  if err != nil { return nil, err }
+  existing, err := lib.dbRecordWhere(&Metadata{}, `(id <> '') LIMIT 1`)
+  if err != nil { return nil, ErrQueryFailed }
+  categories_existing, err := lib.CategoryList()
+  if err != nil { return nil, err }
+  if (len(existing) > 0) || (len(categories_existing) > 0) { return nil, fmt.Errorf("cannot import into a library that already has categories or metadata") }
+
+  // stage archive contents; artwork is held on disk until records are in place
+  staging_path, err := os.MkdirTemp("", "starkiss-import-")
+  if err != nil { return nil, err }
+  defer os.RemoveAll(staging_path)
+
+  var manifest   *ArchiveManifest
+  var properties map[string]string
+  var categories []Category
+  var metadata   []Metadata
+  var inputs     []InputFile
+  gzip_reader, err := gzip.NewReader(reader)
+  if err != nil { return nil, fmt.Errorf("%s: %s", ErrArchiveInvalid.Error(), err.Error()) }
+  tar_reader := tar.NewReader(gzip_reader)
+  for {
+    header, err := tar_reader.Next()
+    if err == io.EOF { break }
+    if err != nil { return nil, fmt.Errorf("%s: %s", ErrArchiveInvalid.Error(), err.Error()) }
+    name := filepath.ToSlash(filepath.Clean(header.Name))
+    if filepath.IsAbs(name) || (name == "..") || strings.HasPrefix(name, "../") { return nil, fmt.Errorf("%s: unsafe path \"%s\"", ErrArchiveInvalid.Error(), header.Name) }
+
+    switch name {
+      case "manifest.json"    : err = json.NewDecoder(tar_reader).Decode(&manifest)
+      case "properties.json"  : err = json.NewDecoder(tar_reader).Decode(&properties)
+      case "categories.json"  : err = json.NewDecoder(tar_reader).Decode(&categories)
+      case "metadata.json"    : err = json.NewDecoder(tar_reader).Decode(&metadata)
+      case "input_files.json" : err = json.NewDecoder(tar_reader).Decode(&inputs)
+      default:
+        if !strings.HasPrefix(name, "artwork/") { continue }
+        staged_path := filepath.Join(staging_path, filepath.FromSlash(name))
+        if !pathContains(staging_path, staged_path) { return nil, fmt.Errorf("%s: unsafe path \"%s\"", ErrArchiveInvalid.Error(), header.Name) }
+        err = archiveStage(tar_reader, header, staged_path)
+    }
+    if err != nil { return nil, fmt.Errorf("%s: %s: %s", ErrArchiveInvalid.Error(), name, err.Error()) }
+
+    // validate before reading further
+    if (name == "manifest.json") && (manifest != nil) {
+      if manifest.Format != ArchiveFormatVersion { return nil, fmt.Errorf("%s: format %d not supported", ErrArchiveInvalid.Error(), manifest.Format) }
+      if manifest.MigrationLevel != lib.MigrationLevelGet() {
+        return nil, fmt.Errorf("archive is from migration level %d, library is at level %d; migrate one to match", manifest.MigrationLevel, lib.MigrationLevelGet())
+      }
+    }
+  }
+  if manifest == nil { return nil, fmt.Errorf("%s: no manifest", ErrArchiveInvalid.Error()) }
+
+  // ids locate staged artwork (and disk paths, through parents); every parent must be imported too
+  parent_ids := map[string]bool { "":true }
+  for index := range categories {
+    if !archiveIdValid.MatchString(categories[index].Id) { return nil, fmt.Errorf("%s: invalid category id \"%s\"", ErrArchiveInvalid.Error(), categories[index].Id) }
+    parent_ids[categories[index].Id] = true
+  }
+  for index := range metadata {
+    if !archiveIdValid.MatchString(metadata[index].Id) { return nil, fmt.Errorf("%s: invalid metadata id \"%s\"", ErrArchiveInvalid.Error(), metadata[index].Id) }
+    parent_ids[metadata[index].Id] = true
+  }
+  for index := range metadata {
+    if !parent_ids[metadata[index].ParentId] { return nil, fmt.Errorf("%s: metadata \"%s\" has parent \"%s\", not in archive", ErrArchiveInvalid.Error(), metadata[index].Id, metadata[index].ParentId) }
+  }
+  for index := range inputs {
+    if !archiveIdValid.MatchString(inputs[index].Id) { return nil, fmt.Errorf("%s: invalid input file id \"%s\"", ErrArchiveInvalid.Error(), inputs[index].Id) }
+  }
+
+  // remap input file paths: within old media path, and per options
+  remap := func(path string) string {
+    if (manifest.MediaPath != "") && pathContains(manifest.MediaPath, path) {
+      relative, _ := filepath.Rel(manifest.MediaPath, path)
+      path = filepath.Join(lib.mediaRoot(), relative)
+    }
+    if (options.SourcePathFrom != "") && pathContains(options.SourcePathFrom, path) {
+      relative, _ := filepath.Rel(options.SourcePathFrom, path)
+      path = filepath.Join(options.SourcePathTo, relative)
+    }
+    return path
+  }
+  for index := range inputs {
+    inputs[index].SourceLocation = remap(inputs[index].SourceLocation)
+    if inputs[index].Sidecar.PosterPath != "" { inputs[index].Sidecar.PosterPath = remap(inputs[index].Sidecar.PosterPath) }
+  }
+
+  // records, together; volumes belong to the old host, so everything lands on the primary volume
+  err = lib.dbTransaction(func(tx *dbTx) error {
+    for key, value := range properties {
+      if archiveExcludedProperties[key] || archivePropertyIsSecret(key) { continue }
+      _, err := tx.Exec(`INSERT INTO properties (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = ?;`, key, value, value)
+      if err != nil { return ErrQueryFailed }
+    }
+    for index := range categories {
+      categories[index].VolumeId = VolumePrimaryId
+      categories[index].setLibrary(lib)
+      if !nameValidForDisk(categories[index].Name) || !categoryMediaTypeValid(categories[index].MediaType) { return fmt.Errorf("invalid category \"%s\"", categories[index].Name) }
+      err := tx.RecordCreate(&categories[index])
+      if err != nil { return fmt.Errorf("error creating category \"%s\": %s", categories[index].Name, err.Error()) }
+    }
+    for index := range metadata {
+      if !nameValidForDisk(metadata[index].NameSort) { return fmt.Errorf("invalid metadata \"%s\"", metadata[index].NameDisplay) }
+      metadata[index].setLibrary(lib)
+      err := tx.RecordCreate(&metadata[index])
+      if err != nil { return fmt.Errorf("error creating metadata \"%s\": %s", metadata[index].NameDisplay, err.Error()) }
+    }
+    for index := range inputs {
+      inputs[index].setLibrary(lib)
+      err := tx.RecordCreate(&inputs[index])
+      if err != nil { return fmt.Errorf("error creating input file \"%s\": %s", inputs[index].SourceLocation, err.Error()) }
+    }
+    return nil
+  })
+  if err != nil { return nil, err }
+
+  // directories, then artwork into place
+  for index := range categories {
+    err = os.MkdirAll(categories[index].DiskPath(), 0770)
+    if err != nil { return manifest, err }
+    err = archiveUnstage(filepath.Join(staging_path, "artwork", "categories", categories[index].Id), categories[index].ArtworkPath())
+    if err != nil { return manifest, err }
+  }
+  for index := range metadata {
+    md := &(metadata[index])
+    if (md.MediaType != MetadataMediaTypeFileVideo) && (md.MediaType != MetadataMediaTypeFileAudio) {
+      media_path, err := md.DiskPath(MetadataPathTypeMedia)
+      if err != nil { return manifest, err }
+      err = os.MkdirAll(media_path, 0770)
+      if err != nil { return manifest, err }
+    }
+  }
+  for index := range metadata {
+    md := &(metadata[index])
+    for _, path_type := range archiveArtworkPathTypes {
+      disk_path, err := md.DiskPath(path_type)
+      if err != nil { return manifest, err }
+      err = archiveUnstage(filepath.Join(staging_path, "artwork", "metadata", md.Id + metadataPathSuffix(md, path_type)), disk_path)
+      if err != nil { return manifest, err }
+    }
+  }
+
+  return manifest, nil
+}
+
+// ============================================================================
+// private utilities
+
+// artwork belonging to a Metadata; other files (media, trickplay, rendered posters) are left behind
+var archiveArtworkPathTypes = []MetadataPathType {
+  MetadataPathTypePosterLarge,
+  MetadataPathTypePosterSmall,
+  MetadataPathTypePosterMaster,
+  MetadataPathTypeArtwork,
+}
+
+func archivePropertyIsSecret(key string) bool {
+  if excluded_properties[key] { return true }
+  key = strings.ToLower(key)
+  for _, word := range []string { "key", "secret", "token", "password" } {
+    if strings.Contains(key, word) { return true }
+  }
+  return false
+}
+
+func archiveWriteJson(tar_writer *tar.Writer, name string, value any) error {
+  data, err := json.MarshalIndent(value, "", "  ")
+  if err != nil { return err }
+  err = tar_writer.WriteHeader(&tar.Header { Name:name, Mode:0660, Size:int64(len(data)), ModTime:time.Now() })
+  if err != nil { return err }
+  _, err = tar_writer.Write(data)
+  return err
+}
+
+// Add a file, or directory tree, to archive as name (nothing, if path doesn't exist).
+func archiveWritePath(tar_writer *tar.Writer, path string, name string) error {
+  if !pathExists(path) { return nil }
+  return filepath.WalkDir(path, func(walk_path string, entry fs.DirEntry, err error) error {
+    if err != nil { return err }
+    info, err := entry.Info()
+    if err != nil { return err }
+    if !info.Mode().IsRegular() && !info.IsDir() { return nil }
+    relative, _ := filepath.Rel(path, walk_path)
+    header, err := tar.FileInfoHeader(info, "")
+    if err != nil { return err }
+    header.Name = filepath.ToSlash(filepath.Join(name, relative))
+    if info.IsDir() { header.Name += "/" }
+    err = tar_writer.WriteHeader(header)
+    if err != nil { return err }
+    if info.IsDir() { return nil }
+
+    file, err := os.Open(walk_path)
+    if err != nil { return err }
+    defer file.Close()
+    _, err = io.Copy(tar_writer, file)
+    return err
+  })
+}
+
+// Write an archive entry to staging.
+func archiveStage(tar_reader *tar.Reader, header *tar.Header, path string) error {
+  switch header.Typeflag {
+    case tar.TypeDir : return os.MkdirAll(path, 0770)
+    case tar.TypeReg : break
+    default          : return nil
+  }
+  err := os.MkdirAll(filepath.Dir(path), 0770)
+  if err != nil { return err }
+  file, err := os.OpenFile(path, os.O_CREATE | os.O_WRONLY | os.O_TRUNC, 0660)
+  if err != nil { return err }
+  defer file.Close()
+  _, err = io.Copy(file, tar_reader)
+  return err
+}
+
+// Move staged artwork into place (nothing, if none was staged).
+func archiveUnstage(staged_path string, path string) error {
+  if !pathExists(staged_path) { return nil }
+  err := os.MkdirAll(filepath.Dir(path), 0770)
+  if err != nil { return err }
+  return pathMove(staged_path, path)
+}
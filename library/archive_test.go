@@ -0,0 +1,120 @@
+package library
+
+import (
+  "os"
+  "bytes"
+  "path/filepath"
+  "archive/tar"
+  "compress/gzip"
+  "testing"
+)
+
+func TestArchive(test *testing.T) {
+  test.Parallel()
+  libraries := []*Library {}
+  for _, name := range []string { "source", "destination" } {
+    lib, err := LibraryOpen(filepath.Join(test.TempDir(), name + ".database"))
+    if err != nil { test.Fatalf("TestArchive: Open failed: %s", err) }
+    defer lib.Shutdown()
+    err = lib.MigrateToLatest()
+    if err != nil { test.Fatalf("TestArchive: MigrateToLatest failed: %s", err) }
+    err = lib.MediaPathSet(filepath.Join(test.TempDir(), name + "-media"))
+    if err != nil { test.Fatalf("TestArchive: MediaPathSet failed: %s", err) }
+    libraries = append(libraries, lib)
+  }
+  source, destination := libraries[0], libraries[1]
+
+  // category, series with poster, episode; input file within media path
+  cat, err := source.CategoryCreate("Shows", CategoryMediaTypeSeries)
+  if err != nil { test.Fatalf("TestArchive: CategoryCreate failed: %s", err) }
+  series := Metadata { ParentId:cat.Id, MediaType:MetadataMediaTypeSeries, NameDisplay:"Series", Streams:[]FileStream {} }
+  err = source.MetadataCreate(&series)
+  if err != nil { test.Fatalf("TestArchive: MetadataCreate failed: %s", err) }
+  episode := Metadata { ParentId:series.Id, MediaType:MetadataMediaTypeFileVideo, NameDisplay:"Episode", Extension:".mp4", Streams:[]FileStream {} }
+  err = source.MetadataCreate(&episode)
+  if err != nil { test.Fatalf("TestArchive: MetadataCreate failed: %s", err) }
+  poster_path, _ := series.DiskPath(MetadataPathTypePosterLarge)
+  os.WriteFile(poster_path, []byte("poster"), 0660)
+  episode_path, _ := episode.DiskPath(MetadataPathTypeMedia)
+  os.WriteFile(episode_path, []byte("media"), 0660)
+  inp := InputFile { SourceLocation:filepath.Join(source.mediaPath, "incoming", "episode.mkv"), SourceStreams:[]FileStream {}, StreamMap:[]int64 {} }
+  err = source.InputFileCreate(&inp)
+  if err != nil { test.Fatalf("TestArchive: InputFileCreate failed: %s", err) }
+  err = source.PropertySet("server_name", "archived")
+  if err != nil { test.Fatalf("TestArchive: PropertySet failed: %s", err) }
+
+  archive := bytes.Buffer {}
+  manifest, err := source.ArchiveExport(&archive)
+  if err != nil { test.Fatalf("TestArchive: ArchiveExport failed: %s", err) }
+  if (manifest.Categories != 1) || (manifest.Metadata != 2) || (manifest.InputFiles != 1) { test.Fatalf("TestArchive: unexpected manifest: %v", manifest) }
+
+  // import, then records resolve under new media path
+  archive_bytes := archive.Bytes()
+  _, err = destination.ArchiveImport(bytes.NewReader(archive_bytes), ArchiveImportOptions {})
+  if err != nil { test.Fatalf("TestArchive: ArchiveImport failed: %s", err) }
+  imported_series, err := destination.MetadataRead(series.Id)
+  if err != nil { test.Fatalf("TestArchive: series not imported: %s", err) }
+  imported_poster, _ := imported_series.DiskPath(MetadataPathTypePosterLarge)
+  if !pathContains(destination.mediaPath, imported_poster) { test.Fatalf("TestArchive: series not under new media path: %s", imported_poster) }
+  if data, err := os.ReadFile(imported_poster); (err != nil) || (string(data) != "poster") { test.Fatalf("TestArchive: poster not imported: %s", err) }
+  imported_inp, err := destination.InputFileRead(inp.Id)
+  if err != nil { test.Fatalf("TestArchive: input file not imported: %s", err) }
+  if imported_inp.SourceLocation != filepath.Join(destination.mediaPath, "incoming", "episode.mkv") { test.Fatalf("TestArchive: input file not remapped: %s", imported_inp.SourceLocation) }
+  if value, _ := destination.PropertyGet("server_name"); value != "archived" { test.Fatalf("TestArchive: property not imported") }
+
+  // media isn't archived; check reports it missing, and nothing else
+  report, err := destination.Check(false)
+  if err != nil { test.Fatalf("TestArchive: Check failed: %s", err) }
+  if (len(report.Issues) != 1) || (report.Issues[0].Type != CheckIssueMissingFile) { test.Fatalf("TestArchive: unexpected check issues: %v", report.Issues) }
+
+  // only into empty libraries
+  _, err = destination.ArchiveImport(bytes.NewReader(archive_bytes), ArchiveImportOptions {})
+  if err == nil { test.Fatalf("TestArchive: ArchiveImport into non-empty library succeeded") }
+}
+
+// Archive of a manifest (at lib's migration level) and metadata records.
+func testArchiveWithMetadata(test *testing.T, lib *Library, metadata []Metadata) []byte {
+  archive := bytes.Buffer {}
+  gzip_writer := gzip.NewWriter(&archive)
+  tar_writer  := tar.NewWriter(gzip_writer)
+  err := archiveWriteJson(tar_writer, "manifest.json", ArchiveManifest { Format:ArchiveFormatVersion, MigrationLevel:lib.MigrationLevelGet(), Metadata:len(metadata) })
+  if err == nil { err = archiveWriteJson(tar_writer, "metadata.json", metadata) }
+  if err == nil { err = tar_writer.Close() }
+  if err == nil { err = gzip_writer.Close() }
+  if err != nil { test.Fatalf("testArchiveWithMetadata: writing archive failed: %s", err) }
+  return archive.Bytes()
+}
+
+func TestArchiveInvalid(test *testing.T) {
+  test.Parallel()
+  lib, err := LibraryOpen(filepath.Join(test.TempDir(), "test.database"))
+  if err != nil { test.Fatalf("TestArchiveInvalid: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestArchiveInvalid: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media", "library"))
+  if err != nil { test.Fatalf("TestArchiveInvalid: MediaPathSet failed: %s", err) }
+
+  // ids that aren't record ids (would place artwork & directories outside staging, or media path), and parents not in the archive
+  valid_id := "0b7d5c8e-3f4a-4c1e-9a2b-6d8e0f1a2b3c"
+  invalid := map[string][]Metadata {
+    "traversing id" : []Metadata { Metadata { Id:"../../escape", MediaType:MetadataMediaTypeSeries, NameDisplay:"Escape", NameSort:"escape" } },
+    "empty id"      : []Metadata { Metadata { Id:"",             MediaType:MetadataMediaTypeSeries, NameDisplay:"Empty",  NameSort:"empty"  } },
+    "missing parent": []Metadata { Metadata { Id:valid_id, ParentId:"../..", MediaType:MetadataMediaTypeSeries, NameDisplay:"Orphan", NameSort:"orphan" } },
+  }
+  for description, metadata := range invalid {
+    _, err = lib.ArchiveImport(bytes.NewReader(testArchiveWithMetadata(test, lib, metadata)), ArchiveImportOptions {})
+    if err == nil { test.Fatalf("TestArchiveInvalid: archive with %s imported", description) }
+    if records, _ := lib.dbRecordWhere(&Metadata{}, ``); len(records) != 0 { test.Fatalf("TestArchiveInvalid: archive with %s created records", description) }
+  }
+  if entries, _ := os.ReadDir(filepath.Dir(lib.mediaPath)); len(entries) != 1 { test.Fatalf("TestArchiveInvalid: files created beside media path: %v", entries) }
+
+  // parent within the archive is fine
+  metadata := []Metadata {
+    Metadata { Id:valid_id, MediaType:MetadataMediaTypeSeries, NameDisplay:"Series", NameSort:"series", Streams:[]FileStream {} },
+    Metadata { Id:"1c8e6d9f-4a5b-4d2f-8b3c-7e9f1a2b3c4d", ParentId:valid_id, MediaType:MetadataMediaTypeSeason, NameDisplay:"Season", NameSort:"season", Streams:[]FileStream {} },
+  }
+  _, err = lib.ArchiveImport(bytes.NewReader(testArchiveWithMetadata(test, lib, metadata)), ArchiveImportOptions {})
+  if err != nil { test.Fatalf("TestArchiveInvalid: valid archive not imported: %s", err) }
+  if !pathIsDirectory(filepath.Join(lib.mediaPath, "series", "season")) { test.Fatalf("TestArchiveInvalid: imported directories not created") }
+}
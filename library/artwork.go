@@ -0,0 +1,263 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "image"
+  "slices"
+  "os/exec"
+  "strings"
+  "image/png"
+  "image/draw"
+  "image/jpeg"
+  "path/filepath"
+  "github.com/nfnt/resize"
+)
+
+type ArtworkType string
+const (
+  ArtworkTypePoster   ArtworkType = "poster"
+  ArtworkTypeBackdrop ArtworkType = "backdrop" // 16:9 fanart
+  ArtworkTypeLogo     ArtworkType = "logo"     // clear logo, on transparent background
+  ArtworkTypeThumb    ArtworkType = "thumb"    // 16:9 episode still
+)
+
+type ArtworkSize string
+const (
+  ArtworkSizeSmall   ArtworkSize = "small"
+  ArtworkSizeLarge   ArtworkSize = "large"
+  ArtworkSizeSmall2x ArtworkSize = "small-2x" // for FHD interfaces
+  ArtworkSizeLarge2x ArtworkSize = "large-2x" // for FHD interfaces
+)
+
+type ArtworkFormat string
+const (
+  ArtworkFormatJpeg ArtworkFormat = "jpg"
+  ArtworkFormatWebp ArtworkFormat = "webp"
+  ArtworkFormatPng  ArtworkFormat = "png"
+)
+
+var ArtworkTypes   = []ArtworkType   { ArtworkTypePoster, ArtworkTypeBackdrop, ArtworkTypeLogo, ArtworkTypeThumb }
+var ArtworkSizes   = []ArtworkSize   { ArtworkSizeSmall, ArtworkSizeLarge, ArtworkSizeSmall2x, ArtworkSizeLarge2x }
+var ArtworkFormats = []ArtworkFormat { ArtworkFormatJpeg, ArtworkFormatWebp, ArtworkFormatPng }
+
+var ErrInvalidArtworkType   = fmt.Errorf("invalid artwork type")
+var ErrInvalidArtworkSize   = fmt.Errorf("invalid artwork size")
+var ErrInvalidArtworkFormat = fmt.Errorf("invalid artwork format")
+
+const artworkJpegQuality = 90
+const artworkMinimumCrop = 0.5 // fraction of source area that must survive cropping to target aspect; otherwise letterbox
+
+// Pixel dimensions of an artwork type & size (square posters are used for music).
+func ArtworkDimensions(art_type ArtworkType, size ArtworkSize, square bool) (width uint, height uint, err error) {
+  small_width, small_height, large_width, large_height := uint(0), uint(0), uint(0), uint(0)
+  switch art_type {
+    case ArtworkTypePoster   : small_width, small_height, large_width, large_height = 183, 275, 360, 540
+    case ArtworkTypeBackdrop : small_width, small_height, large_width, large_height = 640, 360, 1280, 720
+    case ArtworkTypeLogo     : small_width, small_height, large_width, large_height = 200, 78, 400, 155
+    case ArtworkTypeThumb    : small_width, small_height, large_width, large_height = 320, 180, 640, 360
+    default                  : return 0, 0, ErrInvalidArtworkType
+  }
+  if (art_type == ArtworkTypePoster) && square { small_width, small_height, large_width, large_height = 200, 200, 512, 512 }
+
+  switch size {
+    case ArtworkSizeSmall   : return small_width,     small_height,     nil
+    case ArtworkSizeLarge   : return large_width,     large_height,     nil
+    case ArtworkSizeSmall2x : return small_width * 2, small_height * 2, nil
+    case ArtworkSizeLarge2x : return large_width * 2, large_height * 2, nil
+  }
+  return 0, 0, ErrInvalidArtworkSize
+}
+
+// ============================================================================
+// Metadata artwork (posters are kept at their own paths; other types in the artwork directory)
+
+func (md *Metadata) SetArtwork(art_type ArtworkType, img image.Image) error {
+  if art_type == ArtworkTypePoster { return md.SetPoster(img) }
+  if !slices.Contains(ArtworkTypes, art_type) { return ErrInvalidArtworkType }
+  artwork_path, err := md.DiskPath(MetadataPathTypeArtwork)
+  if err != nil { return err }
+  return artworkSet(artwork_path, art_type, img)
+}
+
+func (md *Metadata) ArtworkFile(art_type ArtworkType, size ArtworkSize, format ArtworkFormat) (string, error) {
+  if art_type == ArtworkTypePoster { return md.PosterFile(size, format) }
+  width, height, err := ArtworkDimensions(art_type, size, false)
+  if err != nil { return "", err }
+  artwork_path, err := md.DiskPath(MetadataPathTypeArtwork)
+  if err != nil { return "", err }
+  return artworkFile(artwork_path, art_type, size, format, width, height)
+}
+
+func (md *Metadata) DeleteArtwork(art_type ArtworkType) error {
+  if art_type == ArtworkTypePoster {
+    if !md.HasPoster() { return ErrNotFound }
+    for _, path_type := range []MetadataPathType { MetadataPathTypePosterLarge, MetadataPathTypePosterSmall, MetadataPathTypePosterMaster, MetadataPathTypePosterCache } {
+      poster_path, err := md.DiskPath(path_type)
+      if err != nil { return err }
+      err = os.RemoveAll(poster_path)
+      if err != nil { return err }
+    }
+    err := md.lib().dbRecordPatch(md, map[string]any { "poster_blurhash":"", "poster_color":"" })
+    if err != nil { return ErrQueryFailed }
+    return nil
+  }
+  if !slices.Contains(ArtworkTypes, art_type) { return ErrInvalidArtworkType }
+  artwork_path, err := md.DiskPath(MetadataPathTypeArtwork)
+  if err != nil { return err }
+  return artworkDelete(artwork_path, art_type)
+}
+
+// ============================================================================
+// Category artwork (all types in the category's artwork directory)
+
+func (cat *Category) ArtworkPath() string {
+  return filepath.Join(cat.DiskPath(), ".artwork")
+}
+
+func (cat *Category) SetArtwork(art_type ArtworkType, img image.Image) error {
+  if !slices.Contains(ArtworkTypes, art_type) { return ErrInvalidArtworkType }
+  return artworkSet(cat.ArtworkPath(), art_type, img)
+}
+
+func (cat *Category) ArtworkFile(art_type ArtworkType, size ArtworkSize, format ArtworkFormat) (string, error) {
+  width, height, err := ArtworkDimensions(art_type, size, cat.MediaType == CategoryMediaTypeMusic)
+  if err != nil { return "", err }
+  return artworkFile(cat.ArtworkPath(), art_type, size, format, width, height)
+}
+
+func (cat *Category) DeleteArtwork(art_type ArtworkType) error {
+  if !slices.Contains(ArtworkTypes, art_type) { return ErrInvalidArtworkType }
+  return artworkDelete(cat.ArtworkPath(), art_type)
+}
+
+// ============================================================================
+// Artwork directories: a master ("<type>.png") for each type, plus renderings ("<type>-<size>.<format>")
+
+func artworkSet(artwork_path string, art_type ArtworkType, img image.Image) error {
+  err := os.MkdirAll(artwork_path, 0770)
+  if err != nil { return err }
+  err = artworkDeleteRenderings(artwork_path, art_type)
+  if err != nil { return err }
+  return artworkWrite(filepath.Join(artwork_path, string(art_type) + ".png"), img, ArtworkFormatPng)
+}
+
+func artworkFile(artwork_path string, art_type ArtworkType, size ArtworkSize, format ArtworkFormat, width uint, height uint) (string, error) {
+  if !slices.Contains(ArtworkFormats, format) { return "", ErrInvalidArtworkFormat }
+  master_path := filepath.Join(artwork_path, string(art_type) + ".png")
+  master_stat, err := os.Stat(master_path)
+  if os.IsNotExist(err) { return "", ErrNotFound }
+  if err != nil { return "", err }
+
+  // use cached rendering, if not older than master
+  render_path := filepath.Join(artwork_path, string(art_type) + "-" + string(size) + "." + string(format))
+  if render_stat, err := os.Stat(render_path); (err == nil) && !render_stat.ModTime().Before(master_stat.ModTime()) { return render_path, nil }
+
+  master_file, err := os.Open(master_path)
+  if err != nil { return "", err }
+  master, err := png.Decode(master_file)
+  master_file.Close()
+  if err != nil { return "", err }
+
+  var rendered image.Image
+  if art_type == ArtworkTypeLogo {
+    rendered = artworkFit(master, width, height, image.Transparent)
+  } else {
+    rendered = artworkRender(master, width, height)
+  }
+  err = artworkWrite(render_path, rendered, format)
+  if err != nil { return "", err }
+  return render_path, nil
+}
+
+func artworkDelete(artwork_path string, art_type ArtworkType) error {
+  master_path := filepath.Join(artwork_path, string(art_type) + ".png")
+  if !pathExists(master_path) { return ErrNotFound }
+  err := artworkDeleteRenderings(artwork_path, art_type)
+  if err != nil { return err }
+  return os.Remove(master_path)
+}
+
+func artworkDeleteRenderings(artwork_path string, art_type ArtworkType) error {
+  renderings, err := filepath.Glob(filepath.Join(artwork_path, string(art_type) + "-*"))
+  if err != nil { return err }
+  for _, rendering := range renderings {
+    err = os.Remove(rendering)
+    if err != nil { return err }
+  }
+  return nil
+}
+
+// Resample (lanczos) to width x height: center-cropping to target aspect, or letterboxing when cropping would lose too much.
+func artworkRender(img image.Image, width uint, height uint) image.Image {
+  bounds := img.Bounds()
+  source_width, source_height := float64(bounds.Dx()), float64(bounds.Dy())
+  target_aspect := float64(width) / float64(height)
+  if (source_width < 1) || (source_height < 1) { return image.NewRGBA(image.Rect(0, 0, int(width), int(height))) }
+
+  crop_width, crop_height := source_width, source_height
+  if (source_width / source_height) > target_aspect {
+    crop_width = source_height * target_aspect
+  } else {
+    crop_height = source_width / target_aspect
+  }
+  if ((crop_width * crop_height) / (source_width * source_height)) < artworkMinimumCrop { return artworkFit(img, width, height, image.Black) }
+
+  x := bounds.Min.X + int((source_width  - crop_width ) / 2)
+  y := bounds.Min.Y + int((source_height - crop_height) / 2)
+  cropped := image.NewRGBA(image.Rect(0, 0, int(crop_width), int(crop_height)))
+  draw.Draw(cropped, cropped.Bounds(), img, image.Point { X:x, Y:y }, draw.Src)
+  return resize.Resize(width, height, cropped, resize.Lanczos3)
+}
+
+// Resample (lanczos) to fit within width x height, centered on background.
+func artworkFit(img image.Image, width uint, height uint, background *image.Uniform) image.Image {
+  bounds := img.Bounds()
+  source_width, source_height := float64(bounds.Dx()), float64(bounds.Dy())
+  canvas := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+  draw.Draw(canvas, canvas.Bounds(), background, image.Point {}, draw.Src)
+  if (source_width < 1) || (source_height < 1) { return canvas }
+
+  fit_width, fit_height := width, uint(float64(width) * (source_height / source_width))
+  if fit_height > height { fit_width, fit_height = uint(float64(height) * (source_width / source_height)), height }
+  scaled := resize.Resize(fit_width, fit_height, img, resize.Lanczos3)
+  offset := image.Point { X:(int(width) - scaled.Bounds().Dx()) / 2, Y:(int(height) - scaled.Bounds().Dy()) / 2 }
+  draw.Draw(canvas, scaled.Bounds().Sub(scaled.Bounds().Min).Add(offset), scaled, scaled.Bounds().Min, draw.Over)
+  return canvas
+}
+
+// Write image (atomically); webp is encoded by ffmpeg, as go has no webp encoder.
+func artworkWrite(path string, img image.Image, format ArtworkFormat) error {
+  temp_path := path + ".tmp"
+  png_path  := path + ".tmp.png"
+  defer os.Remove(temp_path)
+  defer os.Remove(png_path)
+
+  encode_path := temp_path ; if format == ArtworkFormatWebp { encode_path = png_path }
+  encode_file, err := os.Create(encode_path)
+  if err != nil { return err }
+  if format == ArtworkFormatJpeg {
+    err = jpeg.Encode(encode_file, artworkOpaque(img), &jpeg.Options { Quality:artworkJpegQuality })
+  } else {
+    err = png.Encode(encode_file, img)
+  }
+  close_err := encode_file.Close()
+  if err == nil { err = close_err }
+  if err != nil { return err }
+
+  if format == ArtworkFormatWebp {
+    ffmpeg := exec.Command("ffmpeg", "-v", "error", "-i", png_path, "-c:v", "libwebp", "-quality", "85", "-f", "webp", "-y", temp_path)
+    output, err := ffmpeg.CombinedOutput()
+    if err != nil { return fmt.Errorf("error encoding webp: %s: %s", err.Error(), strings.TrimSpace(string(output))) }
+  }
+  return os.Rename(temp_path, path)
+}
+
+// Flatten transparency onto black (jpeg has no alpha).
+func artworkOpaque(img image.Image) image.Image {
+  if opaque, ok := img.(interface { Opaque() bool }); ok && opaque.Opaque() { return img }
+  flattened := image.NewRGBA(img.Bounds())
+  draw.Draw(flattened, flattened.Bounds(), image.Black, image.Point {}, draw.Src)
+  draw.Draw(flattened, flattened.Bounds(), img, img.Bounds().Min, draw.Over)
+  return flattened
+}
@@ -0,0 +1,52 @@
+package library
+
+import (
+  "fmt"
+  "slices"
+)
+
+// Output format for audio-only media; chosen per music Category.
+type AudioFormat string
+const (
+  AudioFormatMp3  AudioFormat = "mp3"
+  AudioFormatFlac AudioFormat = "flac"
+  AudioFormatAlac AudioFormat = "alac" // in m4a
+  AudioFormatOpus AudioFormat = "opus"
+  AudioFormatAac  AudioFormat = "aac"  // in m4a
+)
+var AudioFormats = []AudioFormat { AudioFormatMp3, AudioFormatFlac, AudioFormatAlac, AudioFormatOpus, AudioFormatAac }
+
+var ErrInvalidAudioFormat = fmt.Errorf("invalid audio format")
+
+func AudioFormatValid(format AudioFormat) bool {
+  return slices.Contains(AudioFormats, format)
+}
+
+// File extension of output, including leading ".".
+func (format AudioFormat) Extension() string {
+  switch format {
+    case AudioFormatFlac : return ".flac"
+    case AudioFormatAlac : return ".m4a"
+    case AudioFormatOpus : return ".opus"
+    case AudioFormatAac  : return ".m4a"
+  }
+  return ".mp3"
+}
+
+// Codec name (as reported by ffprobe) of output; sources already in this codec can be stream-copied.
+func (format AudioFormat) Codec() string {
+  if AudioFormatValid(format) { return string(format) }
+  return string(AudioFormatMp3)
+}
+
+// Content type to serve media files with, by extension.
+func MediaContentType(extension string) string {
+  switch extension {
+    case ".mp4"  : return "video/mp4"
+    case ".mp3"  : return "audio/mpeg"
+    case ".flac" : return "audio/flac"
+    case ".m4a"  : return "audio/mp4"
+    case ".opus" : return "audio/ogg; codecs=opus"
+  }
+  return "application/octet-stream"
+}
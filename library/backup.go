@@ -0,0 +1,175 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "time"
+  "regexp"
+  "strconv"
+  "strings"
+  "path/filepath"
+  "database/sql"
+)
+
+// Online database backups (VACUUM INTO), timestamped & integrity checked, rotated by count.
+// Settings are properties: backup_path (default "backups", beside the database), backup_interval_hours (0 disables), backup_retention.
+
+const BackupIntervalDefault  int64 = 24
+const BackupRetentionDefault int64 = 7
+
+type Backup struct {
+  Name        string `json:"name"`
+  Path        string `json:"-"`
+  Size        int64  `json:"size"`
+  TimeCreated int64  `json:"time_created"`
+}
+
+// ============================================================================
+// Public Interface
+
+// Back up the database now; the backup is verified, then older backups beyond retention are removed.
+func (lib *Library) BackupCreate() (*Backup, error) {
+  backup_dir := lib.backupPath()
+  err := os.MkdirAll(backup_dir, 0770)
+  if err != nil { return nil, err }
+
+  now := time.Now()
+  name := fmt.Sprintf("%s-%s.db", lib.backupPrefix(), now.Format("20060102-150405"))
+  for index := 2; pathExists(filepath.Join(backup_dir, name)); index++ {
+    name = fmt.Sprintf("%s-%s-%d.db", lib.backupPrefix(), now.Format("20060102-150405"), index)
+  }
+  backup_path  := filepath.Join(backup_dir, name)
+  partial_path := backup_path + ".partial"
+  os.Remove(partial_path)
+
+  err = lib.dbVacuumInto(partial_path)
+  if err == nil { err = dbIntegrityCheck(partial_path) }
+  if err == nil { err = os.Rename(partial_path, backup_path) }
+  if err != nil { os.Remove(partial_path) ; return nil, err }
+
+  stat, err := os.Stat(backup_path)
+  if err != nil { return nil, err }
+  err = lib.backupPrune()
+  if err != nil { return nil, err }
+  return &Backup { Name:name, Path:backup_path, Size:stat.Size(), TimeCreated:now.Unix() }, nil
+}
+
+// List backups, newest first.
+func (lib *Library) BackupList() ([]Backup, error) {
+  backups := []Backup {}
+  entries, err := os.ReadDir(lib.backupPath())
+  if os.IsNotExist(err) { return backups, nil }
+  if err != nil { return nil, err }
+
+  name_pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(lib.backupPrefix()) + `-(\d{8}-\d{6})(-\d+)?\.db$`)
+  for _, entry := range entries {
+    match := name_pattern.FindStringSubmatch(entry.Name())
+    if (match == nil) || entry.IsDir() { continue }
+    created, err := time.ParseInLocation("20060102-150405", match[1], time.Local)
+    if err != nil { continue }
+    info, err := entry.Info()
+    if err != nil { continue }
+    backups = append(backups, Backup { Name:entry.Name(), Path:filepath.Join(lib.backupPath(), entry.Name()), Size:info.Size(), TimeCreated:created.Unix() })
+  }
+  sort.SliceStable(backups, func(a int, b int) bool {
+    if backups[a].TimeCreated != backups[b].TimeCreated { return backups[a].TimeCreated > backups[b].TimeCreated }
+    if len(backups[a].Name) != len(backups[b].Name) { return len(backups[a].Name) > len(backups[b].Name) } // "-2" suffix: same second, later
+    return backups[a].Name > backups[b].Name
+  })
+  return backups, nil
+}
+
+// Find a backup by name.
+func (lib *Library) BackupRead(name string) (*Backup, error) {
+  backups, err := lib.BackupList()
+  if err != nil { return nil, err }
+  for index := range backups {
+    if backups[index].Name == name { return &backups[index], nil }
+  }
+  return nil, ErrNotFound
+}
+
+// Is a scheduled backup due (interval passed since newest backup)?
+func (lib *Library) BackupDue() bool {
+  interval := lib.backupSetting("backup_interval_hours", BackupIntervalDefault)
+  if interval <= 0 { return false }
+  backups, err := lib.BackupList()
+  if err != nil { return false }
+  if len(backups) == 0 { return true }
+  return time.Since(time.Unix(backups[0].TimeCreated, 0)) >= (time.Duration(interval) * time.Hour)
+}
+
+// Replace the database with a backup file (any path), after checking its integrity.
+// The current database is kept beside it, as "<database>.pre-restore".
+func (lib *Library) BackupRestore(backup_path string) error {
+  if !pathExists(backup_path) { return ErrNotFound }
+  err := dbIntegrityCheck(backup_path)
+  if err != nil { return err }
+
+  pre_restore_path := lib.dbPath + ".pre-restore"
+  os.Remove(pre_restore_path)
+  if lib.dbHandle != nil {
+    err = lib.dbVacuumInto(pre_restore_path)
+  } else if pathExists(lib.dbPath) {
+    err = fileCopy(lib.dbPath, pre_restore_path)
+  }
+  if err != nil { return fmt.Errorf("cannot keep current database before restore: %s", err.Error()) }
+
+  return lib.dbReplace(backup_path)
+}
+
+// ============================================================================
+// private utilities
+
+func (lib *Library) backupPath() string {
+  backup_path, err := lib.dbPropertyRead("backup_path")
+  if (err != nil) || (backup_path == "") { backup_path = "backups" }
+  if !filepath.IsAbs(backup_path) { backup_path = filepath.Join(filepath.Dir(lib.dbPath), backup_path) }
+  return backup_path
+}
+
+// Backup file names start with the database's name, so libraries may share a backup directory.
+func (lib *Library) backupPrefix() string {
+  return strings.TrimSuffix(filepath.Base(lib.dbPath), filepath.Ext(lib.dbPath))
+}
+
+func (lib *Library) backupSetting(key string, default_value int64) int64 {
+  value_string, err := lib.dbPropertyRead(key)
+  if err != nil { return default_value }
+  value, err := strconv.ParseInt(strings.TrimSpace(value_string), 10, 64)
+  if err != nil { return default_value }
+  return value
+}
+
+// Remove oldest backups, beyond retention count.
+func (lib *Library) backupPrune() error {
+  retention := lib.backupSetting("backup_retention", BackupRetentionDefault)
+  if retention < 1 { retention = 1 }
+  backups, err := lib.BackupList()
+  if err != nil { return err }
+  for index := int(retention); index < len(backups); index++ {
+    err = os.Remove(backups[index].Path)
+    if err != nil { return err }
+  }
+  return nil
+}
+
+// Write a consistent copy of the live database (safe while in use).
+func (lib *Library) dbVacuumInto(destination string) error {
+  if lib.dbHandle == nil { return ErrDbNotOpened }
+  if pathExists(destination) { return ErrPathExists }
+  return dbRetry(func() error { _, err := lib.dbHandle.Exec(`VACUUM INTO ?;`, destination) ; return err })
+}
+
+// Verify a database file with PRAGMA integrity_check.
+func dbIntegrityCheck(path string) error {
+  db, err := sql.Open("sqlite", path)
+  if err != nil { return err }
+  defer db.Close()
+  result := ""
+  err = db.QueryRow(`PRAGMA integrity_check;`).Scan(&result)
+  if err != nil { return fmt.Errorf("integrity check failed: %s", err.Error()) }
+  if result != "ok" { return fmt.Errorf("integrity check failed: %s", result) }
+  return nil
+}
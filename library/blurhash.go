@@ -0,0 +1,133 @@
+package library
+
+import (
+  "fmt"
+  "math"
+  "image"
+  "strings"
+  "github.com/nfnt/resize"
+)
+
+// https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+
+const blurhashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+const blurhashSampleSize = 32 // images are sampled at most this many pixels per side
+
+// Encode an image as a BlurHash placeholder; uses more components along its longer dimension.
+func BlurhashEncode(img image.Image) string {
+  bounds := img.Bounds()
+  if (bounds.Dx() < 1) || (bounds.Dy() < 1) { return "" }
+  x_components, y_components := 4, 4
+  if bounds.Dx() > bounds.Dy() { y_components = 3 }
+  if bounds.Dy() > bounds.Dx() { x_components = 3 }
+
+  // linear rgb, sampled on a grid (at most blurhashSampleSize per side)
+  width, height := min(bounds.Dx(), blurhashSampleSize), min(bounds.Dy(), blurhashSampleSize)
+  pixels := make([][3]float64, width * height)
+  for y := 0; y < height; y++ {
+    for x := 0; x < width; x++ {
+      source_x := bounds.Min.X + (((x * 2) + 1) * bounds.Dx()) / (width  * 2)
+      source_y := bounds.Min.Y + (((y * 2) + 1) * bounds.Dy()) / (height * 2)
+      r, g, b, _ := img.At(source_x, source_y).RGBA()
+      pixels[(y * width) + x] = [3]float64 { blurhashToLinear(r >> 8), blurhashToLinear(g >> 8), blurhashToLinear(b >> 8) }
+    }
+  }
+
+  factors := make([][3]float64, 0, x_components * y_components)
+  for j := 0; j < y_components; j++ {
+    for i := 0; i < x_components; i++ {
+      normalisation := 2.0 ; if (i == 0) && (j == 0) { normalisation = 1.0 }
+      factor := [3]float64 {}
+      for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+          basis := math.Cos((math.Pi * float64(i) * float64(x)) / float64(width)) * math.Cos((math.Pi * float64(j) * float64(y)) / float64(height))
+          for channel := 0; channel < 3; channel++ { factor[channel] += basis * pixels[(y * width) + x][channel] }
+        }
+      }
+      scale := normalisation / float64(width * height)
+      for channel := 0; channel < 3; channel++ { factor[channel] *= scale }
+      factors = append(factors, factor)
+    }
+  }
+
+  var hash strings.Builder
+  hash.WriteString(blurhashEncode83((x_components - 1) + ((y_components - 1) * 9), 1))
+
+  maximum := 1.0
+  if len(factors) > 1 {
+    actual_maximum := 0.0
+    for _, factor := range factors[1:] {
+      for channel := 0; channel < 3; channel++ { actual_maximum = math.Max(actual_maximum, math.Abs(factor[channel])) }
+    }
+    quantised_maximum := int(math.Max(0, math.Min(82, math.Floor((actual_maximum * 166) - 0.5))))
+    maximum = float64(quantised_maximum + 1) / 166
+    hash.WriteString(blurhashEncode83(quantised_maximum, 1))
+  } else {
+    hash.WriteString(blurhashEncode83(0, 1))
+  }
+
+  dc := factors[0]
+  hash.WriteString(blurhashEncode83((blurhashToSrgb(dc[0]) << 16) + (blurhashToSrgb(dc[1]) << 8) + blurhashToSrgb(dc[2]), 4))
+  for _, factor := range factors[1:] {
+    quantised := [3]int {}
+    for channel := 0; channel < 3; channel++ {
+      value := factor[channel] / maximum
+      signed_sqrt := math.Copysign(math.Sqrt(math.Abs(value)), value)
+      quantised[channel] = int(math.Max(0, math.Min(18, math.Floor((signed_sqrt * 9) + 9.5))))
+    }
+    hash.WriteString(blurhashEncode83((quantised[0] * 19 * 19) + (quantised[1] * 19) + quantised[2], 2))
+  }
+
+  return hash.String()
+}
+
+// Most common color of an image (quantized to 4 bits per channel, then averaged within that bucket), as "#rrggbb".
+func DominantColor(img image.Image) string {
+  sample := resize.Thumbnail(64, 64, img, resize.Bilinear)
+  bounds := sample.Bounds()
+
+  counts := make([]int64,     4096)
+  sums   := make([][3]uint64, 4096)
+  for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+    for x := bounds.Min.X; x < bounds.Max.X; x++ {
+      r, g, b, a := sample.At(x, y).RGBA()
+      if a < 0x8000 { continue } // mostly transparent
+      r, g, b = r >> 8, g >> 8, b >> 8
+      bucket := ((r >> 4) << 8) | ((g >> 4) << 4) | (b >> 4)
+      counts[bucket] += 1
+      sums[bucket][0] += uint64(r)
+      sums[bucket][1] += uint64(g)
+      sums[bucket][2] += uint64(b)
+    }
+  }
+
+  best := -1
+  for bucket := range counts {
+    if counts[bucket] == 0 { continue }
+    if (best < 0) || (counts[bucket] > counts[best]) { best = bucket }
+  }
+  if best < 0 { return "" }
+  count := uint64(counts[best])
+  return fmt.Sprintf("#%02x%02x%02x", sums[best][0] / count, sums[best][1] / count, sums[best][2] / count)
+}
+
+func blurhashEncode83(value int, length int) string {
+  encoded := make([]byte, length)
+  for index := length - 1; index >= 0; index-- {
+    encoded[index] = blurhashCharacters[value % 83]
+    value /= 83
+  }
+  return string(encoded)
+}
+
+func blurhashToLinear(value uint32) float64 {
+  v := float64(value) / 255
+  if v <= 0.04045 { return v / 12.92 }
+  return math.Pow((v + 0.055) / 1.055, 2.4)
+}
+
+func blurhashToSrgb(value float64) int {
+  v := math.Max(0, math.Min(1, value))
+  if v <= 0.0031308 { return int((v * 12.92 * 255) + 0.5) }
+  return int((((1.055 * math.Pow(v, 1 / 2.4)) - 0.055) * 255) + 0.5)
+}
@@ -0,0 +1,35 @@
+package library
+
+import (
+  "image"
+  "image/color"
+  "testing"
+)
+
+// Gradient image; scale > 1 repeats each pixel, so sampling still lands on the same values.
+func testBlurhashImage(width int, height int, scale int) image.Image {
+  img := image.NewRGBA(image.Rect(0, 0, width * scale, height * scale))
+  for y := 0; y < (height * scale); y++ {
+    for x := 0; x < (width * scale); x++ {
+      source_x, source_y := x / scale, y / scale
+      img.Set(x, y, color.RGBA { uint8(source_x * 8), uint8(source_y * 8), uint8(255 - (source_x * 4) - (source_y * 4)), 255 })
+    }
+  }
+  return img
+}
+
+func TestBlurhash(test *testing.T) {
+  test.Parallel()
+
+  // reference hashes, from the algorithm in woltapp/blurhash's Algorithm.md, over every pixel
+  landscape := BlurhashEncode(testBlurhashImage(32, 24, 1))
+  if landscape != "LxH14i2ew#W?myWZjuf9g3fkfQfk" { test.Fatalf("TestBlurhash: landscape hash \"%s\" doesn't match reference", landscape) }
+  portrait := BlurhashEncode(testBlurhashImage(24, 32, 1))
+  if portrait != "TxCt|PF$sXhvajjugMfkfQjKa~ju" { test.Fatalf("TestBlurhash: portrait hash \"%s\" doesn't match reference", portrait) }
+
+  // larger images are sampled down to blurhashSampleSize per side
+  sampled := BlurhashEncode(testBlurhashImage(32, 24, 2))
+  if sampled != landscape { test.Fatalf("TestBlurhash: sampled hash \"%s\" doesn't match \"%s\"", sampled, landscape) }
+
+  if BlurhashEncode(image.NewRGBA(image.Rect(0, 0, 0, 0))) != "" { test.Fatalf("TestBlurhash: empty image produced a hash") }
+}
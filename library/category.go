@@ -13,10 +13,13 @@ const (
   CategoryMediaTypeMusic  CategoryMediaType = "music"
 )
 type Category struct {
-  Id        string            `json:"id"`
-  MediaType CategoryMediaType `json:"media_type"`
-  Name      string            `json:"name"`
-  SortIndex int64             `json:"sort_index"`
+  Id          string            `json:"id"`
+  MediaType   CategoryMediaType `json:"media_type"`
+  Name        string            `json:"name"`
+  SortIndex   int64             `json:"sort_index"`
+  AudioFormat AudioFormat       `json:"audio_format"` // output format for audio files transcoded into this category
+  VolumeId    string            `json:"volume_id"`    // storage volume holding this category ("" == primary)
+  library     *Library                                   // library read from/created in (nil == default)
 }
 
 var ErrInvalidMediaType = fmt.Errorf("invalid media type")
@@ -26,79 +29,79 @@ var ErrInvalidMediaType = fmt.Errorf("invalid media type")
 
 func (cat *Category) Copy() (*Category) {
   copy := Category {}
-  copy.Id        = cat.Id
-  copy.MediaType = cat.MediaType
-  copy.Name      = cat.Name
-  copy.SortIndex = cat.SortIndex
+  copy.Id          = cat.Id
+  copy.MediaType   = cat.MediaType
+  copy.Name        = cat.Name
+  copy.SortIndex   = cat.SortIndex
+  copy.AudioFormat = cat.AudioFormat
+  copy.VolumeId    = cat.VolumeId
+  copy.library     = cat.library
   return &copy
 }
 
 func (cat *Category) DiskPath() string {
-  return filepath.Join(mediaPath, cat.Name)
+  return filepath.Join(cat.lib().volumePath(cat.VolumeId), cat.Name)
 }
 
-func CategoryList() ([]Category, error) {
-  records, err := dbRecordWhere(&Category{}, `(id <> '') ORDER BY sort_index ASC, name ASC`)
+func (lib *Library) CategoryList() ([]Category, error) {
+  records, err := lib.dbRecordWhere(&Category{}, `(id <> '') ORDER BY sort_index ASC, name ASC`)
   if err != nil { return nil, ErrQueryFailed }
   categories := make([]Category, len(records))
   for index, record := range records { categories[index] = *(record.(*Category)) }
   return categories, nil
 }
 
-func CategoryRead(id string) (*Category, error) {
+func (lib *Library) CategoryRead(id string) (*Category, error) {
   cat := Category {}
-  err := dbRecordRead(&cat, id)
+  err := lib.dbRecordRead(&cat, id)
   if err != nil { return nil, err }
   return &cat, nil
 }
 
-func CategoryCreate(name string, media_type CategoryMediaType) (*Category, error) {
+func (lib *Library) CategoryCreate(name string, media_type CategoryMediaType) (*Category, error) {
   // validate inputs
   if !nameValidForDisk(name) { return nil, ErrInvalidName }
   if !categoryMediaTypeValid(media_type) { return nil, ErrInvalidMediaType }
 
   // in DB, verify category doesn't already exist
-  db_exists := CategoryNameExists(name)
+  db_exists := lib.CategoryNameExists(name)
   if db_exists { return nil, fmt.Errorf("category named \"%s\" already exists in database", name) }
 
-  // on FS, verify category doesn't already exist
-  disk_path := filepath.Join(mediaPath, name)
+  // place on volume with most free space; on FS, verify category doesn't already exist
+  volume_id, err := lib.volumeForNew()
+  if err != nil { return nil, err }
+  disk_path := filepath.Join(lib.volumePath(volume_id), name)
   if pathExists(disk_path) { return nil, fmt.Errorf("category named \"%s\" already exists on disk", name) }
 
   // create category on FS
-  err := os.Mkdir(disk_path, 0770)
+  err = os.Mkdir(disk_path, 0770)
   if err != nil { return nil, fmt.Errorf("error creating category \"%s\" on disk: %s", name, err.Error()) }
 
   // create category in DB
-  cat := Category { Name:name, MediaType:media_type, SortIndex:9999 }
-  err = dbRecordCreate(&cat)
+  cat := Category { Name:name, MediaType:media_type, SortIndex:9999, AudioFormat:AudioFormatMp3, VolumeId:volume_id }
+  err = lib.dbRecordCreate(&cat)
   if err != nil { return nil, ErrQueryFailed }
   return &cat, nil
 }
 
-func CategoryDelete(cat *Category) error {
+func (lib *Library) CategoryDelete(cat *Category) error {
   // get children
-  children, err := MetadataForParent(cat.Id)
+  children, err := lib.MetadataForParent(cat.Id)
   if err != nil { return err }
 
   // verify all children can be unparented (moving to root won't result in name collisions)
   for _, child := range children {
-    if metadataCanMoveFilesToPath(&child, mediaPath) == false {
-      return fmt.Errorf("cannot delete category \"%s\": child \"%s\" files cannot be moved to media root", cat.Name, child.NameDisplay)
+    if lib.metadataCanReparent(&child, "", lib.mediaRoot()) != nil {
+      return fmt.Errorf("cannot delete category \"%s\": child \"%s\" cannot be moved to media root", cat.Name, child.NameDisplay)
     }
   }
 
-  // move all children fs objects to root, unparenting DB records as we go
-  for _, child := range children {
-    err := metadataMoveFilesToPath(&child, mediaPath)
-    if err != nil { return fmt.Errorf("category deletion stopped, error moving files for child \"%s\" to media root: %s", child.NameDisplay, err.Error()) }
-    child.ParentId = ""
-    err = dbRecordPatch(&child, map[string]any { "parent_id":"" })
-    if err != nil { return fmt.Errorf("category deletion stopped, error removing child \"%s\" from database parent: %s", child.NameDisplay, err.Error()) }
-  }
+  // move each child to root (files & record together, journaled, so a crash leaves none half-moved); on any failure, move those done back
+  err = lib.metadataUnparentAll(children, cat.Id)
+  if err != nil { return fmt.Errorf("category deletion stopped, %s", err.Error()) }
 
-  // delete category in DB
-  err = dbRecordDelete(cat)
+  // delete category in DB (if interrupted before this, category is left empty)
+  err = lib.dbRecordDelete(cat)
   if err != nil { return fmt.Errorf("error deleting category \"%s\" in database: %s", cat.Name, err.Error()) }
 
   // delete category on FS
@@ -108,7 +111,7 @@ func CategoryDelete(cat *Category) error {
   return nil
 }
 
-func CategoryUpdate(cat *Category, name string, media_type string) error {
+func (lib *Library) CategoryUpdate(cat *Category, name string, media_type string) error {
   // valid inputs?
   media_type_enum := CategoryMediaType(media_type)
   if !categoryMediaTypeValid(media_type_enum) { return ErrInvalidMediaType }
@@ -117,28 +120,40 @@ func CategoryUpdate(cat *Category, name string, media_type string) error {
   // if changing type, verify empty
   if media_type_enum != cat.MediaType {
     // verify no children exist
-    empty := CategoryIsEmpty(cat.Id)
+    empty := lib.CategoryIsEmpty(cat.Id)
     if empty == false { return fmt.Errorf("cannot change media type of category \"%s\": children exist", cat.Name) }
   }
 
   // if changing name, verify no collisions; then move on disk
+  moves := []JournalMove {}
   if name != cat.Name {
-    exists := CategoryNameExists(name)
+    exists := lib.CategoryNameExists(name)
     if exists { return fmt.Errorf("cannot rename category \"%s\" to \"%s\": name already exists", cat.Name, name) }
-    new_disk_path := filepath.Join(mediaPath, name)
+    new_disk_path := filepath.Join(lib.volumePath(cat.VolumeId), name)
     if pathExists(new_disk_path) { return fmt.Errorf("cannot rename category \"%s\" to \"%s\": name already exists on disk", cat.Name, name) }
-    err := os.Rename(cat.DiskPath(), new_disk_path)
-    if err != nil { return fmt.Errorf("error renaming category \"%s\" on disk: %s", cat.Name, err.Error()) }
+    moves = append(moves, JournalMove { Source:cat.DiskPath(), Destination:new_disk_path })
   }
 
   // update record
-  err := dbRecordPatch(cat, map[string]any { "name":name, "media_type":string(media_type_enum) })
+  patch := map[string]any { "name":name, "media_type":string(media_type_enum) }
+  err := lib.journalRun("category-update", moves, cat.TableName(), cat.Id, patch)
+  if err == ErrQueryFailed { return err }
+  if err != nil { return fmt.Errorf("error renaming category \"%s\": %s", cat.Name, err.Error()) }
+  cat.FieldsPatch(patch)
+  return nil
+}
+
+// Set output format for audio transcoded into a music category (existing files are unchanged).
+func (lib *Library) CategorySetAudioFormat(cat *Category, format AudioFormat) error {
+  if !AudioFormatValid(format) { return ErrInvalidAudioFormat }
+  if (cat.MediaType != CategoryMediaTypeMusic) && (format != AudioFormatMp3) { return fmt.Errorf("audio format can only be set for music categories") }
+  err := lib.dbRecordPatch(cat, map[string]any { "audio_format":string(format) })
   if err != nil { return ErrQueryFailed }
   return nil
 }
 
-func CategoryReindex(cat *Category, index int64) error {
-  err := dbRecordPatch(cat, map[string]any { "sort_index":index })
+func (lib *Library) CategoryReindex(cat *Category, index int64) error {
+  err := lib.dbRecordPatch(cat, map[string]any { "sort_index":index })
   if err != nil { return ErrQueryFailed }
   return nil
 }
@@ -146,27 +161,18 @@ func CategoryReindex(cat *Category, index int64) error {
 // ============================================================================
 // public utilities
 
-func CategoryIdExists(id string) bool {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
-  queryRow := dbHandle.QueryRow(`SELECT id FROM categories WHERE id = ? LIMIT 1;`, id)
-  err := queryRow.Scan(&id)
+func (lib *Library) CategoryIdExists(id string) bool {
+  err := dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT id FROM categories WHERE id = ? LIMIT 1;`, id).Scan(&id) })
   return (err == nil)
 }
 
-func CategoryNameExists(name string) bool {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
-  queryRow := dbHandle.QueryRow(`SELECT id FROM categories WHERE name = ? LIMIT 1;`, name)
-  err := queryRow.Scan(&name)
+func (lib *Library) CategoryNameExists(name string) bool {
+  err := dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT id FROM categories WHERE name = ? LIMIT 1;`, name).Scan(&name) })
   return (err == nil)
 }
 
-func CategoryIsEmpty(id string) bool {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
-  row := dbHandle.QueryRow(`SELECT id FROM metadata WHERE parent_id = ? LIMIT 1;`, id)
-  err := row.Scan(&id)
+func (lib *Library) CategoryIsEmpty(id string) bool {
+  err := dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT id FROM metadata WHERE parent_id = ? LIMIT 1;`, id).Scan(&id) })
   found := (err == nil)
   return !found
 }
@@ -193,6 +199,8 @@ func categoryMediaTypeValid(media_type CategoryMediaType) bool {
 func (cat *Category) TableName() string { return "categories"}
 func (cat *Category) GetId() string { return cat.Id }
 func (cat *Category) SetId(id string) { cat.Id = id }
+func (cat *Category) setLibrary(lib *Library) { cat.library = lib }
+func (cat *Category) lib() *Library { if cat.library != nil { return cat.library } ; return defaultLibrary }
 func (cat *Category) RecordCopy() (dbRecord, error) {
   return cat.Copy(), nil
 }
@@ -206,26 +214,32 @@ func (cat *Category) RecordCreate(fields map[string]any) (instance dbRecord, err
 
 func (cat *Category) FieldsRead() (fields map[string]any, err error) {
   fields = make(map[string]any)
-  fields["id"]         = cat.Id
-  fields["media_type"] = string(cat.MediaType)
-  fields["name"]       = cat.Name
-  fields["sort_index"] = cat.SortIndex
+  fields["id"]           = cat.Id
+  fields["media_type"]   = string(cat.MediaType)
+  fields["name"]         = cat.Name
+  fields["sort_index"]   = cat.SortIndex
+  fields["audio_format"] = string(cat.AudioFormat)
+  fields["volume_id"]    = cat.VolumeId
   return fields, nil
 }
 
 func (cat *Category) FieldsReplace(fields map[string]any) (err error) {
-  cat.Id        = fields["id"].(string)
-  cat.MediaType = CategoryMediaType(fields["media_type"].(string))
-  cat.Name      = fields["name"].(string)
-  cat.SortIndex = fields["sort_index"].(int64)
+  cat.Id          = fields["id"].(string)
+  cat.MediaType   = CategoryMediaType(fields["media_type"].(string))
+  cat.Name        = fields["name"].(string)
+  cat.SortIndex   = fields["sort_index"].(int64)
+  cat.AudioFormat = AudioFormat(fields["audio_format"].(string))
+  cat.VolumeId    = fields["volume_id"].(string)
   return nil
 }
 
 func (cat *Category) FieldsPatch(fields map[string]any) (err error) {
-  if id,         ok := fields["id"]         ; ok { cat.Id        = id.(string)                            }
-  if media_type, ok := fields["media_type"] ; ok { cat.MediaType = CategoryMediaType(media_type.(string)) }
-  if name,       ok := fields["name"]       ; ok { cat.Name      = name.(string)                          }
-  if sort_index, ok := fields["sort_index"] ; ok { cat.SortIndex = sort_index.(int64)                     }
+  if id,           ok := fields["id"]           ; ok { cat.Id          = id.(string)                            }
+  if media_type,   ok := fields["media_type"]   ; ok { cat.MediaType   = CategoryMediaType(media_type.(string)) }
+  if name,         ok := fields["name"]         ; ok { cat.Name        = name.(string)                          }
+  if sort_index,   ok := fields["sort_index"]   ; ok { cat.SortIndex   = sort_index.(int64)                     }
+  if audio_format, ok := fields["audio_format"] ; ok { cat.AudioFormat = AudioFormat(audio_format.(string))     }
+  if volume_id,    ok := fields["volume_id"]    ; ok { cat.VolumeId    = volume_id.(string)                     }
   return nil
 }
 
@@ -234,10 +248,12 @@ func (cat_a *Category) FieldsDifference(other dbRecord) (diff map[string]any, er
   cat_b, b_is_cat := other.(*Category)
   if b_is_cat == false { return diff, ErrInvalidType }
 
-  if cat_a.Id        != cat_b.Id        { diff["id"]         = cat_b.Id                }
-  if cat_a.MediaType != cat_b.MediaType { diff["media_type"] = string(cat_b.MediaType) }
-  if cat_a.Name      != cat_b.Name      { diff["name"]       = cat_b.Name              }
-  if cat_a.SortIndex != cat_b.SortIndex { diff["sort_index"] = cat_b.SortIndex         }
+  if cat_a.Id          != cat_b.Id          { diff["id"]           = cat_b.Id                  }
+  if cat_a.MediaType   != cat_b.MediaType   { diff["media_type"]   = string(cat_b.MediaType)   }
+  if cat_a.Name        != cat_b.Name        { diff["name"]         = cat_b.Name                }
+  if cat_a.SortIndex   != cat_b.SortIndex   { diff["sort_index"]   = cat_b.SortIndex           }
+  if cat_a.AudioFormat != cat_b.AudioFormat { diff["audio_format"] = string(cat_b.AudioFormat) }
+  if cat_a.VolumeId    != cat_b.VolumeId    { diff["volume_id"]    = cat_b.VolumeId            }
 
   return diff, nil
 }
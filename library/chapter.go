@@ -0,0 +1,116 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "regexp"
+  "context"
+  "os/exec"
+  "strings"
+  "strconv"
+  "encoding/json"
+  "path/filepath"
+)
+
+type MetadataChapter struct {
+  Start int64  `json:"start"` // milliseconds
+  End   int64  `json:"end"`   // milliseconds
+  Title string `json:"title"`
+}
+
+var ErrInvalidChapters = fmt.Errorf("invalid chapters")
+
+// chapter titles marking a skippable intro
+var introTitlePattern *regexp.Regexp = regexp.MustCompile(`(?i)^\s*(intro|introduction|opening|opening credits|op)\s*$`)
+
+func (chapter *MetadataChapter) IsIntro() bool {
+  return introTitlePattern.MatchString(chapter.Title)
+}
+
+// Read chapter marks from a media file.
+func FileChaptersList(path string) ([]MetadataChapter, error) {
+  chapters := []MetadataChapter {}
+
+  timeout, cancel := context.WithTimeout(context.Background(), time.Second * 30)
+  defer cancel()
+  output, err := exec.CommandContext(timeout, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_chapters", path).Output()
+  if err != nil { return nil, fmt.Errorf("error getting chapter data: %s", err.Error()) }
+
+  probe := struct {
+    Chapters []struct {
+      StartTime string `json:"start_time"`
+      EndTime   string `json:"end_time"`
+      Tags      struct {
+        Title string `json:"title"`
+      } `json:"tags"`
+    } `json:"chapters"`
+  } {}
+  err = json.Unmarshal(output, &probe)
+  if err != nil { return nil, fmt.Errorf("error parsing chapter data: %s", err.Error()) }
+
+  for _, probe_chapter := range probe.Chapters {
+    start, err := strconv.ParseFloat(probe_chapter.StartTime, 64) ; if err != nil { continue }
+    end,   err := strconv.ParseFloat(probe_chapter.EndTime,   64) ; if err != nil { continue }
+    chapters = append(chapters, MetadataChapter { Start:int64(start * 1000), End:int64(end * 1000), Title:probe_chapter.Tags.Title })
+  }
+  return chapters, nil
+}
+
+// Replace chapter marks, in database and (for video) embedded in media file.
+func (md *Metadata) SetChapters(chapters []MetadataChapter) error {
+  if (md.MediaType != MetadataMediaTypeFileVideo) && (md.MediaType != MetadataMediaTypeFileAudio) { return ErrInvalidChapters }
+  for index, chapter := range chapters {
+    if (chapter.Start < 0) || (chapter.End <= chapter.Start) { return ErrInvalidChapters }
+    if (md.Duration > 0) && (chapter.Start > (md.Duration * 1000)) { return ErrInvalidChapters }
+    if (index > 0) && (chapter.Start < chapters[index - 1].End) { return ErrInvalidChapters }
+  }
+
+  if md.MediaType == MetadataMediaTypeFileVideo {
+    media_path, err := md.DiskPath(MetadataPathTypeMedia)
+    if err != nil { return err }
+    err = chaptersEmbed(media_path, chapters)
+    if err != nil { return fmt.Errorf("error embedding chapters: %s", err.Error()) }
+  }
+
+  chapters_bytes, err := json.Marshal(chapters)
+  if err != nil { return err }
+  err = md.lib().dbRecordPatch(md, map[string]any { "chapters":string(chapters_bytes) })
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
+// Rewrite media file (stream copy), replacing its chapters.
+func chaptersEmbed(media_path string, chapters []MetadataChapter) error {
+  metadata_path := media_path + ".chapters.txt"
+  temp_path     := strings.TrimSuffix(media_path, filepath.Ext(media_path)) + ".chapters" + filepath.Ext(media_path)
+  defer os.Remove(metadata_path)
+  defer os.Remove(temp_path)
+
+  ffmetadata := ";FFMETADATA1\n"
+  for _, chapter := range chapters {
+    ffmetadata += fmt.Sprintf("[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", chapter.Start, chapter.End, chaptersEscape(chapter.Title))
+  }
+  err := os.WriteFile(metadata_path, []byte(ffmetadata), 0660)
+  if err != nil { return err }
+
+  ffmpeg := exec.Command("ffmpeg", "-v", "error",
+    "-i", media_path,
+    "-f", "ffmetadata", "-i", metadata_path,
+    "-map", "0", "-map_metadata:g", "-1", "-map_metadata:s", "-1", "-map_chapters", "1", // a bare "-map_metadata -1" also drops chapter titles
+    "-codec", "copy", "-movflags", "+faststart",
+    "-y", temp_path,
+  )
+  output, err := ffmpeg.CombinedOutput()
+  if err != nil { return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(output))) }
+
+  return os.Rename(temp_path, media_path)
+}
+
+// escape special characters for ffmetadata format
+func chaptersEscape(value string) string {
+  for _, special := range []string { "\\", "=", ";", "#", "\n" } {
+    value = strings.ReplaceAll(value, special, "\\" + special)
+  }
+  return value
+}
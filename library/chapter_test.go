@@ -0,0 +1,43 @@
+package library
+
+import (
+  "os/exec"
+  "path/filepath"
+  "testing"
+)
+
+func TestChaptersEmbed(test *testing.T) {
+  test.Parallel()
+  if _, err := exec.LookPath("ffmpeg"); err != nil { test.Skip("ffmpeg not installed") }
+
+  // short video with a global title tag, which embedding should strip
+  media_path := filepath.Join(test.TempDir(), "chapters.mp4")
+  output, err := exec.Command("ffmpeg", "-v", "error",
+    "-f", "lavfi", "-i", "testsrc=duration=6:size=160x120:rate=10",
+    "-codec:v", "mpeg4", "-metadata", "title=Stripped",
+    "-y", media_path,
+  ).CombinedOutput()
+  if err != nil { test.Fatalf("TestChaptersEmbed: creating media failed: %s: %s", err, output) }
+
+  chapters := []MetadataChapter {
+    MetadataChapter { Start:0,    End:2000, Title:"Intro"         },
+    MetadataChapter { Start:2000, End:6000, Title:"Part=1; Act #2" },
+  }
+  err = chaptersEmbed(media_path, chapters)
+  if err != nil { test.Fatalf("TestChaptersEmbed: chaptersEmbed failed: %s", err) }
+
+  // titles (including escaped characters) survive, and intro is still detected
+  embedded, err := FileChaptersList(media_path)
+  if err != nil { test.Fatalf("TestChaptersEmbed: FileChaptersList failed: %s", err) }
+  if len(embedded) != len(chapters) { test.Fatalf("TestChaptersEmbed: expected %d chapters, found %d", len(chapters), len(embedded)) }
+  for index := range chapters {
+    if embedded[index].Title != chapters[index].Title { test.Fatalf("TestChaptersEmbed: chapter %d title \"%s\", expected \"%s\"", index, embedded[index].Title, chapters[index].Title) }
+    if embedded[index].Start != chapters[index].Start { test.Fatalf("TestChaptersEmbed: chapter %d starts at %d, expected %d", index, embedded[index].Start, chapters[index].Start) }
+  }
+  if !embedded[0].IsIntro() || embedded[1].IsIntro() { test.Fatalf("TestChaptersEmbed: intro not detected from embedded titles") }
+
+  // global tags are still dropped
+  tags, err := exec.Command("ffprobe", "-v", "quiet", "-show_entries", "format_tags=title", "-of", "default=noprint_wrappers=1:nokey=1", media_path).Output()
+  if err != nil { test.Fatalf("TestChaptersEmbed: ffprobe failed: %s", err) }
+  if len(tags) > 0 { test.Fatalf("TestChaptersEmbed: global title tag not stripped: %s", tags) }
+}
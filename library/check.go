@@ -0,0 +1,333 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "time"
+  "strings"
+  "io/fs"
+  "path/filepath"
+)
+
+// Consistency check between media path & database.
+
+type CheckIssueType string
+const (
+  CheckIssueOrphanFile       CheckIssueType = "orphan_file"       // file on disk belonging to no record
+  CheckIssueOrphanDirectory  CheckIssueType = "orphan_directory"  // directory on disk belonging to no category/record (deleted series, etc.)
+  CheckIssueMissingFile      CheckIssueType = "missing_file"      // file record whose media file is missing
+  CheckIssueMissingDirectory CheckIssueType = "missing_directory" // category/container record whose directory is missing
+  CheckIssueMissingParent    CheckIssueType = "missing_parent"    // record whose parent_id points at nothing
+  CheckIssueMissingMetadata  CheckIssueType = "missing_metadata"  // input file transcoded successfully, without a metadata record
+  CheckIssueMissingVolume    CheckIssueType = "missing_volume"    // storage volume not available (unmounted disk?); its contents aren't checked
+)
+
+type CheckIssue struct {
+  Type        CheckIssueType `json:"type"`
+  Path        string         `json:"path"`      // on disk, if any
+  RecordId    string         `json:"record_id"` // of related record, if any
+  Detail      string         `json:"detail"`
+  Repaired    bool           `json:"repaired"`
+  RepairError string         `json:"repair_error"`
+}
+
+type CheckReport struct {
+  TimeStarted int64        `json:"time_started"`
+  Repair      bool         `json:"repair"`
+  Issues      []CheckIssue `json:"issues"`
+}
+
+// Issues remaining (found, and not repaired).
+func (report *CheckReport) Unresolved() int {
+  count := 0
+  for _, issue := range report.Issues {
+    if !issue.Repaired { count += 1 }
+  }
+  return count
+}
+
+// Walk volumes & tables, reporting inconsistencies. With repair, safe fixes are applied:
+// orphaned media files are adopted into Lost Items (empty parent), records with missing parents are moved to Lost Items (files too),
+// file records with missing media are removed, and missing directories are recreated.
+func (lib *Library) Check(repair bool) (*CheckReport, error) {
+  err := lib.Ready()
+  if err != nil { return nil, err }
+  report := CheckReport { TimeStarted:time.Now().Unix(), Repair:repair, Issues:[]CheckIssue {} }
+  add := func(issue CheckIssue, repair_err error) {
+    if repair {
+      issue.Repaired = (repair_err == nil)
+      if repair_err != nil { issue.RepairError = repair_err.Error() }
+    }
+    report.Issues = append(report.Issues, issue)
+  }
+
+  categories, err := lib.CategoryList()
+  if err != nil { return nil, err }
+  records, err := lib.dbRecordWhere(&Metadata{}, ``)
+  if err != nil { return nil, ErrQueryFailed }
+  inputs, err := lib.InputFileList()
+  if err != nil { return nil, err }
+  volumes, err := lib.VolumeList()
+  if err != nil { return nil, err }
+
+  // unavailable volumes are reported, and everything on them left alone (rather than "repaired" as missing)
+  offline_paths := []string {}
+  for _, vol := range volumes[1:] {
+    if pathIsDirectory(vol.Path) { continue }
+    offline_paths = append(offline_paths, vol.Path)
+    add(CheckIssue { Type:CheckIssueMissingVolume, Path:vol.Path, RecordId:vol.Id, Detail:fmt.Sprintf("volume \"%s\" not available", vol.Name) }, fmt.Errorf("volume must be remounted, or its categories moved"))
+  }
+  offline := func(path string) bool {
+    for _, offline_path := range offline_paths { if pathContains(offline_path, path) { return true } }
+    return false
+  }
+
+  category_by_id := map[string]*Category {}
+  for index := range categories { category_by_id[categories[index].Id] = &(categories[index]) }
+  metadata_by_id := map[string]*Metadata {}
+  for _, record := range records { md := record.(*Metadata) ; metadata_by_id[md.Id] = md }
+
+  // dangling parents; moved to Lost Items, along with their files (found by name, wherever the missing parent left them)
+  dangling := []*Metadata {}
+  for _, md := range metadata_by_id {
+    if md.ParentId == "" { continue }
+    if (category_by_id[md.ParentId] != nil) || (metadata_by_id[md.ParentId] != nil) { continue }
+    dangling = append(dangling, md)
+  }
+  located := map[string][]string {}
+  if repair && (len(dangling) > 0) {
+    located, err = checkLocate(dangling, volumes, offline, lib.checkBasePaths(category_by_id, metadata_by_id), metadata_by_id)
+    if err != nil { return &report, err }
+  }
+  for _, md := range dangling {
+    issue := CheckIssue { Type:CheckIssueMissingParent, RecordId:md.Id, Detail:fmt.Sprintf("\"%s\" has missing parent \"%s\"", md.NameDisplay, md.ParentId) }
+    var repair_err error
+    if repair { repair_err = lib.checkReparentLost(md, located[md.Id]) }
+    add(issue, repair_err)
+  }
+
+  // expected paths; directories to descend into, and directories owned whole by a record
+  base_paths := lib.checkBasePaths(category_by_id, metadata_by_id)
+  expected_files := map[string]bool {}
+  expected_dirs  := map[string]bool {}
+  expected_whole := map[string]bool {}
+  for _, cat := range category_by_id {
+    if offline(cat.DiskPath()) { continue }
+    expected_dirs[cat.DiskPath()]     = true
+    expected_whole[cat.ArtworkPath()] = true
+    if !pathIsDirectory(cat.DiskPath()) {
+      issue := CheckIssue { Type:CheckIssueMissingDirectory, Path:cat.DiskPath(), RecordId:cat.Id, Detail:fmt.Sprintf("category \"%s\" directory missing", cat.Name) }
+      var repair_err error
+      if repair { repair_err = os.MkdirAll(cat.DiskPath(), 0770) }
+      add(issue, repair_err)
+    }
+  }
+  for _, md := range metadata_by_id {
+    base, ok := base_paths[md.Id]
+    if !ok { continue } // unresolvable (parent missing, and not repaired)
+    if offline(base) { continue }
+    is_file := (md.MediaType == MetadataMediaTypeFileVideo) || (md.MediaType == MetadataMediaTypeFileAudio)
+    for _, path_type := range metadataFilePathTypes {
+      path := base + metadataPathSuffix(md, path_type)
+      switch {
+        case (path_type == MetadataPathTypeMedia) && !is_file : expected_dirs[path]  = true
+        case path_type == MetadataPathTypeMedia               : expected_files[path] = true
+        default                                               : expected_whole[path] = true
+      }
+    }
+
+    media_path := base + metadataPathSuffix(md, MetadataPathTypeMedia)
+    if is_file && !pathExists(media_path) {
+      issue := CheckIssue { Type:CheckIssueMissingFile, Path:media_path, RecordId:md.Id, Detail:fmt.Sprintf("\"%s\" media file missing", md.NameDisplay) }
+      var repair_err error
+      if repair { repair_err = lib.MetadataDelete(md, false) }
+      add(issue, repair_err)
+    } else if !is_file && !pathIsDirectory(media_path) {
+      issue := CheckIssue { Type:CheckIssueMissingDirectory, Path:media_path, RecordId:md.Id, Detail:fmt.Sprintf("\"%s\" directory missing", md.NameDisplay) }
+      var repair_err error
+      if repair { repair_err = os.MkdirAll(media_path, 0770) }
+      add(issue, repair_err)
+    }
+  }
+
+  // input files that completed, without a record (other grouped parts have no record of their own)
+  for index := range inputs {
+    inp := &(inputs[index])
+    if (inp.TranscodingTimeStarted == 0) || (inp.TranscodingTimeElapsed == 0) || (inp.TranscodingError != "") { continue }
+    if (inp.GroupId != "") && !inp.IsGroupLeader() { continue }
+    if metadata_by_id[inp.Id] != nil { continue }
+    issue := CheckIssue { Type:CheckIssueMissingMetadata, RecordId:inp.Id, Detail:fmt.Sprintf("\"%s\" transcoded, but has no metadata record (repair queues it again)", inp.SourceLocation) }
+    var repair_err error
+    if repair { repair_err = lib.dbTransaction(func(tx *dbTx) error { return inputFileStatusClear(tx, inp) }) }
+    add(issue, repair_err)
+  }
+
+  // transcodes in progress write into media root
+  for index := range inputs {
+    _, _, output_path := inputs[index].OutputNames()
+    expected_files[output_path] = true
+  }
+
+  // anything else on disk (on any available volume) is orphaned
+  orphan_dirs := []string {}
+  volume_roots := map[string]bool {}
+  for _, vol := range volumes { volume_roots[vol.Path] = true }
+  walk := func(path string, entry fs.DirEntry, err error) error {
+    if err != nil { return err }
+    if volume_roots[path] { return nil }
+    if expected_whole[path] {
+      if entry.IsDir() { return filepath.SkipDir }
+      return nil
+    }
+    if entry.IsDir() {
+      if expected_dirs[path] { return nil }
+      orphan_dirs = append(orphan_dirs, path)
+      return nil
+    }
+    if expected_files[path] || strings.HasSuffix(path, ".tagging") { return nil }
+
+    issue := CheckIssue { Type:CheckIssueOrphanFile, Path:path, Detail:"file belongs to no record" }
+    var repair_err error
+    if repair {
+      md, adopt_err := lib.checkAdopt(path)
+      if adopt_err == nil { issue.RecordId = md.Id }
+      repair_err = adopt_err
+    }
+    add(issue, repair_err)
+    return nil
+  }
+  for _, vol := range volumes {
+    if offline(vol.Path) { continue }
+    err = filepath.WalkDir(vol.Path, walk)
+    if err != nil { return &report, err }
+  }
+
+  // orphaned directories, deepest first; removed if empty (after adopting their media)
+  sort.Sort(sort.Reverse(sort.StringSlice(orphan_dirs)))
+  for _, path := range orphan_dirs {
+    issue := CheckIssue { Type:CheckIssueOrphanDirectory, Path:path, Detail:"directory belongs to no category or record" }
+    var repair_err error
+    if repair { repair_err = os.Remove(path) }
+    add(issue, repair_err)
+  }
+
+  return &report, nil
+}
+
+// ============================================================================
+// private utilities
+
+// Base disk path (no suffix) of every record that resolves up to media root.
+func (lib *Library) checkBasePaths(category_by_id map[string]*Category, metadata_by_id map[string]*Metadata) map[string]string {
+  base_paths := map[string]string {}
+  var resolve func(md *Metadata, depth int) (string, bool)
+  resolve = func(md *Metadata, depth int) (string, bool) {
+    if base, ok := base_paths[md.Id]; ok { return base, true }
+    if depth > len(metadata_by_id) { return "", false } // cycle
+    parent_path := lib.mediaRoot()
+    if md.ParentId != "" {
+      if cat := category_by_id[md.ParentId]; cat != nil {
+        parent_path = cat.DiskPath()
+      } else if parent := metadata_by_id[md.ParentId]; parent != nil {
+        parent_base, ok := resolve(parent, depth + 1)
+        if !ok { return "", false }
+        parent_path = parent_base + metadataPathSuffix(parent, MetadataPathTypeMedia)
+      } else {
+        return "", false
+      }
+    }
+    base_paths[md.Id] = filepath.Join(parent_path, md.NameSort)
+    return base_paths[md.Id], true
+  }
+  for _, md := range metadata_by_id { resolve(md, 0) }
+  return base_paths
+}
+
+// Paths (media file, or container directory) on available volumes named like each record's media, by record id;
+// paths of records that resolve are claimed, and not returned.
+func checkLocate(records []*Metadata, volumes []Volume, offline func(string) bool, base_paths map[string]string, metadata_by_id map[string]*Metadata) (map[string][]string, error) {
+  ids_by_name := map[string][]string {}
+  for _, md := range records {
+    name := md.NameSort + metadataPathSuffix(md, MetadataPathTypeMedia)
+    ids_by_name[name] = append(ids_by_name[name], md.Id)
+  }
+  claimed := map[string]bool {}
+  for id, base := range base_paths { claimed[base + metadataPathSuffix(metadata_by_id[id], MetadataPathTypeMedia)] = true }
+
+  located := map[string][]string {}
+  walk := func(path string, entry fs.DirEntry, err error) error {
+    if err != nil { return err }
+    if claimed[path] { return nil }
+    for _, id := range ids_by_name[filepath.Base(path)] { located[id] = append(located[id], path) }
+    return nil
+  }
+  for _, vol := range volumes {
+    if offline(vol.Path) { continue }
+    err := filepath.WalkDir(vol.Path, walk)
+    if err != nil { return nil, err }
+  }
+  return located, nil
+}
+
+// Move a record with a missing parent to Lost Items (empty parent), along with its files, if located in one place.
+func (lib *Library) checkReparentLost(md *Metadata, located []string) error {
+  if len(located) > 1 { return fmt.Errorf("media found at %d paths; left in place", len(located)) }
+  moves := []JournalMove {}
+  if len(located) == 1 {
+    base_before := strings.TrimSuffix(located[0], metadataPathSuffix(md, MetadataPathTypeMedia))
+    base_after  := filepath.Join(lib.mediaRoot(), md.NameSort)
+    if base_before != base_after {
+      if !metadataCanMoveFilesToPath(md, lib.mediaRoot()) { return fmt.Errorf("\"%s\" already exists in media root; left in place", md.NameSort) }
+      for _, path_type := range metadataFilePathTypes {
+        suffix := metadataPathSuffix(md, path_type)
+        moves = append(moves, JournalMove { Source:base_before + suffix, Destination:base_after + suffix })
+      }
+    }
+  }
+  patch := map[string]any { "parent_id":"" }
+  err := lib.journalRun("metadata-reparent", moves, md.TableName(), md.Id, patch)
+  if err != nil { return err }
+  md.FieldsPatch(patch)
+  return nil
+}
+
+// Create a Lost Items record for an orphaned media file, moving it to media root under a free name.
+func (lib *Library) checkAdopt(path string) (*Metadata, error) {
+  extension := filepath.Ext(path)
+  md := Metadata { Extension:extension, Streams:[]FileStream {}, Chapters:[]MetadataChapter {} }
+  if extension == ".mp4" { md.MediaType = MetadataMediaTypeFileVideo }
+  for _, format := range AudioFormats {
+    if extension == format.Extension() { md.MediaType = MetadataMediaTypeFileAudio }
+  }
+  if md.MediaType == "" { return nil, fmt.Errorf("not a media file; left in place") }
+
+  md.NameDisplay = strings.TrimSuffix(filepath.Base(path), extension)
+  name_sort := nameGetSortForDisplay(md.NameDisplay)
+  if name_sort == "" { name_sort = "lost" }
+  for index := 1; ; index++ {
+    md.NameSort = name_sort
+    if index > 1 { md.NameSort = fmt.Sprintf("%s %d", name_sort, index) }
+    records, err := lib.dbRecordWhere(&Metadata{}, `(parent_id = '') AND (name_sort = ?) LIMIT 1;`, md.NameSort)
+    if err != nil { return nil, ErrQueryFailed }
+    if len(records) > 0 { continue }
+    if (filepath.Join(lib.mediaRoot(), md.NameSort) + extension == path) || metadataCanMoveFilesToPath(&md, lib.mediaRoot()) { break }
+  }
+
+  stat, err := os.Stat(path)
+  if err != nil { return nil, err }
+  md.Size = stat.Size()
+  if streams, duration, err := FileStreamsList(path); err == nil { md.Streams, md.Duration = streams, duration }
+
+  destination := filepath.Join(lib.mediaRoot(), md.NameSort) + extension
+  if destination != path {
+    err = pathMove(path, destination)
+    if err != nil { return nil, err }
+  }
+  err = lib.MetadataCreate(&md)
+  if err != nil {
+    if destination != path { pathMove(destination, path) }
+    return nil, err
+  }
+  return &md, nil
+}
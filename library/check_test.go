@@ -0,0 +1,77 @@
+package library
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestCheck(test *testing.T) {
+  test.Parallel()
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
+  if err != nil { test.Fatalf("TestCheck: Open failed: %s", err) }
+  defer lib.Shutdown()
+
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestCheck: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestCheck: MediaPathSet failed: %s", err) }
+
+  // consistent library
+  cat, err := lib.CategoryCreate("Movies", CategoryMediaTypeMovie)
+  if err != nil { test.Fatalf("TestCheck: CategoryCreate failed: %s", err) }
+  present := Metadata { ParentId:cat.Id, MediaType:MetadataMediaTypeFileVideo, NameDisplay:"present", Extension:".mp4", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&present)
+  if err != nil { test.Fatalf("TestCheck: MetadataCreate failed: %s", err) }
+  present_path, _ := present.DiskPath(MetadataPathTypeMedia)
+  os.WriteFile(present_path, []byte("media"), 0660)
+  report, err := lib.Check(false)
+  if err != nil { test.Fatalf("TestCheck: Check failed: %s", err) }
+  if len(report.Issues) != 0 { test.Fatalf("TestCheck: consistent library reported issues: %v", report.Issues) }
+
+  // missing media, dangling parent, orphaned file in orphaned directory, missing category directory
+  missing := Metadata { ParentId:cat.Id, MediaType:MetadataMediaTypeFileVideo, NameDisplay:"missing", Extension:".mp4", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&missing)
+  if err != nil { test.Fatalf("TestCheck: MetadataCreate failed: %s", err) }
+  dangling := Metadata { MediaType:MetadataMediaTypeFileVideo, NameDisplay:"dangling", Extension:".mp4", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&dangling)
+  if err != nil { test.Fatalf("TestCheck: MetadataCreate failed: %s", err) }
+  err = lib.dbRecordPatch(&dangling, map[string]any { "parent_id":"deleted-series" })
+  if err != nil { test.Fatalf("TestCheck: patch failed: %s", err) }
+  os.MkdirAll(filepath.Join(lib.mediaPath, "deleted series"), 0770)
+  os.WriteFile(filepath.Join(lib.mediaPath, "deleted series", "dangling.mp4"), []byte("media"), 0660)
+  os.WriteFile(filepath.Join(lib.mediaPath, "deleted series", "Episode.mp4"), []byte("media"), 0660)
+  empty_cat, err := lib.CategoryCreate("Empty", CategoryMediaTypeMovie)
+  if err != nil { test.Fatalf("TestCheck: CategoryCreate failed: %s", err) }
+  os.Remove(empty_cat.DiskPath())
+
+  report, err = lib.Check(false)
+  if err != nil { test.Fatalf("TestCheck: Check failed: %s", err) }
+  found := map[CheckIssueType]int {}
+  for _, issue := range report.Issues { found[issue.Type] += 1 }
+  if found[CheckIssueMissingFile]      != 1 { test.Fatalf("TestCheck: missing file not reported: %v", report.Issues) }
+  if found[CheckIssueMissingParent]    != 1 { test.Fatalf("TestCheck: missing parent not reported: %v", report.Issues) }
+  if found[CheckIssueMissingDirectory] != 1 { test.Fatalf("TestCheck: missing directory not reported: %v", report.Issues) }
+  if found[CheckIssueOrphanDirectory]  != 1 { test.Fatalf("TestCheck: orphan directory not reported: %v", report.Issues) }
+  if found[CheckIssueOrphanFile]       != 2 { test.Fatalf("TestCheck: orphan files not reported: %v", report.Issues) }
+  if _, err = lib.MetadataRead(missing.Id); err != nil { test.Fatalf("TestCheck: check without repair changed records") }
+
+  // repair, then consistent
+  report, err = lib.Check(true)
+  if err != nil { test.Fatalf("TestCheck: Check repair failed: %s", err) }
+  if report.Unresolved() != 0 { test.Fatalf("TestCheck: repair left issues: %v", report.Issues) }
+  if _, err = lib.MetadataRead(missing.Id); err != ErrNotFound { test.Fatalf("TestCheck: record with missing media not removed") }
+  stored, _ := lib.MetadataRead(dangling.Id)
+  if stored.ParentId != "" { test.Fatalf("TestCheck: dangling record not moved to lost items") }
+  if !pathExists(filepath.Join(lib.mediaPath, "dangling.mp4")) { test.Fatalf("TestCheck: dangling record's media not moved with it") }
+  adopted_id := ""
+  for _, issue := range report.Issues {
+    if (issue.Type == CheckIssueOrphanFile) && (filepath.Base(issue.Path) == "Episode.mp4") { adopted_id = issue.RecordId }
+  }
+  adopted, err := lib.MetadataRead(adopted_id)
+  if (err != nil) || (adopted.NameDisplay != "Episode") || (adopted.ParentId != "") { test.Fatalf("TestCheck: orphaned media not adopted: %v", report.Issues) }
+  report, err = lib.Check(false)
+  if err != nil { test.Fatalf("TestCheck: Check failed: %s", err) }
+  if len(report.Issues) != 0 { test.Fatalf("TestCheck: repaired library reported issues: %v", report.Issues) }
+}
@@ -2,37 +2,40 @@ package library
 
 import (
   "os"
+  "fmt"
+  "os/exec"
   "time"
   "sync"
   "strconv"
+  "strings"
   "math/rand"
+  "database/sql"
+  "path/filepath"
   "testing"
 )
 
-func testConcurrency_Reader(test *testing.T, wait_group *sync.WaitGroup) {
+func testConcurrency_Reader(test *testing.T, lib *Library, wait_group *sync.WaitGroup) {
   defer wait_group.Done()
   time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
-  _, err := InputFileList()
-  if err != nil { test.Fatalf("testConcurrency_Reader: InputFileList failed: %s", err) }
+  _, err := lib.InputFileList()
+  if err != nil { test.Errorf("testConcurrency_Reader: InputFileList failed: %s", err) }
 }
 
-func testConcurrency_Writer(test *testing.T, wait_group *sync.WaitGroup, inp *InputFile) {
+func testConcurrency_Writer(test *testing.T, lib *Library, wait_group *sync.WaitGroup, inp *InputFile) {
   defer wait_group.Done()
   time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
-  err := InputFileDelete(inp)
-  if err != nil { test.Fatalf("testConcurrency_Write: InputFileDelete failed: %s", err) }
+  err := lib.InputFileDelete(inp)
+  if err != nil { test.Errorf("testConcurrency_Write: InputFileDelete failed: %s", err) }
 }
 
 func TestConcurrency(test *testing.T) {
-  testDbPath := "./test.database"
-  _ = os.Remove(testDbPath)
-
-  err := LibraryStartup(testDbPath)
+  test.Parallel()
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
   if err != nil { test.Fatalf("TestConcurrency: Open failed: %s", err) }
-  defer os.Remove(testDbPath)
-  defer LibraryShutdown()
+  defer lib.Shutdown()
 
-  _, err = dbHandle.Exec(`CREATE TABLE input_files (
+  _, err = lib.dbWriter.Exec(`CREATE TABLE input_files (
     id                       TEXT NOT NULL PRIMARY KEY UNIQUE,
     source_location          TEXT NOT NULL UNIQUE,
     source_streams           TEXT NOT NULL,
@@ -42,7 +45,15 @@ func TestConcurrency(test *testing.T) {
     transcoding_command      TEXT NOT NULL,
     transcoding_time_started INTEGER NOT NULL,
     transcoding_time_elapsed INTEGER NOT NULL,
-    transcoding_error        TEXT NOT NULL
+    transcoding_error        TEXT NOT NULL,
+    verification             TEXT NOT NULL DEFAULT '{}',
+    video_processing         TEXT NOT NULL DEFAULT '{}',
+    trim_start               INTEGER NOT NULL DEFAULT 0,
+    trim_end                 INTEGER NOT NULL DEFAULT 0,
+    group_id                 TEXT NOT NULL DEFAULT '',
+    group_index              INTEGER NOT NULL DEFAULT 0,
+    sidecar                  TEXT NOT NULL DEFAULT '{}',
+    category_id              TEXT NOT NULL DEFAULT ''
   );`)
   if err != nil { test.Fatalf("TestConcurrency: CREATE TABLE failed: %s", err) }
 
@@ -61,7 +72,7 @@ func TestConcurrency(test *testing.T) {
       TranscodingTimeElapsed:   int64(index + 1),
       TranscodingError:         "error",
     }
-    err := InputFileCreate(&inps[index])
+    err := lib.InputFileCreate(&inps[index])
     if err != nil { test.Fatalf("TestConcurrency: InputFileCreate failed: %s", err) }
   }
 
@@ -69,91 +80,328 @@ func TestConcurrency(test *testing.T) {
   var wait_group sync.WaitGroup
   for index := 0; index < len(inps); index += 1 {
     wait_group.Add(2)
-    go testConcurrency_Reader(test, &wait_group)
-    go testConcurrency_Writer(test, &wait_group, &(inps[index]))
+    go testConcurrency_Reader(test, lib, &wait_group)
+    go testConcurrency_Writer(test, lib, &wait_group, &(inps[index]))
   }
   wait_group.Wait()
 }
 
 func TestTransactions(test *testing.T) {
-  testDbPath := "./test.database"
-  _ = os.Remove(testDbPath)
-
-  err := LibraryStartup(testDbPath)
+  test.Parallel()
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
   if err != nil { test.Fatalf("TestTransactions: Open failed: %s", err) }
-  defer os.Remove(testDbPath)
-  defer LibraryShutdown()
+  defer lib.Shutdown()
 
-  _, err = dbHandle.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT);`)
+  _, err = lib.dbWriter.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT);`)
   if err != nil { test.Fatalf("TestTransactions: CREATE TABLE failed: %s", err) }
 
   // committed transaction should read back changes
-  err = dbTransactionBegin()
-  if err != nil { test.Fatalf("TestTransactions: TransactionBegin failed: %s", err) }
-  _, err = dbHandle.Exec(`INSERT INTO test (id, name) VALUES (1, "committed");`)
-  err = dbTransactionCommit()
-  if err != nil { test.Fatalf("TestTransactions: TransactionCommit failed: %s", err) }
-  row := dbHandle.QueryRow(`SELECT id, name FROM test WHERE name = 'committed';`)
+  err = lib.dbTransaction(func(tx *dbTx) error {
+    _, err := tx.Exec(`INSERT INTO test (id, name) VALUES (1, "committed");`)
+    if err != nil { return err }
+    _, err = tx.Exec(`INSERT INTO test (id, name) VALUES (3, "also-committed");`)
+    return err
+  })
+  if err != nil { test.Fatalf("TestTransactions: Transaction failed: %s", err) }
+  row := lib.dbHandle.QueryRow(`SELECT id, name FROM test WHERE name = 'committed';`)
   var id int ; var name string
   err = row.Scan(&id, &name)
   if err != nil { test.Fatalf("TestTransactions: SELECT failed for committed transaction: %s", err) }
   if id != 1 { test.Fatalf("TestTransactions: SELECT returned wrong id: %d", id) }
   if name != "committed" { test.Fatalf("TestTransactions: SELECT returned wrong name: %s", name) }
+  row = lib.dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`)
+  err = row.Scan(&name)
+  if err != nil { test.Fatalf("TestTransactions: SELECT failed for second change of committed transaction: %s", err) }
 
-  // rolled back transaction should not read back changes
-  err = dbTransactionBegin()
-  if err != nil { test.Fatalf("TestTransactions: TransactionBegin failed: %s", err) }
-  _, err = dbHandle.Exec(`INSERT INTO test (id, name) VALUES (2, "rollback");`)
-  if err != nil { test.Fatalf("TestTransactions: INSERT failed: %s", err) }
-  err = dbTransactionRollback()
-  if err != nil { test.Fatalf("TestTransactions: TransactionRollback failed: %s", err) }
-  row = dbHandle.QueryRow(`SELECT id, name FROM test WHERE name = 'rollback';`)
+  // transaction returning an error should roll back all of its changes
+  work_err := fmt.Errorf("work failed")
+  err = lib.dbTransaction(func(tx *dbTx) error {
+    _, err := tx.Exec(`INSERT INTO test (id, name) VALUES (2, "rollback");`)
+    if err != nil { return err }
+    _, err = tx.Exec(`UPDATE test SET name = "rollback" WHERE id = 1;`)
+    if err != nil { return err }
+    return work_err
+  })
+  if err != work_err { test.Fatalf("TestTransactions: Transaction returned wrong error: %v", err) }
+  row = lib.dbHandle.QueryRow(`SELECT id, name FROM test WHERE name = 'rollback';`)
   err = row.Scan(&id, &name)
   if err == nil { test.Fatalf("TestTransactions: SELECT returned row for rolled back transaction") }
+
+  // failing statement should roll back earlier changes
+  err = lib.dbTransaction(func(tx *dbTx) error {
+    _, err := tx.Exec(`INSERT INTO test (id, name) VALUES (4, "rollback");`)
+    if err != nil { return err }
+    _, err = tx.Exec(`INSERT INTO test (id, name) VALUES (1, "duplicate");`)
+    return err
+  })
+  if err == nil { test.Fatalf("TestTransactions: Transaction succeeded with duplicate id") }
+  row = lib.dbHandle.QueryRow(`SELECT id FROM test WHERE id = 4;`)
+  err = row.Scan(&id)
+  if err == nil { test.Fatalf("TestTransactions: SELECT returned row for rolled back transaction") }
+
+  // database is usable again after a transaction
+  _, err = lib.dbWriter.Exec(`INSERT INTO test (id, name) VALUES (5, "after");`)
+  if err != nil { test.Fatalf("TestTransactions: INSERT after transaction failed: %s", err) }
 }
 
 func TestBackups(test *testing.T) {
-  testDbPath := "./test.database"
-  _ = os.Remove(testDbPath)
-
-  err := LibraryStartup(testDbPath)
+  test.Parallel()
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
   if err != nil { test.Fatalf("TestBackups: Open failed: %s", err) }
-  defer os.Remove(testDbPath)
-  defer os.Remove(testDbPath + ".bak")
 
-  _, err = dbHandle.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT);`)
+  _, err = lib.dbWriter.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT);`)
   if err != nil { test.Fatalf("TestTransactions: CREATE TABLE failed: %s", err) }
-  _, err = dbHandle.Exec(`INSERT INTO test (id, name) VALUES (3, "three");`)
+  _, err = lib.dbWriter.Exec(`INSERT INTO test (id, name) VALUES (3, "three");`)
   if err != nil { test.Fatalf("TestTransactions: INSERT failed: %s", err) }
-  _, err = dbHandle.Exec(`INSERT INTO test (id, name) VALUES (32, "thirty-two");`)
+  _, err = lib.dbWriter.Exec(`INSERT INTO test (id, name) VALUES (32, "thirty-two");`)
   if err != nil { test.Fatalf("TestTransactions: INSERT failed: %s", err) }
-  LibraryShutdown()
 
-  err = dbBackupCreate()
+  // backup taken while open
+  err = lib.dbBackupCreate()
   if err != nil { test.Fatalf("TestBackups: BackupCreate failed: %s", err) }
 
-  err = LibraryStartup(testDbPath)
-  if err != nil { test.Fatalf("TestBackups: Open failed: %s", err) }
-  row := dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`)
+  row := lib.dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`)
   var name string
   err = row.Scan(&name)
   if err != nil { test.Fatalf("TestBackups: SELECT failed: %s", err) }
   if name != "three" { test.Fatalf("TestBackups: SELECT returned wrong name: %s", name) }
-  _, err = dbHandle.Exec(`UPDATE test SET name = "three-fifty" WHERE id = 3;`)
+  _, err = lib.dbWriter.Exec(`UPDATE test SET name = "three-fifty" WHERE id = 3;`)
   if err != nil { test.Fatalf("TestBackups: UPDATE failed: %s", err) }
-  row = dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`)
+  row = lib.dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`)
   err = row.Scan(&name)
   if err != nil { test.Fatalf("TestBackups: SELECT failed: %s", err) }
   if name != "three-fifty" { test.Fatalf("TestBackups: SELECT returned wrong name: %s", name) }
-  LibraryShutdown()
 
-  err = dbBackupRestore()
+  // refused while another process has the database open
+  other, err := sql.Open("sqlite", testDbPath)
+  if err != nil { test.Fatalf("TestBackups: opening other connection failed: %s", err) }
+  err = other.QueryRow(`SELECT name FROM test WHERE id = 32;`).Scan(&name)
+  if err != nil { test.Fatalf("TestBackups: SELECT (other connection) failed: %s", err) }
+  err = lib.dbBackupRestore()
+  if err != ErrDbInUse { test.Fatalf("TestBackups: BackupRestore with other connection open returned %v", err) }
+  err = lib.dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`).Scan(&name)
+  if err != nil { test.Fatalf("TestBackups: database not reopened after refused restore: %s", err) }
+  other.Close()
+
+  // restore reopens database
+  err = lib.dbBackupRestore()
   if err != nil { test.Fatalf("TestBackups: BackupRestore failed: %s", err) }
-  err = LibraryStartup(testDbPath)
-  if err != nil { test.Fatalf("TestBackups: Open failed: %s", err) }
-  row = dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`)
+  row = lib.dbHandle.QueryRow(`SELECT name FROM test WHERE id = 3;`)
   err = row.Scan(&name)
   if err != nil { test.Fatalf("TestBackups: SELECT failed: %s", err) }
   if name != "three" { test.Fatalf("TestBackups: SELECT returned wrong name: %s", name) }
-  LibraryShutdown()
+  lib.Shutdown()
+}
+
+func TestLibraries(test *testing.T) {
+  test.Parallel()
+  lib_a, err := LibraryOpen(filepath.Join(test.TempDir(), "a.database"))
+  if err != nil { test.Fatalf("TestLibraries: Open failed: %s", err) }
+  defer lib_a.Shutdown()
+  lib_b, err := LibraryOpen(filepath.Join(test.TempDir(), "b.database"))
+  if err != nil { test.Fatalf("TestLibraries: Open failed: %s", err) }
+  defer lib_b.Shutdown()
+
+  for _, lib := range []*Library { lib_a, lib_b } {
+    err = lib.MigrateToLatest()
+    if err != nil { test.Fatalf("TestLibraries: MigrateToLatest failed: %s", err) }
+    err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+    if err != nil { test.Fatalf("TestLibraries: MediaPathSet failed: %s", err) }
+  }
+
+  // same names in each library, without collision
+  cat_a, err := lib_a.CategoryCreate("Movies", CategoryMediaTypeMovie)
+  if err != nil { test.Fatalf("TestLibraries: CategoryCreate failed: %s", err) }
+  cat_b, err := lib_b.CategoryCreate("Movies", CategoryMediaTypeMovie)
+  if err != nil { test.Fatalf("TestLibraries: CategoryCreate failed: %s", err) }
+  if cat_a.DiskPath() == cat_b.DiskPath() { test.Fatalf("TestLibraries: categories share a disk path: %s", cat_a.DiskPath()) }
+  if _, err = lib_b.CategoryRead(cat_a.Id); err == nil { test.Fatalf("TestLibraries: category read from other library") }
+
+  // records use the library they were read from
+  md := Metadata { ParentId:cat_b.Id, MediaType:MetadataMediaTypeFileVideo, NameDisplay:"Movie", Extension:".mp4", Streams:[]FileStream {} }
+  err = lib_b.MetadataCreate(&md)
+  if err != nil { test.Fatalf("TestLibraries: MetadataCreate failed: %s", err) }
+  stored, err := lib_b.MetadataRead(md.Id)
+  if err != nil { test.Fatalf("TestLibraries: MetadataRead failed: %s", err) }
+  err = stored.Rename("Film", "")
+  if err != nil { test.Fatalf("TestLibraries: Rename failed: %s", err) }
+  if stored, _ = lib_b.MetadataRead(md.Id); stored.NameSort != "film" { test.Fatalf("TestLibraries: Rename not applied to own library") }
+  if lib_a.MetadataIdExists(md.Id) { test.Fatalf("TestLibraries: record visible in other library") }
+
+  // media path moved by another process (same database) is picked up once the cached value expires
+  lib_other, err := LibraryOpen(lib_b.dbPath)
+  if err != nil { test.Fatalf("TestLibraries: Open (other process) failed: %s", err) }
+  defer lib_other.Shutdown()
+  moved_path := filepath.Join(test.TempDir(), "moved")
+  err = lib_other.MediaPathSet(moved_path)
+  if err != nil { test.Fatalf("TestLibraries: MediaPathSet (other process) failed: %s", err) }
+  lib_b.mediaPathRead = time.Now().Add(-mediaPathTtl)
+  if path, _ := stored.DiskPath(MetadataPathTypeMedia); !pathContains(moved_path, path) { test.Fatalf("TestLibraries: moved media path not picked up: %s", path) }
+}
+
+func TestBackupRotation(test *testing.T) {
+  test.Parallel()
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
+  if err != nil { test.Fatalf("TestBackupRotation: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestBackupRotation: MigrateToLatest failed: %s", err) }
+
+  // rotated to retention count, newest first
+  if !lib.BackupDue() { test.Fatalf("TestBackupRotation: first backup not due") }
+  err = lib.PropertySet("backup_retention", "2")
+  if err != nil { test.Fatalf("TestBackupRotation: PropertySet failed: %s", err) }
+  names := []string {}
+  for index := 0; index < 3; index++ {
+    backup, err := lib.BackupCreate()
+    if err != nil { test.Fatalf("TestBackupRotation: BackupCreate failed: %s", err) }
+    names = append(names, backup.Name)
+  }
+  backups, err := lib.BackupList()
+  if err != nil { test.Fatalf("TestBackupRotation: BackupList failed: %s", err) }
+  if (len(backups) != 2) || (backups[0].Name != names[2]) || (backups[1].Name != names[1]) { test.Fatalf("TestBackupRotation: unexpected backups: %v (created %v)", backups, names) }
+  if lib.BackupDue() { test.Fatalf("TestBackupRotation: backup due right after backing up") }
+
+  // restore, keeping current database aside
+  _, err = lib.dbWriter.Exec(`INSERT INTO properties (key, value) VALUES ('after_backup', 'yes');`)
+  if err != nil { test.Fatalf("TestBackupRotation: INSERT failed: %s", err) }
+  err = lib.BackupRestore(backups[0].Path)
+  if err != nil { test.Fatalf("TestBackupRotation: BackupRestore failed: %s", err) }
+  if _, err = lib.PropertyGet("after_backup"); err != ErrNotFound { test.Fatalf("TestBackupRotation: restore didn't replace database") }
+  if !pathExists(testDbPath + ".pre-restore") { test.Fatalf("TestBackupRotation: database before restore not kept") }
+
+  // damaged backups are refused
+  damaged_path := filepath.Join(test.TempDir(), "damaged.db")
+  os.WriteFile(damaged_path, []byte("not a database"), 0660)
+  if lib.BackupRestore(damaged_path) == nil { test.Fatalf("TestBackupRotation: damaged backup restored") }
+}
+
+func TestMigrations(test *testing.T) {
+  test.Parallel()
+  lib, err := LibraryOpen(filepath.Join(test.TempDir(), "test.database"))
+  if err != nil { test.Fatalf("TestMigrations: Open failed: %s", err) }
+  defer lib.Shutdown()
+
+  // dry run leaves database untouched, and reports new tables
+  err = lib.MigrateTo(11)
+  if err != nil { test.Fatalf("TestMigrations: MigrateTo failed: %s", err) }
+  dry_run, err := lib.MigrateDryRun(MigrationLevelLatest())
+  if err != nil { test.Fatalf("TestMigrations: MigrateDryRun failed: %s", err) }
+  if lib.MigrationLevelGet() != 11 { test.Fatalf("TestMigrations: dry run changed level") }
+  found := map[string]string {}
+  for _, change := range dry_run.Changes { found[change.Type + "/" + change.Name] = change.Change }
+  if (found["table/volumes"] != "added") || (found["table/categories"] != "changed") { test.Fatalf("TestMigrations: unexpected dry run changes: %v", dry_run.Changes) }
+
+  // each level recorded, with checksum of resulting schema
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestMigrations: MigrateToLatest failed: %s", err) }
+  history, err := lib.MigrationHistory()
+  if err != nil { test.Fatalf("TestMigrations: MigrationHistory failed: %s", err) }
+  if (len(history) != int(MigrationLevelLatest())) || (history[len(history) - 1].Level != MigrationLevelLatest()) { test.Fatalf("TestMigrations: unexpected history: %v", history) }
+  checksum, err := lib.SchemaChecksum()
+  if (err != nil) || (checksum != history[len(history) - 1].Checksum) { test.Fatalf("TestMigrations: checksum mismatch: %s, %v", checksum, err) }
+  if history[len(history) - 2].Checksum == checksum { test.Fatalf("TestMigrations: schema change not reflected in checksum") }
+  if err = lib.MigrationCheckLatest(); err != nil { test.Fatalf("TestMigrations: MigrationCheckLatest failed at latest: %s", err) }
+
+  // dry run doesn't recover (the copy's) journal, which would move this library's files
+  moved_path := filepath.Join(test.TempDir(), "moved")
+  os.WriteFile(moved_path, []byte("media"), 0660)
+  entry := JournalEntry {
+    Operation:"metadata-rename", Status:JournalStatusPending, RecordTable:"metadata", RecordId:"none",
+    Moves:[]JournalMove { { Source:filepath.Join(test.TempDir(), "original"), Destination:moved_path } }, Patch:map[string]any {},
+  }
+  err = lib.dbRecordCreate(&entry)
+  if err != nil { test.Fatalf("TestMigrations: creating journal entry failed: %s", err) }
+  _, err = lib.MigrateDryRun(MigrationLevelLatest())
+  if err != nil { test.Fatalf("TestMigrations: MigrateDryRun failed: %s", err) }
+  if !pathExists(moved_path) { test.Fatalf("TestMigrations: dry run undid a journal entry") }
+  lib.dbRecordDelete(&entry)
+
+  // down, then history trimmed
+  err = lib.MigrateTo(11)
+  if err != nil { test.Fatalf("TestMigrations: MigrateTo down failed: %s", err) }
+  history, _ = lib.MigrationHistory()
+  if history[len(history) - 1].Level != 11 { test.Fatalf("TestMigrations: history not trimmed: %v", history) }
+  if err = lib.MigrationCheckLatest(); (err == nil) || !strings.Contains(err.Error(), ErrDbOlder.Error()) { test.Fatalf("TestMigrations: older database not refused: %v", err) }
+
+  // databases from newer programs are refused
+  err = lib.dbPropertyUpsert("migration_level", strconv.FormatUint(uint64(MigrationLevelLatest() + 1), 10))
+  if err != nil { test.Fatalf("TestMigrations: dbPropertyUpsert failed: %s", err) }
+  if err = lib.MigrateToLatest(); (err == nil) || !strings.Contains(err.Error(), ErrDbNewer.Error()) { test.Fatalf("TestMigrations: newer database not refused: %v", err) }
+  if err = lib.MigrationCheckLatest(); (err == nil) || !strings.Contains(err.Error(), ErrDbNewer.Error()) { test.Fatalf("TestMigrations: newer database not refused: %v", err) }
+}
+
+// Worker for TestMultiProcess, run in a child process (skipped otherwise).
+func TestMultiProcessWorker(test *testing.T) {
+  db_path, worker := os.Getenv("STARKISS_STRESS_DB"), os.Getenv("STARKISS_STRESS_WORKER")
+  if db_path == "" { test.Skip("only run as TestMultiProcess child") }
+  lib, err := LibraryOpen(db_path)
+  if err != nil { test.Fatalf("TestMultiProcessWorker: Open failed: %s", err) }
+  defer lib.Shutdown()
+
+  for index := 0; index < testStressWrites; index++ {
+    inp := InputFile { SourceLocation:fmt.Sprintf("%s-%d", worker, index), SourceStreams:[]FileStream {}, StreamMap:[]int64 {} }
+    err = lib.InputFileCreate(&inp)
+    if err != nil { test.Fatalf("TestMultiProcessWorker: InputFileCreate failed: %s", err) }
+    err = lib.dbRecordPatch(&inp, map[string]any { "transcoding_error":"patched" })
+    if err != nil { test.Fatalf("TestMultiProcessWorker: patch failed: %s", err) }
+    err = lib.dbTransaction(func(tx *dbTx) error {
+      return tx.RecordCreate(&InputFile { SourceLocation:fmt.Sprintf("%s-%d-tx", worker, index), SourceStreams:[]FileStream {}, StreamMap:[]int64 {} })
+    })
+    if err != nil { test.Fatalf("TestMultiProcessWorker: transaction failed: %s", err) }
+    _, err = lib.InputFileList()
+    if err != nil { test.Fatalf("TestMultiProcessWorker: InputFileList failed: %s", err) }
+  }
+}
+
+const testStressWorkers int = 4
+const testStressWrites  int = 50
+
+// Separate processes (like scanner, transcoder, & server) writing & reading the same database at once.
+func TestMultiProcess(test *testing.T) {
+  if os.Getenv("STARKISS_STRESS_DB") != "" { test.Skip("child process") }
+  test.Parallel()
+  db_path := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(db_path)
+  if err != nil { test.Fatalf("TestMultiProcess: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestMultiProcess: MigrateToLatest failed: %s", err) }
+
+  workers := []*exec.Cmd {}
+  outputs := []*strings.Builder {}
+  for index := 0; index < testStressWorkers; index++ {
+    output := &strings.Builder {}
+    worker := exec.Command(os.Args[0], "-test.run=^TestMultiProcessWorker$", "-test.count=1")
+    worker.Env = append(os.Environ(), "STARKISS_STRESS_DB=" + db_path, fmt.Sprintf("STARKISS_STRESS_WORKER=worker%d", index))
+    worker.Stdout, worker.Stderr = output, output
+    err = worker.Start()
+    if err != nil { test.Fatalf("TestMultiProcess: worker start failed: %s", err) }
+    workers = append(workers, worker)
+    outputs = append(outputs, output)
+  }
+
+  // this process reads throughout
+  done := make(chan bool)
+  go func() { for _, worker := range workers { worker.Wait() } ; close(done) }()
+  for reading := true; reading; {
+    select {
+      case <-done: reading = false
+      default:
+        if _, err := lib.InputFileList(); err != nil { test.Fatalf("TestMultiProcess: InputFileList failed: %s", err) }
+    }
+  }
+  for index, worker := range workers {
+    if !worker.ProcessState.Success() { test.Fatalf("TestMultiProcess: worker %d failed:\n%s", index, outputs[index].String()) }
+  }
+
+  inps, err := lib.InputFileList()
+  if err != nil { test.Fatalf("TestMultiProcess: InputFileList failed: %s", err) }
+  if len(inps) != (testStressWorkers * testStressWrites * 2) { test.Fatalf("TestMultiProcess: expected %d input files, found %d", testStressWorkers * testStressWrites * 2, len(inps)) }
+  for _, inp := range inps {
+    if !strings.HasSuffix(inp.SourceLocation, "-tx") && (inp.TranscodingError != "patched") { test.Fatalf("TestMultiProcess: patch lost: %s", inp.SourceLocation) }
+  }
 }
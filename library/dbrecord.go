@@ -20,11 +20,65 @@ type dbRecord interface {
   FieldsReplace(fields map[string]any) (err error)                  // write full set of field:values to struct
   FieldsPatch(fields map[string]any) (err error)                    // write partial set of field:values to struct
   FieldsDifference(other dbRecord) (diff map[string]any, err error) // compare field:values
+
+  setLibrary(lib *Library)                                          // bind to library record was read from/created in
+}
+
+// Anything queries can run on: the database handle, or a transaction.
+type dbQueryer interface {
+  Exec(query string, args ...any) (sql.Result, error)
+  Query(query string, args ...any) (*sql.Rows, error)
+  QueryRow(query string, args ...any) *sql.Row
+}
+
+// A unit of work (see dbTransaction): records created/changed/deleted through it are committed, or rolled back, together.
+// Records patched/replaced through it are updated in memory immediately; after a rollback, re-read them.
+type dbTx struct {
+  tx  *sql.Tx
+  lib *Library
+}
+
+func (tx *dbTx) RecordCreate(record dbRecord) error                                           { return tx.lib.dbRecordCreateOn(tx.tx, record)                   }
+func (tx *dbTx) RecordDelete(record dbRecord) error                                           { return tx.lib.dbRecordDeleteOn(tx.tx, record)                   }
+func (tx *dbTx) RecordReplace(current dbRecord, proposed dbRecord) error                      { return tx.lib.dbRecordReplaceOn(tx.tx, current, proposed)       }
+func (tx *dbTx) RecordPatch(current dbRecord, patch map[string]any) error                     { return tx.lib.dbRecordPatchOn(tx.tx, current, patch)            }
+func (tx *dbTx) RecordRead(record dbRecord, id string) error                                  { return tx.lib.dbRecordReadOn(tx.tx, record, id)                 }
+func (tx *dbTx) RecordWhere(record dbRecord, where string, values ...any) ([]dbRecord, error) { return tx.lib.dbRecordWhereOn(tx.tx, record, where, values...) }
+func (tx *dbTx) Exec(query string, args ...any) (sql.Result, error)                           { return tx.tx.Exec(query, args...)                               }
+
+// ============================================================================
+// Outside of a transaction, writes go through the writer connection, reads through the reader pool;
+// each retries while another connection (or process) holds the database busy.
+
+func (lib *Library) dbRecordCreate(record dbRecord) (err error) {
+  return dbRetry(func() error { return lib.dbRecordCreateOn(lib.dbWriter, record) })
+}
+
+func (lib *Library) dbRecordDelete(record dbRecord) (err error) {
+  return dbRetry(func() error { return lib.dbRecordDeleteOn(lib.dbWriter, record) })
+}
+
+func (lib *Library) dbRecordReplace(current dbRecord, proposed dbRecord) (err error) {
+  return dbRetry(func() error { return lib.dbRecordReplaceOn(lib.dbWriter, current, proposed) })
+}
+
+func (lib *Library) dbRecordPatch(current dbRecord, patch map[string]any) (err error) {
+  return dbRetry(func() error { return lib.dbRecordPatchOn(lib.dbWriter, current, patch) })
+}
+
+func (lib *Library) dbRecordRead(record dbRecord, id string) (err error) {
+  return dbRetry(func() error { return lib.dbRecordReadOn(lib.dbHandle, record, id) })
+}
+
+func (lib *Library) dbRecordWhere(record dbRecord, where_string string, where_values ...any) (results []dbRecord, err error) {
+  err = dbRetry(func() (err error) { results, err = lib.dbRecordWhereOn(lib.dbHandle, record, where_string, where_values...) ; return err })
+  return results, err
 }
 
 // ============================================================================
+// Implementations; on the writer, the reader pool, or a transaction (see lib.dbTransaction).
 
-func dbRecordCreate(record dbRecord) (err error) {
+func (lib *Library) dbRecordCreateOn(queryer dbQueryer, record dbRecord) (err error) {
   fields, err := record.FieldsRead()
   if err != nil { return err }
 
@@ -44,25 +98,22 @@ func dbRecordCreate(record dbRecord) (err error) {
   }
 
   query_string := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s);`, record.TableName(), strings.Join(columns, ", "), strings.Join(params, ", "))
-  dbLock.Lock()
-  defer dbLock.Unlock()
-  result, err := dbHandle.Exec(query_string, values...)
+  result, err := queryer.Exec(query_string, values...)
   if err != nil { return err }
   if rows, _ := result.RowsAffected(); rows != 1 { return ErrQueryFailed }
+  record.setLibrary(lib)
   return nil
 }
 
-func dbRecordDelete(record dbRecord) (err error) {
-  dbLock.Lock()
-  defer dbLock.Unlock()
+func (lib *Library) dbRecordDeleteOn(queryer dbQueryer, record dbRecord) (err error) {
   query_string := fmt.Sprintf(`DELETE FROM %s WHERE id = ?;`, record.TableName())
-  result, err := dbHandle.Exec(query_string, record.GetId())
+  result, err := queryer.Exec(query_string, record.GetId())
   if err != nil { return err }
   if rows, _ := result.RowsAffected(); rows != 1 { return ErrQueryFailed }
   return nil
 }
 
-func dbRecordReplace(current dbRecord, proposed dbRecord) (err error) {
+func (lib *Library) dbRecordReplaceOn(queryer dbQueryer, current dbRecord, proposed dbRecord) (err error) {
   difference, err := current.FieldsDifference(proposed)
   if err != nil { return err }
 
@@ -77,10 +128,8 @@ func dbRecordReplace(current dbRecord, proposed dbRecord) (err error) {
   }
   values = append(values, current.GetId())
 
-  dbLock.Lock()
-  defer dbLock.Unlock()
   query_string := fmt.Sprintf(`UPDATE %s SET %s WHERE id = ?;`, current.TableName(), strings.Join(columns, ", "))
-  result, err := dbHandle.Exec(query_string, values...)
+  result, err := queryer.Exec(query_string, values...)
   if err != nil { return err }
   count, err := result.RowsAffected()
   if err != nil { return err }
@@ -90,7 +139,7 @@ func dbRecordReplace(current dbRecord, proposed dbRecord) (err error) {
   return nil
 }
 
-func dbRecordPatch(current dbRecord, patch map[string]any) (err error) {
+func (lib *Library) dbRecordPatchOn(queryer dbQueryer, current dbRecord, patch map[string]any) (err error) {
   if len(patch) == 0 { return nil }
 
   proposed, err := current.RecordCopy()
@@ -105,10 +154,8 @@ func dbRecordPatch(current dbRecord, patch map[string]any) (err error) {
   }
   values = append(values, current.GetId())
 
-  dbLock.Lock()
-  defer dbLock.Unlock()
   query_string := fmt.Sprintf(`UPDATE %s SET %s WHERE id = ?;`, current.TableName(), strings.Join(columns, ", "))
-  result, err := dbHandle.Exec(query_string, values...)
+  result, err := queryer.Exec(query_string, values...)
   if err != nil { return err }
   count, err := result.RowsAffected()
   if err != nil { return err }
@@ -118,7 +165,7 @@ func dbRecordPatch(current dbRecord, patch map[string]any) (err error) {
   return nil
 }
 
-func dbRecordRead(record dbRecord, id string) (err error) {
+func (lib *Library) dbRecordReadOn(queryer dbQueryer, record dbRecord, id string) (err error) {
   fields, err := record.FieldsRead()
   if err != nil { return err }
 
@@ -131,19 +178,18 @@ func dbRecordRead(record dbRecord, id string) (err error) {
   addresses := make([]any, len(values))
   for index := range values { addresses[index] = &(values[index]) }
 
-  dbLock.RLock()
-  defer dbLock.RUnlock()
   query_string := fmt.Sprintf(`SELECT %s FROM %s WHERE id = ?;`, strings.Join(columns, ", "), record.TableName())
-  result := dbHandle.QueryRow(query_string, id)
+  result := queryer.QueryRow(query_string, id)
   err = result.Scan(addresses...)
   if err == sql.ErrNoRows { return ErrNotFound }
   if err != nil { return err }
 
   for index := range values { fields[columns[index]] = values[index] }
+  record.setLibrary(lib)
   return record.FieldsReplace(fields)
 }
 
-func dbRecordWhere(record dbRecord, where_string string, where_values ...any) (results []dbRecord, err error) {
+func (lib *Library) dbRecordWhereOn(queryer dbQueryer, record dbRecord, where_string string, where_values ...any) (results []dbRecord, err error) {
   results = make([]dbRecord, 0)
 
   fields, err := record.FieldsRead()
@@ -158,11 +204,9 @@ func dbRecordWhere(record dbRecord, where_string string, where_values ...any) (r
   addresses := make([]any, len(values))
   for index := range values { addresses[index] = &(values[index]) }
 
-  dbLock.RLock()
-  defer dbLock.RUnlock()
   if where_string != "" { where_string = fmt.Sprintf(`WHERE %s`, where_string) }
   query_string := fmt.Sprintf(`SELECT %s FROM %s %s ;`, strings.Join(columns, ", "), record.TableName(), where_string)
-  rows, err := dbHandle.Query(query_string, where_values...)
+  rows, err := queryer.Query(query_string, where_values...)
   if err != nil { return results, err }
   defer rows.Close()
 
@@ -172,6 +216,7 @@ func dbRecordWhere(record dbRecord, where_string string, where_values ...any) (r
     for index := range values { fields[columns[index]] = values[index] }
     this_result, err := record.RecordCreate(fields)
     if err != nil { return results, err }
+    this_result.setLibrary(lib)
     results = append(results, this_result)
   }
 
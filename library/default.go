@@ -0,0 +1,102 @@
+package library
+
+import "io"
+
+// Package-level interface, operating on the default Library (for single library programs).
+
+// ============================================================================
+// Library Lifecycle
+
+func LibraryStartup(database_path string) error { return defaultLibrary.Startup(database_path) }
+func LibraryReady() error                       { return defaultLibrary.Ready()                }
+func LibraryShutdown()                          { defaultLibrary.Shutdown()                    }
+
+// Default Library, for callers needing an instance.
+func LibraryDefault() *Library { return defaultLibrary }
+
+// ============================================================================
+// Properties & Migrations
+
+func PropertyList() (map[string]string, error)       { return defaultLibrary.PropertyList()         }
+func PropertyGet(key string) (string, error)         { return defaultLibrary.PropertyGet(key)       }
+func PropertySet(key string, value string) error     { return defaultLibrary.PropertySet(key, value) }
+func PropertyDelete(key string) error                { return defaultLibrary.PropertyDelete(key)    }
+func MediaPathGet() (string, error)                  { return defaultLibrary.MediaPathGet()         }
+func MediaPathValid() bool                           { return defaultLibrary.MediaPathValid()       }
+func MediaPathSet(new_path string) error             { return defaultLibrary.MediaPathSet(new_path) }
+func MigrationLevelGet() uint32                      { return defaultLibrary.MigrationLevelGet()    }
+func JwtKeyGet() ([]byte, error)                     { return defaultLibrary.JwtKeyGet()            }
+func MigrateTo(level_target uint32) error            { return defaultLibrary.MigrateTo(level_target) }
+func MigrateToLatest() error                         { return defaultLibrary.MigrateToLatest()      }
+func MigrationCheck() error                          { return defaultLibrary.MigrationCheck()       }
+func MigrationCheckLatest() error                    { return defaultLibrary.MigrationCheckLatest() }
+func MigrationHistory() ([]MigrationRecord, error)   { return defaultLibrary.MigrationHistory()     }
+func MigrateDryRun(level_target uint32) (*MigrationDryRun, error) { return defaultLibrary.MigrateDryRun(level_target) }
+
+// ============================================================================
+// Categories
+
+func CategoryList() ([]Category, error)                                              { return defaultLibrary.CategoryList()                      }
+func CategoryRead(id string) (*Category, error)                                      { return defaultLibrary.CategoryRead(id)                    }
+func CategoryCreate(name string, media_type CategoryMediaType) (*Category, error)    { return defaultLibrary.CategoryCreate(name, media_type)    }
+func CategoryDelete(cat *Category) error                                             { return defaultLibrary.CategoryDelete(cat)                 }
+func CategoryUpdate(cat *Category, name string, media_type string) error             { return defaultLibrary.CategoryUpdate(cat, name, media_type) }
+func CategorySetAudioFormat(cat *Category, format AudioFormat) error                 { return defaultLibrary.CategorySetAudioFormat(cat, format) }
+func CategoryReindex(cat *Category, index int64) error                               { return defaultLibrary.CategoryReindex(cat, index)         }
+func CategoryIdExists(id string) bool                                                { return defaultLibrary.CategoryIdExists(id)                }
+func CategoryNameExists(name string) bool                                            { return defaultLibrary.CategoryNameExists(name)            }
+func CategoryIsEmpty(id string) bool                                                 { return defaultLibrary.CategoryIsEmpty(id)                 }
+func CategoryMoveVolume(cat *Category, volume_id string) error                       { return defaultLibrary.CategoryMoveVolume(cat, volume_id)  }
+
+// ============================================================================
+// Volumes
+
+func VolumeList() ([]Volume, error)                          { return defaultLibrary.VolumeList()             }
+func VolumeRead(id string) (*Volume, error)                  { return defaultLibrary.VolumeRead(id)           }
+func VolumeCreate(name string, path string) (*Volume, error) { return defaultLibrary.VolumeCreate(name, path) }
+func VolumeDelete(vol *Volume) error                         { return defaultLibrary.VolumeDelete(vol)        }
+
+// ============================================================================
+// Metadata
+
+func MetadataRead(id string) (*Metadata, error)                          { return defaultLibrary.MetadataRead(id)                       }
+func PathForId(id string) ([]PathComponent, error)                       { return defaultLibrary.PathForId(id)                          }
+func MetadataForParent(parent_id string) ([]Metadata, error)             { return defaultLibrary.MetadataForParent(parent_id)           }
+func MetadataCreate(md *Metadata) error                                  { return defaultLibrary.MetadataCreate(md)                     }
+func MetadataDelete(md *Metadata, delete_children bool) error            { return defaultLibrary.MetadataDelete(md, delete_children)    }
+func MetadataParentTree(parent_id string) ([]MetadataTreeNode, error)    { return defaultLibrary.MetadataParentTree(parent_id)          }
+func MetadataIdExists(id string) bool                                    { return defaultLibrary.MetadataIdExists(id)                   }
+func MetadataIsEmpty(id string) bool                                     { return defaultLibrary.MetadataIsEmpty(id)                    }
+
+// ============================================================================
+// Input Files
+
+func InputFileCreate(inp *InputFile) error                        { return defaultLibrary.InputFileCreate(inp)                      }
+func InputFileRead(id string) (*InputFile, error)                 { return defaultLibrary.InputFileRead(id)                         }
+func InputFileList() ([]InputFile, error)                         { return defaultLibrary.InputFileList()                           }
+func InputFileDelete(inp *InputFile) error                        { return defaultLibrary.InputFileDelete(inp)                      }
+func InputFileExistsForSource(source_location string) bool        { return defaultLibrary.InputFileExistsForSource(source_location) }
+func InputFileNextForTranscoding() (*InputFile, error)            { return defaultLibrary.InputFileNextForTranscoding()             }
+func InputFileGroupMembers(group_id string) ([]InputFile, error)  { return defaultLibrary.InputFileGroupMembers(group_id)          }
+
+// ============================================================================
+// Journal & Check
+
+func JournalList() ([]JournalEntry, error)       { return defaultLibrary.JournalList()   }
+func JournalRecover() error                      { return defaultLibrary.JournalRecover() }
+func Check(repair bool) (*CheckReport, error)    { return defaultLibrary.Check(repair)   }
+
+// ============================================================================
+// Backups
+
+func BackupCreate() (*Backup, error)          { return defaultLibrary.BackupCreate()             }
+func BackupList() ([]Backup, error)           { return defaultLibrary.BackupList()               }
+func BackupRead(name string) (*Backup, error) { return defaultLibrary.BackupRead(name)           }
+func BackupDue() bool                         { return defaultLibrary.BackupDue()                }
+func BackupRestore(backup_path string) error  { return defaultLibrary.BackupRestore(backup_path) }
+
+// ============================================================================
+// Archives
+
+func ArchiveExport(writer io.Writer) (*ArchiveManifest, error)                               { return defaultLibrary.ArchiveExport(writer)          }
+func ArchiveImport(reader io.Reader, options ArchiveImportOptions) (*ArchiveManifest, error) { return defaultLibrary.ArchiveImport(reader, options) }
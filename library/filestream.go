@@ -4,8 +4,11 @@ import (
   "fmt"
   "time"
   "math"
+  "context"
+  "os/exec"
   "strconv"
   "strings"
+  "encoding/json"
   "github.com/vansante/go-ffprobe"
 )
 
@@ -16,25 +19,41 @@ const (
   FileStreamTypeSubtitle FileStreamType = "subtitle"
 )
 type FileStream struct {
-  StreamType FileStreamType `json:"stream_type"`
-  Index      int64          `json:"index"`
-  Codec      string         `json:"codec"`
-  Width      int64          `json:"width"`
-  Height     int64          `json:"height"`
-  Fps        int64          `json:"fps"`
-  Channels   int64          `json:"channels"`
-  Language   string         `json:"language"`
+  StreamType     FileStreamType `json:"stream_type"`
+  Index          int64          `json:"index"`
+  Codec          string         `json:"codec"`
+  Profile        string         `json:"profile"`
+  Level          int64          `json:"level"`
+  PixelFormat    string         `json:"pixel_format"`
+  BitDepth       int64          `json:"bit_depth"`
+  ColorSpace     string         `json:"color_space"`
+  ColorTransfer  string         `json:"color_transfer"`
+  ColorPrimaries string         `json:"color_primaries"`
+  HdrFormat      string         `json:"hdr_format"`       // "", "hdr10", "hdr10+", "hlg", or "dolby_vision"
+  Width          int64          `json:"width"`
+  Height         int64          `json:"height"`
+  Fps            int64          `json:"fps"`
+  Channels       int64          `json:"channels"`
+  Language       string         `json:"language"`
 }
 func (stream *FileStream) Copy() (*FileStream) {
   copy := FileStream{}
-  copy.StreamType = stream.StreamType
-  copy.Index      = stream.Index
-  copy.Codec      = stream.Codec
-  copy.Width      = stream.Width
-  copy.Height     = stream.Height
-  copy.Fps        = stream.Fps
-  copy.Channels   = stream.Channels
-  copy.Language   = stream.Language
+  copy.StreamType     = stream.StreamType
+  copy.Index          = stream.Index
+  copy.Codec          = stream.Codec
+  copy.Profile        = stream.Profile
+  copy.Level          = stream.Level
+  copy.PixelFormat    = stream.PixelFormat
+  copy.BitDepth       = stream.BitDepth
+  copy.ColorSpace     = stream.ColorSpace
+  copy.ColorTransfer  = stream.ColorTransfer
+  copy.ColorPrimaries = stream.ColorPrimaries
+  copy.HdrFormat      = stream.HdrFormat
+  copy.Width          = stream.Width
+  copy.Height         = stream.Height
+  copy.Fps            = stream.Fps
+  copy.Channels       = stream.Channels
+  copy.Language       = stream.Language
   return &copy
 }
 
@@ -44,18 +63,32 @@ func FileStreamsList(path string) (file_streams []FileStream, duration int64, er
   probe, err := ffprobe.GetProbeData(path, time.Second * 30)
   if err != nil             { return nil, 0, fmt.Errorf("error getting probe data: %s", err.Error()) }
   if len(probe.Streams) < 1 { return nil, 0, fmt.Errorf("no streams found in file \"%s\"", path) }
+  color_info := probeColorInfo(path)
 
   for _, probe_stream := range probe.Streams {
+    // cover art (mp3 apic, mp4 covr, mkv image attachments) isn't a video stream; posters extract it separately
+    if probe_stream.Disposition.AttachedPic == 1 { continue }
+
     if probe_stream.CodecType == "video" {
       video_stream := FileStream{}
-      video_stream.StreamType = FileStreamTypeVideo
-      video_stream.Index      = int64(probe_stream.Index)
-      video_stream.Codec      = probe_stream.CodecName
-      video_stream.Width      = int64(probe_stream.Width)
-      video_stream.Height     = int64(probe_stream.Height)
-      video_stream.Fps        = 0
-      video_stream.Channels   = 0
-      video_stream.Language   = ""
+      video_stream.StreamType     = FileStreamTypeVideo
+      video_stream.Index          = int64(probe_stream.Index)
+      video_stream.Codec          = probe_stream.CodecName
+      video_stream.Profile        = probe_stream.Profile
+      video_stream.Level          = int64(probe_stream.Level)
+      video_stream.PixelFormat    = probe_stream.PixFmt
+      video_stream.BitDepth       = convertBitDepth(probe_stream.BitsPerRawSample, probe_stream.PixFmt)
+      video_stream.Width          = int64(probe_stream.Width)
+      video_stream.Height         = int64(probe_stream.Height)
+      video_stream.Fps            = 0
+      video_stream.Channels       = 0
+      video_stream.Language       = ""
+      if color, ok := color_info[video_stream.Index]; ok {
+        video_stream.ColorSpace     = color.ColorSpace
+        video_stream.ColorTransfer  = color.ColorTransfer
+        video_stream.ColorPrimaries = color.ColorPrimaries
+        video_stream.HdrFormat      = color.hdrFormat()
+      }
 
       r_fps := convertFpsString(probe_stream.RFrameRate)
       a_fps := convertFpsString(probe_stream.AvgFrameRate)
@@ -69,26 +102,27 @@ func FileStreamsList(path string) (file_streams []FileStream, duration int64, er
       streams = append(streams, video_stream)
     } else if probe_stream.CodecType == "audio" {
       audio_stream := FileStream{}
-      audio_stream.StreamType = FileStreamTypeAudio
-      audio_stream.Index      = int64(probe_stream.Index)
-      audio_stream.Codec      = probe_stream.CodecName
-      audio_stream.Width      = 0
-      audio_stream.Height     = 0
-      audio_stream.Fps        = 0
-      audio_stream.Channels   = int64(probe_stream.Channels)
-      audio_stream.Language   = probe_stream.Tags.Language
+      audio_stream.StreamType     = FileStreamTypeAudio
+      audio_stream.Index          = int64(probe_stream.Index)
+      audio_stream.Codec          = probe_stream.CodecName
+      audio_stream.Profile        = probe_stream.Profile
+      audio_stream.Width          = 0
+      audio_stream.Height         = 0
+      audio_stream.Fps            = 0
+      audio_stream.Channels       = int64(probe_stream.Channels)
+      audio_stream.Language       = probe_stream.Tags.Language
 
       streams = append(streams, audio_stream)
     } else if probe_stream.CodecType == "subtitle" {
       subtitle_stream := FileStream{}
-      subtitle_stream.StreamType = FileStreamTypeSubtitle
-      subtitle_stream.Index      = int64(probe_stream.Index)
-      subtitle_stream.Codec      = probe_stream.CodecName
-      subtitle_stream.Width      = 0
-      subtitle_stream.Height     = 0
-      subtitle_stream.Fps        = 0
-      subtitle_stream.Channels   = 0
-      subtitle_stream.Language   = probe_stream.Tags.Language
+      subtitle_stream.StreamType     = FileStreamTypeSubtitle
+      subtitle_stream.Index          = int64(probe_stream.Index)
+      subtitle_stream.Codec          = probe_stream.CodecName
+      subtitle_stream.Width          = 0
+      subtitle_stream.Height         = 0
+      subtitle_stream.Fps            = 0
+      subtitle_stream.Channels       = 0
+      subtitle_stream.Language       = probe_stream.Tags.Language
 
       streams = append(streams, subtitle_stream)
     }
@@ -97,6 +131,48 @@ func FileStreamsList(path string) (file_streams []FileStream, duration int64, er
   return streams, int64(probe.Format.DurationSeconds), nil
 }
 
+func (stream *FileStream) IsHdr() bool {
+  return stream.HdrFormat != ""
+}
+
+// go-ffprobe doesn't expose color transfer/primaries, or side data; so re-probe video streams for these
+type colorProbeStream struct {
+  Index          int64  `json:"index"`
+  ColorSpace     string `json:"color_space"`
+  ColorTransfer  string `json:"color_transfer"`
+  ColorPrimaries string `json:"color_primaries"`
+  SideDataList   []struct {
+    SideDataType string `json:"side_data_type"`
+  } `json:"side_data_list"`
+}
+
+func (color *colorProbeStream) hdrFormat() string {
+  for _, side_data := range color.SideDataList {
+    if strings.HasPrefix(side_data.SideDataType, "DOVI") { return "dolby_vision" }
+    if strings.Contains(side_data.SideDataType, "HDR10+") { return "hdr10+" }
+  }
+  if color.ColorTransfer == "smpte2084"    { return "hdr10" }
+  if color.ColorTransfer == "arib-std-b67" { return "hlg"   }
+  return ""
+}
+
+// Returns map of stream index to color info; empty if probing fails (these values are informational).
+func probeColorInfo(path string) map[int64]colorProbeStream {
+  color_info := map[int64]colorProbeStream {}
+
+  timeout, cancel := context.WithTimeout(context.Background(), time.Second * 30)
+  defer cancel()
+  output, err := exec.CommandContext(timeout, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", "-select_streams", "v", path).Output()
+  if err != nil { return color_info }
+
+  probe := struct { Streams []colorProbeStream `json:"streams"` } {}
+  err = json.Unmarshal(output, &probe)
+  if err != nil { return color_info }
+
+  for _, stream := range probe.Streams { color_info[stream.Index] = stream }
+  return color_info
+}
+
 func convertFpsString(fps_string string) int64 {
   split := strings.Split(fps_string, "/")                ; if len(split) != 2 { return 0 }
   numerator  , err := strconv.ParseInt(split[0], 10, 64) ; if err != nil { return 0 }
@@ -109,3 +185,13 @@ func convertFpsString(fps_string string) int64 {
   if ceiling > 320.0 { return 0 } // found some invalid files with things like 90000/1
   return int64(ceiling)
 }
+
+// bits_per_raw_sample isn't always reported, fall back to guessing from pixel format
+func convertBitDepth(bits_string string, pixel_format string) int64 {
+  bits, err := strconv.ParseInt(bits_string, 10, 64)
+  if (err == nil) && (bits > 0) { return bits }
+  if pixel_format == "" { return 0 }
+  if strings.Contains(pixel_format, "12") { return 12 }
+  if strings.Contains(pixel_format, "10") { return 10 }
+  return 8
+}
@@ -0,0 +1,186 @@
+package library
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "bytes"
+  "strconv"
+  "path/filepath"
+)
+
+// ID3v2.4 tag writing for audio files, from library data.
+// https://id3.org/id3v2.4.0-structure , https://id3.org/id3v2.4.0-frames
+
+var ErrInvalidId3       = fmt.Errorf("invalid id3 tag")
+var ErrTagsUnsupported = fmt.Errorf("tags not supported for this audio format")
+
+const id3HeaderSize = 10
+const id3v1Size     = 128
+
+// Values written into a file's tags. Zero values are omitted.
+type Id3Tags struct {
+  Title       string
+  Artist      string
+  AlbumArtist string
+  Album       string
+  TrackNumber int64
+  DiscNumber  int64
+  Year        int64
+  Cover       []byte // jpeg
+}
+
+// Gather tags for an audio file from its record, and its album/artist ancestors.
+func (md *Metadata) Id3Tags() (*Id3Tags, error) {
+  lib := md.lib()
+  tags := Id3Tags {}
+  tags.Title       = md.NameDisplay
+  tags.TrackNumber = md.TrackNumber
+  tags.DiscNumber  = md.DiscNumber
+  tags.Year        = md.Year
+
+  cover_path, _ := md.DiskPath(MetadataPathTypePosterLarge)
+  parent_id := md.ParentId
+  for (parent_id != "") && !lib.CategoryIdExists(parent_id) {
+    parent, err := lib.MetadataRead(parent_id)
+    if err != nil { return nil, err }
+    switch parent.MediaType {
+      case MetadataMediaTypeAlbum  : tags.Album  = parent.NameDisplay ; if tags.Year == 0 { tags.Year = parent.Year }
+      case MetadataMediaTypeArtist : tags.Artist = parent.NameDisplay
+    }
+    if !pathExists(cover_path) { cover_path, _ = parent.DiskPath(MetadataPathTypePosterLarge) }
+    parent_id = parent.ParentId
+  }
+  tags.AlbumArtist = tags.Artist
+
+  if pathExists(cover_path) {
+    cover, err := os.ReadFile(cover_path)
+    if err != nil { return nil, err }
+    tags.Cover = cover
+  }
+  return &tags, nil
+}
+
+// Write ID3v2.4 tags into this record's media file (replacing any existing ID3v2/ID3v1 tags).
+// Only mp3 files are tagged; other audio formats return ErrTagsUnsupported.
+func (md *Metadata) WriteTags() error {
+  if md.MediaType != MetadataMediaTypeFileAudio { return nil }
+  media_path, err := md.DiskPath(MetadataPathTypeMedia)
+  if err != nil { return err }
+  if filepath.Ext(media_path) != ".mp3" { return ErrTagsUnsupported }
+  if !pathExists(media_path) { return ErrNotFound }
+
+  tags, err := md.Id3Tags()
+  if err != nil { return err }
+  return id3Write(media_path, tags)
+}
+
+// Write tags for a record and all of its descendants (after renaming an album or artist, for example).
+// Files missing from disk, and formats that can't be tagged, are skipped.
+func (md *Metadata) WriteTagsRecursive() error {
+  if (md.MediaType == MetadataMediaTypeFileAudio) || (md.MediaType == MetadataMediaTypeFileVideo) {
+    err := md.WriteTags()
+    if (err == ErrNotFound) || (err == ErrTagsUnsupported) { return nil }
+    return err
+  }
+  children, err := md.lib().MetadataForParent(md.Id)
+  if err != nil { return err }
+  for index := range children {
+    err = children[index].WriteTagsRecursive()
+    if err != nil { return err }
+  }
+  return nil
+}
+
+// Replace tags of an mp3 file, in place (through a temporary file, renamed over the original).
+func id3Write(path string, tags *Id3Tags) error {
+  source, err := os.Open(path)
+  if err != nil { return err }
+  defer source.Close()
+  source_stat, err := source.Stat()
+  if err != nil { return err }
+
+  // audio data lies between any leading ID3v2 tag & trailing ID3v1 tag
+  audio_start, err := id3v2Length(source)
+  if err != nil { return err }
+  audio_end := source_stat.Size()
+  if audio_end - audio_start >= id3v1Size {
+    trailer := make([]byte, 3)
+    _, err = source.ReadAt(trailer, audio_end - id3v1Size)
+    if err != nil { return err }
+    if string(trailer) == "TAG" { audio_end -= id3v1Size }
+  }
+
+  temp_path := path + ".tagging"
+  dest, err := os.Create(temp_path)
+  if err != nil { return err }
+  _, err = dest.Write(id3Encode(tags))
+  if err == nil { _, err = io.Copy(dest, io.NewSectionReader(source, audio_start, audio_end - audio_start)) }
+  if err == nil { err = dest.Sync() }
+  close_err := dest.Close()
+  if err == nil { err = close_err }
+  if err != nil { os.Remove(temp_path) ; return err }
+  return os.Rename(temp_path, path)
+}
+
+// Total length of an ID3v2 tag at start of file (0 if none).
+func id3v2Length(source io.ReaderAt) (int64, error) {
+  header := make([]byte, id3HeaderSize)
+  _, err := source.ReadAt(header, 0)
+  if err == io.EOF { return 0, nil }
+  if err != nil { return 0, err }
+  if string(header[0:3]) != "ID3" { return 0, nil }
+  for _, size_byte := range header[6:10] {
+    if size_byte & 0x80 != 0 { return 0, ErrInvalidId3 }
+  }
+  length := id3HeaderSize + int64(id3SyncsafeDecode(header[6:10]))
+  if header[5] & 0x10 != 0 { length += id3HeaderSize } // footer present
+  return length, nil
+}
+
+func id3Encode(tags *Id3Tags) []byte {
+  var frames bytes.Buffer
+  id3WriteTextFrame(&frames, "TIT2", tags.Title)
+  id3WriteTextFrame(&frames, "TPE1", tags.Artist)
+  id3WriteTextFrame(&frames, "TPE2", tags.AlbumArtist)
+  id3WriteTextFrame(&frames, "TALB", tags.Album)
+  if tags.TrackNumber > 0 { id3WriteTextFrame(&frames, "TRCK", strconv.FormatInt(tags.TrackNumber, 10)) }
+  if tags.DiscNumber  > 0 { id3WriteTextFrame(&frames, "TPOS", strconv.FormatInt(tags.DiscNumber,  10)) }
+  if tags.Year        > 0 { id3WriteTextFrame(&frames, "TDRC", strconv.FormatInt(tags.Year,        10)) }
+  if len(tags.Cover)  > 0 {
+    // encoding (utf-8), mime type, picture type (front cover), description (empty), data
+    payload := []byte { 0x03 }
+    payload  = append(payload, []byte("image/jpeg")...)
+    payload  = append(payload, 0x00, 0x03, 0x00)
+    payload  = append(payload, tags.Cover...)
+    id3WriteFrame(&frames, "APIC", payload)
+  }
+
+  var tag bytes.Buffer
+  tag.WriteString("ID3")
+  tag.Write([]byte { 0x04, 0x00, 0x00 }) // version 2.4.0, no flags
+  tag.Write(id3SyncsafeEncode(uint32(frames.Len())))
+  tag.Write(frames.Bytes())
+  return tag.Bytes()
+}
+
+func id3WriteTextFrame(frames *bytes.Buffer, id string, value string) {
+  if value == "" { return }
+  id3WriteFrame(frames, id, append([]byte { 0x03 }, []byte(value)...)) // utf-8
+}
+
+func id3WriteFrame(frames *bytes.Buffer, id string, payload []byte) {
+  frames.WriteString(id)
+  frames.Write(id3SyncsafeEncode(uint32(len(payload))))
+  frames.Write([]byte { 0x00, 0x00 }) // no flags
+  frames.Write(payload)
+}
+
+// 28 bit integer, as 4 bytes of 7 bits each.
+func id3SyncsafeEncode(value uint32) []byte {
+  return []byte { byte((value >> 21) & 0x7F), byte((value >> 14) & 0x7F), byte((value >> 7) & 0x7F), byte(value & 0x7F) }
+}
+
+func id3SyncsafeDecode(value []byte) uint32 {
+  return (uint32(value[0]) << 21) | (uint32(value[1]) << 14) | (uint32(value[2]) << 7) | uint32(value[3])
+}
@@ -0,0 +1,121 @@
+package library
+
+import (
+  "os"
+  "bytes"
+  "path/filepath"
+  "testing"
+)
+
+func TestId3Syncsafe(test *testing.T) {
+  test.Parallel()
+  for _, value := range []uint32 { 0, 1, 127, 128, 255, 16383, 16384, 0x0FFFFFFF } {
+    encoded := id3SyncsafeEncode(value)
+    for _, encoded_byte := range encoded {
+      if encoded_byte & 0x80 != 0 { test.Fatalf("TestId3Syncsafe: %d encoded with high bit set: %v", value, encoded) }
+    }
+    if id3SyncsafeDecode(encoded) != value { test.Fatalf("TestId3Syncsafe: %d decoded as %d", value, id3SyncsafeDecode(encoded)) }
+  }
+  if !bytes.Equal(id3SyncsafeEncode(255), []byte { 0x00, 0x00, 0x01, 0x7F }) { test.Fatalf("TestId3Syncsafe: 255 encoded as %v", id3SyncsafeEncode(255)) }
+}
+
+func TestId3Encode(test *testing.T) {
+  test.Parallel()
+  tags := Id3Tags { Title:"Song", Artist:"Band", TrackNumber:3, Cover:[]byte { 0xFF, 0xD8 } }
+  tag := id3Encode(&tags)
+
+  // header: "ID3", version 2.4.0, no flags, syncsafe size of frames
+  if (string(tag[0:3]) != "ID3") || !bytes.Equal(tag[3:6], []byte { 0x04, 0x00, 0x00 }) { test.Fatalf("TestId3Encode: invalid header: %v", tag[0:6]) }
+  if int(id3SyncsafeDecode(tag[6:10])) != (len(tag) - id3HeaderSize) { test.Fatalf("TestId3Encode: header size doesn't match frames") }
+  length, err := id3v2Length(bytes.NewReader(tag))
+  if (err != nil) || (length != int64(len(tag))) { test.Fatalf("TestId3Encode: id3v2Length = %d, %v; expected %d", length, err, len(tag)) }
+
+  // frames: id, syncsafe size, flags, payload; empty values are omitted
+  frames := map[string][]byte {}
+  order  := []string {}
+  for offset := id3HeaderSize; offset < len(tag); {
+    id   := string(tag[offset : offset + 4])
+    size := int(id3SyncsafeDecode(tag[offset + 4 : offset + 8]))
+    if !bytes.Equal(tag[offset + 8 : offset + 10], []byte { 0x00, 0x00 }) { test.Fatalf("TestId3Encode: %s has flags set", id) }
+    frames[id] = tag[offset + 10 : offset + 10 + size]
+    order = append(order, id)
+    offset += 10 + size
+  }
+  expected := []string { "TIT2", "TPE1", "TRCK", "APIC" }
+  if len(order) != len(expected) { test.Fatalf("TestId3Encode: frames %v, expected %v", order, expected) }
+  for index := range expected {
+    if order[index] != expected[index] { test.Fatalf("TestId3Encode: frames %v, expected %v", order, expected) }
+  }
+  if string(frames["TIT2"]) != "\x03Song" { test.Fatalf("TestId3Encode: TIT2 = %q", frames["TIT2"]) }
+  if string(frames["TRCK"]) != "\x033"    { test.Fatalf("TestId3Encode: TRCK = %q", frames["TRCK"]) }
+  if string(frames["APIC"]) != "\x03image/jpeg\x00\x03\x00\xFF\xD8" { test.Fatalf("TestId3Encode: APIC = %q", frames["APIC"]) }
+}
+
+func TestId3Write(test *testing.T) {
+  test.Parallel()
+  audio := bytes.Repeat([]byte { 0xFF, 0xFB, 0x90, 0x00 }, 256)
+  id3v1 := append([]byte("TAG"), make([]byte, id3v1Size - 3)...)
+
+  // existing ID3v2 & ID3v1 tags are both replaced; audio is untouched
+  path := filepath.Join(test.TempDir(), "song.mp3")
+  original := append(id3Encode(&Id3Tags { Title:"Old Title", Album:"Old Album" }), audio...)
+  original  = append(original, id3v1...)
+  err := os.WriteFile(path, original, 0660)
+  if err != nil { test.Fatalf("TestId3Write: WriteFile failed: %s", err) }
+
+  tags := Id3Tags { Title:"New Title", Year:2001 }
+  err = id3Write(path, &tags)
+  if err != nil { test.Fatalf("TestId3Write: id3Write failed: %s", err) }
+  written, err := os.ReadFile(path)
+  if err != nil { test.Fatalf("TestId3Write: ReadFile failed: %s", err) }
+  if !bytes.Equal(written, append(id3Encode(&tags), audio...)) { test.Fatalf("TestId3Write: unexpected file contents after tagging") }
+  if pathExists(path + ".tagging") { test.Fatalf("TestId3Write: temporary file left behind") }
+
+  // untagged files get a tag prepended; rewriting is idempotent
+  untagged := filepath.Join(test.TempDir(), "untagged.mp3")
+  os.WriteFile(untagged, audio, 0660)
+  for pass := 0; pass < 2; pass++ {
+    err = id3Write(untagged, &tags)
+    if err != nil { test.Fatalf("TestId3Write: id3Write (untagged, pass %d) failed: %s", pass, err) }
+    written, _ = os.ReadFile(untagged)
+    if !bytes.Equal(written, append(id3Encode(&tags), audio...)) { test.Fatalf("TestId3Write: unexpected untagged file contents, pass %d", pass) }
+  }
+}
+
+func TestWriteTags(test *testing.T) {
+  test.Parallel()
+  lib, err := LibraryOpen(filepath.Join(test.TempDir(), "test.database"))
+  if err != nil { test.Fatalf("TestWriteTags: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestWriteTags: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestWriteTags: MediaPathSet failed: %s", err) }
+
+  cat, err := lib.CategoryCreate("Music", CategoryMediaTypeMusic)
+  if err != nil { test.Fatalf("TestWriteTags: CategoryCreate failed: %s", err) }
+  album := Metadata { ParentId:cat.Id, MediaType:MetadataMediaTypeAlbum, NameDisplay:"Album", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&album)
+  if err != nil { test.Fatalf("TestWriteTags: MetadataCreate failed: %s", err) }
+
+  // mp3 is tagged with its album; other formats are reported unsupported, and skipped when recursing
+  audio := []byte { 0xFF, 0xFB, 0x90, 0x00 }
+  mp3  := Metadata { ParentId:album.Id, MediaType:MetadataMediaTypeFileAudio, NameDisplay:"Song",  Extension:".mp3",  Streams:[]FileStream {} }
+  flac := Metadata { ParentId:album.Id, MediaType:MetadataMediaTypeFileAudio, NameDisplay:"Other", Extension:".flac", Streams:[]FileStream {} }
+  for _, md := range []*Metadata { &mp3, &flac } {
+    err = lib.MetadataCreate(md)
+    if err != nil { test.Fatalf("TestWriteTags: MetadataCreate failed: %s", err) }
+    media_path, _ := md.DiskPath(MetadataPathTypeMedia)
+    os.WriteFile(media_path, audio, 0660)
+  }
+  if err = flac.WriteTags(); err != ErrTagsUnsupported { test.Fatalf("TestWriteTags: flac WriteTags returned %v", err) }
+  err = album.WriteTagsRecursive()
+  if err != nil { test.Fatalf("TestWriteTags: WriteTagsRecursive failed: %s", err) }
+
+  mp3_path, _ := mp3.DiskPath(MetadataPathTypeMedia)
+  written, _ := os.ReadFile(mp3_path)
+  expected := append(id3Encode(&Id3Tags { Title:"Song", Album:"Album" }), audio...)
+  if !bytes.Equal(written, expected) { test.Fatalf("TestWriteTags: mp3 not tagged with title & album") }
+  flac_path, _ := flac.DiskPath(MetadataPathTypeMedia)
+  if written, _ = os.ReadFile(flac_path); !bytes.Equal(written, audio) { test.Fatalf("TestWriteTags: flac modified") }
+}
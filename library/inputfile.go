@@ -1,29 +1,66 @@
 package library
 
 import (
-  "os"
   "fmt"
+  "regexp"
   "strings"
   "encoding/json"
   "path/filepath"
 )
 
 type InputFile struct {
-  Id                       string       `json:"id"`                        // Metadata.Id == InputFile.Id
-  SourceLocation           string       `json:"source_location"`           // path to source file
-  SourceStreams            []FileStream `json:"source_streams"`
-  StreamMap                []int64      `json:"stream_map"`                // empty == needs_map
-  SourceDuration           int64        `json:"source_duration"`           // length of media in seconds
-  TimeScanned              int64        `json:"time_scanned"`
-  TranscodingCommand       string       `json:"transcoding_command"`       // ffmpeg command line
-  TranscodingTimeStarted   int64        `json:"transcoding_time_started"`  // time transcoding was started
-  TranscodingTimeElapsed   int64        `json:"transcoding_time_elapsed"`  // seconds elapsed during transcoding
-  TranscodingError         string       `json:"transcoding_error"`         // error message from transcoding process
+  Id                       string                `json:"id"`                        // Metadata.Id == InputFile.Id
+  SourceLocation           string                `json:"source_location"`           // path to source file
+  SourceStreams            []FileStream          `json:"source_streams"`
+  StreamMap                []int64               `json:"stream_map"`                // empty == needs_map
+  SourceDuration           int64                 `json:"source_duration"`           // length of media in seconds
+  TimeScanned              int64                 `json:"time_scanned"`
+  TranscodingCommand       string                `json:"transcoding_command"`       // ffmpeg command line
+  TranscodingTimeStarted   int64                 `json:"transcoding_time_started"`  // time transcoding was started
+  TranscodingTimeElapsed   int64                 `json:"transcoding_time_elapsed"`  // seconds elapsed during transcoding
+  TranscodingError         string                `json:"transcoding_error"`         // error message from transcoding process
+  Verification             TranscodeVerification `json:"verification"`              // results of post-transcode verification
+  VideoProcessing          VideoProcessing       `json:"video_processing"`          // detected interlacing/cropping, and overrides
+  TrimStart                int64                 `json:"trim_start"`                // seconds to skip at start of source (0 == none)
+  TrimEnd                  int64                 `json:"trim_end"`                  // seconds into source to stop at (0 == end of source)
+  GroupId                  string                `json:"group_id"`                  // id of first part, when joining multiple parts ("" == not grouped)
+  GroupIndex               int64                 `json:"group_index"`               // order of this part within group
+  Sidecar                  SidecarMetadata       `json:"sidecar"`                   // imported from nfo/json sidecars & embedded tags
+  CategoryId               string                `json:"category_id"`               // destination category ("" == none; output is left at media root)
+  library                  *Library                                                   // library read from/created in (nil == default)
+}
+
+type TranscodeVerification struct {
+  TimeVerified     int64   `json:"time_verified"`     // time verification was run (0 == not verified)
+  Passed           bool    `json:"passed"`
+  Failure          string  `json:"failure"`           // reason verification failed
+  DurationSource   int64   `json:"duration_source"`   // seconds
+  DurationOutput   int64   `json:"duration_output"`   // seconds
+  StreamsExpected  []int64 `json:"streams_expected"`  // count of video, audio, subtitle streams mapped from source
+  StreamsFound     []int64 `json:"streams_found"`     // count of video, audio, subtitle streams in output
+  DecodeChecked    bool    `json:"decode_checked"`    // full decode of output was run
+  DecodeErrors     string  `json:"decode_errors"`     // errors reported by full decode
+  QualityMetric    string  `json:"quality_metric"`    // "ssim", "vmaf", or "" (not sampled)
+  QualityScore     float64 `json:"quality_score"`
+}
+
+type VideoProcessing struct {
+  TimeAnalyzed     int64   `json:"time_analyzed"`     // time scanner analysis was run (0 == not analyzed)
+  Interlaced       bool    `json:"interlaced"`        // detected by idet
+  FieldOrder       string  `json:"field_order"`       // "tff", "bff", or ""
+  InterlacedRatio  float64 `json:"interlaced_ratio"`  // fraction of sampled frames detected as interlaced
+  Crop             string  `json:"crop"`              // detected by cropdetect, "w:h:x:y" ("" == no crop)
+  DeinterlaceMode  string  `json:"deinterlace_mode"`  // override: "" (auto), "off", "bwdif", or "yadif"
+  CropMode         string  `json:"crop_mode"`         // override: "" (auto), "off", or "w:h:x:y"
 }
 
 var ErrInvalidStreamIndex = fmt.Errorf("invalid stream index")
 var ErrMissingVideoStream = fmt.Errorf("missing video stream")
 var ErrMissingAudioStream = fmt.Errorf("missing audio stream")
+var ErrInvalidDeinterlace = fmt.Errorf("invalid deinterlace mode")
+var ErrInvalidCrop        = fmt.Errorf("invalid crop")
+
+var cropPattern *regexp.Regexp = regexp.MustCompile(`^[0-9]+:[0-9]+:[0-9]+:[0-9]+$`)
 
 // ============================================================================
 // Public Interface
@@ -40,6 +77,15 @@ func (inp *InputFile) Copy() (*InputFile) {
   copy.TranscodingTimeStarted   = inp.TranscodingTimeStarted
   copy.TranscodingTimeElapsed   = inp.TranscodingTimeElapsed
   copy.TranscodingError         = inp.TranscodingError
+  copy.Verification             = *(inp.Verification.Copy())
+  copy.VideoProcessing          = inp.VideoProcessing
+  copy.TrimStart                = inp.TrimStart
+  copy.TrimEnd                  = inp.TrimEnd
+  copy.GroupId                  = inp.GroupId
+  copy.GroupIndex               = inp.GroupIndex
+  copy.Sidecar                  = *(inp.Sidecar.Copy())
+  copy.CategoryId               = inp.CategoryId
+  copy.library                  = inp.library
 
   for index, stream := range inp.SourceStreams {
     stream_copy := stream.Copy()
@@ -51,6 +97,15 @@ func (inp *InputFile) Copy() (*InputFile) {
   return &copy
 }
 
+func (ver *TranscodeVerification) Copy() (*TranscodeVerification) {
+  copy := *ver
+  copy.StreamsExpected = make([]int64, len(ver.StreamsExpected))
+  copy.StreamsFound    = make([]int64, len(ver.StreamsFound))
+  for index, count := range ver.StreamsExpected { copy.StreamsExpected[index] = count }
+  for index, count := range ver.StreamsFound    { copy.StreamsFound[index]    = count }
+  return &copy
+}
+
 func (inp *InputFile) Remap(source_stream_map []int64) error {
   has_video := false
   has_audio := false
@@ -74,7 +129,57 @@ func (inp *InputFile) Remap(source_stream_map []int64) error {
   inp_update := inp.Copy()
   inp_update.StreamMap = source_stream_map
 
-  err := dbRecordReplace(inp, inp_update)
+  err := inp.lib().dbRecordReplace(inp, inp_update)
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
+// Deinterlace filter to use ("" == none), resolving override against detected interlacing.
+// Detected field order is passed as parity, rather than trusting (often missing or wrong) container flags.
+func (vp *VideoProcessing) DeinterlaceFilter(default_filter string) string {
+  filter := ""
+  switch vp.DeinterlaceMode {
+    case "off"   : return ""
+    case "bwdif" : filter = "bwdif"
+    case "yadif" : filter = "yadif"
+    default      : if vp.Interlaced { filter = default_filter }
+  }
+  if filter == "" { return "" }
+  if (vp.FieldOrder != "tff") && (vp.FieldOrder != "bff") { return filter }
+  separator := "=" ; if strings.Contains(filter, "=") { separator = ":" } // default_filter may carry its own options
+  return filter + separator + "parity=" + vp.FieldOrder
+}
+
+// Crop ("w:h:x:y", "" == none) to use, resolving override against detected cropping.
+func (vp *VideoProcessing) CropFilter() string {
+  if vp.CropMode == "off" { return "" }
+  if vp.CropMode != ""    { return vp.CropMode }
+  return vp.Crop
+}
+
+func (inp *InputFile) SetVideoAnalysis(analysis *VideoProcessing) error {
+  inp_update := inp.Copy()
+  inp_update.VideoProcessing.TimeAnalyzed    = analysis.TimeAnalyzed
+  inp_update.VideoProcessing.Interlaced      = analysis.Interlaced
+  inp_update.VideoProcessing.FieldOrder      = analysis.FieldOrder
+  inp_update.VideoProcessing.InterlacedRatio = analysis.InterlacedRatio
+  inp_update.VideoProcessing.Crop            = analysis.Crop
+  err := inp.lib().dbRecordReplace(inp, inp_update)
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
+func (inp *InputFile) SetVideoOverrides(deinterlace_mode string, crop_mode string) error {
+  switch deinterlace_mode {
+    case "", "off", "bwdif", "yadif": ;
+    default: return ErrInvalidDeinterlace
+  }
+  if (crop_mode != "") && (crop_mode != "off") && (cropPattern.MatchString(crop_mode) == false) { return ErrInvalidCrop }
+
+  inp_update := inp.Copy()
+  inp_update.VideoProcessing.DeinterlaceMode = deinterlace_mode
+  inp_update.VideoProcessing.CropMode        = crop_mode
+  err := inp.lib().dbRecordReplace(inp, inp_update)
   if err != nil { return ErrQueryFailed }
   return nil
 }
@@ -83,17 +188,49 @@ func (inp *InputFile) OutputNames() (name_display string, name_sort string, path
   output_type := inp.OutputType()
   output_extension := ""
   if output_type == FileStreamTypeVideo { output_extension = ".mp4" }
-  if output_type == FileStreamTypeAudio { output_extension = ".mp3" }
+  if output_type == FileStreamTypeAudio { output_extension = inp.OutputAudioFormat().Extension() }
 
   path_base   := filepath.Base(inp.SourceLocation)
   name_display = strings.TrimSuffix(path_base, filepath.Ext(path_base))
+  if inp.GroupId != "" { name_display = partSuffixPattern.ReplaceAllString(name_display, "") } // "Movie CD1" -> "Movie"
   name_sort    = nameGetSortForDisplay(name_display)
   name_sort    = strings.TrimPrefix(name_sort, "the ") // very basic cleanup, first time InputFile->Metadata only
-  path = filepath.Join(mediaPath, name_sort) + output_extension
+
+  // prefer imported titles; numbered so episodes & tracks sort in order
+  if inp.Sidecar.Title != "" {
+    name_display = inp.Sidecar.Title
+    name_sort    = nameGetSortForDisplay(inp.Sidecar.SortTitle)
+    if name_sort == "" { name_sort = strings.TrimPrefix(nameGetSortForDisplay(name_display), "the ") }
+    if (inp.Sidecar.Season > 0) && (inp.Sidecar.Episode > 0) {
+      name_sort = fmt.Sprintf("s%02de%02d %s", inp.Sidecar.Season, inp.Sidecar.Episode, name_sort)
+    } else if inp.Sidecar.TrackNumber > 0 {
+      name_sort = fmt.Sprintf("%02d %s", inp.Sidecar.TrackNumber, name_sort)
+      if inp.Sidecar.DiscNumber > 1 { name_sort = fmt.Sprintf("%d-%s", inp.Sidecar.DiscNumber, name_sort) }
+    }
+  }
+  path = filepath.Join(inp.lib().mediaRoot(), name_sort) + output_extension
 
   return name_display, name_sort, path
 }
 
+// Audio output format, from destination category (mp3 if none).
+func (inp *InputFile) OutputAudioFormat() AudioFormat {
+  if inp.CategoryId == "" { return AudioFormatMp3 }
+  cat, err := inp.lib().CategoryRead(inp.CategoryId)
+  if (err != nil) || (cat.MediaType != CategoryMediaTypeMusic) || !AudioFormatValid(cat.AudioFormat) { return AudioFormatMp3 }
+  return cat.AudioFormat
+}
+
+// Set destination category (or "" for none); only before transcoding, as it may change output format.
+func (inp *InputFile) SetCategory(category_id string) error {
+  lib := inp.lib()
+  if inp.TranscodingTimeStarted != 0 { return fmt.Errorf("input file already transcoded") }
+  if (category_id != "") && !lib.CategoryIdExists(category_id) { return ErrNotFound }
+  err := lib.dbRecordPatch(inp, map[string]any { "category_id":category_id })
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
 func (inp *InputFile) OutputType() FileStreamType {
   has_video := false
   has_audio := false
@@ -120,7 +257,7 @@ func (inp *InputFile) StatusSetStarted(time int64, command string) error {
   inp_update := inp.Copy()
   inp_update.TranscodingTimeStarted = time
   inp_update.TranscodingCommand     = command
-  err := dbRecordReplace(inp, inp_update)
+  err := inp.lib().dbRecordReplace(inp, inp_update)
   if err != nil { return ErrQueryFailed }
   return nil
 }
@@ -129,7 +266,15 @@ func (inp *InputFile) StatusSetFailed(time int64, error string) error {
   inp_update := inp.Copy()
   inp_update.TranscodingError       = error
   inp_update.TranscodingTimeElapsed = time - inp.TranscodingTimeStarted
-  err := dbRecordReplace(inp, inp_update)
+  err := inp.lib().dbRecordReplace(inp, inp_update)
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
+func (inp *InputFile) StatusSetVerified(verification *TranscodeVerification) error {
+  inp_update := inp.Copy()
+  inp_update.Verification = *(verification.Copy())
+  err := inp.lib().dbRecordReplace(inp, inp_update)
   if err != nil { return ErrQueryFailed }
   return nil
 }
@@ -139,7 +284,7 @@ func (inp *InputFile) StatusSetSucceeded(time int64) error {
   inp_update.TranscodingError = ""
   inp_update.TranscodingTimeElapsed = time - inp.TranscodingTimeStarted
   if inp_update.TranscodingTimeElapsed < 1 { inp_update.TranscodingTimeElapsed = 1 }
-  err := dbRecordReplace(inp, inp_update)
+  err := inp.lib().dbRecordReplace(inp, inp_update)
   if err != nil { return ErrQueryFailed }
   return nil
 }
@@ -147,80 +292,100 @@ func (inp *InputFile) StatusSetSucceeded(time int64) error {
 func (inp *InputFile) StatusDidSucceed() bool {
   if (inp.TranscodingTimeStarted == 0) || (inp.TranscodingError != "") { return false }
   md := Metadata {}
-  err := dbRecordRead(&md, inp.Id)
+  err := inp.lib().dbRecordRead(&md, inp.Id)
   if err != nil { return false }
   if (md.Duration == 0) || (md.Size == 0) { return false }
   return true
 }
 
 func (inp *InputFile) StatusReset() error {
-  // delete existing transcoded file (if any)
-  _, _, output_path := inp.OutputNames()
-  if pathExists(output_path) {
-    err := os.Remove(output_path)
-    if err != nil { return fmt.Errorf("error deleting transcoded file: %s", err.Error()) }
+  lib := inp.lib()
+  // grouped parts (other than first) have no output of their own
+  if (inp.GroupId != "") && (inp.IsGroupLeader() == false) {
+    return lib.dbTransaction(func(tx *dbTx) error { return inputFileStatusClear(tx, inp) })
   }
 
-  // delete existing metadata record (if any)
-  md := Metadata {}
-  err := dbRecordRead(&md, inp.Id)
-  if err == nil {
-    err = dbRecordDelete(&md)
-    if err != nil { return fmt.Errorf("error deleting metadata record: %s", err.Error()) }
+  // existing output: with a record, all of its files (media, posters, trickplay, artwork; wherever it's been moved to),
+  // otherwise the transcoded file (if any); removed once records are
+  _, _, output_path := inp.OutputNames()
+  paths := []string {}
+  if existing, err := lib.MetadataRead(inp.Id); err == nil {
+    paths = metadataFilePaths(existing)
+  } else if pathExists(output_path) {
+    paths = append(paths, output_path)
   }
 
-  inp_update := inp.Copy()
-  inp_update.TranscodingTimeStarted = 0
-  inp_update.TranscodingTimeElapsed = 0
-  inp_update.TranscodingCommand     = ""
-  inp_update.TranscodingError       = ""
-  err = dbRecordReplace(inp, inp_update)
-  if err != nil { return ErrQueryFailed }
+  // delete existing metadata record (if any) & reset status, of this and other parts joined into this output, together
+  err := lib.dbTransaction(func(tx *dbTx) error {
+    md := Metadata {}
+    err := tx.RecordRead(&md, inp.Id)
+    if err == nil {
+      err = tx.RecordDelete(&md)
+      if err != nil { return fmt.Errorf("error deleting metadata record: %s", err.Error()) }
+    }
+
+    err = inputFileStatusClear(tx, inp)
+    if err != nil { return err }
+    if inp.IsGroupLeader() == false { return nil }
+    records, err := tx.RecordWhere(&InputFile{}, `(group_id = ?) AND (id <> ?)`, inp.Id, inp.Id)
+    if err != nil { return ErrQueryFailed }
+    for _, record := range records {
+      err = inputFileStatusClear(tx, record.(*InputFile))
+      if err != nil { return err }
+    }
+    return nil
+  })
+  if err != nil { return err }
+  err = metadataRemovePaths(paths)
+  if err != nil { return fmt.Errorf("error deleting transcoded files: %s", err.Error()) }
   return nil
 }
 
-func InputFileCreate(inp *InputFile) error {
-  err := dbRecordCreate(inp)
+func (lib *Library) InputFileCreate(inp *InputFile) error {
+  err := lib.dbRecordCreate(inp)
   if err != nil { return ErrQueryFailed }
   return nil
 }
 
-func InputFileRead(id string) (*InputFile, error) {
+func (lib *Library) InputFileRead(id string) (*InputFile, error) {
   inp := InputFile {}
-  err := dbRecordRead(&inp, id)
+  err := lib.dbRecordRead(&inp, id)
   if err != nil { return nil, ErrNotFound }
   return &inp, nil
 }
 
-func InputFileList() ([]InputFile, error) {
-  records, err := dbRecordWhere(&InputFile{}, ``)
+func (lib *Library) InputFileList() ([]InputFile, error) {
+  records, err := lib.dbRecordWhere(&InputFile{}, ``)
   if err != nil { return nil, ErrQueryFailed }
   inputs := make([]InputFile, len(records))
   for index, record := range records { inputs[index] = *(record.(*InputFile)) }
   return inputs, nil
 }
 
-func InputFileDelete(inp *InputFile) error {
+func (lib *Library) InputFileDelete(inp *InputFile) error {
   if inp.StatusDidSucceed() == false {
     // if processing did not complete, make sure to delete metadata & output file
     err := inp.StatusReset()
     if err != nil { return err }
   }
-  err := dbRecordDelete(inp)
-  if err != nil { return ErrQueryFailed }
-  return nil
+  // remaining parts become standalone files
+  return lib.dbTransaction(func(tx *dbTx) error {
+    err := inputFileGroupClear(tx, inp)
+    if err != nil { return err }
+    err = tx.RecordDelete(inp)
+    if err != nil { return ErrQueryFailed }
+    return nil
+  })
 }
 
-func InputFileExistsForSource(source_location string) bool {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
-  queryRow := dbHandle.QueryRow(`SELECT id FROM input_files WHERE source_location = ? LIMIT 1;`, source_location)
-  err := queryRow.Scan(&source_location)
+func (lib *Library) InputFileExistsForSource(source_location string) bool {
+  err := dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT id FROM input_files WHERE source_location = ? LIMIT 1;`, source_location).Scan(&source_location) })
   return (err == nil)
 }
 
-func InputFileNextForTranscoding() (*InputFile, error) {
-  records, err := dbRecordWhere(&InputFile{}, `(transcoding_time_started = 0) AND (stream_map <> '[]') AND (transcoding_error = '') LIMIT 1`)
+func (lib *Library) InputFileNextForTranscoding() (*InputFile, error) {
+  // grouped parts are transcoded along with their first part
+  records, err := lib.dbRecordWhere(&InputFile{}, `(transcoding_time_started = 0) AND (stream_map <> '[]') AND (transcoding_error = '') AND ((group_id = '') OR (group_id = id)) LIMIT 1`)
   if err != nil { return nil, err }
   if len(records) == 0 { return nil, nil }
   return records[0].(*InputFile), nil
@@ -232,6 +397,8 @@ func InputFileNextForTranscoding() (*InputFile, error) {
 func (inp *InputFile) TableName() string { return "input_files"}
 func (inp *InputFile) GetId() string { return inp.Id }
 func (inp *InputFile) SetId(id string) { inp.Id = id }
+func (inp *InputFile) setLibrary(lib *Library) { inp.library = lib }
+func (inp *InputFile) lib() *Library { if inp.library != nil { return inp.library } ; return defaultLibrary }
 
 func (inp *InputFile) RecordCopy() (dbRecord, error) {
   return inp.Copy(), nil
@@ -247,6 +414,9 @@ func (inp *InputFile) RecordCreate(fields map[string]any) (instance dbRecord, er
 func (inp *InputFile) FieldsRead() (fields map[string]any, err error) {
   streams_bytes, err := json.Marshal(inp.SourceStreams) ; if err != nil { return nil, err } ; streams_string := string(streams_bytes)
   map_bytes, err := json.Marshal(inp.StreamMap) ; if err != nil { return nil, err } ; map_string := string(map_bytes)
+  verification_bytes, err := json.Marshal(inp.Verification) ; if err != nil { return nil, err } ; verification_string := string(verification_bytes)
+  processing_bytes, err := json.Marshal(inp.VideoProcessing) ; if err != nil { return nil, err } ; processing_string := string(processing_bytes)
+  sidecar_bytes, err := json.Marshal(inp.Sidecar) ; if err != nil { return nil, err } ; sidecar_string := string(sidecar_bytes)
 
   fields = make(map[string]any)
   fields["id"]                       = inp.Id
@@ -259,6 +429,14 @@ func (inp *InputFile) FieldsRead() (fields map[string]any, err error) {
   fields["transcoding_time_started"] = inp.TranscodingTimeStarted
   fields["transcoding_time_elapsed"] = inp.TranscodingTimeElapsed
   fields["transcoding_error"]        = inp.TranscodingError
+  fields["verification"]             = verification_string
+  fields["video_processing"]         = processing_string
+  fields["trim_start"]               = inp.TrimStart
+  fields["trim_end"]                 = inp.TrimEnd
+  fields["group_id"]                 = inp.GroupId
+  fields["group_index"]              = inp.GroupIndex
+  fields["sidecar"]                  = sidecar_string
+  fields["category_id"]              = inp.CategoryId
 
   return fields, nil
 }
@@ -266,6 +444,9 @@ func (inp *InputFile) FieldsRead() (fields map[string]any, err error) {
 func (inp *InputFile) FieldsReplace(fields map[string]any) (err error) {
   streams_string := fields["source_streams"].(string) ; var source_streams []FileStream ; err = json.Unmarshal([]byte(streams_string), &source_streams) ; if err != nil { return err }
   map_string := fields["stream_map"].(string) ; var stream_map []int64 ; err = json.Unmarshal([]byte(map_string), &stream_map) ; if err != nil { return err }
+  verification_string := fields["verification"].(string) ; var verification TranscodeVerification ; err = json.Unmarshal([]byte(verification_string), &verification) ; if err != nil { return err }
+  processing_string := fields["video_processing"].(string) ; var processing VideoProcessing ; err = json.Unmarshal([]byte(processing_string), &processing) ; if err != nil { return err }
+  sidecar_string := fields["sidecar"].(string) ; var sidecar SidecarMetadata ; err = json.Unmarshal([]byte(sidecar_string), &sidecar) ; if err != nil { return err }
 
   inp.Id                     = fields["id"].(string)
   inp.SourceLocation         = fields["source_location"].(string)
@@ -277,6 +458,14 @@ func (inp *InputFile) FieldsReplace(fields map[string]any) (err error) {
   inp.TranscodingTimeStarted = fields["transcoding_time_started"].(int64)
   inp.TranscodingTimeElapsed = fields["transcoding_time_elapsed"].(int64)
   inp.TranscodingError       = fields["transcoding_error"].(string)
+  inp.Verification           = verification
+  inp.VideoProcessing        = processing
+  inp.TrimStart              = fields["trim_start"].(int64)
+  inp.TrimEnd                = fields["trim_end"].(int64)
+  inp.GroupId                = fields["group_id"].(string)
+  inp.GroupIndex             = fields["group_index"].(int64)
+  inp.Sidecar                = sidecar
+  inp.CategoryId             = fields["category_id"].(string)
   return nil
 }
 
@@ -289,6 +478,11 @@ func (inp *InputFile) FieldsPatch(fields map[string]any) (err error) {
   if transcoding_time_started, ok := fields["transcoding_time_started"] ; ok { inp.TranscodingTimeStarted = transcoding_time_started.(int64) }
   if transcoding_time_elapsed, ok := fields["transcoding_time_elapsed"] ; ok { inp.TranscodingTimeElapsed = transcoding_time_elapsed.(int64) }
   if transcoding_error,        ok := fields["transcoding_error"]        ; ok { inp.TranscodingError       = transcoding_error.(string)       }
+  if trim_start,               ok := fields["trim_start"]               ; ok { inp.TrimStart              = trim_start.(int64)               }
+  if trim_end,                 ok := fields["trim_end"]                 ; ok { inp.TrimEnd                = trim_end.(int64)                 }
+  if group_id,                 ok := fields["group_id"]                 ; ok { inp.GroupId                = group_id.(string)                }
+  if group_index,              ok := fields["group_index"]              ; ok { inp.GroupIndex             = group_index.(int64)              }
+  if category_id,              ok := fields["category_id"]              ; ok { inp.CategoryId             = category_id.(string)             }
 
   if source_streams, ok := fields["source_streams"] ; ok {
     streams_string := source_streams.(string) ; var source_streams []FileStream ; err = json.Unmarshal([]byte(streams_string), &source_streams) ; if err != nil { return err }
@@ -300,6 +494,21 @@ func (inp *InputFile) FieldsPatch(fields map[string]any) (err error) {
     inp.StreamMap = stream_map
   }
 
+  if verification, ok := fields["verification"] ; ok {
+    verification_string := verification.(string) ; var verification TranscodeVerification ; err = json.Unmarshal([]byte(verification_string), &verification) ; if err != nil { return err }
+    inp.Verification = verification
+  }
+
+  if video_processing, ok := fields["video_processing"] ; ok {
+    processing_string := video_processing.(string) ; var processing VideoProcessing ; err = json.Unmarshal([]byte(processing_string), &processing) ; if err != nil { return err }
+    inp.VideoProcessing = processing
+  }
+
+  if sidecar, ok := fields["sidecar"] ; ok {
+    sidecar_string := sidecar.(string) ; var sidecar SidecarMetadata ; err = json.Unmarshal([]byte(sidecar_string), &sidecar) ; if err != nil { return err }
+    inp.Sidecar = sidecar
+  }
+
   return nil
 }
 
@@ -312,6 +521,12 @@ func (inp_a *InputFile) FieldsDifference(other dbRecord) (diff map[string]any, e
   b_streams_bytes, err := json.Marshal(inp_b.SourceStreams) ; if err != nil { return nil, err } ; b_streams_string := string(b_streams_bytes)
   a_map_bytes, err := json.Marshal(inp_a.StreamMap) ; if err != nil { return nil, err } ; a_map_string := string(a_map_bytes)
   b_map_bytes, err := json.Marshal(inp_b.StreamMap) ; if err != nil { return nil, err } ; b_map_string := string(b_map_bytes)
+  a_verification_bytes, err := json.Marshal(inp_a.Verification) ; if err != nil { return nil, err } ; a_verification_string := string(a_verification_bytes)
+  b_verification_bytes, err := json.Marshal(inp_b.Verification) ; if err != nil { return nil, err } ; b_verification_string := string(b_verification_bytes)
+  a_processing_bytes, err := json.Marshal(inp_a.VideoProcessing) ; if err != nil { return nil, err } ; a_processing_string := string(a_processing_bytes)
+  b_processing_bytes, err := json.Marshal(inp_b.VideoProcessing) ; if err != nil { return nil, err } ; b_processing_string := string(b_processing_bytes)
+  a_sidecar_bytes, err := json.Marshal(inp_a.Sidecar) ; if err != nil { return nil, err } ; a_sidecar_string := string(a_sidecar_bytes)
+  b_sidecar_bytes, err := json.Marshal(inp_b.Sidecar) ; if err != nil { return nil, err } ; b_sidecar_string := string(b_sidecar_bytes)
 
   if inp_a.Id                       != inp_b.Id                       { diff["id"]                       = inp_b.Id                       }
   if inp_a.SourceLocation           != inp_b.SourceLocation           { diff["source_location"]          = inp_b.SourceLocation           }
@@ -323,6 +538,14 @@ func (inp_a *InputFile) FieldsDifference(other dbRecord) (diff map[string]any, e
   if inp_a.TranscodingTimeStarted   != inp_b.TranscodingTimeStarted   { diff["transcoding_time_started"] = inp_b.TranscodingTimeStarted   }
   if inp_a.TranscodingTimeElapsed   != inp_b.TranscodingTimeElapsed   { diff["transcoding_time_elapsed"] = inp_b.TranscodingTimeElapsed   }
   if inp_a.TranscodingError         != inp_b.TranscodingError         { diff["transcoding_error"]        = inp_b.TranscodingError         }
+  if a_verification_string          != b_verification_string          { diff["verification"]             = b_verification_string          }
+  if a_processing_string            != b_processing_string            { diff["video_processing"]         = b_processing_string            }
+  if inp_a.TrimStart                != inp_b.TrimStart                { diff["trim_start"]               = inp_b.TrimStart                }
+  if inp_a.TrimEnd                  != inp_b.TrimEnd                  { diff["trim_end"]                 = inp_b.TrimEnd                  }
+  if inp_a.GroupId                  != inp_b.GroupId                  { diff["group_id"]                 = inp_b.GroupId                  }
+  if inp_a.GroupIndex               != inp_b.GroupIndex               { diff["group_index"]              = inp_b.GroupIndex               }
+  if a_sidecar_string               != b_sidecar_string               { diff["sidecar"]                  = b_sidecar_string               }
+  if inp_a.CategoryId               != inp_b.CategoryId               { diff["category_id"]              = inp_b.CategoryId               }
 
   return diff, nil
 }
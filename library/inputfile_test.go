@@ -0,0 +1,47 @@
+package library
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestInputFileStatusReset(test *testing.T) {
+  test.Parallel()
+  lib, err := LibraryOpen(filepath.Join(test.TempDir(), "test.database"))
+  if err != nil { test.Fatalf("TestInputFileStatusReset: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestInputFileStatusReset: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestInputFileStatusReset: MediaPathSet failed: %s", err) }
+
+  // transcoded, moved into a category, with generated posters, trickplay & artwork
+  cat, err := lib.CategoryCreate("Movies", CategoryMediaTypeMovie)
+  if err != nil { test.Fatalf("TestInputFileStatusReset: CategoryCreate failed: %s", err) }
+  inp := InputFile { SourceLocation:filepath.Join(test.TempDir(), "movie.mkv"), SourceStreams:[]FileStream {}, StreamMap:[]int64 {}, TranscodingTimeStarted:1, TranscodingTimeElapsed:1 }
+  err = lib.InputFileCreate(&inp)
+  if err != nil { test.Fatalf("TestInputFileStatusReset: InputFileCreate failed: %s", err) }
+  md := Metadata { Id:inp.Id, ParentId:cat.Id, MediaType:MetadataMediaTypeFileVideo, NameDisplay:"Movie", Extension:".mp4", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&md)
+  if err != nil { test.Fatalf("TestInputFileStatusReset: MetadataCreate failed: %s", err) }
+  paths := []string {}
+  for _, path_type := range []MetadataPathType { MetadataPathTypeMedia, MetadataPathTypePosterLarge, MetadataPathTypePosterSmall, MetadataPathTypePosterMaster, MetadataPathTypeBif } {
+    path, _ := md.DiskPath(path_type)
+    os.WriteFile(path, []byte("generated"), 0660)
+    paths = append(paths, path)
+  }
+  thumbs_path, _ := md.DiskPath(MetadataPathTypeThumbs)
+  os.MkdirAll(thumbs_path, 0770)
+  paths = append(paths, thumbs_path)
+
+  // reset removes record, and every file it had; so transcoding again can move into the same category
+  err = inp.StatusReset()
+  if err != nil { test.Fatalf("TestInputFileStatusReset: StatusReset failed: %s", err) }
+  if lib.MetadataIdExists(inp.Id) { test.Fatalf("TestInputFileStatusReset: metadata record not deleted") }
+  for _, path := range paths {
+    if pathExists(path) { test.Fatalf("TestInputFileStatusReset: \"%s\" left behind", path) }
+  }
+  stored, err := lib.InputFileRead(inp.Id)
+  if (err != nil) || (stored.TranscodingTimeStarted != 0) { test.Fatalf("TestInputFileStatusReset: status not cleared: %v, %v", stored, err) }
+}
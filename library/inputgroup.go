@@ -0,0 +1,152 @@
+package library
+
+import (
+  "fmt"
+  "regexp"
+)
+
+var ErrInvalidTrim  = fmt.Errorf("invalid trim points")
+var ErrInvalidGroup = fmt.Errorf("invalid input file group")
+
+// trailing "CD1", "disc 2", "part.3", "pt-4", etc.
+var partSuffixPattern *regexp.Regexp = regexp.MustCompile(`(?i)[\s._-]*[\[(]?(cd|disc|disk|part|pt)[\s._-]*[0-9]+[\])]?$`)
+
+// ============================================================================
+// Trimming
+
+// Set in/out trim points, in seconds (0 == untrimmed).
+func (inp *InputFile) TrimSet(trim_start int64, trim_end int64) error {
+  if (trim_start < 0) || (trim_end < 0) { return ErrInvalidTrim }
+  if (trim_end > 0) && (trim_end <= trim_start) { return ErrInvalidTrim }
+  if (inp.SourceDuration > 0) && (trim_start >= inp.SourceDuration) { return ErrInvalidTrim }
+  if (inp.SourceDuration > 0) && (trim_end > inp.SourceDuration) { return ErrInvalidTrim }
+  if inp.TranscodingTimeStarted != 0 { return fmt.Errorf("cannot trim input file \"%s\": already transcoded (reset first)", inp.Id) }
+
+  inp_update := inp.Copy()
+  inp_update.TrimStart = trim_start
+  inp_update.TrimEnd   = trim_end
+  err := inp.lib().dbRecordReplace(inp, inp_update)
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
+// Length of source, in seconds, after trimming.
+func (inp *InputFile) TrimmedDuration() int64 {
+  end := inp.SourceDuration
+  if (inp.TrimEnd > 0) && (inp.TrimEnd < end) { end = inp.TrimEnd }
+  duration := end - inp.TrimStart
+  if duration < 0 { return 0 }
+  return duration
+}
+
+// ============================================================================
+// Grouping
+
+func (inp *InputFile) IsGroupLeader() bool {
+  return (inp.GroupId != "") && (inp.GroupId == inp.Id)
+}
+
+// List all parts in group, ordered.
+func (lib *Library) InputFileGroupMembers(group_id string) ([]InputFile, error) {
+  records, err := lib.dbRecordWhere(&InputFile{}, `(group_id = ?) ORDER BY group_index ASC`, group_id)
+  if err != nil { return nil, ErrQueryFailed }
+  members := make([]InputFile, len(records))
+  for index, record := range records { members[index] = *(record.(*InputFile)) }
+  return members, nil
+}
+
+// Expected length of output, in seconds, after trimming and joining all parts.
+func (inp *InputFile) OutputDuration() (int64, error) {
+  if inp.GroupId == "" { return inp.TrimmedDuration(), nil }
+  members, err := inp.lib().InputFileGroupMembers(inp.GroupId)
+  if err != nil { return 0, err }
+  duration := int64(0)
+  for _, member := range members { duration += member.TrimmedDuration() }
+  return duration, nil
+}
+
+// Join other input files (in order) onto the end of this one, producing a single output.
+// An empty part_ids list ungroups all current parts.
+func (inp *InputFile) GroupSet(part_ids []string) error {
+  lib := inp.lib()
+  // validate new parts
+  parts := []*InputFile {}
+  for _, part_id := range part_ids {
+    if part_id == inp.Id { return ErrInvalidGroup }
+    part, err := lib.InputFileRead(part_id)
+    if err != nil { return fmt.Errorf("input file \"%s\" not found", part_id) }
+    if (part.GroupId != "") && (part.GroupId != inp.Id) { return fmt.Errorf("input file \"%s\" already belongs to another group", part_id) }
+    if (part.GroupId == "") && (part.TranscodingTimeStarted != 0) { return fmt.Errorf("input file \"%s\" already transcoded (reset first)", part_id) }
+    if part.OutputType() != inp.OutputType() { return fmt.Errorf("input file \"%s\" output type doesn't match", part_id) }
+    parts = append(parts, part)
+  }
+  if (inp.GroupId != "") && (inp.IsGroupLeader() == false) { return fmt.Errorf("input file \"%s\" is already a part of another group", inp.Id) }
+  if inp.TranscodingTimeStarted != 0 { return fmt.Errorf("input file \"%s\" already transcoded (reset first)", inp.Id) }
+
+  // remove existing parts & set new parts, together
+  return lib.dbTransaction(func(tx *dbTx) error {
+    err := inputFileGroupClear(tx, inp)
+    if err != nil { return err }
+    group_id := "" ; if len(parts) > 0 { group_id = inp.Id }
+    err = tx.RecordPatch(inp, map[string]any { "group_id":group_id, "group_index":int64(0) })
+    if err != nil { return ErrQueryFailed }
+    for index, part := range parts {
+      err = tx.RecordPatch(part, map[string]any { "group_id":group_id, "group_index":int64(index + 1) })
+      if err != nil { return ErrQueryFailed }
+    }
+    return nil
+  })
+}
+
+// Mark all other parts of this group as transcoded, along with this (leader) one.
+func (inp *InputFile) GroupSetSucceeded(time int64) error {
+  lib := inp.lib()
+  if inp.IsGroupLeader() == false { return nil }
+  members, err := lib.InputFileGroupMembers(inp.Id)
+  if err != nil { return err }
+  return lib.dbTransaction(func(tx *dbTx) error {
+    for index := range members {
+      if members[index].Id == inp.Id { continue }
+      member_update := members[index].Copy()
+      member_update.TranscodingTimeStarted = inp.TranscodingTimeStarted
+      member_update.TranscodingCommand     = inp.TranscodingCommand
+      member_update.TranscodingError       = ""
+      member_update.TranscodingTimeElapsed = time - inp.TranscodingTimeStarted
+      if member_update.TranscodingTimeElapsed < 1 { member_update.TranscodingTimeElapsed = 1 }
+      err := tx.RecordReplace(&(members[index]), member_update)
+      if err != nil { return ErrQueryFailed }
+    }
+    return nil
+  })
+}
+
+// ============================================================================
+// private utilities
+
+// Return all other parts in group to standalone input files.
+func inputFileGroupClear(tx *dbTx, inp *InputFile) error {
+  if inp.IsGroupLeader() == false { return nil }
+  records, err := tx.RecordWhere(&InputFile{}, `(group_id = ?)`, inp.Id)
+  if err != nil { return ErrQueryFailed }
+  for _, record := range records {
+    member := record.(*InputFile)
+    if member.Id == inp.Id { continue }
+    err = tx.RecordPatch(member, map[string]any { "group_id":"", "group_index":int64(0) })
+    if err != nil { return ErrQueryFailed }
+    err = inputFileStatusClear(tx, member)
+    if err != nil { return err }
+  }
+  return nil
+}
+
+func inputFileStatusClear(tx *dbTx, inp *InputFile) error {
+  inp_update := inp.Copy()
+  inp_update.TranscodingTimeStarted = 0
+  inp_update.TranscodingTimeElapsed = 0
+  inp_update.TranscodingCommand     = ""
+  inp_update.TranscodingError       = ""
+  inp_update.Verification           = TranscodeVerification {}
+  err := tx.RecordReplace(inp, inp_update)
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
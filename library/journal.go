@@ -0,0 +1,330 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "time"
+  "strings"
+  "encoding/json"
+)
+
+// Operations that move files on disk, then update the database, are journaled:
+// the intended moves are recorded before any happen, so an interrupted operation can be
+// rolled forward (all moves done) or undone (some moves done) by LibraryStartup.
+// Each entry records its owning process; recovery leaves alone entries whose owner is still running
+// (scanner, transcoder & server share a database), or that belong to another host.
+
+// this process, as owner of the entries it creates (start time, in case its pid is reused after a restart)
+var journalOwnerHost, _ = os.Hostname()
+var journalOwnerPid     = int64(os.Getpid())
+var journalOwnerStarted = time.Now().UnixNano()
+
+type JournalStatus string
+const (
+  JournalStatusPending JournalStatus = "pending" // moves may be partially done
+  JournalStatusMoved   JournalStatus = "moved"   // all moves done; database update outstanding
+  JournalStatusFailed  JournalStatus = "failed"  // recovery couldn't resolve; needs manual attention
+)
+
+type JournalMove struct {
+  Source      string `json:"source"`
+  Destination string `json:"destination"`
+}
+
+type JournalEntry struct {
+  Id           string         `json:"id"`
+  Operation    string         `json:"operation"`
+  Moves        []JournalMove  `json:"moves"`
+  RecordTable  string         `json:"record_table"` // table updated once moves are done ("properties" is keyed by key, others by id)
+  RecordId     string         `json:"record_id"`
+  Patch        map[string]any `json:"patch"`
+  Status       JournalStatus  `json:"status"`
+  Error        string         `json:"error"`
+  TimeCreated  int64          `json:"time_created"`
+  OwnerHost    string         `json:"owner_host"`    // process that created entry ("" == unknown, recoverable by anyone)
+  OwnerPid     int64          `json:"owner_pid"`
+  OwnerStarted int64          `json:"owner_started"`
+  library      *Library                              // library read from/created in (nil == default)
+}
+
+// ============================================================================
+// Public Interface
+
+func (entry *JournalEntry) Copy() *JournalEntry {
+  copy := JournalEntry {}
+  copy.Id           = entry.Id
+  copy.Operation    = entry.Operation
+  copy.Moves        = make([]JournalMove, len(entry.Moves))
+  copy.RecordTable  = entry.RecordTable
+  copy.RecordId     = entry.RecordId
+  copy.Patch        = make(map[string]any, len(entry.Patch))
+  copy.Status       = entry.Status
+  copy.Error        = entry.Error
+  copy.TimeCreated  = entry.TimeCreated
+  copy.OwnerHost    = entry.OwnerHost
+  copy.OwnerPid     = entry.OwnerPid
+  copy.OwnerStarted = entry.OwnerStarted
+  copy.library      = entry.library
+
+  for index, move := range entry.Moves { copy.Moves[index] = move }
+  for key, value := range entry.Patch { copy.Patch[key] = value }
+  return &copy
+}
+
+// List outstanding (interrupted, or unrecoverable) operations.
+func (lib *Library) JournalList() ([]JournalEntry, error) {
+  records, err := lib.dbRecordWhere(&JournalEntry{}, `(id <> '') ORDER BY time_created ASC`)
+  if err != nil { return nil, ErrQueryFailed }
+  entries := make([]JournalEntry, len(records))
+  for index, record := range records { entries[index] = *(record.(*JournalEntry)) }
+  return entries, nil
+}
+
+// Resolve operations interrupted by a crash (whose owning process has exited).
+// Entries that can't be resolved are marked failed, and left in place.
+func (lib *Library) JournalRecover() error {
+  if !lib.journalTableCurrent() { return nil }
+  entries, err := lib.JournalList()
+  if err != nil { return err }
+
+  for index := range entries {
+    entry := &(entries[index])
+    if entry.OwnerAlive() { continue }
+    switch entry.Status {
+      case JournalStatusMoved   : err = lib.journalRollForward(entry)
+      case JournalStatusPending : err = lib.journalUndo(entry)
+      default                   : continue
+    }
+    if err == nil { continue }
+    err = lib.dbRecordPatch(entry, map[string]any { "status":string(JournalStatusFailed), "error":err.Error() })
+    if err != nil { return ErrQueryFailed }
+  }
+  return nil
+}
+
+// Might this entry's operation still be in progress? True if its owner is running, or can't be checked (another host).
+func (entry *JournalEntry) OwnerAlive() bool {
+  if (entry.OwnerHost == "") || (entry.OwnerPid == 0) { return false }
+  if entry.OwnerHost != journalOwnerHost { return true }
+  if entry.OwnerPid  == journalOwnerPid  { return entry.OwnerStarted == journalOwnerStarted }
+  return processAlive(int(entry.OwnerPid))
+}
+
+// ============================================================================
+// private utilities
+
+// Move files, then update a record with patch; journaled, so an interruption can be recovered.
+// If a move, or the update, fails, completed moves are undone.
+func (lib *Library) journalRun(operation string, moves []JournalMove, record_table string, record_id string, patch map[string]any) error {
+  entry := JournalEntry { Operation:operation, Moves:[]JournalMove {}, RecordTable:record_table, RecordId:record_id, Patch:patch }
+  for _, move := range moves {
+    if pathExists(move.Source) { entry.Moves = append(entry.Moves, move) }
+  }
+  if len(entry.Moves) == 0 { return lib.dbTransaction(func(tx *dbTx) error { return lib.journalApply(tx, &entry) }) }
+
+  entry.Status       = JournalStatusPending
+  entry.TimeCreated  = time.Now().Unix()
+  entry.OwnerHost    = journalOwnerHost
+  entry.OwnerPid     = journalOwnerPid
+  entry.OwnerStarted = journalOwnerStarted
+  err := lib.dbRecordCreate(&entry)
+  if err != nil { return ErrQueryFailed }
+
+  // undo (the first count) moves; on success, journal entry is no longer needed
+  undo := func(count int, cause error) error {
+    entry_undo := entry.Copy()
+    entry_undo.Moves = entry.Moves[:count]
+    undo_err := lib.journalUndo(entry_undo)
+    if undo_err != nil {
+      lib.dbRecordPatch(&entry, map[string]any { "status":string(JournalStatusFailed), "error":undo_err.Error() })
+      return fmt.Errorf("%s (and undoing file moves failed: %s)", cause.Error(), undo_err.Error())
+    }
+    return cause
+  }
+
+  for index, move := range entry.Moves {
+    err = pathMove(move.Source, move.Destination)
+    if err != nil { return undo(index, err) }
+  }
+  err = lib.dbRecordPatch(&entry, map[string]any { "status":string(JournalStatusMoved) })
+  if err != nil { return undo(len(entry.Moves), ErrQueryFailed) }
+
+  err = lib.dbTransaction(func(tx *dbTx) error {
+    err := lib.journalApply(tx, &entry)
+    if err != nil { return err }
+    return tx.RecordDelete(&entry)
+  })
+  if err != nil { return undo(len(entry.Moves), err) }
+  return nil
+}
+
+// Apply an entry's database update.
+func (lib *Library) journalApply(tx *dbTx, entry *JournalEntry) error {
+  if entry.RecordTable == "properties" {
+    value, ok := entry.Patch["value"]
+    if !ok { return ErrInvalidProperty }
+    _, err := tx.Exec(`INSERT INTO properties (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = ?;`, entry.RecordId, value, value)
+    if err != nil { return ErrQueryFailed }
+    return nil
+  }
+
+  columns := []string {}
+  for column := range entry.Patch { columns = append(columns, column) }
+  if len(columns) == 0 { return nil }
+  sort.Strings(columns)
+  values := []any {}
+  for index, column := range columns {
+    values = append(values, entry.Patch[column])
+    columns[index] = fmt.Sprintf("%s = ?", column)
+  }
+  values = append(values, entry.RecordId)
+
+  result, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s WHERE id = ?;`, entry.RecordTable, strings.Join(columns, ", ")), values...)
+  if err != nil { return ErrQueryFailed }
+  if rows, _ := result.RowsAffected(); rows != 1 { return fmt.Errorf("%s record \"%s\" not found", entry.RecordTable, entry.RecordId) }
+  return nil
+}
+
+// All moves were done: apply database update.
+func (lib *Library) journalRollForward(entry *JournalEntry) error {
+  return lib.dbTransaction(func(tx *dbTx) error {
+    err := lib.journalApply(tx, entry)
+    if err != nil { return err }
+    return tx.RecordDelete(entry)
+  })
+}
+
+// Some moves may have been done: put files back where they were, and forget the operation.
+func (lib *Library) journalUndo(entry *JournalEntry) error {
+  for index := len(entry.Moves) - 1; index >= 0; index-- {
+    move := entry.Moves[index]
+    source_exists, destination_exists := pathExists(move.Source), pathExists(move.Destination)
+    if source_exists && !destination_exists { continue } // never moved
+    if source_exists && (pathCompare(move.Source, move.Destination) == nil) {
+      // copied (across filesystems) & verified, but source not yet removed: drop the copy
+      err := os.RemoveAll(move.Destination)
+      if err != nil { return err }
+      continue
+    }
+    if !destination_exists || source_exists { return fmt.Errorf("cannot undo move of \"%s\" to \"%s\"", move.Source, move.Destination) }
+    err := pathMove(move.Destination, move.Source)
+    if err != nil { return err }
+  }
+  return lib.dbRecordDelete(entry)
+}
+
+// Does the journal table exist, with owner columns? (recovery runs at startup, possibly before migrating)
+func (lib *Library) journalTableCurrent() bool {
+  var count int64
+  return dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT COUNT(owner_started) FROM journal;`).Scan(&count) }) == nil
+}
+
+// ============================================================================
+// dbRecord interface
+
+func (entry *JournalEntry) TableName() string {
+  return "journal"
+}
+
+func (entry *JournalEntry) RecordCopy() (dbRecord, error) {
+  return entry.Copy(), nil
+}
+
+func (entry *JournalEntry) RecordCreate(fields map[string]any) (instance dbRecord, err error) {
+  new_instance := JournalEntry {}
+  err = new_instance.FieldsReplace(fields)
+  if err != nil { return nil, err }
+  return &new_instance, nil
+}
+
+func (entry *JournalEntry) GetId() string {
+  return entry.Id
+}
+func (entry *JournalEntry) SetId(id string) {
+  entry.Id = id
+}
+func (entry *JournalEntry) setLibrary(lib *Library) {
+  entry.library = lib
+}
+
+func (entry *JournalEntry) FieldsRead() (fields map[string]any, err error) {
+  fields = make(map[string]any)
+  moves := entry.Moves ; if moves == nil { moves = []JournalMove {} }
+  moves_bytes, err := json.Marshal(moves) ; if err != nil { return nil, err }
+  patch := entry.Patch ; if patch == nil { patch = map[string]any {} }
+  patch_bytes, err := json.Marshal(patch) ; if err != nil { return nil, err }
+
+  fields["id"           ] = entry.Id
+  fields["operation"    ] = entry.Operation
+  fields["moves"        ] = string(moves_bytes)
+  fields["record_table" ] = entry.RecordTable
+  fields["record_id"    ] = entry.RecordId
+  fields["patch"        ] = string(patch_bytes)
+  fields["status"       ] = string(entry.Status)
+  fields["error"        ] = entry.Error
+  fields["time_created" ] = entry.TimeCreated
+  fields["owner_host"   ] = entry.OwnerHost
+  fields["owner_pid"    ] = entry.OwnerPid
+  fields["owner_started"] = entry.OwnerStarted
+  return fields, nil
+}
+
+func (entry *JournalEntry) FieldsReplace(fields map[string]any) (err error) {
+  var moves []JournalMove ; err = json.Unmarshal([]byte(fields["moves"].(string)), &moves) ; if err != nil { return err }
+  var patch map[string]any ; err = json.Unmarshal([]byte(fields["patch"].(string)), &patch) ; if err != nil { return err }
+
+  entry.Id           = fields["id"           ].(string)
+  entry.Operation    = fields["operation"    ].(string)
+  entry.Moves        = moves
+  entry.RecordTable  = fields["record_table" ].(string)
+  entry.RecordId     = fields["record_id"    ].(string)
+  entry.Patch        = patch
+  entry.Status       = JournalStatus(fields["status"].(string))
+  entry.Error        = fields["error"        ].(string)
+  entry.TimeCreated  = fields["time_created" ].(int64)
+  entry.OwnerHost    = fields["owner_host"   ].(string)
+  entry.OwnerPid     = fields["owner_pid"    ].(int64)
+  entry.OwnerStarted = fields["owner_started"].(int64)
+  return nil
+}
+
+func (entry *JournalEntry) FieldsPatch(fields map[string]any) (err error) {
+  if id,            ok := fields["id"           ] ; ok { entry.Id           = id.(string)                    }
+  if operation,     ok := fields["operation"    ] ; ok { entry.Operation    = operation.(string)             }
+  if record_table,  ok := fields["record_table" ] ; ok { entry.RecordTable  = record_table.(string)          }
+  if record_id,     ok := fields["record_id"    ] ; ok { entry.RecordId     = record_id.(string)             }
+  if status,        ok := fields["status"       ] ; ok { entry.Status       = JournalStatus(status.(string)) }
+  if entry_error,   ok := fields["error"        ] ; ok { entry.Error        = entry_error.(string)           }
+  if time_created,  ok := fields["time_created" ] ; ok { entry.TimeCreated  = time_created.(int64)           }
+  if owner_host,    ok := fields["owner_host"   ] ; ok { entry.OwnerHost    = owner_host.(string)            }
+  if owner_pid,     ok := fields["owner_pid"    ] ; ok { entry.OwnerPid     = owner_pid.(int64)              }
+  if owner_started, ok := fields["owner_started"] ; ok { entry.OwnerStarted = owner_started.(int64)          }
+
+  if moves, ok := fields["moves"] ; ok {
+    var moves_list []JournalMove
+    err = json.Unmarshal([]byte(moves.(string)), &moves_list)
+    if err != nil { return err }
+    entry.Moves = moves_list
+  }
+  if patch, ok := fields["patch"] ; ok {
+    var patch_map map[string]any
+    err = json.Unmarshal([]byte(patch.(string)), &patch_map)
+    if err != nil { return err }
+    entry.Patch = patch_map
+  }
+  return nil
+}
+
+func (entry_a *JournalEntry) FieldsDifference(other dbRecord) (diff map[string]any, err error) {
+  diff = make(map[string]any)
+  entry_b, b_is_entry := other.(*JournalEntry)
+  if b_is_entry == false { return diff, ErrInvalidType }
+
+  fields_a, err := entry_a.FieldsRead() ; if err != nil { return nil, err }
+  fields_b, err := entry_b.FieldsRead() ; if err != nil { return nil, err }
+  for key, value := range fields_b {
+    if fields_a[key] != value { diff[key] = value }
+  }
+  return diff, nil
+}
@@ -0,0 +1,224 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "os/exec"
+  "path/filepath"
+  "testing"
+)
+
+func TestJournal(test *testing.T) {
+  test.Parallel()
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
+  if err != nil { test.Fatalf("TestJournal: Open failed: %s", err) }
+  defer lib.Shutdown()
+
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestJournal: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestJournal: MediaPathSet failed: %s", err) }
+
+  md := Metadata { MediaType:MetadataMediaTypeFileVideo, NameDisplay:"one", Extension:".mp4", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&md)
+  if err != nil { test.Fatalf("TestJournal: MetadataCreate failed: %s", err) }
+  media_path, _ := md.DiskPath(MetadataPathTypeMedia)
+  err = os.WriteFile(media_path, []byte("media"), 0660)
+  if err != nil { test.Fatalf("TestJournal: writing media failed: %s", err) }
+
+  // completed operation leaves no journal entry
+  err = md.Rename("Two", "")
+  if err != nil { test.Fatalf("TestJournal: Rename failed: %s", err) }
+  renamed_path, _ := md.DiskPath(MetadataPathTypeMedia)
+  if !pathExists(renamed_path) || pathExists(media_path) { test.Fatalf("TestJournal: Rename didn't move media file") }
+  if entries, _ := lib.JournalList(); len(entries) != 0 { test.Fatalf("TestJournal: Rename left %d journal entries", len(entries)) }
+
+  // interrupted after moving: rolled forward
+  entry := JournalEntry {
+    Operation:"metadata-rename", Status:JournalStatusMoved, RecordTable:"metadata", RecordId:md.Id,
+    Moves:[]JournalMove { { Source:renamed_path, Destination:filepath.Join(lib.mediaPath, "three.mp4") } },
+    Patch:map[string]any { "name_display":"Three", "name_sort":"three" },
+  }
+  err = lib.dbRecordCreate(&entry)
+  if err != nil { test.Fatalf("TestJournal: creating entry failed: %s", err) }
+  err = os.Rename(renamed_path, filepath.Join(lib.mediaPath, "three.mp4"))
+  if err != nil { test.Fatalf("TestJournal: moving media failed: %s", err) }
+  err = lib.JournalRecover()
+  if err != nil { test.Fatalf("TestJournal: JournalRecover failed: %s", err) }
+  stored, err := lib.MetadataRead(md.Id)
+  if err != nil { test.Fatalf("TestJournal: MetadataRead failed: %s", err) }
+  if (stored.NameSort != "three") || (stored.NameDisplay != "Three") { test.Fatalf("TestJournal: moved entry not rolled forward: %s", stored.NameSort) }
+  if entries, _ := lib.JournalList(); len(entries) != 0 { test.Fatalf("TestJournal: recovery left %d journal entries", len(entries)) }
+
+  // interrupted while moving: undone
+  entry = JournalEntry {
+    Operation:"metadata-rename", Status:JournalStatusPending, RecordTable:"metadata", RecordId:md.Id,
+    Moves:[]JournalMove { { Source:filepath.Join(lib.mediaPath, "three.mp4"), Destination:filepath.Join(lib.mediaPath, "four.mp4") } },
+    Patch:map[string]any { "name_display":"Four", "name_sort":"four" },
+  }
+  err = lib.dbRecordCreate(&entry)
+  if err != nil { test.Fatalf("TestJournal: creating entry failed: %s", err) }
+  err = os.Rename(filepath.Join(lib.mediaPath, "three.mp4"), filepath.Join(lib.mediaPath, "four.mp4"))
+  if err != nil { test.Fatalf("TestJournal: moving media failed: %s", err) }
+  err = lib.JournalRecover()
+  if err != nil { test.Fatalf("TestJournal: JournalRecover failed: %s", err) }
+  if !pathExists(filepath.Join(lib.mediaPath, "three.mp4")) { test.Fatalf("TestJournal: pending entry not undone") }
+  stored, _ = lib.MetadataRead(md.Id)
+  if stored.NameSort != "three" { test.Fatalf("TestJournal: pending entry updated record: %s", stored.NameSort) }
+  if entries, _ := lib.JournalList(); len(entries) != 0 { test.Fatalf("TestJournal: recovery left %d journal entries", len(entries)) }
+
+  // unresolvable: left in place, failed
+  os.WriteFile(filepath.Join(lib.mediaPath, "five.mp4"), []byte("other"), 0660)
+  entry = JournalEntry {
+    Operation:"metadata-rename", Status:JournalStatusPending, RecordTable:"metadata", RecordId:md.Id,
+    Moves:[]JournalMove { { Source:filepath.Join(lib.mediaPath, "three.mp4"), Destination:filepath.Join(lib.mediaPath, "five.mp4") } },
+    Patch:map[string]any { "name_display":"Five", "name_sort":"five" },
+  }
+  err = lib.dbRecordCreate(&entry)
+  if err != nil { test.Fatalf("TestJournal: creating entry failed: %s", err) }
+  err = lib.JournalRecover()
+  if err != nil { test.Fatalf("TestJournal: JournalRecover failed: %s", err) }
+  entries, _ := lib.JournalList()
+  if (len(entries) != 1) || (entries[0].Status != JournalStatusFailed) || (entries[0].Error == "") { test.Fatalf("TestJournal: unresolvable entry not marked failed: %v", entries) }
+}
+
+func TestJournalOwner(test *testing.T) {
+  test.Parallel()
+  lib, err := LibraryOpen(filepath.Join(test.TempDir(), "test.database"))
+  if err != nil { test.Fatalf("TestJournalOwner: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestJournalOwner: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestJournalOwner: MediaPathSet failed: %s", err) }
+
+  // a process that has exited
+  exited := exec.Command(os.Args[0], "-test.run=^$")
+  err = exited.Run()
+  if err != nil { test.Fatalf("TestJournalOwner: running child failed: %s", err) }
+
+  // pending moves (not yet started): only those whose owner is gone are undone
+  owners := []JournalEntry {
+    JournalEntry { OwnerHost:journalOwnerHost, OwnerPid:journalOwnerPid,           OwnerStarted:journalOwnerStarted     }, // this process: live
+    JournalEntry { OwnerHost:journalOwnerHost, OwnerPid:int64(os.Getppid()),       OwnerStarted:1                       }, // parent process: live
+    JournalEntry { OwnerHost:"elsewhere",      OwnerPid:1,                         OwnerStarted:1                       }, // other host: can't tell
+    JournalEntry { OwnerHost:journalOwnerHost, OwnerPid:journalOwnerPid,           OwnerStarted:journalOwnerStarted - 1 }, // earlier process, same pid
+    JournalEntry { OwnerHost:journalOwnerHost, OwnerPid:int64(exited.Process.Pid), OwnerStarted:1                       }, // exited
+    JournalEntry {                                                                                                       }, // unknown (created before owners were recorded)
+  }
+  live := 3
+  for index := range owners {
+    source := filepath.Join(lib.mediaPath, fmt.Sprintf("%d.mp4", index))
+    os.WriteFile(source, []byte("media"), 0660)
+    owners[index].Operation   = "metadata-rename"
+    owners[index].Status      = JournalStatusPending
+    owners[index].RecordTable = "metadata"
+    owners[index].Moves       = []JournalMove { { Source:source, Destination:source + ".moved" } }
+    owners[index].Patch       = map[string]any {}
+    err = lib.dbRecordCreate(&(owners[index]))
+    if err != nil { test.Fatalf("TestJournalOwner: creating entry failed: %s", err) }
+  }
+  err = lib.JournalRecover()
+  if err != nil { test.Fatalf("TestJournalOwner: JournalRecover failed: %s", err) }
+  entries, _ := lib.JournalList()
+  if len(entries) != live { test.Fatalf("TestJournalOwner: expected %d entries left, found %d", live, len(entries)) }
+  for _, entry := range entries {
+    if !entry.OwnerAlive() || (entry.Status != JournalStatusPending) { test.Fatalf("TestJournalOwner: entry of live owner recovered: %v", entry) }
+  }
+
+}
+
+// Not parallel: replaces pathRename, so every rename fails as though across filesystems.
+func TestJournalCrossDevice(test *testing.T) {
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
+  if err != nil { test.Fatalf("TestJournalCrossDevice: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestJournalCrossDevice: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestJournalCrossDevice: MediaPathSet failed: %s", err) }
+
+  renames := 0
+  pathRename = func(source string, destination string) error {
+    renames += 1
+    return &os.LinkError { Op:"rename", Old:source, New:destination, Err:errCrossDevice }
+  }
+  defer func() { pathRename = os.Rename }()
+
+  // move is copied across, then the database update fails (no such record): undone by copying back
+  source_path      := filepath.Join(lib.mediaPath, "one.mp4")
+  destination_path := filepath.Join(lib.mediaPath, "two.mp4")
+  os.WriteFile(source_path, []byte("media"), 0660)
+  moves := []JournalMove { { Source:source_path, Destination:destination_path } }
+  err = lib.journalRun("metadata-rename", moves, "metadata", "no-such-record", map[string]any { "name_sort":"two" })
+  if err == nil { test.Fatalf("TestJournalCrossDevice: journalRun succeeded, updating a missing record") }
+  if renames != 2 { test.Fatalf("TestJournalCrossDevice: expected a move and its undo to try renaming, tried %d", renames) }
+  content, _ := os.ReadFile(source_path)
+  if (string(content) != "media") || pathExists(destination_path) { test.Fatalf("TestJournalCrossDevice: move not undone: %s", err) }
+  if pathExists(source_path + ".partial") || pathExists(destination_path + ".partial") { test.Fatalf("TestJournalCrossDevice: partial copy left behind") }
+  if entries, _ := lib.JournalList(); len(entries) != 0 { test.Fatalf("TestJournalCrossDevice: undo left %d journal entries: %v", len(entries), entries) }
+
+  // interrupted after copying across: undone on recovery
+  entry := JournalEntry {
+    Operation:"metadata-rename", Status:JournalStatusPending, RecordTable:"metadata", RecordId:"no-such-record",
+    Moves:moves, Patch:map[string]any { "name_sort":"two" },
+  }
+  err = lib.dbRecordCreate(&entry)
+  if err != nil { test.Fatalf("TestJournalCrossDevice: creating entry failed: %s", err) }
+  err = pathMove(source_path, destination_path)
+  if err != nil { test.Fatalf("TestJournalCrossDevice: pathMove failed: %s", err) }
+  err = lib.JournalRecover()
+  if err != nil { test.Fatalf("TestJournalCrossDevice: JournalRecover failed: %s", err) }
+  if !pathExists(source_path) || pathExists(destination_path) { test.Fatalf("TestJournalCrossDevice: pending entry not undone") }
+  if entries, _ := lib.JournalList(); len(entries) != 0 { test.Fatalf("TestJournalCrossDevice: recovery left %d journal entries: %v", len(entries), entries) }
+}
+
+// Not parallel: replaces pathRename, to fail a move partway through.
+func TestJournalUnparent(test *testing.T) {
+  lib, err := LibraryOpen(filepath.Join(test.TempDir(), "test.database"))
+  if err != nil { test.Fatalf("TestJournalUnparent: Open failed: %s", err) }
+  defer lib.Shutdown()
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestJournalUnparent: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestJournalUnparent: MediaPathSet failed: %s", err) }
+
+  cat, err := lib.CategoryCreate("Movies", CategoryMediaTypeMovie)
+  if err != nil { test.Fatalf("TestJournalUnparent: CategoryCreate failed: %s", err) }
+  children := []*Metadata {}
+  for _, name := range []string { "one", "two" } {
+    md := Metadata { ParentId:cat.Id, MediaType:MetadataMediaTypeFileVideo, NameDisplay:name, Extension:".mp4", Streams:[]FileStream {} }
+    err = lib.MetadataCreate(&md)
+    if err != nil { test.Fatalf("TestJournalUnparent: MetadataCreate failed: %s", err) }
+    media_path, _ := md.DiskPath(MetadataPathTypeMedia)
+    os.WriteFile(media_path, []byte("media"), 0660)
+    children = append(children, &md)
+  }
+
+  // second child's move fails: first is moved back, category kept
+  pathRename = func(source string, destination string) error {
+    if filepath.Base(source) == "two.mp4" { return &os.LinkError { Op:"rename", Old:source, New:destination, Err:os.ErrPermission } }
+    return os.Rename(source, destination)
+  }
+  err = lib.CategoryDelete(cat)
+  pathRename = os.Rename
+  if err == nil { test.Fatalf("TestJournalUnparent: CategoryDelete succeeded with a failing move") }
+  if _, err = lib.CategoryRead(cat.Id); err != nil { test.Fatalf("TestJournalUnparent: category deleted after failing move") }
+  for _, md := range children {
+    stored, _ := lib.MetadataRead(md.Id)
+    media_path, _ := stored.DiskPath(MetadataPathTypeMedia)
+    if (stored.ParentId != cat.Id) || !pathExists(media_path) { test.Fatalf("TestJournalUnparent: \"%s\" not back in category: %s", md.NameDisplay, media_path) }
+  }
+
+  // children (files & records) moved to media root, without leftover journal entries
+  err = lib.CategoryDelete(cat)
+  if err != nil { test.Fatalf("TestJournalUnparent: CategoryDelete failed: %s", err) }
+  for _, md := range children {
+    stored, _ := lib.MetadataRead(md.Id)
+    if (stored.ParentId != "") || !pathExists(filepath.Join(lib.mediaPath, md.NameSort + ".mp4")) { test.Fatalf("TestJournalUnparent: \"%s\" not moved to media root", md.NameDisplay) }
+  }
+  if pathExists(cat.DiskPath()) { test.Fatalf("TestJournalUnparent: category directory left behind") }
+  if entries, _ := lib.JournalList(); len(entries) != 0 { test.Fatalf("TestJournalUnparent: %d journal entries left", len(entries)) }
+}
@@ -4,9 +4,14 @@ import (
   "os"
   "io"
   "fmt"
-  "sync"
+  "time"
   "regexp"
   "strings"
+  "sync"
+  "io/fs"
+  "path/filepath"
+  "crypto/sha256"
+  "encoding/hex"
   "database/sql"
   _ "modernc.org/sqlite"
 )
@@ -20,40 +25,68 @@ var ErrQueryFailed     = fmt.Errorf("database query failed")
 var ErrInvalidName     = fmt.Errorf("invalid name")
 var ErrDbPathNotSet    = fmt.Errorf("database path not set")
 var ErrDbNotOpened     = fmt.Errorf("database not opened")
+var ErrDbInUse         = fmt.Errorf("database in use by another process (stop server, scanner, and transcoder first)")
 var ErrMediaPathNotSet = fmt.Errorf("media path not set")
 var ErrMediaPathNotDir = fmt.Errorf("media path not a valid directory")
 
 // ============================================================================
-// Shared Globals
+// Library
+
+// A database, and the media path it describes. Any number can be open at once;
+// package-level functions (see default.go) operate on a single default Library.
+type Library struct {
+  dbPath        string
+  dbHandle      *sql.DB    // reader pool
+  dbWriter      *sql.DB    // single connection; all writes (and transactions) are serialized through it
+  mediaPath     string     // cached; see mediaRoot
+  mediaPathRead time.Time  // when mediaPath was last read from database
+  mediaPathLock sync.Mutex
+}
 
-var dbPath    string  = ""
-var dbHandle  *sql.DB = nil
-var mediaPath string  = ""
-var dbLock    sync.RWMutex
+var defaultLibrary *Library = &Library {}
+
+// Open a library from its database (creating new db, if necessary).
+func LibraryOpen(database_path string) (*Library, error) {
+  lib := &Library {}
+  err := lib.Startup(database_path)
+  if err != nil { return nil, err }
+  return lib, nil
+}
 
 // ============================================================================
 // Library Lifecycle
 
-func LibraryStartup(database_path string) error {
-  dbPath = database_path
-  err := dbOpen()
+func (lib *Library) Startup(database_path string) error {
+  lib.dbPath        = database_path
+  lib.mediaPath     = ""
+  lib.mediaPathRead = time.Time {}
+  err := lib.dbOpen()
+  if err != nil { return err }
+
+  // finish (or undo) any file moves interrupted by a crash, before anything reads paths
+  err = lib.JournalRecover()
   if err != nil { return err }
 
-  MediaPathGet()
+  lib.MediaPathGet()
   return nil
 }
 
-func LibraryReady() error {
-  if dbPath    == ""                     { return ErrDbPathNotSet    }
-  if dbHandle  == nil                    { return ErrDbNotOpened     }
-  if mediaPath == ""                     { return ErrMediaPathNotSet }
-  if pathIsDirectory(mediaPath) == false { return ErrMediaPathNotDir }
+func (lib *Library) Ready() error {
+  if lib.dbPath    == ""                     { return ErrDbPathNotSet    }
+  if lib.dbHandle  == nil                    { return ErrDbNotOpened     }
+  media_path := lib.mediaRoot()
+  if media_path    == ""                     { return ErrMediaPathNotSet }
+  if pathIsDirectory(media_path) == false    { return ErrMediaPathNotDir }
 
   return nil
 }
 
-func LibraryShutdown() {
-  if dbHandle != nil { dbClose() }
+func (lib *Library) Shutdown() {
+  if lib.dbHandle != nil { lib.dbClose() }
+  lib.dbHandle      = nil
+  lib.dbWriter      = nil
+  lib.mediaPath     = ""
+  lib.mediaPathRead = time.Time {}
 }
 
 // ============================================================================
@@ -107,84 +140,222 @@ func fileCopy(source string, destination string) (err error) {
   if err != nil { return err }
   defer destination_file.Close()
 
-  io.Copy(destination_file, source_file)
+  _, err = io.Copy(destination_file, source_file)
   if err != nil { return err }
 
   return nil
 }
 
+// Renames within a filesystem; replaced in tests, to simulate moves across filesystems.
+var pathRename = os.Rename
+
 // Move path from source to destination (move/rename).
+// Across filesystems, source is copied, the copy verified, then source removed.
 func pathMove(source string, destination string) (err error) {
   if pathExists(source)      == false { return ErrNotFound   }
   if pathExists(destination) == true  { return ErrPathExists }
-  return os.Rename(source, destination)
+  err = pathRename(source, destination)
+  if (err == nil) || !errIsCrossDevice(err) { return err }
+
+  err = pathCopyVerified(source, destination)
+  if err != nil { return err }
+  return os.RemoveAll(source)
+}
+
+// Copy a file or directory tree; written to a partial path, verified against source, then renamed into place.
+func pathCopyVerified(source string, destination string) error {
+  partial_path := destination + ".partial"
+  os.RemoveAll(partial_path)
+  err := pathCopy(source, partial_path)
+  if err == nil { err = pathCompare(source, partial_path) }
+  if err == nil { err = os.Rename(partial_path, destination) }
+  if err != nil { os.RemoveAll(partial_path) }
+  return err
+}
+
+// Copy a file or directory tree, keeping permissions.
+func pathCopy(source string, destination string) error {
+  return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+    if err != nil { return err }
+    relative, _ := filepath.Rel(source, path)
+    target := filepath.Join(destination, relative)
+    info, err := entry.Info()
+    if err != nil { return err }
+    if entry.IsDir() { return os.MkdirAll(target, info.Mode().Perm()) }
+    if !info.Mode().IsRegular() { return fmt.Errorf("cannot copy \"%s\": not a regular file", path) }
+    err = fileCopy(path, target)
+    if err != nil { return err }
+    return os.Chmod(target, info.Mode().Perm())
+  })
+}
+
+// Verify two trees hold the same directories, and files (same size & content).
+func pathCompare(path_a string, path_b string) error {
+  count_a, count_b := 0, 0
+  err := filepath.WalkDir(path_a, func(path string, entry fs.DirEntry, err error) error {
+    if err != nil { return err }
+    count_a += 1
+    relative, _ := filepath.Rel(path_a, path)
+    stat_b, err := os.Stat(filepath.Join(path_b, relative))
+    if err != nil { return fmt.Errorf("verify failed: \"%s\" missing from copy", relative) }
+    if entry.IsDir() != stat_b.IsDir() { return fmt.Errorf("verify failed: \"%s\" differs in copy", relative) }
+    if entry.IsDir() { return nil }
+    hash_a, err := fileHash(path)
+    if err != nil { return err }
+    hash_b, err := fileHash(filepath.Join(path_b, relative))
+    if err != nil { return err }
+    if hash_a != hash_b { return fmt.Errorf("verify failed: \"%s\" differs in copy", relative) }
+    return nil
+  })
+  if err != nil { return err }
+  err = filepath.WalkDir(path_b, func(path string, entry fs.DirEntry, err error) error {
+    if err == nil { count_b += 1 }
+    return err
+  })
+  if err != nil { return err }
+  if count_a != count_b { return fmt.Errorf("verify failed: copy has %d entries, source has %d", count_b, count_a) }
+  return nil
+}
+
+// SHA-256 of a file's content (hex).
+func fileHash(path string) (string, error) {
+  file, err := os.Open(path)
+  if err != nil { return "", err }
+  defer file.Close()
+  hash := sha256.New()
+  _, err = io.Copy(hash, file)
+  if err != nil { return "", err }
+  return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 // ============================================================================
 // Database Utilities
 
 // Open the database (creating new db, if necessary).
-func dbOpen() error {
+func (lib *Library) dbOpen() error {
   var err error
-  dbHandle, err = sql.Open("sqlite", dbPath)
+  // WAL: readers don't block the writer (or each other), across processes (scanner, transcoder, server)
+  lib.dbWriter, err = sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate", lib.dbPath, dbBusyTimeout))
   if err != nil { return err }
+  lib.dbWriter.SetMaxOpenConns(1)
 
-  if err == nil {
-    dbLock.Lock()
-    defer dbLock.Unlock()
-    // sqlite won't complain about an invalid file until you actually attempt to write to it...
-    _, err = dbHandle.Exec(`CREATE TABLE is_connection_valid (id INTEGER PRIMARY KEY, name TEXT);`)
-    if err != nil { dbClose() ; return err }
-    _, err = dbHandle.Exec(`DROP TABLE is_connection_valid;`)
-    if err != nil { dbClose() ; return err }
-  }
-
+  // sqlite won't complain about an invalid file until you actually attempt to write to it...
+  err = dbRetry(func() error {
+    _, err := lib.dbWriter.Exec(`CREATE TABLE is_connection_valid (id INTEGER PRIMARY KEY, name TEXT);`)
+    if err != nil { return err }
+    _, err = lib.dbWriter.Exec(`DROP TABLE is_connection_valid;`)
+    return err
+  })
+  if err != nil { lib.dbWriter.Close() ; lib.dbWriter = nil ; return err }
+
+  lib.dbHandle, err = sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", lib.dbPath, dbBusyTimeout))
+  if err != nil { lib.dbWriter.Close() ; lib.dbWriter = nil ; return err }
+  lib.dbHandle.SetMaxOpenConns(dbReadersMax)
+  lib.dbHandle.SetMaxIdleConns(dbReadersIdle)
   return nil
 }
 
 // Close the database.
-func dbClose() error {
-  return dbHandle.Close()
+func (lib *Library) dbClose() error {
+  err := lib.dbHandle.Close()
+  if lib.dbWriter != nil {
+    // last connection out checkpoints, and removes the WAL
+    err_writer := lib.dbWriter.Close()
+    if err == nil { err = err_writer }
+  }
+  return err
 }
 
-// Copy database to backup file.
-func dbBackupCreate() error {
-  backup_path := fmt.Sprintf("%s.bak", dbPath)
-  if (pathExists(dbPath) == false) { return ErrNotFound }
+// Copy database to backup file (online, with VACUUM INTO).
+func (lib *Library) dbBackupCreate() error {
+  backup_path := fmt.Sprintf("%s.bak", lib.dbPath)
   if (pathExists(backup_path) == true) {
     err := os.Remove(backup_path)
     if err != nil { return err }
   }
 
-  return fileCopy(dbPath, backup_path)
+  return lib.dbVacuumInto(backup_path)
 }
 
 // Restore database from backup file.
-func dbBackupRestore() error {
-  backup_path := fmt.Sprintf("%s.bak", dbPath)
-  if (pathExists(backup_path) == false) { return ErrNotFound }
-  if (pathExists(dbPath) == true) {
-    err := os.Remove(dbPath)
-    if err != nil { return err }
+func (lib *Library) dbBackupRestore() error {
+  backup_path := fmt.Sprintf("%s.bak", lib.dbPath)
+  return lib.dbReplace(backup_path)
+}
+
+// Replace database file with a copy of another; an open database is closed first, and reopened after.
+// Refused while other processes have the database open (removing its files under them would corrupt it).
+func (lib *Library) dbReplace(source_path string) error {
+  if (pathExists(source_path) == false) { return ErrNotFound }
+
+  was_open := (lib.dbHandle != nil)
+  if was_open { lib.dbClose() ; lib.dbHandle = nil ; lib.dbWriter = nil }
+  err := dbExclusive(lib.dbPath)
+  if err != nil {
+    if was_open { lib.dbOpen() }
+    return err
   }
+  for _, suffix := range []string { "", "-journal", "-wal", "-shm" } { os.Remove(lib.dbPath + suffix) }
+  err = fileCopy(source_path, lib.dbPath)
+  if err != nil { return err }
+  lib.mediaPathExpire()
 
-  return fileCopy(backup_path, dbPath)
+  if was_open { return lib.dbOpen() }
+  return nil
 }
 
-// Begin a transaction.
-func dbTransactionBegin() error {
-  _, err := dbHandle.Exec(`BEGIN TRANSACTION;`)
-  return err
+// Check no other connections have the database open, by leaving WAL mode (which waits out the busy timeout, then fails, while any other connection is open).
+// Back in rollback mode, the -wal & -shm files are removed, and opening in WAL mode again recreates them.
+func dbExclusive(path string) error {
+  if (pathExists(path) == false) { return nil }
+  db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", path, dbBusyTimeout))
+  if err != nil { return err }
+  defer db.Close()
+  mode := ""
+  err = db.QueryRow(`PRAGMA journal_mode=DELETE;`).Scan(&mode)
+  if (err != nil) || (mode != "delete") { return ErrDbInUse }
+  return nil
 }
 
-// Commit a transaction (that has begun, and not been rolled back).
-func dbTransactionCommit() error {
-  _, err := dbHandle.Exec(`COMMIT;`)
-  return err
+// Run work as a single transaction: committed if work returns nil, rolled back otherwise (or if work panics).
+// The writer connection is held throughout, so work must only write through tx; other writes would deadlock
+// (reads elsewhere won't see the transaction's changes until it's committed).
+func (lib *Library) dbTransaction(work func(tx *dbTx) error) (err error) {
+  var sql_tx *sql.Tx
+  err = dbRetry(func() (err error) { sql_tx, err = lib.dbWriter.Begin() ; return err })
+  if err != nil { return err }
+  committed := false
+  defer func() { if !committed { sql_tx.Rollback() } }()
+
+  err = work(&dbTx { tx:sql_tx, lib:lib })
+  if err != nil { return err }
+  err = sql_tx.Commit()
+  if err != nil { return err }
+  committed = true
+  return nil
 }
 
-// Rollback a transaction (that has begun, and not been committed).
-func dbTransactionRollback() error {
-  _, err := dbHandle.Exec(`ROLLBACK;`)
-  return err
+const dbBusyTimeout int = 5000 // milliseconds a connection waits on another's lock, before SQLITE_BUSY
+const dbBusyRetries int = 5    // retries after SQLITE_BUSY, with backoff
+const dbReadersMax  int = 8    // connections in reader pool
+const dbReadersIdle int = 4    // idle connections kept in reader pool
+
+// Run a query, retrying while the database is busy (another process holding a lock past the busy timeout,
+// or a lock that sqlite can't wait on, like a reader upgrading to writer).
+func dbRetry(query func() error) (err error) {
+  for attempt := 0; ; attempt++ {
+    err = query()
+    if (attempt >= dbBusyRetries) || !dbErrIsBusy(err) { return err }
+    time.Sleep(time.Duration(50 << attempt) * time.Millisecond)
+  }
+}
+
+// Is error SQLITE_BUSY, or SQLITE_LOCKED (including extended codes)?
+func dbErrIsBusy(err error) bool {
+  if err == nil { return false }
+  if coded, ok := err.(interface { Code() int }); ok {
+    code := coded.Code() & 0xFF
+    return (code == 5) || (code == 6)
+  }
+  return strings.Contains(err.Error(), "SQLITE_BUSY") || strings.Contains(err.Error(), "database is locked")
 }
@@ -3,21 +3,36 @@ package library
 import (
   "os"
   "fmt"
-  "image"
-  "image/jpeg"
-  "github.com/nfnt/resize"
   "strings"
   "path/filepath"
   "encoding/json"
+  "database/sql"
 )
 type MetadataPathType string
 const (
-  MetadataPathTypeBase        MetadataPathType = "base"
-  MetadataPathTypeMedia       MetadataPathType = "media"
-  MetadataPathTypePosterLarge MetadataPathType = "poster_large"
-  MetadataPathTypePosterSmall MetadataPathType = "poster_small"
+  MetadataPathTypeBase         MetadataPathType = "base"
+  MetadataPathTypeMedia        MetadataPathType = "media"
+  MetadataPathTypePosterLarge  MetadataPathType = "poster_large"
+  MetadataPathTypePosterSmall  MetadataPathType = "poster_small"
+  MetadataPathTypeBif          MetadataPathType = "bif"
+  MetadataPathTypeThumbs       MetadataPathType = "thumbs"
+  MetadataPathTypePosterMaster MetadataPathType = "poster_master"
+  MetadataPathTypePosterCache  MetadataPathType = "poster_cache"
+  MetadataPathTypeArtwork      MetadataPathType = "artwork"
 )
 
+// all files (or directories) on disk belonging to a Metadata, that move/delete with it
+var metadataFilePathTypes = []MetadataPathType {
+  MetadataPathTypeMedia,
+  MetadataPathTypePosterLarge,
+  MetadataPathTypePosterSmall,
+  MetadataPathTypeBif,
+  MetadataPathTypeThumbs,
+  MetadataPathTypePosterMaster,
+  MetadataPathTypePosterCache,
+  MetadataPathTypeArtwork,
+}
+
 type MetadataMediaType string
 const (
   MetadataMediaTypeFileVideo MetadataMediaType = "file-video"
@@ -29,14 +44,24 @@ const (
 )
 
 type Metadata struct {
-  Id          string            `json:"id"`
-  ParentId    string            `json:"parent_id"`
-  MediaType   MetadataMediaType `json:"media_type"`
-  NameDisplay string            `json:"name_display"`
-  NameSort    string            `json:"name_sort"`
-  Streams     []FileStream      `json:"streams"`
-  Duration    int64             `json:"duration"`
-  Size        int64             `json:"size"`
+  Id             string            `json:"id"`
+  ParentId       string            `json:"parent_id"`
+  MediaType      MetadataMediaType `json:"media_type"`
+  NameDisplay    string            `json:"name_display"`
+  NameSort       string            `json:"name_sort"`
+  Streams        []FileStream      `json:"streams"`
+  Duration       int64             `json:"duration"`
+  Size           int64             `json:"size"`
+  Chapters       []MetadataChapter `json:"chapters"`
+  PosterBlurhash string            `json:"poster_blurhash"` // placeholder, for clients, while poster loads
+  PosterColor    string            `json:"poster_color"`    // dominant color of poster, as "#rrggbb"
+  Year           int64             `json:"year"`            // 0 == unknown
+  TrackNumber    int64             `json:"track_number"`    // 0 == unknown
+  DiscNumber     int64             `json:"disc_number"`     // 0 == unknown
+  Plot           string            `json:"plot"`
+  ExternalIds    map[string]string `json:"external_ids"`    // provider name -> provider's id for this item
+  Extension      string            `json:"extension"`       // of media file, for file types (".mp4", ".flac", ...)
+  library        *Library                                    // library read from/created in (nil == default)
 }
 
 type PathComponent struct {
@@ -49,112 +74,106 @@ type PathComponent struct {
 
 func (md *Metadata) Copy() (*Metadata) {
   copy := Metadata {}
-  copy.Id          = md.Id
-  copy.ParentId    = md.ParentId
-  copy.MediaType   = md.MediaType
-  copy.NameDisplay = md.NameDisplay
-  copy.NameSort    = md.NameSort
-  copy.Streams     = make([]FileStream, len(md.Streams))
-  copy.Duration    = md.Duration
-  copy.Size        = md.Size
+  copy.Id             = md.Id
+  copy.ParentId       = md.ParentId
+  copy.MediaType      = md.MediaType
+  copy.NameDisplay    = md.NameDisplay
+  copy.NameSort       = md.NameSort
+  copy.Streams        = make([]FileStream, len(md.Streams))
+  copy.Duration       = md.Duration
+  copy.Size           = md.Size
+  copy.Chapters       = make([]MetadataChapter, len(md.Chapters))
+  copy.PosterBlurhash = md.PosterBlurhash
+  copy.PosterColor    = md.PosterColor
+  copy.Year           = md.Year
+  copy.TrackNumber    = md.TrackNumber
+  copy.DiscNumber     = md.DiscNumber
+  copy.Plot           = md.Plot
+  copy.ExternalIds    = make(map[string]string, len(md.ExternalIds))
+  copy.Extension      = md.Extension
+  copy.library        = md.library
 
   for index, stream := range md.Streams {
     stream_copy := stream.Copy()
     copy.Streams[index] = *stream_copy
   }
+  for index, chapter := range md.Chapters {
+    copy.Chapters[index] = chapter
+  }
+  for provider, external_id := range md.ExternalIds {
+    copy.ExternalIds[provider] = external_id
+  }
 
   return &copy
 }
 
 func (md *Metadata) DiskPath(path_type MetadataPathType) (string, error) {
+  lib := md.lib()
   md_path := md.NameSort
+  root_path := lib.mediaRoot() // category's volume, or primary for Lost Items
 
   parent_id := md.ParentId
   for parent_id != "" {
-    if CategoryIdExists(parent_id) {
-      cat, err := CategoryRead(parent_id)
+    if lib.CategoryIdExists(parent_id) {
+      cat, err := lib.CategoryRead(parent_id)
       if err != nil { return "", fmt.Errorf("category not found: %s", parent_id) }
-      md_path = filepath.Join(cat.Name, md_path)
+      root_path = cat.DiskPath()
       break
     } else {
       parent := Metadata {}
-      err := dbRecordRead(&parent, parent_id)
+      err := lib.dbRecordRead(&parent, parent_id)
       if err != nil { return "", fmt.Errorf("metadata not found: %s", parent_id) }
       md_path = filepath.Join(parent.NameSort, md_path)
       parent_id = parent.ParentId
     }
   }
-  md_path = filepath.Join(mediaPath, md_path)
-
-  switch path_type {
-    case MetadataPathTypeMedia: {
-      switch(md.MediaType) {
-        case MetadataMediaTypeFileVideo: md_path += ".mp4"
-        case MetadataMediaTypeFileAudio: md_path += ".mp3"
-      }
-    }
-    case MetadataPathTypePosterLarge: md_path += ".large.jpg"
-    case MetadataPathTypePosterSmall: md_path += ".small.jpg"
-    case MetadataPathTypeBase: ; // do nothing
-  }
+  md_path = filepath.Join(root_path, md_path)
+  md_path += metadataPathSuffix(md, path_type)
 
   return md_path, nil
 }
 
 func (md *Metadata) Reparent(new_parent_id string) error {
+  lib := md.lib()
   if new_parent_id == md.ParentId { return nil }
 
-  parent_path := mediaPath
+  parent_path := lib.mediaRoot()
   if new_parent_id != "" {
-    if CategoryIdExists(new_parent_id) {
-      cat, err := CategoryRead(new_parent_id)
+    if lib.CategoryIdExists(new_parent_id) {
+      cat, err := lib.CategoryRead(new_parent_id)
       if err != nil { return fmt.Errorf("category not found: %s", new_parent_id) }
       parent_path = cat.DiskPath()
     } else {
       parent := Metadata {}
-      err := dbRecordRead(&parent, new_parent_id)
+      err := lib.dbRecordRead(&parent, new_parent_id)
       if err != nil { return fmt.Errorf("metadata not found: %s", new_parent_id) }
       parent_path, err = parent.DiskPath(MetadataPathTypeMedia)
       if err != nil { return err }
     }
   }
 
-  // verify name_sort, within parent, is unique
-  records, err := dbRecordWhere(&Metadata{}, `(parent_id = ?) AND (name_sort = ?) LIMIT 1;`, new_parent_id, md.NameSort)
-  if err != nil { return ErrQueryFailed }
-  if len(records) > 0 { return fmt.Errorf("metadata named \"%s\" already exists in parent \"%s\"", md.NameSort, new_parent_id) }
-
-  // verify name_sort, on disk, isn't taken
-  if metadataCanMoveFilesToPath(md, parent_path) == false {
-    return fmt.Errorf("metadata named \"%s\" already exists on disk", md.NameSort)
-  }
+  err := lib.metadataCanReparent(md, new_parent_id, parent_path)
+  if err != nil { return err }
 
-  // move files on disk
+  // move files on disk & update record
   old_parent_id := md.ParentId
-  paths_source := make([]string, 3)
-  paths_dest   := make([]string, 3)
-  md.ParentId = old_parent_id
-  paths_source[0], _ = md.DiskPath(MetadataPathTypeMedia)
-  paths_source[1], _ = md.DiskPath(MetadataPathTypePosterLarge)
-  paths_source[2], _ = md.DiskPath(MetadataPathTypePosterSmall)
+  moves := make([]JournalMove, len(metadataFilePathTypes))
+  for index, path_type := range metadataFilePathTypes { moves[index].Source, _ = md.DiskPath(path_type) }
   md.ParentId = new_parent_id
-  paths_dest[0], _ = md.DiskPath(MetadataPathTypeMedia)
-  paths_dest[1], _ = md.DiskPath(MetadataPathTypePosterLarge)
-  paths_dest[2], _ = md.DiskPath(MetadataPathTypePosterSmall)
+  for index, path_type := range metadataFilePathTypes { moves[index].Destination, _ = md.DiskPath(path_type) }
   md.ParentId = old_parent_id
-  for index := range paths_source {
-    if pathExists(paths_source[index]) == false { continue }
-    err := os.Rename(paths_source[index], paths_dest[index])
-    if err != nil { return err }
-  }
+  patch := map[string]any { "parent_id":new_parent_id }
+  err = lib.journalRun("metadata-reparent", moves, md.TableName(), md.Id, patch)
+  if err != nil { return err }
+  md.FieldsPatch(patch)
 
-  // update record
-  err = dbRecordPatch(md, map[string]any { "parent_id":new_parent_id })
-  if err != nil { return ErrQueryFailed }
+  // series/season/album without a poster default to their first child's
+  lib.posterDefaultAncestors(new_parent_id)
   return nil
 }
 
 func (md *Metadata) Rename(new_name_display string, new_name_sort string) error {
+  lib := md.lib()
   if new_name_sort == "" { new_name_sort = nameGetSortForDisplay(new_name_display) }
   new_name_sort = strings.TrimSpace(new_name_sort)
 
@@ -162,7 +181,7 @@ func (md *Metadata) Rename(new_name_display string, new_name_sort string) error
 
   if new_name_sort != md.NameSort {
     // verify name_sort, within parent, is unique
-    records, err := dbRecordWhere(&Metadata{}, `(parent_id = ?) AND (name_sort = ?) LIMIT 1;`, md.ParentId, new_name_sort)
+    records, err := lib.dbRecordWhere(&Metadata{}, `(parent_id = ?) AND (name_sort = ?) LIMIT 1;`, md.ParentId, new_name_sort)
     if err != nil { return ErrQueryFailed }
     if len(records) > 0 { return fmt.Errorf("metadata named \"%s\" already exists in parent \"%s\"", new_name_sort, md.ParentId) }
 
@@ -175,94 +194,45 @@ func (md *Metadata) Rename(new_name_display string, new_name_sort string) error
       md.NameSort = old_name_sort
       return fmt.Errorf("metadata named \"%s\" already exists on disk", new_name_sort)
     }
-
-    // move files on disk
-    paths_source := make([]string, 3)
-    paths_dest   := make([]string, 3)
-    md.NameSort = old_name_sort
-    paths_source[0], _ = md.DiskPath(MetadataPathTypeMedia)
-    paths_source[1], _ = md.DiskPath(MetadataPathTypePosterLarge)
-    paths_source[2], _ = md.DiskPath(MetadataPathTypePosterSmall)
-    md.NameSort = new_name_sort
-    paths_dest[0], _ = md.DiskPath(MetadataPathTypeMedia)
-    paths_dest[1], _ = md.DiskPath(MetadataPathTypePosterLarge)
-    paths_dest[2], _ = md.DiskPath(MetadataPathTypePosterSmall)
     md.NameSort = old_name_sort
-    for index := range paths_source {
-      if pathExists(paths_source[index]) == false { continue }
-      err := os.Rename(paths_source[index], paths_dest[index])
-      if err != nil { return err }
-    }
   }
 
-  // update record
-  err := dbRecordPatch(md, map[string]any { "name_display":new_name_display, "name_sort":new_name_sort })
-  if err != nil { return ErrQueryFailed }
+  // move files on disk (if name_sort changed) & update record
+  moves := make([]JournalMove, len(metadataFilePathTypes))
+  for index, path_type := range metadataFilePathTypes { moves[index].Source, _ = md.DiskPath(path_type) }
+  old_name_sort := md.NameSort
+  md.NameSort = new_name_sort
+  for index, path_type := range metadataFilePathTypes { moves[index].Destination, _ = md.DiskPath(path_type) }
+  md.NameSort = old_name_sort
+  if new_name_sort == old_name_sort { moves = []JournalMove {} }
+  patch := map[string]any { "name_display":new_name_display, "name_sort":new_name_sort }
+  err := lib.journalRun("metadata-rename", moves, md.TableName(), md.Id, patch)
+  if err != nil { return err }
+  md.FieldsPatch(patch)
   return nil
 }
 
-func (md *Metadata) SetPoster(img image.Image) error {
-  // get image sizes
-  large_width  := uint(1)
-  large_height := uint(1)
-  small_width  := uint(1)
-  small_height := uint(1)
-
-  switch(md.MediaType) {
-    case MetadataMediaTypeFileVideo: fallthrough
-    case MetadataMediaTypeSeries   : fallthrough
-    case MetadataMediaTypeSeason   : {
-      large_width  = 360
-      large_height = 540
-      small_width  = 183
-      small_height = 275
-    }
-    case MetadataMediaTypeFileAudio: fallthrough
-    case MetadataMediaTypeArtist   : fallthrough
-    case MetadataMediaTypeAlbum    : {
-      large_width  = 512
-      large_height = 512
-      small_width  = 200
-      small_height = 200
-    }
-  }
-
-  // save large poster
-  large_path, err := md.DiskPath(MetadataPathTypePosterLarge)
-  if err != nil { return err }
-  large_file, err := os.Create(large_path)
-  if err != nil { return err }
-  defer large_file.Close()
-  large_image := resize.Resize(large_width, large_height, img, resize.NearestNeighbor)
-  err = jpeg.Encode(large_file, large_image, nil)
-  if err != nil { return err }
-
-  // save small poster
-  small_path, err := md.DiskPath(MetadataPathTypePosterSmall)
-  if err != nil { return err }
-  small_file, err := os.Create(small_path)
-  if err != nil { return err }
-  defer small_file.Close()
-  small_image := resize.Resize(small_width, small_height, img, resize.NearestNeighbor)
-  err = jpeg.Encode(small_file, small_image, nil)
-  if err != nil { return err }
-
+// Update descriptive fields; zero values clear them.
+func (md *Metadata) SetDetails(year int64, track_number int64, disc_number int64, plot string) error {
+  if (year < 0) || (track_number < 0) || (disc_number < 0) { return fmt.Errorf("invalid year, track, or disc number") }
+  err := md.lib().dbRecordPatch(md, map[string]any { "year":year, "track_number":track_number, "disc_number":disc_number, "plot":strings.TrimSpace(plot) })
+  if err != nil { return ErrQueryFailed }
   return nil
 }
 
-func MetadataRead(id string) (*Metadata, error) {
+func (lib *Library) MetadataRead(id string) (*Metadata, error) {
   md := Metadata {}
-  err := dbRecordRead(&md, id)
+  err := lib.dbRecordRead(&md, id)
   if err != nil { return nil, err }
   return &md, nil
 }
 
-func PathForId(id string) ([]PathComponent, error) {
+func (lib *Library) PathForId(id string) ([]PathComponent, error) {
   components := []PathComponent{}
 
   for {
-    if CategoryIdExists(id) {
-      cat, err := CategoryRead(id)
+    if lib.CategoryIdExists(id) {
+      cat, err := lib.CategoryRead(id)
       if err != nil { return nil, fmt.Errorf("error reading category %s", id) }
 
       cmp := PathComponent{}
@@ -274,7 +244,7 @@ func PathForId(id string) ([]PathComponent, error) {
     }
 
     md := Metadata {}
-    err := dbRecordRead(&md, id)
+    err := lib.dbRecordRead(&md, id)
     if err != nil { return nil, fmt.Errorf("metadata record not found: %s", id) }
 
     cmp := PathComponent{}
@@ -286,21 +256,23 @@ func PathForId(id string) ([]PathComponent, error) {
   }
 }
 
-func MetadataForParent(parent_id string) ([]Metadata, error) {
-  records, err := dbRecordWhere(&Metadata{}, `(parent_id = ?) ORDER BY name_sort ASC`, parent_id)
+func (lib *Library) MetadataForParent(parent_id string) ([]Metadata, error) {
+  records, err := lib.dbRecordWhere(&Metadata{}, `(parent_id = ?) ORDER BY name_sort ASC`, parent_id)
   if err != nil { return nil, ErrQueryFailed }
   metadata := make([]Metadata, len(records))
   for index, record := range records { metadata[index] = *(record.(*Metadata)) }
   return metadata, nil
 }
 
-func MetadataCreate(md *Metadata) error {
+func (lib *Library) MetadataCreate(md *Metadata) error {
+  md.setLibrary(lib)
+
   // verify valid name_sort
   if md.NameSort == "" { md.NameSort = nameGetSortForDisplay(md.NameDisplay) }
   if !nameValidForDisk(md.NameSort) { return ErrInvalidName }
 
   // verify name_sort, within parent, is unique
-  records, err := dbRecordWhere(&Metadata{}, `(parent_id = ?) AND (name_sort = ?) LIMIT 1;`, md.ParentId, md.NameSort)
+  records, err := lib.dbRecordWhere(&Metadata{}, `(parent_id = ?) AND (name_sort = ?) LIMIT 1;`, md.ParentId, md.NameSort)
   if err != nil { return ErrQueryFailed }
   if len(records) > 0 { return fmt.Errorf("metadata named \"%s\" already exists in parent \"%s\"", md.NameSort, md.ParentId) }
 
@@ -313,47 +285,88 @@ func MetadataCreate(md *Metadata) error {
   }
 
   // save record
-  err = dbRecordCreate(md)
+  err = lib.dbRecordCreate(md)
   if err != nil { return ErrQueryFailed }
   return nil
 }
 
-func MetadataDelete(md *Metadata, delete_children bool) error {
+func (lib *Library) MetadataDelete(md *Metadata, delete_children bool) error {
   if delete_children == true {
-    // delete all children first (cannot bulk because of parent_id hierarchy)
-    children, err := MetadataForParent(md.Id)
+    // gather whole subtree, children before their parents (cannot bulk delete because of parent_id hierarchy)
+    records, err := lib.metadataSubtree(md)
     if err != nil { return err }
-    for _, child := range children {
-      err := MetadataDelete(&child, true)
-      if err != nil { return err }
-    }
-  } else {
-    // reparent all children to "lost" (empty parent)
-    children, err := MetadataForParent(md.Id)
+    paths := []string {}
+    for index := range records { paths = append(paths, metadataFilePaths(&records[index])...) }
+
+    // delete records together; only then files, so a failure leaves the tree as it was
+    err = lib.dbTransaction(func(tx *dbTx) error {
+      for index := range records {
+        err := tx.RecordDelete(&records[index])
+        if err != nil { return err }
+      }
+      return nil
+    })
+    if err != nil { return ErrQueryFailed }
+    return metadataRemovePaths(paths)
+  }
+
+  // reparent all children to "lost" (empty parent): verify all can move, then move each (journaled); then delete record
+  children, err := lib.MetadataForParent(md.Id)
+  if err != nil { return err }
+  for index := range children {
+    err = lib.metadataCanReparent(&children[index], "", lib.mediaRoot())
     if err != nil { return err }
-    for _, child := range children {
-      err := child.Reparent("")
-      if err != nil { return err }
-    }
   }
+  err = lib.metadataUnparentAll(children, md.Id)
+  if err != nil { return err }
+  paths := metadataFilePaths(md)
+  err = lib.dbRecordDelete(md)
+  if err != nil { return ErrQueryFailed }
+  return metadataRemovePaths(paths)
+}
+
+// Move children of parent_id to "lost" (empty parent), each with a journaled Reparent; if one fails, those already moved are moved back.
+func (lib *Library) metadataUnparentAll(children []Metadata, parent_id string) error {
+  for index := range children {
+    err := children[index].Reparent("")
+    if err == nil { continue }
+    for moved := 0; moved < index; moved++ { children[moved].Reparent(parent_id) }
+    return fmt.Errorf("error moving child \"%s\" to media root: %s", children[index].NameDisplay, err.Error())
+  }
+  return nil
+}
+
+// A record and all of its descendants, descendants first.
+func (lib *Library) metadataSubtree(md *Metadata) ([]Metadata, error) {
+  subtree := []Metadata {}
+  children, err := lib.MetadataForParent(md.Id)
+  if err != nil { return nil, err }
+  for index := range children {
+    descendants, err := lib.metadataSubtree(&children[index])
+    if err != nil { return nil, err }
+    subtree = append(subtree, descendants...)
+  }
+  return append(subtree, *md), nil
+}
+
+// Paths of all of a record's files that exist on disk.
+func metadataFilePaths(md *Metadata) []string {
+  paths := []string {}
+  for _, path_type := range metadataFilePathTypes {
+    path, _ := md.DiskPath(path_type)
+    if pathExists(path) { paths = append(paths, path) }
+  }
+  return paths
+}
 
-  // delete files on disk
-  paths := make([]string, 3)
-  paths[0], _ = md.DiskPath(MetadataPathTypeMedia)
-  paths[1], _ = md.DiskPath(MetadataPathTypePosterLarge)
-  paths[2], _ = md.DiskPath(MetadataPathTypePosterSmall)
+// Remove files (or directories), continuing past errors.
+func metadataRemovePaths(paths []string) error {
   var anyerr error = nil
-  for index := range paths {
-    if pathExists(paths[index]) == false { continue }
-    err := os.Remove(paths[index])
+  for _, path := range paths {
+    err := os.RemoveAll(path)
     if err != nil { anyerr = err }
   }
-  if anyerr != nil { return anyerr }
-
-  // delete record
-  err := dbRecordDelete(md)
-  if err != nil { return ErrQueryFailed }
-  return nil
+  return anyerr
 }
 
 type MetadataTreeNode struct {
@@ -362,25 +375,26 @@ type MetadataTreeNode struct {
   MediaType string             `json:"media_type"`
   Children  []MetadataTreeNode `json:"children"` // not a map because want this ordered
 }
-func MetadataParentTree(parent_id string) ([]MetadataTreeNode, error) {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
+func (lib *Library) MetadataParentTree(parent_id string) ([]MetadataTreeNode, error) {
   listing := []MetadataTreeNode {}
-  rows, err := dbHandle.Query(`SELECT id, name_display, media_type FROM metadata WHERE parent_id = ? ORDER BY name_sort;`, parent_id)
+  var rows *sql.Rows
+  err := dbRetry(func() (err error) { rows, err = lib.dbHandle.Query(`SELECT id, name_display, media_type FROM metadata WHERE parent_id = ? ORDER BY name_sort;`, parent_id) ; return err })
   if err != nil { return listing, err }
-  defer rows.Close()
 
   for rows.Next() {
     var id, name, media_type string
     err = rows.Scan(&id, &name, &media_type)
-    if err != nil { return listing, err }
+    if err != nil { rows.Close() ; return listing, err }
 
     if (media_type == string(MetadataMediaTypeFileAudio)) || (media_type == string(MetadataMediaTypeFileVideo)) { continue }
-    entry := MetadataTreeNode { Id: id, Name: name, MediaType: media_type, Children: []MetadataTreeNode{} }
-    entry.Children, err = MetadataParentTree(id)
-    if err != nil { return listing, nil }
+    listing = append(listing, MetadataTreeNode { Id: id, Name: name, MediaType: media_type, Children: []MetadataTreeNode{} })
+  }
+  rows.Close()
 
-    listing = append(listing, entry)
+  // children read after rows are closed, so recursion doesn't hold a reader connection per level
+  for index := range listing {
+    listing[index].Children, err = lib.MetadataParentTree(listing[index].Id)
+    if err != nil { return listing, nil }
   }
 
   return listing, nil
@@ -389,19 +403,13 @@ func MetadataParentTree(parent_id string) ([]MetadataTreeNode, error) {
 // ============================================================================
 // public utilities
 
-func MetadataIdExists(id string) bool {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
-  queryRow := dbHandle.QueryRow(`SELECT id FROM metadata WHERE id = ? LIMIT 1;`, id)
-  err := queryRow.Scan(&id)
+func (lib *Library) MetadataIdExists(id string) bool {
+  err := dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT id FROM metadata WHERE id = ? LIMIT 1;`, id).Scan(&id) })
   return (err == nil)
 }
 
-func MetadataIsEmpty(id string) bool {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
-  row := dbHandle.QueryRow(`SELECT id FROM metadata WHERE parent_id = ? LIMIT 1;`, id)
-  err := row.Scan(&id)
+func (lib *Library) MetadataIsEmpty(id string) bool {
+  err := dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT id FROM metadata WHERE parent_id = ? LIMIT 1;`, id).Scan(&id) })
   found := (err == nil)
   return !found
 }
@@ -425,48 +433,42 @@ func metadataMediaTypeValid(media_type MetadataMediaType) bool {
   }
 }
 
-func metadataCanMoveFilesToPath(md *Metadata, path string) bool {
-  check_paths := []string {
-    filepath.Join(path, md.NameSort) + ".large.jpg",
-    filepath.Join(path, md.NameSort) + ".small.jpg",
-  }
-  switch(md.MediaType) {
-    case MetadataMediaTypeFileVideo: check_paths = append(check_paths, filepath.Join(path, md.NameSort) + ".mp4")
-    case MetadataMediaTypeFileAudio: check_paths = append(check_paths, filepath.Join(path, md.NameSort) + ".mp3")
-    default:                         check_paths = append(check_paths, filepath.Join(path, md.NameSort)         )
-  }
-  for _, check_path := range check_paths {
-    if pathExists(check_path) { return false }
+// Suffix appended to a Metadata's base path, for each type of file on disk.
+func metadataPathSuffix(md *Metadata, path_type MetadataPathType) string {
+  switch path_type {
+    case MetadataPathTypeMedia: {
+      if md.Extension != "" { return md.Extension }
+      switch(md.MediaType) { // records from before extensions were stored
+        case MetadataMediaTypeFileVideo: return ".mp4"
+        case MetadataMediaTypeFileAudio: return ".mp3"
+      }
+    }
+    case MetadataPathTypePosterLarge:  return ".large.jpg"
+    case MetadataPathTypePosterSmall:  return ".small.jpg"
+    case MetadataPathTypeBif:          return ".bif"
+    case MetadataPathTypeThumbs:       return ".thumbs"
+    case MetadataPathTypePosterMaster: return ".poster.png"
+    case MetadataPathTypePosterCache:  return ".posters"
+    case MetadataPathTypeArtwork:      return ".artwork"
   }
-  return true
+  return ""
 }
-func metadataMoveFilesToPath(md *Metadata, path string) error {
-  path_base_before, err := md.DiskPath(MetadataPathTypeBase)
-  path_base_after := filepath.Join(path, md.NameSort)
-  if err != nil { return err }
 
-  paths_before := []string {
-    path_base_before + ".large.jpg",
-    path_base_before + ".small.jpg",
-  }
-  paths_after := []string {
-    path_base_after + ".large.jpg",
-    path_base_after + ".small.jpg",
-  }
-  switch(md.MediaType) {
-    case MetadataMediaTypeFileVideo: paths_before = append(paths_before, path_base_before + ".mp4") ; paths_after = append(paths_after, path_base_after + ".mp4")
-    case MetadataMediaTypeFileAudio: paths_before = append(paths_before, path_base_before + ".mp3") ; paths_after = append(paths_after, path_base_after + ".mp3")
-    default:                         paths_before = append(paths_before, path_base_before         ) ; paths_after = append(paths_after, path_base_after         )
+// Verify name_sort is free within a new parent, both in the DB and on disk.
+func (lib *Library) metadataCanReparent(md *Metadata, new_parent_id string, parent_path string) error {
+  records, err := lib.dbRecordWhere(&Metadata{}, `(parent_id = ?) AND (name_sort = ?) LIMIT 1;`, new_parent_id, md.NameSort)
+  if err != nil { return ErrQueryFailed }
+  if len(records) > 0 { return fmt.Errorf("metadata named \"%s\" already exists in parent \"%s\"", md.NameSort, new_parent_id) }
+  if metadataCanMoveFilesToPath(md, parent_path) == false {
+    return fmt.Errorf("metadata named \"%s\" already exists on disk", md.NameSort)
   }
-
-  var any_error error = nil
-  for index := range paths_before {
-    if !pathExists(paths_before[index]) { continue }
-    err := os.Rename(paths_before[index], paths_after[index])
-    if err != nil { any_error = err }
+  return nil
+}
+func metadataCanMoveFilesToPath(md *Metadata, path string) bool {
+  for _, path_type := range metadataFilePathTypes {
+    if pathExists(filepath.Join(path, md.NameSort) + metadataPathSuffix(md, path_type)) { return false }
   }
-
-  return any_error
+  return true
 }
 
 // ============================================================================
@@ -493,25 +495,47 @@ func (md *Metadata) GetId() string {
 func (md *Metadata) SetId(id string) {
   md.Id = id
 }
+func (md *Metadata) setLibrary(lib *Library) {
+  md.library = lib
+}
+func (md *Metadata) lib() *Library {
+  if md.library != nil { return md.library }
+  return defaultLibrary
+}
 
 func (md *Metadata) FieldsRead() (fields map[string]any, err error) {
   fields = make(map[string]any)
   streams_bytes, err := json.Marshal(md.Streams) ; if err != nil { return nil, err } ; streams_string := string(streams_bytes)
-
-  fields["id"           ] = md.Id
-  fields["parent_id"    ] = md.ParentId
-  fields["media_type"   ] = string(md.MediaType)
-  fields["name_display" ] = md.NameDisplay
-  fields["name_sort"    ] = md.NameSort
-  fields["streams"      ] = streams_string
-  fields["duration"     ] = md.Duration
-  fields["size"         ] = md.Size
+  chapters := md.Chapters ; if chapters == nil { chapters = []MetadataChapter {} }
+  chapters_bytes, err := json.Marshal(chapters) ; if err != nil { return nil, err } ; chapters_string := string(chapters_bytes)
+  external_ids := md.ExternalIds ; if external_ids == nil { external_ids = map[string]string {} }
+  external_ids_bytes, err := json.Marshal(external_ids) ; if err != nil { return nil, err } ; external_ids_string := string(external_ids_bytes)
+
+  fields["id"             ] = md.Id
+  fields["parent_id"      ] = md.ParentId
+  fields["media_type"     ] = string(md.MediaType)
+  fields["name_display"   ] = md.NameDisplay
+  fields["name_sort"      ] = md.NameSort
+  fields["streams"        ] = streams_string
+  fields["duration"       ] = md.Duration
+  fields["size"           ] = md.Size
+  fields["chapters"       ] = chapters_string
+  fields["poster_blurhash"] = md.PosterBlurhash
+  fields["poster_color"   ] = md.PosterColor
+  fields["year"           ] = md.Year
+  fields["track_number"   ] = md.TrackNumber
+  fields["disc_number"    ] = md.DiscNumber
+  fields["plot"           ] = md.Plot
+  fields["external_ids"   ] = external_ids_string
+  fields["extension"      ] = md.Extension
 
   return fields, nil
 }
 
 func (md *Metadata) FieldsReplace(fields map[string]any) (err error) {
   streams_string := fields["streams"].(string) ; var streams []FileStream ; err = json.Unmarshal([]byte(streams_string), &streams) ; if err != nil { return err }
+  chapters_string := fields["chapters"].(string) ; var chapters []MetadataChapter ; err = json.Unmarshal([]byte(chapters_string), &chapters) ; if err != nil { return err }
+  external_ids_string := fields["external_ids"].(string) ; var external_ids map[string]string ; err = json.Unmarshal([]byte(external_ids_string), &external_ids) ; if err != nil { return err }
   media_type :=  MetadataMediaType(fields["media_type"].(string))
 
   md.Id               = fields["id"               ].(string)
@@ -522,18 +546,34 @@ func (md *Metadata) FieldsReplace(fields map[string]any) (err error) {
   md.Streams          = streams
   md.Duration         = fields["duration"         ].(int64)
   md.Size             = fields["size"             ].(int64)
+  md.Chapters         = chapters
+  md.PosterBlurhash   = fields["poster_blurhash"  ].(string)
+  md.PosterColor      = fields["poster_color"     ].(string)
+  md.Year             = fields["year"             ].(int64)
+  md.TrackNumber      = fields["track_number"     ].(int64)
+  md.DiscNumber       = fields["disc_number"      ].(int64)
+  md.Plot             = fields["plot"             ].(string)
+  md.ExternalIds      = external_ids
+  md.Extension        = fields["extension"        ].(string)
 
   return nil
 }
 
 func (md *Metadata) FieldsPatch(fields map[string]any) (err error) {
-  if id,           ok := fields["id"]           ; ok { md.Id          = id.(string)                            }
-  if parent_id,    ok := fields["parent_id"]    ; ok { md.ParentId    = parent_id.(string)                     }
-  if media_type,   ok := fields["media_type"]   ; ok { md.MediaType   = MetadataMediaType(media_type.(string)) }
-  if name_display, ok := fields["name_display"] ; ok { md.NameDisplay = name_display.(string)                  }
-  if name_sort,    ok := fields["name_sort"]    ; ok { md.NameSort    = name_sort.(string)                     }
-  if duration,     ok := fields["duration"]     ; ok { md.Duration    = duration.(int64)                       }
-  if size,         ok := fields["size"]         ; ok { md.Size        = size.(int64)                           }
+  if id,           ok := fields["id"]              ; ok { md.Id             = id.(string)                            }
+  if parent_id,    ok := fields["parent_id"]       ; ok { md.ParentId       = parent_id.(string)                     }
+  if media_type,   ok := fields["media_type"]      ; ok { md.MediaType      = MetadataMediaType(media_type.(string)) }
+  if name_display, ok := fields["name_display"]    ; ok { md.NameDisplay    = name_display.(string)                  }
+  if name_sort,    ok := fields["name_sort"]       ; ok { md.NameSort       = name_sort.(string)                     }
+  if duration,     ok := fields["duration"]        ; ok { md.Duration       = duration.(int64)                       }
+  if size,         ok := fields["size"]            ; ok { md.Size           = size.(int64)                           }
+  if blurhash,     ok := fields["poster_blurhash"] ; ok { md.PosterBlurhash = blurhash.(string)                      }
+  if color,        ok := fields["poster_color"]    ; ok { md.PosterColor    = color.(string)                         }
+  if year,         ok := fields["year"]            ; ok { md.Year           = year.(int64)                           }
+  if track_number, ok := fields["track_number"]    ; ok { md.TrackNumber    = track_number.(int64)                   }
+  if disc_number,  ok := fields["disc_number"]     ; ok { md.DiscNumber     = disc_number.(int64)                    }
+  if plot,         ok := fields["plot"]            ; ok { md.Plot           = plot.(string)                          }
+  if extension,    ok := fields["extension"]       ; ok { md.Extension      = extension.(string)                     }
 
   if streams, ok := fields["streams"] ; ok {
     streams_string := streams.(string)
@@ -543,6 +583,22 @@ func (md *Metadata) FieldsPatch(fields map[string]any) (err error) {
     md.Streams = streams
   }
 
+  if chapters, ok := fields["chapters"] ; ok {
+    chapters_string := chapters.(string)
+    var chapters []MetadataChapter
+    err = json.Unmarshal([]byte(chapters_string), &chapters)
+    if err != nil { return err }
+    md.Chapters = chapters
+  }
+
+  if external_ids, ok := fields["external_ids"] ; ok {
+    external_ids_string := external_ids.(string)
+    var external_ids map[string]string
+    err = json.Unmarshal([]byte(external_ids_string), &external_ids)
+    if err != nil { return err }
+    md.ExternalIds = external_ids
+  }
+
   return nil
 }
 
@@ -553,15 +609,28 @@ func (md_a *Metadata) FieldsDifference(other dbRecord) (diff map[string]any, err
 
   a_streams_bytes, err := json.Marshal(md_a.Streams) ; if err != nil { return nil, err } ; a_streams_string := string(a_streams_bytes)
   b_streams_bytes, err := json.Marshal(md_b.Streams) ; if err != nil { return nil, err } ; b_streams_string := string(b_streams_bytes)
-
-  if md_a.Id          != md_b.Id          { diff["id"           ] = md_b.Id                }
-  if md_a.ParentId    != md_b.ParentId    { diff["parent_id"    ] = md_b.ParentId          }
-  if md_a.MediaType   != md_b.MediaType   { diff["media_type"   ] = string(md_b.MediaType) }
-  if md_a.NameDisplay != md_b.NameDisplay { diff["name_display" ] = md_b.NameDisplay       }
-  if md_a.NameSort    != md_b.NameSort    { diff["name_sort"    ] = md_b.NameSort          }
-  if a_streams_string != b_streams_string { diff["streams"      ] = b_streams_string       }
-  if md_a.Duration    != md_b.Duration    { diff["duration"     ] = md_b.Duration          }
-  if md_a.Size        != md_b.Size        { diff["size"         ] = md_b.Size              }
+  a_chapter_bytes, err := json.Marshal(md_a.Chapters) ; if err != nil { return nil, err } ; a_chapter_string := string(a_chapter_bytes)
+  b_chapter_bytes, err := json.Marshal(md_b.Chapters) ; if err != nil { return nil, err } ; b_chapter_string := string(b_chapter_bytes)
+  a_external_bytes, err := json.Marshal(md_a.ExternalIds) ; if err != nil { return nil, err } ; a_external_string := string(a_external_bytes)
+  b_external_bytes, err := json.Marshal(md_b.ExternalIds) ; if err != nil { return nil, err } ; b_external_string := string(b_external_bytes)
+
+  if md_a.Id             != md_b.Id             { diff["id"             ] = md_b.Id                }
+  if md_a.ParentId       != md_b.ParentId       { diff["parent_id"      ] = md_b.ParentId          }
+  if md_a.MediaType      != md_b.MediaType      { diff["media_type"     ] = string(md_b.MediaType) }
+  if md_a.NameDisplay    != md_b.NameDisplay    { diff["name_display"   ] = md_b.NameDisplay       }
+  if md_a.NameSort       != md_b.NameSort       { diff["name_sort"      ] = md_b.NameSort          }
+  if a_streams_string    != b_streams_string    { diff["streams"        ] = b_streams_string       }
+  if md_a.Duration       != md_b.Duration       { diff["duration"       ] = md_b.Duration          }
+  if md_a.Size           != md_b.Size           { diff["size"           ] = md_b.Size              }
+  if a_chapter_string    != b_chapter_string    { diff["chapters"       ] = b_chapter_string       }
+  if md_a.PosterBlurhash != md_b.PosterBlurhash { diff["poster_blurhash"] = md_b.PosterBlurhash    }
+  if md_a.PosterColor    != md_b.PosterColor    { diff["poster_color"   ] = md_b.PosterColor       }
+  if md_a.Year           != md_b.Year           { diff["year"           ] = md_b.Year              }
+  if md_a.TrackNumber    != md_b.TrackNumber    { diff["track_number"   ] = md_b.TrackNumber       }
+  if md_a.DiscNumber     != md_b.DiscNumber     { diff["disc_number"    ] = md_b.DiscNumber        }
+  if md_a.Plot           != md_b.Plot           { diff["plot"           ] = md_b.Plot              }
+  if a_external_string   != b_external_string   { diff["external_ids"   ] = b_external_string      }
+  if md_a.Extension      != md_b.Extension      { diff["extension"      ] = md_b.Extension         }
 
   return diff, nil
 }
@@ -2,11 +2,11 @@ package library
 
 type migration0000 struct {}
 
-func (m *migration0000) Up() (error) {
+func (m *migration0000) Up(db dbQueryer) (error) {
   println("Migration0000.Up()")
   return nil
 }
 
-func (m *migration0000) Down() (error) {
+func (m *migration0000) Down(db dbQueryer) (error) {
   return nil
 }
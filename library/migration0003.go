@@ -0,0 +1,13 @@
+package library
+
+type migration0003 struct {}
+
+func (m *migration0003) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN verification TEXT NOT NULL DEFAULT '{}';`)
+  return err
+}
+
+func (m *migration0003) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN verification;`)
+  return err
+}
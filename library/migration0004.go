@@ -0,0 +1,13 @@
+package library
+
+type migration0004 struct {}
+
+func (m *migration0004) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN video_processing TEXT NOT NULL DEFAULT '{}';`)
+  return err
+}
+
+func (m *migration0004) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN video_processing;`)
+  return err
+}
@@ -0,0 +1,21 @@
+package library
+
+type migration0005 struct {}
+
+func (m *migration0005) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN trim_start  INTEGER NOT NULL DEFAULT 0; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN trim_end    INTEGER NOT NULL DEFAULT 0; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN group_id    TEXT NOT NULL DEFAULT '';   `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN group_index INTEGER NOT NULL DEFAULT 0; `) ; if err != nil { return err }
+  _, err = db.Exec(`CREATE INDEX input_files_group ON input_files (group_id);`)
+  return err
+}
+
+func (m *migration0005) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`DROP INDEX input_files_group;                      `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN group_index;   `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN group_id;      `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN trim_end;      `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN trim_start;    `) ; if err != nil { return err }
+  return nil
+}
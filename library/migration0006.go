@@ -0,0 +1,13 @@
+package library
+
+type migration0006 struct {}
+
+func (m *migration0006) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN chapters TEXT NOT NULL DEFAULT '[]';`)
+  return err
+}
+
+func (m *migration0006) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN chapters;`)
+  return err
+}
@@ -0,0 +1,15 @@
+package library
+
+type migration0007 struct {}
+
+func (m *migration0007) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN poster_blurhash TEXT NOT NULL DEFAULT ''; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN poster_color    TEXT NOT NULL DEFAULT ''; `) ; if err != nil { return err }
+  return nil
+}
+
+func (m *migration0007) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN poster_color;    `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN poster_blurhash; `) ; if err != nil { return err }
+  return nil
+}
@@ -0,0 +1,21 @@
+package library
+
+type migration0008 struct {}
+
+func (m *migration0008) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN sidecar   TEXT NOT NULL DEFAULT '{}'; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN year         INTEGER NOT NULL DEFAULT 0; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN track_number INTEGER NOT NULL DEFAULT 0; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN disc_number  INTEGER NOT NULL DEFAULT 0; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN plot         TEXT NOT NULL DEFAULT '';   `) ; if err != nil { return err }
+  return nil
+}
+
+func (m *migration0008) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN plot;         `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN disc_number;  `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN track_number; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN year;         `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN sidecar;   `) ; if err != nil { return err }
+  return nil
+}
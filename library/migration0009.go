@@ -0,0 +1,13 @@
+package library
+
+type migration0009 struct {}
+
+func (m *migration0009) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata ADD COLUMN external_ids TEXT NOT NULL DEFAULT '{}'; `) ; if err != nil { return err }
+  return nil
+}
+
+func (m *migration0009) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata DROP COLUMN external_ids; `) ; if err != nil { return err }
+  return nil
+}
@@ -0,0 +1,19 @@
+package library
+
+type migration0010 struct {}
+
+func (m *migration0010) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE categories  ADD COLUMN audio_format TEXT NOT NULL DEFAULT 'mp3'; `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files ADD COLUMN category_id  TEXT NOT NULL DEFAULT '';    `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE metadata    ADD COLUMN extension    TEXT NOT NULL DEFAULT '';    `) ; if err != nil { return err }
+  _, err = db.Exec(`UPDATE metadata SET extension = '.mp4' WHERE media_type = 'file-video';      `) ; if err != nil { return err }
+  _, err = db.Exec(`UPDATE metadata SET extension = '.mp3' WHERE media_type = 'file-audio';      `) ; if err != nil { return err }
+  return nil
+}
+
+func (m *migration0010) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE metadata    DROP COLUMN extension;    `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE input_files DROP COLUMN category_id;  `) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE categories  DROP COLUMN audio_format; `) ; if err != nil { return err }
+  return nil
+}
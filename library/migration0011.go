@@ -0,0 +1,24 @@
+package library
+
+type migration0011 struct {}
+
+func (m *migration0011) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`CREATE TABLE journal (
+    id           TEXT    NOT NULL PRIMARY KEY,
+    operation    TEXT    NOT NULL DEFAULT '',
+    moves        TEXT    NOT NULL DEFAULT '[]',
+    record_table TEXT    NOT NULL DEFAULT '',
+    record_id    TEXT    NOT NULL DEFAULT '',
+    patch        TEXT    NOT NULL DEFAULT '{}',
+    status       TEXT    NOT NULL DEFAULT 'pending',
+    error        TEXT    NOT NULL DEFAULT '',
+    time_created INTEGER NOT NULL DEFAULT 0
+  );`)
+  if err != nil { return err }
+  return nil
+}
+
+func (m *migration0011) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`DROP TABLE journal;`) ; if err != nil { return err }
+  return nil
+}
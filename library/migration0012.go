@@ -0,0 +1,21 @@
+package library
+
+type migration0012 struct {}
+
+func (m *migration0012) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`CREATE TABLE volumes (
+    id   TEXT NOT NULL PRIMARY KEY UNIQUE,
+    name TEXT NOT NULL UNIQUE,
+    path TEXT NOT NULL UNIQUE
+  );`)
+  if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE categories ADD COLUMN volume_id TEXT NOT NULL DEFAULT '';`)
+  if err != nil { return err }
+  return nil
+}
+
+func (m *migration0012) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE categories DROP COLUMN volume_id;`) ; if err != nil { return err }
+  _, err = db.Exec(`DROP TABLE volumes;`                          ) ; if err != nil { return err }
+  return nil
+}
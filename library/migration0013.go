@@ -0,0 +1,17 @@
+package library
+
+type migration0013 struct {}
+
+func (m *migration0013) Up(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE journal ADD COLUMN owner_host    TEXT    NOT NULL DEFAULT '';`) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE journal ADD COLUMN owner_pid     INTEGER NOT NULL DEFAULT 0;` ) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE journal ADD COLUMN owner_started INTEGER NOT NULL DEFAULT 0;` ) ; if err != nil { return err }
+  return nil
+}
+
+func (m *migration0013) Down(db dbQueryer) (err error) {
+  _, err = db.Exec(`ALTER TABLE journal DROP COLUMN owner_started;`) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE journal DROP COLUMN owner_pid;`    ) ; if err != nil { return err }
+  _, err = db.Exec(`ALTER TABLE journal DROP COLUMN owner_host;`   ) ; if err != nil { return err }
+  return nil
+}
@@ -1,61 +1,277 @@
 package library
 
 import (
+  "os"
   "fmt"
+  "sort"
+  "time"
+  "strings"
+  "path/filepath"
+  "crypto/sha256"
+  "database/sql"
+  "encoding/hex"
 )
 
 type Migration interface {
-  Up() (error)
-  Down() (error)
+  Up(db dbQueryer) (error)
+  Down(db dbQueryer) (error)
 }
 var Migrations []Migration = []Migration {
   &migration0000{},
   &migration0001{},
   &migration0002{},
+  &migration0003{},
+  &migration0004{},
+  &migration0005{},
+  &migration0006{},
+  &migration0007{},
+  &migration0008{},
+  &migration0009{},
+  &migration0010{},
+  &migration0011{},
+  &migration0012{},
+  &migration0013{},
 }
 
+// Migrations needing to run outside a transaction (VACUUM, PRAGMA foreign_keys, ...) implement this, returning false.
+// Those rely on the pre-migration backup for rollback.
+type MigrationTransactional interface {
+  Transactional() bool
+}
+
+// Applied migration level (recorded in migration_history, which the migration runner maintains itself).
+type MigrationRecord struct {
+  Level       uint32 `json:"level"`
+  TimeApplied int64  `json:"time_applied"`
+  Checksum    string `json:"checksum"`     // of schema, after migration applied
+}
+
+// Schema changes a migration would make (see MigrateDryRun).
+type MigrationDryRun struct {
+  LevelFrom uint32         `json:"level_from"`
+  LevelTo   uint32         `json:"level_to"`
+  Changes   []SchemaChange `json:"changes"`
+}
+type SchemaChange struct {
+  Change    string `json:"change"`     // "added", "removed", "changed"
+  Type      string `json:"type"`       // "table", "index", ...
+  Name      string `json:"name"`
+  SqlBefore string `json:"sql_before"`
+  SqlAfter  string `json:"sql_after"`
+}
+
+var ErrDbNewer = fmt.Errorf("database is newer than this program")
+var ErrDbOlder = fmt.Errorf("database is older than this program")
+
 // ============================================================================
 
+// Latest level known to this program.
+func MigrationLevelLatest() uint32 {
+  return uint32(len(Migrations) - 1)
+}
+
+// Error if database is at a level beyond this program's migrations (written by a newer version).
+func (lib *Library) MigrationCheck() error {
+  level_current := lib.MigrationLevelGet()
+  if level_current > MigrationLevelLatest() {
+    return fmt.Errorf("%s: database at migration level %d, program supports up to %d", ErrDbNewer.Error(), level_current, MigrationLevelLatest())
+  }
+  return nil
+}
+
+// Error unless database is at exactly this program's latest level; for programs that don't migrate (scanner, transcoder).
+func (lib *Library) MigrationCheckLatest() error {
+  err := lib.MigrationCheck()
+  if err != nil { return err }
+  level_current := lib.MigrationLevelGet()
+  if level_current < MigrationLevelLatest() {
+    return fmt.Errorf("%s: database at migration level %d, program expects %d (run server to migrate)", ErrDbOlder.Error(), level_current, MigrationLevelLatest())
+  }
+  return nil
+}
+
 // Migrate to specified level.
-// NOTE: Creates a backup of db before starting, and rolls back if migration fails.
+// Each step runs in a transaction (with its history record), where SQLite allows.
+// NOTE: Creates a backup of db before starting, and rolls back to it if any step fails.
 // NOTE: This will overwrite the current backup file.
-func MigrateTo(level_target uint32) (err error) {
-  level_current := MigrationLevelGet()
+func (lib *Library) MigrateTo(level_target uint32) (err error) {
+  err = lib.MigrationCheck()
+  if err != nil { return err }
+  if level_target > MigrationLevelLatest() { return fmt.Errorf("Migration level %d unknown; latest is %d", level_target, MigrationLevelLatest()) }
+  level_current := lib.MigrationLevelGet()
   if level_current == level_target { return nil }
 
-  err = dbBackupCreate()
+  err = lib.dbBackupCreate()
   if err != nil { return fmt.Errorf("Migration backup failed: %s", err.Error()) }
 
-  if level_target > level_current {
-    for index := level_current + 1; index <= level_target; index++ {
-      err = Migrations[index].Up()
-      if err != nil {
-        err_new := dbBackupRestore()
-        if err_new != nil { return fmt.Errorf("Migration step %d, and rollback failed: %s; %s", index, err.Error(), err_new.Error()) }
-        return fmt.Errorf("Migration step %d failed (rollback successful): %s", index, err.Error())
-      }
+  for level_current != level_target {
+    index, level_next := level_current + 1, level_current + 1
+    if level_target < level_current { index, level_next = level_current, level_current - 1 }
+
+    err = lib.migrationStep(Migrations[index], (level_next > level_current), level_next)
+    if err != nil {
+      err_new := lib.dbBackupRestore()
+      if err_new != nil { return fmt.Errorf("Migration step %d, and rollback failed: %s; %s", index, err.Error(), err_new.Error()) }
+      return fmt.Errorf("Migration step %d failed (rollback successful): %s", index, err.Error())
     }
-  } else {
-    for index := level_current; index > level_target; index-- {
-      err = Migrations[index].Down()
-      if err != nil {
-        err_new := dbBackupRestore()
-        if err_new != nil { return fmt.Errorf("Migration step %d, and rollback failed: %s; %s", index, err.Error(), err_new.Error()) }
-        return fmt.Errorf("Migration step %d failed (rollback successful): %s", index, err.Error())
-      }
+    level_current = level_next
+  }
+
+  lib.mediaPathExpire()
+  return nil
+}
+
+// Migrate to the latest level.
+func (lib *Library) MigrateToLatest() (err error) {
+  return lib.MigrateTo(MigrationLevelLatest())
+}
+
+// Apply migrations to a temporary copy of the database, and report resulting schema changes; database is unchanged.
+func (lib *Library) MigrateDryRun(level_target uint32) (*MigrationDryRun, error) {
+  err := lib.MigrationCheck()
+  if err != nil { return nil, err }
+  if lib.dbHandle == nil { return nil, ErrDbNotOpened }
+
+  temp_path, err := os.MkdirTemp("", "starkiss-migrate-")
+  if err != nil { return nil, err }
+  defer os.RemoveAll(temp_path)
+  copy_path := filepath.Join(temp_path, filepath.Base(lib.dbPath))
+  err = lib.dbVacuumInto(copy_path)
+  if err != nil { return nil, fmt.Errorf("cannot copy database for dry run: %s", err.Error()) }
+
+  // opened without Startup: recovering the copy's journal would move this library's files
+  copy_lib := &Library { dbPath:copy_path }
+  err = copy_lib.dbOpen()
+  if err != nil { return nil, err }
+  defer copy_lib.Shutdown()
+
+  result := MigrationDryRun { LevelFrom:copy_lib.MigrationLevelGet(), LevelTo:level_target, Changes:[]SchemaChange {} }
+  schema_before, err := copy_lib.dbSchema()
+  if err != nil { return nil, err }
+  err = copy_lib.MigrateTo(level_target)
+  if err != nil { return nil, err }
+  schema_after, err := copy_lib.dbSchema()
+  if err != nil { return nil, err }
+
+  for key, before := range schema_before {
+    after, present := schema_after[key]
+    if !present {
+      result.Changes = append(result.Changes, SchemaChange { Change:"removed", Type:before.Type, Name:before.Name, SqlBefore:before.Sql })
+    } else if after.Sql != before.Sql {
+      result.Changes = append(result.Changes, SchemaChange { Change:"changed", Type:before.Type, Name:before.Name, SqlBefore:before.Sql, SqlAfter:after.Sql })
     }
   }
+  for key, after := range schema_after {
+    if _, present := schema_before[key]; !present {
+      result.Changes = append(result.Changes, SchemaChange { Change:"added", Type:after.Type, Name:after.Name, SqlAfter:after.Sql })
+    }
+  }
+  sort.Slice(result.Changes, func(a int, b int) bool {
+    if result.Changes[a].Type != result.Changes[b].Type { return result.Changes[a].Type > result.Changes[b].Type } // tables, then indexes
+    return result.Changes[a].Name < result.Changes[b].Name
+  })
+  return &result, nil
+}
 
-  err = migrationLevelSet(level_target)
-  if err != nil {
-    return fmt.Errorf("Migration succeeded, but setting current migration level failed: %s", err.Error())
+// Applied levels, oldest first (levels applied before history was kept aren't listed).
+func (lib *Library) MigrationHistory() ([]MigrationRecord, error) {
+  history := []MigrationRecord {}
+  if lib.dbHandle == nil { return nil, ErrDbNotOpened }
+  var rows *sql.Rows
+  err := dbRetry(func() (err error) { rows, err = lib.dbHandle.Query(`SELECT level, time_applied, checksum FROM migration_history ORDER BY level ASC;`) ; return err })
+  if (err != nil) && strings.Contains(err.Error(), "no such table") { return history, nil }
+  if err != nil { return nil, ErrQueryFailed }
+  defer rows.Close()
+  for rows.Next() {
+    record := MigrationRecord {}
+    err = rows.Scan(&record.Level, &record.TimeApplied, &record.Checksum)
+    if err != nil { return nil, ErrQueryFailed }
+    history = append(history, record)
   }
+  return history, nil
+}
 
-  return nil
+// Checksum of the current schema (tables, indexes, ...; excluding migration_history).
+func (lib *Library) SchemaChecksum() (string, error) {
+  if lib.dbHandle == nil { return "", ErrDbNotOpened }
+  checksum := ""
+  err := dbRetry(func() (err error) { checksum, err = dbSchemaChecksum(lib.dbHandle) ; return err })
+  return checksum, err
 }
 
-// Migrate to the latest level.
-func MigrateToLatest() (err error) {
-  level_latest := uint32(len(Migrations) - 1)
-  return MigrateTo(level_latest)
+// ============================================================================
+// private utilities
+
+type schemaObject struct {
+  Type string
+  Name string
+  Sql  string
+}
+
+// Run one migration step, and record it (with resulting level) together.
+func (lib *Library) migrationStep(migration Migration, up bool, level_next uint32) error {
+  run := func(db dbQueryer) error {
+    var err error
+    if up { err = migration.Up(db) } else { err = migration.Down(db) }
+    if err != nil { return err }
+    return migrationRecord(db, up, level_next)
+  }
+
+  if transactional, ok := migration.(MigrationTransactional); ok && !transactional.Transactional() {
+    return run(lib.dbWriter)
+  }
+  return lib.dbTransaction(func(tx *dbTx) error { return run(tx.tx) })
+}
+
+// Record level reached, and its schema checksum; level 0 has no properties table, so isn't recorded.
+func migrationRecord(db dbQueryer, up bool, level uint32) error {
+  if level == 0 { return nil }
+  _, err := db.Exec(`CREATE TABLE IF NOT EXISTS migration_history (
+    level        INTEGER NOT NULL PRIMARY KEY UNIQUE,
+    time_applied INTEGER NOT NULL,
+    checksum     TEXT NOT NULL
+  );`)
+  if err != nil { return err }
+  if !up {
+    _, err = db.Exec(`DELETE FROM migration_history WHERE level > ?;`, level)
+    if err != nil { return err }
+  } else {
+    checksum, err := dbSchemaChecksum(db)
+    if err != nil { return err }
+    _, err = db.Exec(`INSERT INTO migration_history (level, time_applied, checksum) VALUES (?, ?, ?) ON CONFLICT(level) DO UPDATE SET time_applied = ?, checksum = ?;`,
+      level, time.Now().Unix(), checksum, time.Now().Unix(), checksum)
+    if err != nil { return err }
+  }
+  _, err = db.Exec(`INSERT INTO properties (key, value) VALUES ('migration_level', ?) ON CONFLICT(key) DO UPDATE SET value = ?;`, level, level)
+  return err
+}
+
+func dbSchemaRead(db dbQueryer) (map[string]schemaObject, error) {
+  rows, err := db.Query(`SELECT type, name, COALESCE(sql, '') FROM sqlite_master WHERE (name NOT LIKE 'sqlite_%') AND (name <> 'migration_history');`)
+  if err != nil { return nil, err }
+  defer rows.Close()
+  schema := map[string]schemaObject {}
+  for rows.Next() {
+    object := schemaObject {}
+    err = rows.Scan(&object.Type, &object.Name, &object.Sql)
+    if err != nil { return nil, err }
+    schema[object.Type + "/" + object.Name] = object
+  }
+  return schema, rows.Err()
+}
+
+func (lib *Library) dbSchema() (schema map[string]schemaObject, err error) {
+  err = dbRetry(func() (err error) { schema, err = dbSchemaRead(lib.dbHandle) ; return err })
+  return schema, err
+}
+
+func dbSchemaChecksum(db dbQueryer) (string, error) {
+  schema, err := dbSchemaRead(db)
+  if err != nil { return "", err }
+  keys := make([]string, 0, len(schema))
+  for key := range schema { keys = append(keys, key) }
+  sort.Strings(keys)
+  hash := sha256.New()
+  for _, key := range keys { fmt.Fprintf(hash, "%s\n%s\n", key, schema[key].Sql) }
+  return hex.EncodeToString(hash.Sum(nil)), nil
 }
@@ -0,0 +1,242 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "bytes"
+  "image"
+  "context"
+  "os/exec"
+  "slices"
+  "strconv"
+  "image/png"
+  "encoding/json"
+  "path/filepath"
+)
+
+var ErrNoPosterSource = fmt.Errorf("no poster source found")
+
+const posterBlackLuma = 32 // average luma (0-255) below which a frame is considered too dark for a poster
+
+// Fractions of duration sampled for a representative video frame.
+var posterFramePositions = []float64 { 0.10, 0.20, 0.30, 0.40, 0.50, 0.60 }
+
+func (md *Metadata) HasPoster() bool {
+  poster_path, err := md.DiskPath(MetadataPathTypePosterLarge)
+  if err != nil { return false }
+  return pathExists(poster_path)
+}
+
+// Pixel dimensions of a poster size, for this Metadata's media type.
+func (md *Metadata) PosterDimensions(size ArtworkSize) (width uint, height uint, err error) {
+  square := (md.MediaType == MetadataMediaTypeFileAudio) || (md.MediaType == MetadataMediaTypeArtist) || (md.MediaType == MetadataMediaTypeAlbum)
+  return ArtworkDimensions(ArtworkTypePoster, size, square)
+}
+
+// Replace poster: keeps the full image as a master, and renders default (small & large jpeg) sizes.
+// Other sizes & formats are rendered from the master on demand (see PosterFile).
+func (md *Metadata) SetPoster(img image.Image) error {
+  // save master
+  master_path, err := md.DiskPath(MetadataPathTypePosterMaster)
+  if err != nil { return err }
+  err = artworkWrite(master_path, img, ArtworkFormatPng)
+  if err != nil { return err }
+
+  // clear previously rendered sizes
+  cache_path, err := md.DiskPath(MetadataPathTypePosterCache)
+  if err != nil { return err }
+  err = os.RemoveAll(cache_path)
+  if err != nil { return err }
+
+  // render default sizes
+  var small image.Image = nil
+  for _, size := range []ArtworkSize { ArtworkSizeLarge, ArtworkSizeSmall } {
+    path_type := MetadataPathTypePosterLarge ; if size == ArtworkSizeSmall { path_type = MetadataPathTypePosterSmall }
+    poster_path, err := md.DiskPath(path_type)
+    if err != nil { return err }
+    width, height, _ := md.PosterDimensions(size)
+    rendered := artworkRender(img, width, height)
+    err = artworkWrite(poster_path, rendered, ArtworkFormatJpeg)
+    if err != nil { return err }
+    small = rendered
+  }
+
+  // placeholders for clients, from the poster as it's displayed
+  err = md.lib().dbRecordPatch(md, map[string]any { "poster_blurhash":BlurhashEncode(small), "poster_color":DominantColor(small) })
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
+// Path to poster of size & format, rendering (and caching on disk) if needed.
+func (md *Metadata) PosterFile(size ArtworkSize, format ArtworkFormat) (string, error) {
+  width, height, err := md.PosterDimensions(size)
+  if err != nil { return "", err }
+  if !slices.Contains(ArtworkFormats, format) { return "", ErrInvalidArtworkFormat }
+
+  // default sizes are always rendered
+  if (format == ArtworkFormatJpeg) && ((size == ArtworkSizeSmall) || (size == ArtworkSizeLarge)) {
+    path_type := MetadataPathTypePosterLarge ; if size == ArtworkSizeSmall { path_type = MetadataPathTypePosterSmall }
+    poster_path, err := md.DiskPath(path_type)
+    if err != nil { return "", err }
+    if !pathExists(poster_path) { return "", ErrNotFound }
+    return poster_path, nil
+  }
+
+  // use cached rendering, if not older than master
+  cache_path, err := md.DiskPath(MetadataPathTypePosterCache)
+  if err != nil { return "", err }
+  poster_path := filepath.Join(cache_path, string(size) + "." + string(format))
+  master_path, _ := md.DiskPath(MetadataPathTypePosterMaster)
+  if poster_stat, err := os.Stat(poster_path); err == nil {
+    master_stat, err := os.Stat(master_path)
+    if (err != nil) || !poster_stat.ModTime().Before(master_stat.ModTime()) { return poster_path, nil }
+  }
+
+  master, err := md.posterMaster()
+  if err != nil { return "", err }
+  err = os.MkdirAll(cache_path, 0770)
+  if err != nil { return "", err }
+  err = artworkWrite(poster_path, artworkRender(master, width, height), format)
+  if err != nil { return "", err }
+  return poster_path, nil
+}
+
+// Full size poster image (or large poster, for posters set before masters were kept).
+func (md *Metadata) posterMaster() (image.Image, error) {
+  master_path, err := md.DiskPath(MetadataPathTypePosterMaster)
+  if err != nil { return nil, err }
+  if !pathExists(master_path) {
+    master_path, err = md.DiskPath(MetadataPathTypePosterLarge)
+    if err != nil { return nil, err }
+    if !pathExists(master_path) { return nil, ErrNotFound }
+  }
+  master_file, err := os.Open(master_path)
+  if err != nil { return nil, err }
+  defer master_file.Close()
+  img, _, err := image.Decode(master_file)
+  return img, err
+}
+
+// (Re)generate poster:
+//   files      : embedded cover art (from source or transcoded media), or a representative video frame
+//   containers : first child's poster
+func (md *Metadata) PosterGenerate() error {
+  var img image.Image = nil
+  var err error = nil
+  switch md.MediaType {
+    case MetadataMediaTypeFileVideo : fallthrough
+    case MetadataMediaTypeFileAudio : img, err = posterFromFile(md)
+    default                         : img, err = posterFromChildren(md)
+  }
+  if err != nil { return err }
+  return md.SetPoster(img)
+}
+
+// Give posters to any ancestors (starting with parent_id) that don't already have one.
+func (lib *Library) posterDefaultAncestors(parent_id string) {
+  for parent_id != "" {
+    parent, err := lib.MetadataRead(parent_id)
+    if err != nil { return } // reached category (or missing record)
+    if parent.HasPoster() { return }
+    if parent.PosterGenerate() != nil { return }
+    parent_id = parent.ParentId
+  }
+}
+
+func posterFromFile(md *Metadata) (image.Image, error) {
+  sources := []string {}
+  if inp, err := md.lib().InputFileRead(md.Id); (err == nil) && pathExists(inp.SourceLocation) { sources = append(sources, inp.SourceLocation) }
+  if media_path, err := md.DiskPath(MetadataPathTypeMedia); err == nil { sources = append(sources, media_path) }
+
+  for _, source := range sources {
+    if img, err := posterCoverArt(source); err == nil { return img, nil }
+  }
+  if md.MediaType == MetadataMediaTypeFileVideo {
+    for _, source := range sources {
+      if img, err := posterVideoFrame(source, md.Duration); err == nil { return img, nil }
+    }
+  }
+  return nil, ErrNoPosterSource
+}
+
+func posterFromChildren(md *Metadata) (image.Image, error) {
+  children, err := md.lib().MetadataForParent(md.Id)
+  if err != nil { return nil, err }
+  for index := range children {
+    if img, err := children[index].posterMaster(); err == nil { return img, nil }
+  }
+  return nil, ErrNoPosterSource
+}
+
+// Extract embedded cover art (MP3 APIC, MP4 covr, and MKV image attachments are all exposed by ffmpeg as attached_pic streams).
+func posterCoverArt(path string) (image.Image, error) {
+  timeout, cancel := context.WithTimeout(context.Background(), time.Second * 30)
+  defer cancel()
+  output, err := exec.CommandContext(timeout, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", "-select_streams", "v", path).Output()
+  if err != nil { return nil, fmt.Errorf("error getting stream data: %s", err.Error()) }
+
+  probe := struct {
+    Streams []struct {
+      Index       int64 `json:"index"`
+      Disposition struct {
+        AttachedPic int64 `json:"attached_pic"`
+      } `json:"disposition"`
+    } `json:"streams"`
+  } {}
+  err = json.Unmarshal(output, &probe)
+  if err != nil { return nil, fmt.Errorf("error parsing stream data: %s", err.Error()) }
+
+  for _, stream := range probe.Streams {
+    if stream.Disposition.AttachedPic != 1 { continue }
+    return posterExtract("-i", path, "-map", "0:" + strconv.FormatInt(stream.Index, 10))
+  }
+  return nil, ErrNoPosterSource
+}
+
+// Pick the first sampled video frame that isn't (mostly) black; or the brightest, if all are dark.
+func posterVideoFrame(path string, duration int64) (image.Image, error) {
+  var brightest image.Image = nil
+  brightest_luma := -1.0
+  for _, position := range posterFramePositions {
+    start := int64(float64(duration) * position)
+    img, err := posterExtract("-ss", strconv.FormatInt(start, 10), "-i", path, "-map", "0:v:0", "-vf", "thumbnail=50")
+    if err != nil { continue }
+    luma := posterAverageLuma(img)
+    if luma >= posterBlackLuma { return img, nil }
+    if luma > brightest_luma { brightest, brightest_luma = img, luma }
+  }
+  if brightest == nil { return nil, ErrNoPosterSource }
+  return brightest, nil
+}
+
+// Run ffmpeg with input arguments, returning a single output frame.
+func posterExtract(input_arguments ...string) (image.Image, error) {
+  arguments := append([]string { "-v", "error" }, input_arguments...)
+  arguments  = append(arguments, "-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-")
+
+  var stdout bytes.Buffer
+  var stderr bytes.Buffer
+  ffmpeg := exec.Command("ffmpeg", arguments...)
+  ffmpeg.Stdout = &stdout
+  ffmpeg.Stderr = &stderr
+  err := ffmpeg.Run()
+  if err != nil { return nil, fmt.Errorf("%s: %s", err.Error(), bytes.TrimSpace(stderr.Bytes())) }
+  if stdout.Len() == 0 { return nil, ErrNoPosterSource }
+  return png.Decode(&stdout)
+}
+
+// Average luma of (a sampling of) an image's pixels, 0-255.
+func posterAverageLuma(img image.Image) float64 {
+  bounds := img.Bounds()
+  total, count := 0.0, 0.0
+  for y := bounds.Min.Y; y < bounds.Max.Y; y += 4 {
+    for x := bounds.Min.X; x < bounds.Max.X; x += 4 {
+      r, g, b, _ := img.At(x, y).RGBA()
+      total += (0.299 * float64(r >> 8)) + (0.587 * float64(g >> 8)) + (0.114 * float64(b >> 8))
+      count += 1
+    }
+  }
+  if count == 0 { return 0 }
+  return total / count
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package library
+
+import (
+  "errors"
+  "syscall"
+)
+
+// Is a process with this id running (on this host)?
+func processAlive(pid int) bool {
+  if pid <= 0 { return false }
+  err := syscall.Kill(pid, 0)
+  return (err == nil) || errors.Is(err, syscall.EPERM) // EPERM: exists, owned by another user
+}
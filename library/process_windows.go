@@ -0,0 +1,19 @@
+package library
+
+import (
+  "syscall"
+)
+
+const processQueryLimitedInformation = 0x1000
+const processStillActive             = 259
+
+// Is a process with this id running (on this host)?
+func processAlive(pid int) bool {
+  if pid <= 0 { return false }
+  handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+  if err != nil { return false }
+  defer syscall.CloseHandle(handle)
+  exit_code := uint32(0)
+  err = syscall.GetExitCodeProcess(handle, &exit_code)
+  return (err == nil) && (exit_code == processStillActive)
+}
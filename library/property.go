@@ -3,6 +3,7 @@ package library
 import (
   "os"
   "fmt"
+  "time"
   "strconv"
   "path/filepath"
   "database/sql"
@@ -22,8 +23,8 @@ var excluded_properties = map[string]bool {
 // General Properties
 
 // List all properties that can be freely edited.
-func PropertyList() (map[string]string, error) {
-  full_list, err := dbPropertyList()
+func (lib *Library) PropertyList() (map[string]string, error) {
+  full_list, err := lib.dbPropertyList()
   if err != nil { return nil, err }
 
   for key := range full_list {
@@ -34,35 +35,59 @@ func PropertyList() (map[string]string, error) {
   return full_list, nil
 }
 
-func PropertyGet(key string) (string, error) {
+func (lib *Library) PropertyGet(key string) (string, error) {
   if excluded_properties[key] == true { return "", ErrInvalidProperty }
-  return dbPropertyRead(key)
+  return lib.dbPropertyRead(key)
 }
 
-func PropertySet(key string, value string) error {
+func (lib *Library) PropertySet(key string, value string) error {
   if excluded_properties[key] == true { return ErrInvalidProperty }
-  return dbPropertyUpsert(key, value)
+  return lib.dbPropertyUpsert(key, value)
 }
 
-func PropertyDelete(key string) error {
+func (lib *Library) PropertyDelete(key string) error {
   if excluded_properties[key] == true { return ErrInvalidProperty }
-  return dbPropertyDelete(key)
+  return lib.dbPropertyDelete(key)
 }
 
 // ============================================================================
 // Special Properties
 
-func MediaPathGet() (string, error) {
-  if mediaPath != "" { return mediaPath, nil }
+// cached media path is re-read after this long; another process (server) may have moved it
+const mediaPathTtl time.Duration = 5 * time.Second
 
-  var err error
-  mediaPath, err = dbPropertyRead("media_path")
-  if err == ErrNotFound { return "", nil }
-  return mediaPath, err
+func (lib *Library) MediaPathGet() (string, error) {
+  lib.mediaPathLock.Lock()
+  media_path, fresh := lib.mediaPath, !lib.mediaPathRead.IsZero() && (time.Since(lib.mediaPathRead) < mediaPathTtl)
+  lib.mediaPathLock.Unlock()
+  if fresh { return media_path, nil }
+
+  // errors (no properties table, before migrating) are cached too, keeping the last known path
+  media_path, err := lib.dbPropertyRead("media_path")
+  if err == ErrNotFound { media_path, err = "", nil }
+  lib.mediaPathLock.Lock()
+  defer lib.mediaPathLock.Unlock()
+  lib.mediaPathRead = time.Now()
+  if err != nil { return lib.mediaPath, err }
+  lib.mediaPath = media_path
+  return media_path, nil
+}
+
+// Re-read media path on next use (database migrated, or replaced).
+func (lib *Library) mediaPathExpire() {
+  lib.mediaPathLock.Lock()
+  lib.mediaPathRead = time.Time {}
+  lib.mediaPathLock.Unlock()
 }
 
-func MediaPathValid() bool {
-  media_path, err := MediaPathGet()
+// Media path, for building disk paths; on error reading it, the last known.
+func (lib *Library) mediaRoot() string {
+  media_path, _ := lib.MediaPathGet()
+  return media_path
+}
+
+func (lib *Library) MediaPathValid() bool {
+  media_path, err := lib.MediaPathGet()
   if err != nil { return false }
   if media_path == "" { return false }
   if pathIsDirectory(media_path) == false { return false }
@@ -72,37 +97,46 @@ func MediaPathValid() bool {
 // Set the media path.
 // This involves moving the media directory, and may take a while.
 // This call should not be allowed from a web interface.
-func MediaPathSet(new_path string) error {
+func (lib *Library) MediaPathSet(new_path string) error {
   if !nameValidForDisk(filepath.Base(new_path)) { return ErrInvalidName }
 
+  volumes, _ := lib.VolumeList()
+  for _, vol := range volumes {
+    if vol.Id == VolumePrimaryId { continue }
+    if pathContains(vol.Path, new_path) || pathContains(new_path, vol.Path) { return fmt.Errorf("media path overlaps volume \"%s\"", vol.Name) }
+  }
+
   new_library := false
-  curr_path, err := MediaPathGet()
+  lib.mediaPathExpire() // moving what's current, not what was cached
+  curr_path, err := lib.MediaPathGet()
   if err != nil { return err }
   if curr_path == "" {
     new_library = true
   } else {
-    if !pathExists(curr_path) { return fmt.Errorf("cannot move media library, existing path \"%s\" not found: %s", curr_path, err.Error()) }
+    if !pathExists(curr_path) { return fmt.Errorf("cannot move media library, existing path \"%s\" not found", curr_path) }
   }
 
+  moves := []JournalMove {}
   if new_library {
     err = os.MkdirAll(new_path, 0770)
     if err != nil { return fmt.Errorf("cannot create media library at \"%s\": %s", new_path, err.Error()) }
   } else {
     if pathExists(new_path) { return fmt.Errorf("cannot move media to \"%s\": path already exists", new_path) }
-    err = os.Rename(curr_path, new_path)
-    if err != nil { return fmt.Errorf("cannot move media library from \"%s\" to \"%s\": %s", curr_path, new_path, err.Error()) }
+    moves = append(moves, JournalMove { Source:curr_path, Destination:new_path })
   }
 
-  err = dbPropertyUpsert("media_path", new_path)
-  if err != nil { return fmt.Errorf("cannot update database with new media path \"%s\": %s", new_path, err.Error()) }
+  err = lib.journalRun("media-path-set", moves, "properties", "media_path", map[string]any { "value":new_path })
+  if err != nil { return fmt.Errorf("cannot move media library from \"%s\" to \"%s\": %s", curr_path, new_path, err.Error()) }
 
-  mediaPath = new_path
+  lib.mediaPathLock.Lock()
+  lib.mediaPath, lib.mediaPathRead = new_path, time.Now()
+  lib.mediaPathLock.Unlock()
   return nil
 }
 
 // Get current migration level.
-func MigrationLevelGet() (level uint32) {
-  level_string, err := dbPropertyRead("migration_level")
+func (lib *Library) MigrationLevelGet() (level uint32) {
+  level_string, err := lib.dbPropertyRead("migration_level")
   if err != nil { return 0 }
 
   level64, err := strconv.ParseUint(level_string, 10, 32)
@@ -110,20 +144,13 @@ func MigrationLevelGet() (level uint32) {
   return uint32(level64)
 }
 
-// Set current migration level.
-func migrationLevelSet(level uint32) (err error) {
-  err = dbPropertyUpsert("migration_level", strconv.FormatUint(uint64(level), 10))
-  if err != nil { return err }
-  return nil
-}
-
 // Get JWT key.
-func JwtKeyGet() ([]byte, error) {
-  key_base64, err := dbPropertyRead("jwt_key")
+func (lib *Library) JwtKeyGet() ([]byte, error) {
+  key_base64, err := lib.dbPropertyRead("jwt_key")
   if err == ErrNotFound {
-    err = jwtKeyReset()
+    err = lib.jwtKeyReset()
     if err != nil { return nil, err }
-    key_base64, err = dbPropertyRead("jwt_key")
+    key_base64, err = lib.dbPropertyRead("jwt_key")
   }
   if err != nil { return nil, err }
 
@@ -133,13 +160,13 @@ func JwtKeyGet() ([]byte, error) {
   return key_bytes[:wrote_length], nil
 }
 
-func jwtKeyReset() error {
+func (lib *Library) jwtKeyReset() error {
   key_bytes := make([]byte, 32)
   _, err := rand.Read(key_bytes)
   if err != nil { return fmt.Errorf("Error creating JWT key: \"%s\"\n", err.Error()); }
   key_base64 := base64.StdEncoding.EncodeToString(key_bytes)
-  err = dbPropertyUpsert("jwt_key", key_base64)
-  if err != nil { fmt.Errorf("Error creating JWT key: \"%s\"\n", err.Error()) }
+  err = lib.dbPropertyUpsert("jwt_key", key_base64)
+  if err != nil { return fmt.Errorf("Error creating JWT key: \"%s\"\n", err.Error()) }
   return nil
 }
 
@@ -147,21 +174,20 @@ func jwtKeyReset() error {
 // database interface
 
 // Read a key/value from properties.
-func dbPropertyRead(key string) (value string, err error) {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
-  row := dbHandle.QueryRow(`SELECT value FROM properties WHERE key = ?;`, key)
-  err = row.Scan(&value)
+func (lib *Library) dbPropertyRead(key string) (value string, err error) {
+  err = dbRetry(func() error { return lib.dbHandle.QueryRow(`SELECT value FROM properties WHERE key = ?;`, key).Scan(&value) })
   if err == sql.ErrNoRows { return "", ErrNotFound }
   if err != nil { return "", ErrQueryFailed }
   return value, nil
 }
 
 // Insert/Update a key/value in properties.
-func dbPropertyUpsert(key string, value string) (err error) {
-  dbLock.Lock()
-  defer dbLock.Unlock()
-  result, err := dbHandle.Exec(`INSERT INTO properties (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = ?;`, key, value, value)
+func (lib *Library) dbPropertyUpsert(key string, value string) (err error) {
+  var result sql.Result
+  err = dbRetry(func() (err error) {
+    result, err = lib.dbWriter.Exec(`INSERT INTO properties (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = ?;`, key, value, value)
+    return err
+  })
   if err != nil { return ErrQueryFailed }
   affected, err := result.RowsAffected()
   if err != nil { return ErrQueryFailed }
@@ -170,10 +196,12 @@ func dbPropertyUpsert(key string, value string) (err error) {
 }
 
 // Delete a key/value from properties.
-func dbPropertyDelete(key string) (err error) {
-  dbLock.Lock()
-  defer dbLock.Unlock()
-  result, err := dbHandle.Exec(`DELETE FROM properties WHERE key = ?;`, key)
+func (lib *Library) dbPropertyDelete(key string) (err error) {
+  var result sql.Result
+  err = dbRetry(func() (err error) {
+    result, err = lib.dbWriter.Exec(`DELETE FROM properties WHERE key = ?;`, key)
+    return err
+  })
   if err != nil { return ErrQueryFailed }
   affected, err := result.RowsAffected()
   if err != nil { return ErrQueryFailed }
@@ -182,11 +210,10 @@ func dbPropertyDelete(key string) (err error) {
 }
 
 // Read all key/values from properties.
-func dbPropertyList() (properties map[string]string, err error) {
-  dbLock.RLock()
-  defer dbLock.RUnlock()
+func (lib *Library) dbPropertyList() (properties map[string]string, err error) {
   properties = map[string]string {}
-  rows, err := dbHandle.Query(`SELECT key, value FROM properties;`)
+  var rows *sql.Rows
+  err = dbRetry(func() (err error) { rows, err = lib.dbHandle.Query(`SELECT key, value FROM properties;`) ; return err })
   if err != nil { return nil, ErrQueryFailed }
   defer rows.Close()
   for rows.Next() {
@@ -0,0 +1,143 @@
+package library
+
+import (
+  "fmt"
+  "sync"
+  "image"
+  "slices"
+  "encoding/json"
+)
+
+var ErrProviderNotFound = fmt.Errorf("metadata provider not found")
+var ErrProviderExists   = fmt.Errorf("metadata provider already registered")
+
+// A possible match for a Metadata item, from a provider search.
+type ProviderCandidate struct {
+  Provider   string            `json:"provider"`
+  ExternalId string            `json:"external_id"`
+  MediaType  MetadataMediaType `json:"media_type"`
+  Title      string            `json:"title"`
+  Year       int64             `json:"year"` // 0 == unknown
+  Plot       string            `json:"plot"`
+}
+
+// Full details of a provider's item. Zero values mean "not provided".
+type ProviderDetails struct {
+  ExternalId  string
+  Title       string
+  SortTitle   string
+  Year        int64
+  Plot        string
+  TrackNumber int64
+  DiscNumber  int64
+}
+
+// A source of titles, plots and artwork (online database, local fixture, ...).
+type MetadataProvider interface {
+  Name() string
+  Search(title string, year int64, media_type MetadataMediaType) ([]ProviderCandidate, error) // year 0 == any
+  Details(external_id string) (*ProviderDetails, error)                                       // ErrNotFound if no such item
+  Image(external_id string, artwork_type ArtworkType) (image.Image, error)                    // ErrNotFound if no such image
+}
+
+var providerRegistry     = []MetadataProvider {}
+var providerRegistryLock sync.RWMutex
+
+// ============================================================================
+// Registry
+
+func ProviderRegister(provider MetadataProvider) error {
+  providerRegistryLock.Lock()
+  defer providerRegistryLock.Unlock()
+  for _, existing := range providerRegistry {
+    if existing.Name() == provider.Name() { return ErrProviderExists }
+  }
+  providerRegistry = append(providerRegistry, provider)
+  return nil
+}
+
+func ProviderUnregister(name string) {
+  providerRegistryLock.Lock()
+  defer providerRegistryLock.Unlock()
+  providerRegistry = slices.DeleteFunc(providerRegistry, func(provider MetadataProvider) bool { return provider.Name() == name })
+}
+
+func ProviderGet(name string) (MetadataProvider, error) {
+  providerRegistryLock.RLock()
+  defer providerRegistryLock.RUnlock()
+  for _, provider := range providerRegistry {
+    if provider.Name() == name { return provider, nil }
+  }
+  return nil, ErrProviderNotFound
+}
+
+// Names of registered providers, in registration order.
+func ProviderList() []string {
+  providerRegistryLock.RLock()
+  defer providerRegistryLock.RUnlock()
+  names := make([]string, len(providerRegistry))
+  for index, provider := range providerRegistry { names[index] = provider.Name() }
+  return names
+}
+
+// ============================================================================
+// Identification
+
+// Search all providers for items matching this one. Empty title or zero year default to the record's own.
+// Providers that fail are skipped; an error is only returned if every provider failed.
+func (md *Metadata) IdentifyCandidates(title string, year int64) ([]ProviderCandidate, error) {
+  if title == "" { title = md.NameDisplay }
+  if year  == 0  { year  = md.Year        }
+
+  providerRegistryLock.RLock()
+  providers := slices.Clone(providerRegistry)
+  providerRegistryLock.RUnlock()
+
+  candidates := []ProviderCandidate {}
+  var last_err error = nil
+  failures := 0
+  for _, provider := range providers {
+    results, err := provider.Search(title, year, md.MediaType)
+    if err != nil { last_err = err ; failures += 1 ; continue }
+    for index := range results { results[index].Provider = provider.Name() }
+    candidates = append(candidates, results...)
+  }
+  if (len(providers) > 0) && (failures == len(providers)) { return nil, last_err }
+  return candidates, nil
+}
+
+// Apply a provider's item to this record: title, year, plot, numbering, artwork; and remember its external id.
+func (md *Metadata) IdentifyApply(provider_name string, external_id string) error {
+  provider, err := ProviderGet(provider_name)
+  if err != nil { return err }
+  details, err := provider.Details(external_id)
+  if err != nil { return err }
+
+  if (details.Title != "") && (details.Title != md.NameDisplay) {
+    err = md.Rename(details.Title, details.SortTitle)
+    if err != nil { return err }
+  }
+
+  external_ids := map[string]string {}
+  for name, id := range md.ExternalIds { external_ids[name] = id }
+  external_ids[provider_name] = external_id
+  external_ids_bytes, err := json.Marshal(external_ids)
+  if err != nil { return err }
+
+  patch := map[string]any { "external_ids":string(external_ids_bytes) }
+  if details.Year        != 0  { patch["year"        ] = details.Year        }
+  if details.Plot        != "" { patch["plot"        ] = details.Plot        }
+  if details.TrackNumber != 0  { patch["track_number"] = details.TrackNumber }
+  if details.DiscNumber  != 0  { patch["disc_number" ] = details.DiscNumber  }
+  err = md.lib().dbRecordPatch(md, patch)
+  if err != nil { return ErrQueryFailed }
+
+  for _, artwork_type := range ArtworkTypes {
+    img, err := provider.Image(external_id, artwork_type)
+    if err == ErrNotFound { continue }
+    if err != nil { return err }
+    err = md.SetArtwork(artwork_type, img)
+    if err != nil { return err }
+  }
+  return nil
+}
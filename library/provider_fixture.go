@@ -0,0 +1,86 @@
+package library
+
+import (
+  "os"
+  "image"
+  "strings"
+  "encoding/json"
+  "path/filepath"
+)
+
+// A provider serving items from local files, for tests & offline use. Its directory holds:
+//   fixture.json                           : array of FixtureItem
+//   <external_id>.<artwork_type>.(png|jpg) : artwork images (optional)
+type FixtureProvider struct {
+  name  string
+  path  string
+  items []FixtureItem
+}
+
+type FixtureItem struct {
+  ExternalId  string            `json:"external_id"`
+  MediaType   MetadataMediaType `json:"media_type"` // "" matches any
+  Title       string            `json:"title"`
+  SortTitle   string            `json:"sort_title"`
+  Year        int64             `json:"year"`
+  Plot        string            `json:"plot"`
+  TrackNumber int64             `json:"track_number"`
+  DiscNumber  int64             `json:"disc_number"`
+}
+
+func NewFixtureProvider(name string, path string) (*FixtureProvider, error) {
+  data, err := os.ReadFile(filepath.Join(path, "fixture.json"))
+  if err != nil { return nil, err }
+  items := []FixtureItem {}
+  err = json.Unmarshal(data, &items)
+  if err != nil { return nil, err }
+  return &FixtureProvider { name:name, path:path, items:items }, nil
+}
+
+func (provider *FixtureProvider) Name() string { return provider.name }
+
+// Items whose titles contain the search title (ignoring case & punctuation); exact title matches first.
+func (provider *FixtureProvider) Search(title string, year int64, media_type MetadataMediaType) ([]ProviderCandidate, error) {
+  search := nameGetSortForDisplay(title)
+  exact   := []ProviderCandidate {}
+  partial := []ProviderCandidate {}
+  for _, item := range provider.items {
+    if (item.MediaType != "") && (item.MediaType != media_type) { continue }
+    if (year != 0) && (item.Year != 0) && (year != item.Year) { continue }
+    item_title := nameGetSortForDisplay(item.Title)
+    if !strings.Contains(item_title, search) { continue }
+    candidate := ProviderCandidate { ExternalId:item.ExternalId, MediaType:item.MediaType, Title:item.Title, Year:item.Year, Plot:item.Plot }
+    if item_title == search { exact = append(exact, candidate) } else { partial = append(partial, candidate) }
+  }
+  return append(exact, partial...), nil
+}
+
+func (provider *FixtureProvider) Details(external_id string) (*ProviderDetails, error) {
+  for _, item := range provider.items {
+    if item.ExternalId != external_id { continue }
+    return &ProviderDetails {
+      ExternalId  : item.ExternalId,
+      Title       : item.Title,
+      SortTitle   : item.SortTitle,
+      Year        : item.Year,
+      Plot        : item.Plot,
+      TrackNumber : item.TrackNumber,
+      DiscNumber  : item.DiscNumber,
+    }, nil
+  }
+  return nil, ErrNotFound
+}
+
+func (provider *FixtureProvider) Image(external_id string, artwork_type ArtworkType) (image.Image, error) {
+  if strings.ContainsAny(external_id + string(artwork_type), `/\`) { return nil, ErrNotFound }
+  for _, extension := range []string { ".png", ".jpg" } {
+    image_path := filepath.Join(provider.path, external_id + "." + string(artwork_type) + extension)
+    if !pathExists(image_path) { continue }
+    image_file, err := os.Open(image_path)
+    if err != nil { return nil, err }
+    defer image_file.Close()
+    img, _, err := image.Decode(image_file)
+    return img, err
+  }
+  return nil, ErrNotFound
+}
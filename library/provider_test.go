@@ -0,0 +1,89 @@
+package library
+
+import (
+  "os"
+  "image"
+  "image/png"
+  "image/color"
+  "path/filepath"
+  "testing"
+)
+
+const testProviderFixture = `[
+  { "external_id":"alien-1979",  "media_type":"file-video", "title":"Alien",  "year":1979, "plot":"In space, no one can hear you scream." },
+  { "external_id":"aliens-1986", "media_type":"file-video", "title":"Aliens", "year":1986, "plot":"This time it's war." },
+  { "external_id":"alien-tv",    "media_type":"series",     "title":"Alien",  "year":2025, "plot":"Not a movie." }
+]`
+
+func testProviderWriteImage(test *testing.T, path string, width int, height int) {
+  img := image.NewRGBA(image.Rect(0, 0, width, height))
+  for y := 0; y < height; y++ {
+    for x := 0; x < width; x++ { img.Set(x, y, color.RGBA { uint8(x), uint8(y), 128, 255 }) }
+  }
+  file, err := os.Create(path)
+  if err != nil { test.Fatalf("TestProviders: creating image failed: %s", err) }
+  defer file.Close()
+  err = png.Encode(file, img)
+  if err != nil { test.Fatalf("TestProviders: encoding image failed: %s", err) }
+}
+
+func TestProviders(test *testing.T) {
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
+  if err != nil { test.Fatalf("TestProviders: Open failed: %s", err) }
+  defer lib.Shutdown()
+
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestProviders: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestProviders: MediaPathSet failed: %s", err) }
+
+  // fixture provider, with a poster & backdrop for one item
+  fixture_path := test.TempDir()
+  err = os.WriteFile(filepath.Join(fixture_path, "fixture.json"), []byte(testProviderFixture), 0660)
+  if err != nil { test.Fatalf("TestProviders: writing fixture failed: %s", err) }
+  testProviderWriteImage(test, filepath.Join(fixture_path, "alien-1979.poster.png"),   200, 300)
+  testProviderWriteImage(test, filepath.Join(fixture_path, "alien-1979.backdrop.png"), 320, 180)
+
+  provider, err := NewFixtureProvider("fixture", fixture_path)
+  if err != nil { test.Fatalf("TestProviders: NewFixtureProvider failed: %s", err) }
+  err = ProviderRegister(provider)
+  if err != nil { test.Fatalf("TestProviders: ProviderRegister failed: %s", err) }
+  defer ProviderUnregister("fixture")
+  if ProviderRegister(provider) != ErrProviderExists { test.Fatalf("TestProviders: ProviderRegister allowed duplicate name") }
+  if names := ProviderList(); (len(names) != 1) || (names[0] != "fixture") { test.Fatalf("TestProviders: ProviderList returned %v", names) }
+
+  md := Metadata { MediaType:MetadataMediaTypeFileVideo, NameDisplay:"alien", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&md)
+  if err != nil { test.Fatalf("TestProviders: MetadataCreate failed: %s", err) }
+
+  // search: exact title first, other media types excluded
+  candidates, err := md.IdentifyCandidates("", 0)
+  if err != nil { test.Fatalf("TestProviders: IdentifyCandidates failed: %s", err) }
+  if len(candidates) != 2 { test.Fatalf("TestProviders: IdentifyCandidates returned %d candidates, expected 2", len(candidates)) }
+  if candidates[0].ExternalId != "alien-1979"  { test.Fatalf("TestProviders: first candidate was %s", candidates[0].ExternalId) }
+  if candidates[1].ExternalId != "aliens-1986" { test.Fatalf("TestProviders: second candidate was %s", candidates[1].ExternalId) }
+  if candidates[0].Provider   != "fixture"     { test.Fatalf("TestProviders: candidate provider was %s", candidates[0].Provider) }
+
+  // search: year narrows results
+  candidates, err = md.IdentifyCandidates("alien", 1986)
+  if err != nil { test.Fatalf("TestProviders: IdentifyCandidates failed: %s", err) }
+  if (len(candidates) != 1) || (candidates[0].ExternalId != "aliens-1986") { test.Fatalf("TestProviders: IdentifyCandidates by year returned %v", candidates) }
+
+  // apply
+  if md.IdentifyApply("missing", "alien-1979") != ErrProviderNotFound { test.Fatalf("TestProviders: IdentifyApply accepted unknown provider") }
+  if md.IdentifyApply("fixture", "missing") != ErrNotFound { test.Fatalf("TestProviders: IdentifyApply accepted unknown external id") }
+  err = md.IdentifyApply("fixture", "alien-1979")
+  if err != nil { test.Fatalf("TestProviders: IdentifyApply failed: %s", err) }
+
+  stored, err := lib.MetadataRead(md.Id)
+  if err != nil { test.Fatalf("TestProviders: MetadataRead failed: %s", err) }
+  if stored.NameDisplay != "Alien" { test.Fatalf("TestProviders: title not applied: %s", stored.NameDisplay) }
+  if stored.Year != 1979 { test.Fatalf("TestProviders: year not applied: %d", stored.Year) }
+  if stored.Plot != "In space, no one can hear you scream." { test.Fatalf("TestProviders: plot not applied: %s", stored.Plot) }
+  if stored.ExternalIds["fixture"] != "alien-1979" { test.Fatalf("TestProviders: external id not stored: %v", stored.ExternalIds) }
+  if !stored.HasPoster() { test.Fatalf("TestProviders: poster not applied") }
+  if stored.PosterBlurhash == "" { test.Fatalf("TestProviders: poster blurhash not set") }
+  if _, err = stored.ArtworkFile(ArtworkTypeBackdrop, ArtworkSizeSmall, ArtworkFormatJpeg); err != nil { test.Fatalf("TestProviders: backdrop not applied: %s", err) }
+  if _, err = stored.ArtworkFile(ArtworkTypeLogo, ArtworkSizeSmall, ArtworkFormatJpeg); err != ErrNotFound { test.Fatalf("TestProviders: unexpected logo: %v", err) }
+}
@@ -0,0 +1,69 @@
+package library
+
+import (
+  "os"
+  "image"
+  "slices"
+)
+
+// Metadata imported from a source's sidecar files (nfo, info.json, artwork) and embedded tags.
+// Zero values mean "not found".
+type SidecarMetadata struct {
+  Title       string   `json:"title,omitempty"`
+  SortTitle   string   `json:"sort_title,omitempty"`
+  Year        int64    `json:"year,omitempty"`
+  Plot        string   `json:"plot,omitempty"`
+  Season      int64    `json:"season,omitempty"`
+  Episode     int64    `json:"episode,omitempty"`
+  TrackNumber int64    `json:"track_number,omitempty"`
+  DiscNumber  int64    `json:"disc_number,omitempty"`
+  PosterPath  string   `json:"poster_path,omitempty"` // path to poster/cover image, next to source
+  Sources     []string `json:"sources,omitempty"`     // names of importers that contributed
+}
+
+func (sc *SidecarMetadata) Copy() (*SidecarMetadata) {
+  copy := *sc
+  copy.Sources = slices.Clone(sc.Sources)
+  return &copy
+}
+
+// Fill any fields not already set, from other (earlier imports take precedence).
+func (sc *SidecarMetadata) Merge(other *SidecarMetadata, source string) {
+  contributed := false
+  merge_string := func(field *string, value string) { if (*field == "") && (value != "") { *field = value ; contributed = true } }
+  merge_int64  := func(field *int64,  value int64 ) { if (*field == 0 ) && (value != 0 ) { *field = value ; contributed = true } }
+
+  merge_string(&sc.Title,       other.Title      )
+  merge_string(&sc.SortTitle,   other.SortTitle  )
+  merge_int64 (&sc.Year,        other.Year       )
+  merge_string(&sc.Plot,        other.Plot       )
+  merge_int64 (&sc.Season,      other.Season     )
+  merge_int64 (&sc.Episode,     other.Episode    )
+  merge_int64 (&sc.TrackNumber, other.TrackNumber)
+  merge_int64 (&sc.DiscNumber,  other.DiscNumber )
+  merge_string(&sc.PosterPath,  other.PosterPath )
+
+  if contributed && !slices.Contains(sc.Sources, source) { sc.Sources = append(sc.Sources, source) }
+}
+
+// Apply imported fields (year, track & disc numbers, plot, poster) to a Metadata record.
+// Titles are applied earlier, through InputFile.OutputNames.
+func (md *Metadata) ApplySidecar(sidecar *SidecarMetadata) error {
+  patch := map[string]any {}
+  if sidecar.Year        != 0  { patch["year"        ] = sidecar.Year        }
+  if sidecar.TrackNumber != 0  { patch["track_number"] = sidecar.TrackNumber }
+  if sidecar.DiscNumber  != 0  { patch["disc_number" ] = sidecar.DiscNumber  }
+  if sidecar.Plot        != "" { patch["plot"        ] = sidecar.Plot        }
+  err := md.lib().dbRecordPatch(md, patch)
+  if err != nil { return ErrQueryFailed }
+
+  if (sidecar.PosterPath != "") && pathExists(sidecar.PosterPath) {
+    poster_file, err := os.Open(sidecar.PosterPath)
+    if err != nil { return err }
+    defer poster_file.Close()
+    img, _, err := image.Decode(poster_file)
+    if err != nil { return err }
+    return md.SetPoster(img)
+  }
+  return nil
+}
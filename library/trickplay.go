@@ -0,0 +1,174 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "image"
+  "strings"
+  "os/exec"
+  "image/draw"
+  "image/jpeg"
+  "encoding/json"
+  "path/filepath"
+  "encoding/binary"
+)
+
+const trickplaySpriteColumns = 10
+const trickplaySpriteRows    = 10
+
+var ErrInvalidTrickplay = fmt.Errorf("invalid trickplay settings")
+
+// Written as index.json in a Metadata's thumbs directory; describes layout of sprite sheets.
+type TrickplayManifest struct {
+  Interval int64    `json:"interval"` // seconds between thumbnails
+  Width    int64    `json:"width"`    // of a single thumbnail
+  Height   int64    `json:"height"`   // of a single thumbnail
+  Columns  int64    `json:"columns"`
+  Rows     int64    `json:"rows"`
+  Count    int64    `json:"count"`    // total thumbnails
+  Sprites  []string `json:"sprites"`  // sprite sheet file names, in order
+}
+
+// Generate seek preview thumbnails for a video: sprite sheets (in thumbs directory), and a Roku BIF file.
+func (md *Metadata) SetTrickplay(interval int64, width int64) error {
+  if md.MediaType != MetadataMediaTypeFileVideo { return ErrInvalidTrickplay }
+  if (interval < 1) || (width < 16) { return ErrInvalidTrickplay }
+
+  media_path,  err := md.DiskPath(MetadataPathTypeMedia)  ; if err != nil { return err }
+  bif_path,    err := md.DiskPath(MetadataPathTypeBif)    ; if err != nil { return err }
+  thumbs_path, err := md.DiskPath(MetadataPathTypeThumbs) ; if err != nil { return err }
+
+  // extract frames
+  frames_path, err := os.MkdirTemp("", "starkiss-trickplay-")
+  if err != nil { return err }
+  defer os.RemoveAll(frames_path)
+  ffmpeg := exec.Command("ffmpeg", "-v", "error",
+    "-i", media_path,
+    "-an", "-sn",
+    "-vf", fmt.Sprintf("fps=1/%d,scale=%d:-2", interval, width),
+    "-q:v", "5",
+    filepath.Join(frames_path, "%06d.jpg"),
+  )
+  output, err := ffmpeg.CombinedOutput()
+  if err != nil { return fmt.Errorf("error extracting frames: %s: %s", err.Error(), strings.TrimSpace(string(output))) }
+
+  frames, err := filepath.Glob(filepath.Join(frames_path, "*.jpg"))
+  if err != nil { return err }
+  if len(frames) == 0 { return fmt.Errorf("no frames extracted") }
+  sort.Strings(frames)
+
+  err = trickplayWriteBif(bif_path, frames, interval)
+  if err != nil { return fmt.Errorf("error writing bif: %s", err.Error()) }
+  err = trickplayWriteSprites(thumbs_path, frames, interval)
+  if err != nil { return fmt.Errorf("error writing sprites: %s", err.Error()) }
+  return nil
+}
+
+// Read sprite sheet layout for a Metadata.
+func (md *Metadata) TrickplayManifest() (*TrickplayManifest, error) {
+  thumbs_path, err := md.DiskPath(MetadataPathTypeThumbs)
+  if err != nil { return nil, err }
+  manifest_bytes, err := os.ReadFile(filepath.Join(thumbs_path, "index.json"))
+  if os.IsNotExist(err) { return nil, ErrNotFound }
+  if err != nil { return nil, err }
+  manifest := TrickplayManifest {}
+  err = json.Unmarshal(manifest_bytes, &manifest)
+  if err != nil { return nil, err }
+  return &manifest, nil
+}
+
+// BIF layout (little endian): 64 byte header, index of (frame number, offset) pairs terminated by 0xFFFFFFFF, then jpeg data.
+func trickplayWriteBif(bif_path string, frames []string, interval int64) error {
+  frame_data := make([][]byte, len(frames))
+  for index := range frames {
+    data, err := os.ReadFile(frames[index])
+    if err != nil { return err }
+    frame_data[index] = data
+  }
+
+  header := make([]byte, 64)
+  copy(header[0:8], []byte { 0x89, 0x42, 0x49, 0x46, 0x0D, 0x0A, 0x1A, 0x0A })
+  binary.LittleEndian.PutUint32(header[8:12],  0) // version
+  binary.LittleEndian.PutUint32(header[12:16], uint32(len(frames)))
+  binary.LittleEndian.PutUint32(header[16:20], uint32(interval * 1000)) // timestamp multiplier (ms)
+
+  index_table := make([]byte, (len(frames) + 1) * 8)
+  offset := uint32(len(header) + len(index_table))
+  for index := range frame_data {
+    binary.LittleEndian.PutUint32(index_table[(index * 8)    :(index * 8) + 4], uint32(index))
+    binary.LittleEndian.PutUint32(index_table[(index * 8) + 4:(index * 8) + 8], offset)
+    offset += uint32(len(frame_data[index]))
+  }
+  last := len(frames) * 8
+  binary.LittleEndian.PutUint32(index_table[last    :last + 4], 0xFFFFFFFF)
+  binary.LittleEndian.PutUint32(index_table[last + 4:last + 8], offset)
+
+  temp_path := bif_path + ".tmp"
+  bif_file, err := os.Create(temp_path)
+  if err != nil { return err }
+  _, err = bif_file.Write(header)
+  if err == nil { _, err = bif_file.Write(index_table) }
+  for index := range frame_data {
+    if err != nil { break }
+    _, err = bif_file.Write(frame_data[index])
+  }
+  close_err := bif_file.Close()
+  if err == nil { err = close_err }
+  if err != nil { os.Remove(temp_path) ; return err }
+  return os.Rename(temp_path, bif_path)
+}
+
+// Tile frames into sprite sheets, replacing any existing thumbs directory.
+func trickplayWriteSprites(thumbs_path string, frames []string, interval int64) error {
+  err := os.RemoveAll(thumbs_path)
+  if err != nil { return err }
+  err = os.MkdirAll(thumbs_path, 0770)
+  if err != nil { return err }
+
+  manifest := TrickplayManifest {}
+  manifest.Interval = interval
+  manifest.Columns  = trickplaySpriteColumns
+  manifest.Rows     = trickplaySpriteRows
+  manifest.Count    = int64(len(frames))
+  manifest.Sprites  = []string {}
+
+  per_sprite := trickplaySpriteColumns * trickplaySpriteRows
+  for first := 0; first < len(frames); first += per_sprite {
+    var sprite *image.RGBA = nil
+    for index := first; (index < len(frames)) && (index < first + per_sprite); index++ {
+      frame, err := trickplayReadFrame(frames[index])
+      if err != nil { return err }
+      if sprite == nil {
+        if manifest.Width == 0 {
+          manifest.Width  = int64(frame.Bounds().Dx())
+          manifest.Height = int64(frame.Bounds().Dy())
+        }
+        sprite = image.NewRGBA(image.Rect(0, 0, int(manifest.Width) * trickplaySpriteColumns, int(manifest.Height) * trickplaySpriteRows))
+      }
+      tile := index - first
+      x := (tile % trickplaySpriteColumns) * int(manifest.Width)
+      y := (tile / trickplaySpriteColumns) * int(manifest.Height)
+      draw.Draw(sprite, image.Rect(x, y, x + int(manifest.Width), y + int(manifest.Height)), frame, frame.Bounds().Min, draw.Src)
+    }
+
+    sprite_name := fmt.Sprintf("sprite-%03d.jpg", first / per_sprite)
+    sprite_file, err := os.Create(filepath.Join(thumbs_path, sprite_name))
+    if err != nil { return err }
+    err = jpeg.Encode(sprite_file, sprite, &jpeg.Options { Quality:80 })
+    sprite_file.Close()
+    if err != nil { return err }
+    manifest.Sprites = append(manifest.Sprites, sprite_name)
+  }
+
+  manifest_bytes, err := json.Marshal(manifest)
+  if err != nil { return err }
+  return os.WriteFile(filepath.Join(thumbs_path, "index.json"), manifest_bytes, 0660)
+}
+
+func trickplayReadFrame(path string) (image.Image, error) {
+  frame_file, err := os.Open(path)
+  if err != nil { return nil, err }
+  defer frame_file.Close()
+  return jpeg.Decode(frame_file)
+}
@@ -0,0 +1,194 @@
+package library
+
+import (
+  "os"
+  "fmt"
+  "strings"
+  "path/filepath"
+)
+
+// Storage volumes: directories (usually on separate disks) holding categories.
+// The primary volume is media_path (id ""); it also holds Lost Items, and transcoder output.
+
+const VolumePrimaryId   string = ""
+const VolumePrimaryName string = "primary"
+
+type Volume struct {
+  Id        string   `json:"id"`
+  Name      string   `json:"name"`
+  Path      string   `json:"path"`
+  FreeSpace uint64   `json:"free_space"` // bytes available; not stored, read from disk when listed
+  library   *Library                     // library read from/created in (nil == default)
+}
+
+var ErrVolumeInUse = fmt.Errorf("volume has categories assigned")
+
+// ============================================================================
+// Public Interface
+
+func (vol *Volume) Copy() *Volume {
+  copy := Volume {}
+  copy.Id        = vol.Id
+  copy.Name      = vol.Name
+  copy.Path      = vol.Path
+  copy.FreeSpace = vol.FreeSpace
+  copy.library   = vol.library
+  return &copy
+}
+
+// List volumes, primary first, with free space.
+func (lib *Library) VolumeList() ([]Volume, error) {
+  records, err := lib.dbRecordWhere(&Volume{}, `(id <> '') ORDER BY name ASC`)
+  if err != nil { return nil, ErrQueryFailed }
+  volumes := make([]Volume, len(records) + 1)
+  volumes[0] = Volume { Id:VolumePrimaryId, Name:VolumePrimaryName, Path:lib.mediaRoot(), library:lib }
+  for index, record := range records { volumes[index + 1] = *(record.(*Volume)) }
+  for index := range volumes { volumes[index].FreeSpace, _ = volumeFreeSpace(volumes[index].Path) }
+  return volumes, nil
+}
+
+func (lib *Library) VolumeRead(id string) (*Volume, error) {
+  if id == VolumePrimaryId { return &Volume { Id:VolumePrimaryId, Name:VolumePrimaryName, Path:lib.mediaRoot(), library:lib }, nil }
+  vol := Volume {}
+  err := lib.dbRecordRead(&vol, id)
+  if err != nil { return nil, err }
+  return &vol, nil
+}
+
+// Add a volume; path is created if it doesn't exist, and may not overlap any other volume.
+func (lib *Library) VolumeCreate(name string, path string) (*Volume, error) {
+  name = strings.TrimSpace(name)
+  if (name == "") || (name == VolumePrimaryName) { return nil, ErrInvalidName }
+  if !filepath.IsAbs(path) { return nil, fmt.Errorf("volume path must be absolute") }
+  path = filepath.Clean(path)
+
+  volumes, err := lib.VolumeList()
+  if err != nil { return nil, err }
+  for _, vol := range volumes {
+    if vol.Name == name { return nil, fmt.Errorf("volume named \"%s\" already exists", name) }
+    if (vol.Path != "") && (pathContains(vol.Path, path) || pathContains(path, vol.Path)) { return nil, fmt.Errorf("volume path overlaps volume \"%s\"", vol.Name) }
+  }
+
+  err = os.MkdirAll(path, 0770)
+  if err != nil { return nil, fmt.Errorf("cannot create volume at \"%s\": %s", path, err.Error()) }
+
+  vol := Volume { Name:name, Path:path }
+  err = lib.dbRecordCreate(&vol)
+  if err != nil { return nil, ErrQueryFailed }
+  return &vol, nil
+}
+
+// Remove a volume (not its files); only once no categories are assigned to it.
+func (lib *Library) VolumeDelete(vol *Volume) error {
+  if vol.Id == VolumePrimaryId { return fmt.Errorf("cannot delete primary volume") }
+  records, err := lib.dbRecordWhere(&Category{}, `(volume_id = ?) LIMIT 1`, vol.Id)
+  if err != nil { return ErrQueryFailed }
+  if len(records) > 0 { return ErrVolumeInUse }
+  err = lib.dbRecordDelete(vol)
+  if err != nil { return ErrQueryFailed }
+  return nil
+}
+
+// Move a category (and everything in it) to another volume. Across filesystems, files are copied,
+// verified, then removed from their source; journaled, so an interruption can be recovered.
+func (lib *Library) CategoryMoveVolume(cat *Category, volume_id string) error {
+  if volume_id == cat.VolumeId { return nil }
+  vol, err := lib.VolumeRead(volume_id)
+  if err == ErrNotFound { return fmt.Errorf("volume not found: %s", volume_id) }
+  if err != nil { return err }
+  if !pathIsDirectory(vol.Path) { return fmt.Errorf("volume \"%s\" not available at \"%s\"", vol.Name, vol.Path) }
+
+  destination := filepath.Join(vol.Path, cat.Name)
+  if pathExists(destination) { return fmt.Errorf("cannot move category \"%s\" to volume \"%s\": name already exists on disk", cat.Name, vol.Name) }
+
+  moves := []JournalMove { { Source:cat.DiskPath(), Destination:destination } }
+  patch := map[string]any { "volume_id":volume_id }
+  err = lib.journalRun("category-volume", moves, cat.TableName(), cat.Id, patch)
+  if err == ErrQueryFailed { return err }
+  if err != nil { return fmt.Errorf("error moving category \"%s\" to volume \"%s\": %s", cat.Name, vol.Name, err.Error()) }
+  cat.FieldsPatch(patch)
+  return nil
+}
+
+// ============================================================================
+// private utilities
+
+// Root path of a volume; primary if unknown.
+func (lib *Library) volumePath(id string) string {
+  if id == VolumePrimaryId { return lib.mediaRoot() }
+  vol := Volume {}
+  err := lib.dbRecordRead(&vol, id)
+  if err != nil { return lib.mediaRoot() }
+  return vol.Path
+}
+
+// Placement for new categories: the available volume with the most free space.
+func (lib *Library) volumeForNew() (string, error) {
+  volumes, err := lib.VolumeList()
+  if err != nil { return VolumePrimaryId, err }
+  best := volumes[0]
+  for _, vol := range volumes[1:] {
+    if !pathIsDirectory(vol.Path) { continue }
+    if vol.FreeSpace > best.FreeSpace { best = vol }
+  }
+  return best.Id, nil
+}
+
+// Is child path inside (or equal to) parent path?
+func pathContains(parent string, child string) bool {
+  relative, err := filepath.Rel(parent, child)
+  if err != nil { return false }
+  return (relative == ".") || ((relative != "..") && !strings.HasPrefix(relative, ".." + string(filepath.Separator)))
+}
+
+// ============================================================================
+// dbRecord interface
+
+func (vol *Volume) TableName() string { return "volumes" }
+func (vol *Volume) GetId() string { return vol.Id }
+func (vol *Volume) SetId(id string) { vol.Id = id }
+func (vol *Volume) setLibrary(lib *Library) { vol.library = lib }
+func (vol *Volume) RecordCopy() (dbRecord, error) {
+  return vol.Copy(), nil
+}
+
+func (vol *Volume) RecordCreate(fields map[string]any) (instance dbRecord, err error) {
+  new_instance := Volume {}
+  err = new_instance.FieldsReplace(fields)
+  if err != nil { return nil, err }
+  return &new_instance, nil
+}
+
+func (vol *Volume) FieldsRead() (fields map[string]any, err error) {
+  fields = make(map[string]any)
+  fields["id"]   = vol.Id
+  fields["name"] = vol.Name
+  fields["path"] = vol.Path
+  return fields, nil
+}
+
+func (vol *Volume) FieldsReplace(fields map[string]any) (err error) {
+  vol.Id   = fields["id"].(string)
+  vol.Name = fields["name"].(string)
+  vol.Path = fields["path"].(string)
+  return nil
+}
+
+func (vol *Volume) FieldsPatch(fields map[string]any) (err error) {
+  if id,   ok := fields["id"]   ; ok { vol.Id   = id.(string)   }
+  if name, ok := fields["name"] ; ok { vol.Name = name.(string) }
+  if path, ok := fields["path"] ; ok { vol.Path = path.(string) }
+  return nil
+}
+
+func (vol_a *Volume) FieldsDifference(other dbRecord) (diff map[string]any, err error) {
+  diff = make(map[string]any)
+  vol_b, b_is_vol := other.(*Volume)
+  if b_is_vol == false { return diff, ErrInvalidType }
+
+  if vol_a.Id   != vol_b.Id   { diff["id"]   = vol_b.Id   }
+  if vol_a.Name != vol_b.Name { diff["name"] = vol_b.Name }
+  if vol_a.Path != vol_b.Path { diff["path"] = vol_b.Path }
+
+  return diff, nil
+}
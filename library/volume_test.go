@@ -0,0 +1,82 @@
+package library
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestVolumes(test *testing.T) {
+  test.Parallel()
+  testDbPath := filepath.Join(test.TempDir(), "test.database")
+  lib, err := LibraryOpen(testDbPath)
+  if err != nil { test.Fatalf("TestVolumes: Open failed: %s", err) }
+  defer lib.Shutdown()
+
+  err = lib.MigrateToLatest()
+  if err != nil { test.Fatalf("TestVolumes: MigrateToLatest failed: %s", err) }
+  err = lib.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestVolumes: MediaPathSet failed: %s", err) }
+
+  // volumes may not overlap
+  second_path := filepath.Join(test.TempDir(), "second")
+  vol, err := lib.VolumeCreate("second", second_path)
+  if err != nil { test.Fatalf("TestVolumes: VolumeCreate failed: %s", err) }
+  if _, err = lib.VolumeCreate("nested", filepath.Join(second_path, "nested")); err == nil { test.Fatalf("TestVolumes: overlapping volume created") }
+  volumes, err := lib.VolumeList()
+  if (err != nil) || (len(volumes) != 2) || (volumes[0].Id != VolumePrimaryId) { test.Fatalf("TestVolumes: VolumeList failed: %v, %s", volumes, err) }
+
+  // new categories are placed on a volume, and items resolve through it
+  cat, err := lib.CategoryCreate("Movies", CategoryMediaTypeMovie)
+  if err != nil { test.Fatalf("TestVolumes: CategoryCreate failed: %s", err) }
+  if !pathIsDirectory(cat.DiskPath()) || !pathContains(lib.volumePath(cat.VolumeId), cat.DiskPath()) { test.Fatalf("TestVolumes: category not created on its volume: %s", cat.DiskPath()) }
+  md := Metadata { ParentId:cat.Id, MediaType:MetadataMediaTypeFileVideo, NameDisplay:"Movie", Extension:".mp4", Streams:[]FileStream {} }
+  err = lib.MetadataCreate(&md)
+  if err != nil { test.Fatalf("TestVolumes: MetadataCreate failed: %s", err) }
+  media_path, _ := md.DiskPath(MetadataPathTypeMedia)
+  os.WriteFile(media_path, []byte("media"), 0660)
+
+  // move between volumes, both ways
+  for _, volume_id := range []string { vol.Id, VolumePrimaryId } {
+    if cat.VolumeId == volume_id { continue }
+    err = lib.CategoryMoveVolume(cat, volume_id)
+    if err != nil { test.Fatalf("TestVolumes: CategoryMoveVolume failed: %s", err) }
+    stored, _ := lib.CategoryRead(cat.Id)
+    if stored.VolumeId != volume_id { test.Fatalf("TestVolumes: volume not stored") }
+    moved_path, _ := md.DiskPath(MetadataPathTypeMedia)
+    if !pathContains(lib.volumePath(volume_id), moved_path) || !pathExists(moved_path) { test.Fatalf("TestVolumes: media not moved to volume: %s", moved_path) }
+  }
+
+  // volumes in use can't be removed
+  if cat.VolumeId == vol.Id {
+    if err = lib.VolumeDelete(vol); err != ErrVolumeInUse { test.Fatalf("TestVolumes: VolumeDelete of used volume: %v", err) }
+    err = lib.CategoryMoveVolume(cat, VolumePrimaryId)
+    if err != nil { test.Fatalf("TestVolumes: CategoryMoveVolume failed: %s", err) }
+  }
+  err = lib.VolumeDelete(vol)
+  if err != nil { test.Fatalf("TestVolumes: VolumeDelete failed: %s", err) }
+
+  // media path can't be moved from where it no longer is
+  os.RemoveAll(lib.mediaPath)
+  if err = lib.MediaPathSet(filepath.Join(test.TempDir(), "moved")); err == nil { test.Fatalf("TestVolumes: MediaPathSet moved a missing media path") }
+}
+
+func TestPathCopyVerified(test *testing.T) {
+  test.Parallel()
+  source := filepath.Join(test.TempDir(), "source")
+  os.MkdirAll(filepath.Join(source, "season 1"), 0770)
+  os.WriteFile(filepath.Join(source, "season 1", "episode.mp4"), []byte("episode"), 0660)
+  os.WriteFile(filepath.Join(source, "poster.jpg"), []byte("poster"), 0660)
+
+  destination := filepath.Join(test.TempDir(), "destination")
+  err := pathCopyVerified(source, destination)
+  if err != nil { test.Fatalf("TestPathCopyVerified: copy failed: %s", err) }
+  if pathExists(destination + ".partial") { test.Fatalf("TestPathCopyVerified: partial copy left behind") }
+  if err = pathCompare(source, destination); err != nil { test.Fatalf("TestPathCopyVerified: copy differs: %s", err) }
+
+  os.WriteFile(filepath.Join(destination, "season 1", "episode.mp4"), []byte("episodE"), 0660)
+  if pathCompare(source, destination) == nil { test.Fatalf("TestPathCopyVerified: changed content not detected") }
+  os.WriteFile(filepath.Join(destination, "season 1", "episode.mp4"), []byte("episode"), 0660)
+  os.WriteFile(filepath.Join(destination, "extra.nfo"), []byte("extra"), 0660)
+  if pathCompare(source, destination) == nil { test.Fatalf("TestPathCopyVerified: extra file not detected") }
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package library
+
+import (
+  "errors"
+  "syscall"
+)
+
+// Bytes available to unprivileged users, on the filesystem holding path.
+func volumeFreeSpace(path string) (uint64, error) {
+  stat := syscall.Statfs_t {}
+  err := syscall.Statfs(path, &stat)
+  if err != nil { return 0, err }
+  return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// Rename failure when source & destination are on different filesystems.
+var errCrossDevice error = syscall.EXDEV
+
+// Did a rename fail because source & destination are on different filesystems?
+func errIsCrossDevice(err error) bool {
+  return errors.Is(err, errCrossDevice)
+}
@@ -0,0 +1,26 @@
+package library
+
+import (
+  "errors"
+  "syscall"
+  "unsafe"
+)
+
+// Bytes available to the current user, on the volume holding path.
+func volumeFreeSpace(path string) (uint64, error) {
+  path_utf16, err := syscall.UTF16PtrFromString(path)
+  if err != nil { return 0, err }
+  kernel32 := syscall.NewLazyDLL("kernel32.dll")
+  free_bytes := uint64(0)
+  result, _, err := kernel32.NewProc("GetDiskFreeSpaceExW").Call(uintptr(unsafe.Pointer(path_utf16)), uintptr(unsafe.Pointer(&free_bytes)), 0, 0)
+  if result == 0 { return 0, err }
+  return free_bytes, nil
+}
+
+// Rename failure when source & destination are on different volumes.
+var errCrossDevice error = syscall.Errno(17) // ERROR_NOT_SAME_DEVICE
+
+// Did a rename fail because source & destination are on different volumes?
+func errIsCrossDevice(err error) bool {
+  return errors.Is(err, errCrossDevice)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  "bytes"
+  "regexp"
+  "os/exec"
+  "strconv"
+  "github.com/daumiller/starkiss/library"
+)
+
+const analyzeSegmentCount   = 4  // number of segments sampled through the file
+const analyzeSegmentSeconds = 20 // length of each sampled segment
+const analyzeInterlaceRatio = 0.3
+
+var idetPattern       *regexp.Regexp = regexp.MustCompile(`Multi frame detection:\s*TFF:\s*([0-9]+)\s*BFF:\s*([0-9]+)\s*Progressive:\s*([0-9]+)`)
+var cropdetectPattern *regexp.Regexp = regexp.MustCompile(`crop=([0-9]+):([0-9]+):([0-9]+):([0-9]+)`)
+
+// Sample segments of a video stream with idet & cropdetect, to find interlacing and black bars.
+func analyzeVideo(path string, stream *library.FileStream, duration int64) (*library.VideoProcessing, error) {
+  analysis := library.VideoProcessing {}
+  analysis.TimeAnalyzed = time.Now().Unix()
+
+  tff, bff, progressive := int64(0), int64(0), int64(0)
+  crop_width, crop_height := int64(0), int64(0)
+  crop_x, crop_y := int64(0), int64(0)
+
+  for segment := int64(1); segment <= analyzeSegmentCount; segment++ {
+    start := (duration * segment) / (analyzeSegmentCount + 1)
+    if duration < (analyzeSegmentSeconds * 2) { start = 0 }
+
+    var stderr bytes.Buffer
+    ffmpeg := exec.Command("ffmpeg",
+      "-ss", strconv.FormatInt(start, 10), "-t", strconv.Itoa(analyzeSegmentSeconds),
+      "-i", path,
+      "-map", "0:" + strconv.FormatInt(stream.Index, 10),
+      "-vf", "idet,cropdetect=limit=24:round=2:reset=0",
+      "-an", "-sn", "-f", "null", "-",
+    )
+    ffmpeg.Stderr = &stderr
+    err := ffmpeg.Run()
+    if err != nil { return nil, fmt.Errorf("error analyzing segment at %ds: %s", start, err.Error()) }
+    output := stderr.String()
+
+    idet := idetPattern.FindStringSubmatch(output)
+    if idet != nil {
+      count, _ := strconv.ParseInt(idet[1], 10, 64) ; tff         += count
+      count, _  = strconv.ParseInt(idet[2], 10, 64) ; bff         += count
+      count, _  = strconv.ParseInt(idet[3], 10, 64) ; progressive += count
+    }
+
+    // keep the largest crop seen across segments (dark scenes over-crop)
+    crops := cropdetectPattern.FindAllStringSubmatch(output, -1)
+    if len(crops) > 0 {
+      last := crops[len(crops) - 1]
+      width,  _ := strconv.ParseInt(last[1], 10, 64)
+      height, _ := strconv.ParseInt(last[2], 10, 64)
+      x,      _ := strconv.ParseInt(last[3], 10, 64)
+      y,      _ := strconv.ParseInt(last[4], 10, 64)
+      if (width * height) > (crop_width * crop_height) { crop_width, crop_height, crop_x, crop_y = width, height, x, y }
+    }
+
+    if start == 0 { break }
+  }
+
+  interlaced := tff + bff
+  if (interlaced + progressive) > 0 {
+    analysis.InterlacedRatio = float64(interlaced) / float64(interlaced + progressive)
+    analysis.Interlaced      = analysis.InterlacedRatio >= analyzeInterlaceRatio
+    if analysis.Interlaced { analysis.FieldOrder = "tff" ; if bff > tff { analysis.FieldOrder = "bff" } }
+  }
+
+  if (crop_width > 0) && (crop_height > 0) && ((crop_width < stream.Width) || (crop_height < stream.Height)) {
+    analysis.Crop = fmt.Sprintf("%d:%d:%d:%d", crop_width, crop_height, crop_x, crop_y)
+  }
+
+  return &analysis, nil
+}
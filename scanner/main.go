@@ -15,33 +15,53 @@ func main() {
   err := library.LibraryStartup(db_path)
   if err != nil { fmt.Printf("Error starting library: %s\n", err.Error()) ; os.Exit(-1) }
   defer library.LibraryShutdown()
-  if library.LibraryReady() != nil {
+  err = library.LibraryReady()
+  if err != nil {
     fmt.Printf("Library not ready: %s\n", err.Error())
     os.Exit(-1)
   }
+  // never migrates; server does, and a mismatched schema would be misread (or miswritten)
+  err = library.MigrationCheckLatest()
+  if err != nil { fmt.Printf("Cannot open library: %s\n", err.Error()) ; os.Exit(-1) }
 
   if len(os.Args) < 2 {
-    fmt.Printf("Usage: scanner <path>\n")
+    fmt.Printf("Usage: scanner <path> [category]\n")
     fmt.Printf("  <path> is a glob pattern to match files against (ex: \"/media/**/*.mp4\")\n")
     fmt.Printf("  <path> can also be a single file\n")
+    fmt.Printf("  [category] is the name of a category to place transcoded files into (also sets audio output format)\n")
     fmt.Printf("Use DBFILE environment variable to set alternate path to database.\n")
     fmt.Printf("\n")
     os.Exit(0)
   }
 
+  category_id := ""
+  if len(os.Args) > 2 {
+    category_id = findCategory(os.Args[2])
+    if category_id == "" { fmt.Printf("Category \"%s\" not found.\n", os.Args[2]) ; os.Exit(-1) }
+  }
+
   paths, err := filepathx.Glob(os.Args[1])
   if err != nil { fmt.Printf("Error processing glob: %s\n", err.Error()) ; os.Exit(-1) }
 
   fmt.Printf("Scanning %d paths...\n", len(paths))
   for _, path := range paths {
-    skipped_reason := processFile(path)
+    skipped_reason := processFile(path, category_id)
     if skipped_reason != "" { fmt.Printf("Skipped \"%s\" (%s)\n", path, skipped_reason) }
   }
 
   fmt.Printf("Done!\n")
 }
 
-func processFile(path string) (skip_reason string) {
+func findCategory(name string) string {
+  categories, err := library.CategoryList()
+  if err != nil { fmt.Printf("Error reading categories: %s\n", err.Error()) ; os.Exit(-1) }
+  for _, cat := range categories {
+    if cat.Name == name { return cat.Id }
+  }
+  return ""
+}
+
+func processFile(path string, category_id string) (skip_reason string) {
   exists := library.InputFileExistsForSource(path)
   if exists { return "already-processed" }
 
@@ -68,6 +88,7 @@ func processFile(path string) (skip_reason string) {
   inp.TranscodingTimeStarted = 0
   inp.TranscodingTimeElapsed = 0
   inp.TranscodingError       = ""
+  inp.CategoryId             = category_id
 
   video_stream_count    := 0 ; video_stream_index    := int64(0)
   audio_stream_count    := 0 ; audio_stream_index    := int64(0)
@@ -96,6 +117,20 @@ func processFile(path string) (skip_reason string) {
     inp.StreamMap = stream_map
   }
 
+  // look for interlacing & black bars (in first video stream)
+  if video_stream_count > 0 {
+    for index := range source_streams {
+      if source_streams[index].StreamType != library.FileStreamTypeVideo { continue }
+      analysis, err := analyzeVideo(path, &(source_streams[index]), source_duration)
+      if err != nil { fmt.Printf("Unable to analyze video of \"%s\": %s\n", path, err.Error()) ; break }
+      inp.VideoProcessing = *analysis
+      break
+    }
+  }
+
+  // titles, numbering, plot & poster from nfo/json sidecars & embedded tags
+  inp.Sidecar = importSidecars(path)
+
   err = library.InputFileCreate(&inp)
   if err != nil {
     println(err.Error())
@@ -0,0 +1,254 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "regexp"
+  "context"
+  "os/exec"
+  "slices"
+  "strconv"
+  "strings"
+  "encoding/xml"
+  "encoding/json"
+  "path/filepath"
+  "github.com/daumiller/starkiss/library"
+)
+
+// An importer reads one kind of sidecar (or embedded) metadata for a source file.
+type sidecarImporter interface {
+  Name() string
+  Import(path string) (*library.SidecarMetadata, error) // nil, nil == nothing found
+}
+
+// In order of precedence; earlier importers' fields win.
+var sidecarImporters = []sidecarImporter {
+  &nfoImporter      {},
+  &infoJsonImporter {},
+  &tagImporter      {},
+  &artworkImporter  {},
+}
+
+// Run all importers against a source file, merging their results.
+func importSidecars(path string) library.SidecarMetadata {
+  sidecar := library.SidecarMetadata {}
+  for _, importer := range sidecarImporters {
+    imported, err := importer.Import(path)
+    if err != nil { fmt.Printf("Unable to import %s metadata for \"%s\": %s\n", importer.Name(), path, err.Error()) ; continue }
+    if imported == nil { continue }
+    sidecar.Merge(imported, importer.Name())
+  }
+  return sidecar
+}
+
+// "/path/Movie.mkv" -> "/path/Movie"
+func sidecarBase(path string) string {
+  return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// Sidecars, subtitles & artwork; anything else in a directory is taken to be another media file.
+var sidecarExtensions = []string { ".nfo", ".json", ".jpg", ".jpeg", ".png", ".srt", ".ass", ".vtt", ".sub", ".idx", ".txt" }
+
+// Directory-level files ("movie.nfo", "info.json", "poster.jpg", ...) only describe a source that's alone in its directory.
+func sidecarDirectoryOwned(path string) bool {
+  entries, err := os.ReadDir(filepath.Dir(path))
+  if err != nil { return false }
+  for _, entry := range entries {
+    if entry.IsDir() || (entry.Name()[0] == '.') || (entry.Name() == filepath.Base(path)) { continue }
+    if slices.Contains(sidecarExtensions, strings.ToLower(filepath.Ext(entry.Name()))) { continue }
+    return false
+  }
+  return true
+}
+
+// First existing path of candidates, or "".
+func sidecarFind(candidates ...string) string {
+  for _, candidate := range candidates {
+    if _, err := os.Stat(candidate); err == nil { return candidate }
+  }
+  return ""
+}
+
+var sidecarNumberPattern *regexp.Regexp = regexp.MustCompile(`^\s*([0-9]+)`)
+var sidecarYearPattern   *regexp.Regexp = regexp.MustCompile(`^\s*([0-9]{4})`)
+
+// Leading integer of a value ("3/12" -> 3), or 0.
+func sidecarNumber(value string) int64 {
+  match := sidecarNumberPattern.FindStringSubmatch(value)
+  if match == nil { return 0 }
+  number, _ := strconv.ParseInt(match[1], 10, 64)
+  return number
+}
+
+// Year from start of a date ("2019-05-01", "20190501", "2019") or 0.
+func sidecarYear(value string) int64 {
+  match := sidecarYearPattern.FindStringSubmatch(value)
+  if match == nil { return 0 }
+  year, _ := strconv.ParseInt(match[1], 10, 64)
+  return year
+}
+
+// ============================================================================
+// Kodi .nfo (xml): "<name>.nfo" beside source, or "movie.nfo" if source is alone in its directory
+
+type nfoImporter struct {}
+
+type nfoDocument struct {
+  XMLName   xml.Name
+  Title     string `xml:"title"`
+  SortTitle string `xml:"sorttitle"`
+  Year      string `xml:"year"`
+  Premiered string `xml:"premiered"`
+  Aired     string `xml:"aired"`
+  Plot      string `xml:"plot"`
+  Season    string `xml:"season"`
+  Episode   string `xml:"episode"`
+  Track     string `xml:"track"`
+}
+
+func (importer *nfoImporter) Name() string { return "nfo" }
+
+func (importer *nfoImporter) Import(path string) (*library.SidecarMetadata, error) {
+  nfo_path := sidecarFind(sidecarBase(path) + ".nfo")
+  if (nfo_path == "") && sidecarDirectoryOwned(path) { nfo_path = sidecarFind(filepath.Join(filepath.Dir(path), "movie.nfo")) }
+  if nfo_path == "" { return nil, nil }
+
+  document, err := nfoRead(nfo_path)
+  if err != nil { return nil, err }
+  sidecar := library.SidecarMetadata {}
+  sidecar.Title       = strings.TrimSpace(document.Title)
+  sidecar.SortTitle   = strings.TrimSpace(document.SortTitle)
+  sidecar.Plot        = strings.TrimSpace(document.Plot)
+  sidecar.Season      = sidecarNumber(document.Season)
+  sidecar.Episode     = sidecarNumber(document.Episode)
+  sidecar.TrackNumber = sidecarNumber(document.Track)
+  sidecar.Year        = sidecarYear(document.Year)
+  if sidecar.Year == 0 { sidecar.Year = sidecarYear(document.Premiered) }
+  if sidecar.Year == 0 { sidecar.Year = sidecarYear(document.Aired)     }
+  return &sidecar, nil
+}
+
+func nfoRead(path string) (*nfoDocument, error) {
+  data, err := os.ReadFile(path)
+  if err != nil { return nil, err }
+  document := nfoDocument {}
+  err = xml.Unmarshal(data, &document)
+  if err != nil { return nil, err }
+  return &document, nil
+}
+
+// ============================================================================
+// downloader (yt-dlp style) json: "<name>.info.json" beside source, or "info.json" if source is alone in its directory
+
+type infoJsonImporter struct {}
+
+func (importer *infoJsonImporter) Name() string { return "info.json" }
+
+func (importer *infoJsonImporter) Import(path string) (*library.SidecarMetadata, error) {
+  json_path := sidecarFind(sidecarBase(path) + ".info.json")
+  if (json_path == "") && sidecarDirectoryOwned(path) { json_path = sidecarFind(filepath.Join(filepath.Dir(path), "info.json")) }
+  if json_path == "" { return nil, nil }
+  data, err := os.ReadFile(json_path)
+  if err != nil { return nil, err }
+
+  info := struct {
+    Title         string `json:"title"`
+    Track         string `json:"track"`
+    Description   string `json:"description"`
+    UploadDate    string `json:"upload_date"`
+    ReleaseDate   string `json:"release_date"`
+    ReleaseYear   int64  `json:"release_year"`
+    TrackNumber   int64  `json:"track_number"`
+    DiscNumber    int64  `json:"disc_number"`
+    SeasonNumber  int64  `json:"season_number"`
+    EpisodeNumber int64  `json:"episode_number"`
+  } {}
+  err = json.Unmarshal(data, &info)
+  if err != nil { return nil, err }
+
+  sidecar := library.SidecarMetadata {}
+  sidecar.Title       = strings.TrimSpace(info.Title)
+  if info.Track != "" { sidecar.Title = strings.TrimSpace(info.Track) } // music: "track" is the song title, "title" the video's
+  sidecar.Plot        = strings.TrimSpace(info.Description)
+  sidecar.TrackNumber = info.TrackNumber
+  sidecar.DiscNumber  = info.DiscNumber
+  sidecar.Season      = info.SeasonNumber
+  sidecar.Episode     = info.EpisodeNumber
+  sidecar.Year        = info.ReleaseYear
+  if sidecar.Year == 0 { sidecar.Year = sidecarYear(info.ReleaseDate) }
+  if sidecar.Year == 0 { sidecar.Year = sidecarYear(info.UploadDate)  }
+  return &sidecar, nil
+}
+
+// ============================================================================
+// embedded tags (ID3, MP4/iTunes, Matroska, Vorbis), read through ffprobe
+
+type tagImporter struct {}
+
+func (importer *tagImporter) Name() string { return "tags" }
+
+func (importer *tagImporter) Import(path string) (*library.SidecarMetadata, error) {
+  timeout, cancel := context.WithTimeout(context.Background(), time.Second * 30)
+  defer cancel()
+  output, err := exec.CommandContext(timeout, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", path).Output()
+  if err != nil { return nil, fmt.Errorf("error getting tags: %s", err.Error()) }
+
+  probe := struct {
+    Format struct {
+      Tags map[string]string `json:"tags"`
+    } `json:"format"`
+  } {}
+  err = json.Unmarshal(output, &probe)
+  if err != nil { return nil, fmt.Errorf("error parsing tags: %s", err.Error()) }
+  if len(probe.Format.Tags) == 0 { return nil, nil }
+
+  // tag names vary in case between containers
+  tags := map[string]string {}
+  for key, value := range probe.Format.Tags { tags[strings.ToLower(key)] = strings.TrimSpace(value) }
+  first := func(keys ...string) string {
+    for _, key := range keys { if tags[key] != "" { return tags[key] } }
+    return ""
+  }
+
+  sidecar := library.SidecarMetadata {}
+  sidecar.Title       = first("title")
+  sidecar.SortTitle   = first("sort_name", "title-sort", "titlesort")
+  sidecar.Year        = sidecarYear(first("date", "year", "originaldate", "creation_time"))
+  sidecar.Plot        = first("synopsis", "description", "comment")
+  sidecar.Season      = sidecarNumber(first("season_number"))
+  sidecar.Episode     = sidecarNumber(first("episode_sort"))
+  sidecar.TrackNumber = sidecarNumber(first("track", "tracknumber"))
+  sidecar.DiscNumber  = sidecarNumber(first("disc", "discnumber"))
+
+  // creation_time is when the file was muxed, not released; only trust it for audio-style (album) tags
+  if (first("date", "year", "originaldate") == "") && (first("album") == "") { sidecar.Year = 0 }
+  return &sidecar, nil
+}
+
+// ============================================================================
+// artwork beside source: "<name>-poster.jpg", "<name>.jpg"; or "poster.jpg", "folder.jpg", "cover.jpg" if source is alone in its directory
+
+type artworkImporter struct {}
+
+func (importer *artworkImporter) Name() string { return "artwork" }
+
+func (importer *artworkImporter) Import(path string) (*library.SidecarMetadata, error) {
+  base      := sidecarBase(path)
+  directory := filepath.Dir(path)
+  candidates := []string {}
+  for _, extension := range []string { ".jpg", ".jpeg", ".png" } {
+    candidates = append(candidates, base + "-poster" + extension, base + extension)
+  }
+  if sidecarDirectoryOwned(path) {
+    for _, name := range []string { "poster", "folder", "cover" } {
+      for _, extension := range []string { ".jpg", ".jpeg", ".png" } {
+        candidates = append(candidates, filepath.Join(directory, name + extension))
+      }
+    }
+  }
+
+  poster_path := sidecarFind(candidates...)
+  if poster_path == "" { return nil, nil }
+  return &library.SidecarMetadata { PosterPath:poster_path }, nil
+}
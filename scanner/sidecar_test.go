@@ -0,0 +1,94 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func testSidecarWrite(test *testing.T, path string, content string) {
+  err := os.MkdirAll(filepath.Dir(path), 0770)
+  if err == nil { err = os.WriteFile(path, []byte(content), 0660) }
+  if err != nil { test.Fatalf("testSidecarWrite: writing \"%s\" failed: %s", path, err) }
+}
+
+func TestSidecarValues(test *testing.T) {
+  numbers := map[string]int64 { "3": 3, " 3/12": 3, "07": 7, "": 0, "x1": 0 }
+  for value, expected := range numbers {
+    if sidecarNumber(value) != expected { test.Fatalf("TestSidecarValues: sidecarNumber(\"%s\") = %d, expected %d", value, sidecarNumber(value), expected) }
+  }
+  years := map[string]int64 { "2019-05-01": 2019, "20190501": 2019, "2019": 2019, "19": 0, "": 0 }
+  for value, expected := range years {
+    if sidecarYear(value) != expected { test.Fatalf("TestSidecarValues: sidecarYear(\"%s\") = %d, expected %d", value, sidecarYear(value), expected) }
+  }
+}
+
+func TestSidecarNfo(test *testing.T) {
+  directory := test.TempDir()
+  source    := filepath.Join(directory, "Show S01E02.mkv")
+  testSidecarWrite(test, source, "")
+  testSidecarWrite(test, filepath.Join(directory, "Show S01E02.nfo"), `<?xml version="1.0" encoding="UTF-8"?>
+<episodedetails>
+  <title> The Second One </title>
+  <sorttitle>Second One</sorttitle>
+  <season>1</season>
+  <episode>2</episode>
+  <aired>2004-10-05</aired>
+  <plot>Things happen.</plot>
+</episodedetails>`)
+
+  sidecar, err := (&nfoImporter {}).Import(source)
+  if (err != nil) || (sidecar == nil) { test.Fatalf("TestSidecarNfo: Import failed: %v, %s", sidecar, err) }
+  if (sidecar.Title != "The Second One") || (sidecar.SortTitle != "Second One") || (sidecar.Plot != "Things happen.") { test.Fatalf("TestSidecarNfo: unexpected text fields: %v", sidecar) }
+  if (sidecar.Season != 1) || (sidecar.Episode != 2) || (sidecar.Year != 2004) { test.Fatalf("TestSidecarNfo: unexpected numbers: %v", sidecar) }
+
+  // malformed nfo is reported, not ignored
+  testSidecarWrite(test, filepath.Join(directory, "Show S01E02.nfo"), "<episodedetails><title>")
+  if _, err = (&nfoImporter {}).Import(source); err == nil { test.Fatalf("TestSidecarNfo: malformed nfo imported") }
+}
+
+func TestSidecarInfoJson(test *testing.T) {
+  directory := test.TempDir()
+  source    := filepath.Join(directory, "Song.opus")
+  testSidecarWrite(test, source, "")
+  testSidecarWrite(test, filepath.Join(directory, "Song.info.json"), `{
+    "title": "Band - Song (Official Video)", "track": "Song", "description": "Music video.",
+    "upload_date": "20210304", "track_number": 4, "disc_number": 2
+  }`)
+
+  // music: track is the song title; upload date is used when there's no release date
+  sidecar, err := (&infoJsonImporter {}).Import(source)
+  if (err != nil) || (sidecar == nil) { test.Fatalf("TestSidecarInfoJson: Import failed: %v, %s", sidecar, err) }
+  if (sidecar.Title != "Song") || (sidecar.Plot != "Music video.") { test.Fatalf("TestSidecarInfoJson: unexpected text fields: %v", sidecar) }
+  if (sidecar.Year != 2021) || (sidecar.TrackNumber != 4) || (sidecar.DiscNumber != 2) { test.Fatalf("TestSidecarInfoJson: unexpected numbers: %v", sidecar) }
+}
+
+func TestSidecarDirectory(test *testing.T) {
+  // a source alone in its directory picks up directory-level files
+  alone := filepath.Join(test.TempDir(), "Movie (1999).mkv")
+  testSidecarWrite(test, alone, "")
+  testSidecarWrite(test, filepath.Join(filepath.Dir(alone), "movie.nfo"),  "<movie><title>Movie</title><year>1999</year></movie>")
+  testSidecarWrite(test, filepath.Join(filepath.Dir(alone), "info.json"),  `{ "title":"Downloaded", "release_year":2000, "description":"Downloaded plot." }`)
+  testSidecarWrite(test, filepath.Join(filepath.Dir(alone), "folder.jpg"), "")
+  testSidecarWrite(test, filepath.Join(filepath.Dir(alone), "Movie (1999).en.srt"), "")
+  sidecar := importSidecars(alone)
+  if (sidecar.Title != "Movie") || (sidecar.Year != 1999) { test.Fatalf("TestSidecarDirectory: nfo should take precedence over info.json: %v", sidecar) }
+  if sidecar.Plot != "Downloaded plot." { test.Fatalf("TestSidecarDirectory: info.json should fill fields nfo lacks: %v", sidecar) }
+  if sidecar.PosterPath != filepath.Join(filepath.Dir(alone), "folder.jpg") { test.Fatalf("TestSidecarDirectory: folder.jpg not used: %v", sidecar) }
+  if (len(sidecar.Sources) < 3) || (sidecar.Sources[0] != "nfo") || (sidecar.Sources[1] != "info.json") { test.Fatalf("TestSidecarDirectory: unexpected sources: %v", sidecar.Sources) }
+
+  // sources sharing a directory only get their own (basename-matched) files
+  shared := filepath.Join(test.TempDir(), "Episode 1.mkv")
+  testSidecarWrite(test, shared, "")
+  testSidecarWrite(test, filepath.Join(filepath.Dir(shared), "Episode 2.mkv"), "")
+  testSidecarWrite(test, filepath.Join(filepath.Dir(shared), "movie.nfo"),     "<movie><title>Wrong</title></movie>")
+  testSidecarWrite(test, filepath.Join(filepath.Dir(shared), "info.json"),     `{ "title":"Wrong" }`)
+  testSidecarWrite(test, filepath.Join(filepath.Dir(shared), "cover.png"),     "")
+  for _, importer := range []sidecarImporter { &nfoImporter {}, &infoJsonImporter {}, &artworkImporter {} } {
+    imported, err := importer.Import(shared)
+    if (err != nil) || (imported != nil) { test.Fatalf("TestSidecarDirectory: %s imported directory-level file for shared directory: %v, %s", importer.Name(), imported, err) }
+  }
+  testSidecarWrite(test, filepath.Join(filepath.Dir(shared), "Episode 1-poster.png"), "")
+  imported, err := (&artworkImporter {}).Import(shared)
+  if (err != nil) || (imported == nil) || (imported.PosterPath != filepath.Join(filepath.Dir(shared), "Episode 1-poster.png")) { test.Fatalf("TestSidecarDirectory: basename poster not found: %v, %s", imported, err) }
+}
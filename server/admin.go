@@ -2,6 +2,8 @@ package main
 
 import (
   "strings"
+  "strconv"
+  "time"
   "image"
   _ "image/jpeg"
   _ "image/png"
@@ -11,93 +13,233 @@ import (
   "net/http"
 )
 
-func startupAdminRoutes(server *echo.Echo) {
-  server.GET   ("/admin/properties",   adminPropertiesRead)
+func startupAdminRoutes(server *echo.Group) {
+  server.GET   ("/admin/properties",   adminPropertiesRead  )
   server.POST  ("/admin/properties",   adminPropertiesUpdate)
-
-  server.GET   ("/admin/categories",   adminCategoryList  )
-  server.POST  ("/admin/category",     adminCategoryCreate)
-  server.POST  ("/admin/category/:id", adminCategoryUpdate)
-  server.DELETE("/admin/category/:id", adminCategoryDelete)
-
-  server.GET   ("/admin/metadata/tree",                 adminMetadataTree        )
-  server.GET   ("/admin/metadata/by-parent/:parent_id", adminMetadataByParentList)
-  server.POST  ("/admin/metadata",                      adminMetadataCreate      )
-  server.DELETE("/admin/metadata/:id",                  adminMetadataDelete      )
-  server.POST  ("/admin/metadata/:id",                  adminMetadataUpdate      )
-  server.POST  ("/admin/metadata/:id/poster",           adminMetadataPoster      )
-
-  server.GET   ("/admin/input-files",             adminInputFileList    )
-  server.DELETE("/admin/input-file/:id",          adminInputFileDelete  )
-  server.POST  ("/admin/input-file/:id/map",      adminInputFileMap     )
-  server.POST  ("/admin/input-file/:id/reset",    adminInputFileReset   )
+  server.GET   ("/admin/journal",      adminJournalList     )
+  server.GET   ("/admin/check",        adminCheck           )
+  server.POST  ("/admin/check",        adminCheckRepair     )
+  server.GET   ("/admin/volumes",      adminVolumeList      )
+  server.POST  ("/admin/volume",       adminVolumeCreate    )
+  server.DELETE("/admin/volume/:id",   adminVolumeDelete    )
+  server.GET   ("/admin/backups",      adminBackupList      )
+  server.POST  ("/admin/backups",      adminBackupCreate    )
+  server.GET   ("/admin/backup/:name", adminBackupDownload  )
+  server.GET   ("/admin/export",       adminExport          )
+
+  server.GET   ("/admin/categories",                 adminCategoryList         )
+  server.POST  ("/admin/category",                   adminCategoryCreate       )
+  server.POST  ("/admin/category/:id",               adminCategoryUpdate       )
+  server.DELETE("/admin/category/:id",               adminCategoryDelete       )
+  server.POST  ("/admin/category/:id/artwork/:type", adminCategoryArtworkSet   )
+  server.DELETE("/admin/category/:id/artwork/:type", adminCategoryArtworkDelete)
+  server.POST  ("/admin/category/:id/volume",        adminCategoryVolumeSet    )
+
+  server.GET   ("/admin/metadata/tree",                  adminMetadataTree            )
+  server.GET   ("/admin/metadata/by-parent/:parent_id",  adminMetadataByParentList    )
+  server.POST  ("/admin/metadata",                       adminMetadataCreate          )
+  server.DELETE("/admin/metadata/:id",                   adminMetadataDelete          )
+  server.POST  ("/admin/metadata/:id",                   adminMetadataUpdate          )
+  server.POST  ("/admin/metadata/:id/poster",            adminMetadataPoster          )
+  server.POST  ("/admin/metadata/:id/poster/regenerate", adminMetadataPosterRegenerate)
+  server.POST  ("/admin/metadata/:id/chapters",          adminMetadataChapters        )
+  server.POST  ("/admin/metadata/:id/artwork/:type",     adminMetadataArtworkSet      )
+  server.DELETE("/admin/metadata/:id/artwork/:type",     adminMetadataArtworkDelete   )
+  server.GET   ("/admin/metadata/:id/identify",          adminMetadataIdentifySearch  )
+  server.POST  ("/admin/metadata/:id/identify",          adminMetadataIdentifyApply   )
+  server.GET   ("/admin/providers",                      adminProviderList            )
+
+  server.GET   ("/admin/input-files",             adminInputFileList       )
+  server.DELETE("/admin/input-file/:id",          adminInputFileDelete     )
+  server.POST  ("/admin/input-file/:id/map",      adminInputFileMap        )
+  server.POST  ("/admin/input-file/:id/reset",    adminInputFileReset      )
+  server.POST  ("/admin/input-file/:id/video",    adminInputFileVideo      )
+  server.POST  ("/admin/input-file/:id/trim",     adminInputFileTrim       )
+  server.GET   ("/admin/input-file/:id/group",    adminInputFileGroupGet   )
+  server.POST  ("/admin/input-file/:id/group",    adminInputFileGroupSet   )
+  server.POST  ("/admin/input-file/:id/category", adminInputFileCategorySet)
 }
 
 // ============================================================================
 // Properties
 
 func adminPropertiesRead(context echo.Context) error {
-  properties, err := library.PropertyList()
+  lib := contextLibrary(context)
+  properties, err := lib.PropertyList()
   if err != nil { return debug500(context, err) }
   return json200(context, properties)
 }
 func adminPropertiesUpdate(context echo.Context) error {
+  lib := contextLibrary(context)
   updates := map[string]string{}
   if err := context.Bind(&updates); err != nil { return json400(context, err) }
 
   for key, value := range updates {
-    err := library.PropertySet(key, value)
+    err := lib.PropertySet(key, value)
     if err == library.ErrQueryFailed { return debug500(context, err) }
     if err != nil { return json400(context, err) }
   }
   return json200(context, map[string]string{})
 }
 
+// ============================================================================
+// Journal
+
+// Interrupted file operations; normally empty (resolved at startup), except for entries that need manual attention.
+func adminJournalList(context echo.Context) error {
+  lib := contextLibrary(context)
+  entries, err := lib.JournalList()
+  if err != nil { return debug500(context, err) }
+  return json200(context, entries)
+}
+
+// ============================================================================
+// Consistency Check
+
+func adminCheck(context echo.Context) error {
+  lib := contextLibrary(context)
+  report, err := lib.Check(false)
+  if err != nil { return debug500(context, err) }
+  return json200(context, report)
+}
+func adminCheckRepair(context echo.Context) error {
+  lib := contextLibrary(context)
+  report, err := lib.Check(true)
+  if err != nil { return debug500(context, err) }
+  return json200(context, report)
+}
+
+// ============================================================================
+// Backups
+
+func adminBackupList(context echo.Context) error {
+  lib := contextLibrary(context)
+  backups, err := lib.BackupList()
+  if err != nil { return debug500(context, err) }
+  return json200(context, backups)
+}
+
+func adminBackupCreate(context echo.Context) error {
+  lib := contextLibrary(context)
+  backup, err := lib.BackupCreate()
+  if err != nil { return debug500(context, err) }
+  return context.JSON(http.StatusCreated, backup)
+}
+
+func adminBackupDownload(context echo.Context) error {
+  lib := contextLibrary(context)
+  backup, err := lib.BackupRead(context.Param("name"))
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+  return context.Attachment(backup.Path, backup.Name)
+}
+
+// ============================================================================
+// Export
+
+// Portable archive of library records & artwork (no media), streamed as it's written.
+func adminExport(context echo.Context) error {
+  lib := contextLibrary(context)
+  err := lib.Ready()
+  if err != nil { return debug500(context, err) }
+  file_name := contextLibraryName(context) + "-" + time.Now().Format("20060102-150405") + ".tar.gz"
+  context.Response().Header().Set(echo.HeaderContentType, "application/gzip")
+  context.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\"" + file_name + "\"")
+  context.Response().WriteHeader(http.StatusOK)
+  _, err = lib.ArchiveExport(context.Response())
+  if err != nil { context.Logger().Errorf("export of library \"%s\" failed: %s", contextLibraryName(context), err.Error()) }
+  return nil
+}
+
+// ============================================================================
+// Volumes
+
+func adminVolumeList(context echo.Context) error {
+  lib := contextLibrary(context)
+  volumes, err := lib.VolumeList()
+  if err != nil { return debug500(context, err) }
+  return json200(context, volumes)
+}
+
+func adminVolumeCreate(context echo.Context) error {
+  lib := contextLibrary(context)
+  volume := library.Volume{}
+  if err := context.Bind(&volume); err != nil { return json400(context, err) }
+  result, err := lib.VolumeCreate(volume.Name, volume.Path)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+  return context.JSON(http.StatusCreated, result)
+}
+
+func adminVolumeDelete(context echo.Context) error {
+  lib := contextLibrary(context)
+  volume, err := lib.VolumeRead(context.Param("id"))
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  err = lib.VolumeDelete(volume)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+  return json200(context, map[string]string{})
+}
+
 // ============================================================================
 // Category
 
 func adminCategoryList(context echo.Context) error {
-  categories, err := library.CategoryList()
+  lib := contextLibrary(context)
+  categories, err := lib.CategoryList()
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
   return json200(context, categories)
 }
 
 func adminCategoryCreate(context echo.Context) error {
+  lib := contextLibrary(context)
   category := library.Category{}
   if err := context.Bind(&category); err != nil { return json400(context, err) }
   category.Id = ""
-  result, err := library.CategoryCreate(category.Name, category.MediaType)
+  result, err := lib.CategoryCreate(category.Name, category.MediaType)
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
   return context.JSON(http.StatusCreated, result)
 }
 
 type CategoryUpdateRequest struct {
-  Name       string `json:"name"`
-  MediaType  string `json:"media_type"`
-  SortIndex  int64  `json:"sort_index"`
+  Name        string `json:"name"`
+  MediaType   string `json:"media_type"`
+  SortIndex   int64  `json:"sort_index"`
+  AudioFormat string `json:"audio_format"`
 }
 func adminCategoryUpdate(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  original, err := library.CategoryRead(id)
+  original, err := lib.CategoryRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
 
   changes := CategoryUpdateRequest{}
   if err = context.Bind(&changes); err != nil { return json400(context, err) }
   changes.Name      = strings.TrimSpace(changes.Name)
-  if changes.Name       == "" { changes.Name      = original.Name              }
-  if changes.MediaType  == "" { changes.MediaType = string(original.MediaType) }
+  if changes.Name        == "" { changes.Name        = original.Name                }
+  if changes.MediaType   == "" { changes.MediaType   = string(original.MediaType)   }
+  if changes.AudioFormat == "" { changes.AudioFormat = string(original.AudioFormat) }
 
   if (changes.Name != original.Name) || (changes.MediaType != string(original.MediaType)) {
-    err = library.CategoryUpdate(original, changes.Name, changes.MediaType)
+    err = lib.CategoryUpdate(original, changes.Name, changes.MediaType)
     if err == library.ErrQueryFailed { return debug500(context, err) }
     if err != nil { return json400(context, err) }
+    resetArtworkCache(contextLibraryName(context), id)
   }
 
   if changes.SortIndex != original.SortIndex {
-    err = library.CategoryReindex(original, changes.SortIndex)
+    err = lib.CategoryReindex(original, changes.SortIndex)
+    if err == library.ErrQueryFailed { return debug500(context, err) }
+    if err != nil { return json400(context, err) }
+  }
+
+  if changes.AudioFormat != string(original.AudioFormat) {
+    err = lib.CategorySetAudioFormat(original, library.AudioFormat(changes.AudioFormat))
     if err == library.ErrQueryFailed { return debug500(context, err) }
     if err != nil { return json400(context, err) }
   }
@@ -105,15 +247,69 @@ func adminCategoryUpdate(context echo.Context) error {
 }
 
 func adminCategoryDelete(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  category, err := library.CategoryRead(id)
+  category, err := lib.CategoryRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
 
-  err = library.CategoryDelete(category)
+  err = lib.CategoryDelete(category)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, map[string]string{})
+}
+
+func adminCategoryArtworkSet(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  category, err := lib.CategoryRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  img, err := adminFormImage(context, "artwork")
+  if err != nil { return json400(context, err) }
+
+  err = category.SetArtwork(library.ArtworkType(context.Param("type")), img)
+  if err != nil { return json400(context, err) }
+
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, map[string]string{})
+}
+
+type CategoryVolumeRequest struct {
+  VolumeId string `json:"volume_id"`
+}
+// Move a category between volumes; copies, verifies, then deletes when volumes are on separate disks (may take a while).
+func adminCategoryVolumeSet(context echo.Context) error {
+  lib := contextLibrary(context)
+  category, err := lib.CategoryRead(context.Param("id"))
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  request := CategoryVolumeRequest{}
+  if err = context.Bind(&request); err != nil { return json400(context, err) }
+  err = lib.CategoryMoveVolume(category, request.VolumeId)
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
+
+  poster_cache.Purge() // everything in the category has moved
+  return json200(context, category)
+}
+
+func adminCategoryArtworkDelete(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  category, err := lib.CategoryRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  err = category.DeleteArtwork(library.ArtworkType(context.Param("type")))
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return json400(context, err) }
+
+  resetArtworkCache(contextLibraryName(context), id)
   return json200(context, map[string]string{})
 }
 
@@ -121,19 +317,20 @@ func adminCategoryDelete(context echo.Context) error {
 // Metadata
 
 func adminMetadataTree(context echo.Context) error {
+  lib := contextLibrary(context)
   var err error
   lost_items := library.MetadataTreeNode{Id: "lost", Name: "Lost Items", MediaType: ""}
-  lost_items.Children, err = library.MetadataParentTree("")
+  lost_items.Children, err = lib.MetadataParentTree("")
   if err != nil { return debug500(context, err) }
 
-  categories, err := library.CategoryList()
+  categories, err := lib.CategoryList()
   if err != nil { return debug500(context, err) }
 
   root_items := make([]*library.MetadataTreeNode, len(categories)+1)
   root_items[0] = &lost_items
   for index, cat := range categories {
     cat_tree := library.MetadataTreeNode{Id: cat.Id, Name: cat.Name, MediaType: string(cat.MediaType)}
-    cat_tree.Children, err = library.MetadataParentTree(cat.Id)
+    cat_tree.Children, err = lib.MetadataParentTree(cat.Id)
     if err != nil { return debug500(context, err) }
     root_items[index+1] = &cat_tree
   }
@@ -142,25 +339,28 @@ func adminMetadataTree(context echo.Context) error {
 }
 
 func adminMetadataByParentList(context echo.Context) error {
+  lib := contextLibrary(context)
   parent_id := context.Param("parent_id")
   if parent_id == "lost" { parent_id = "" }
-  metadata_list, err := library.MetadataForParent(parent_id)
+  metadata_list, err := lib.MetadataForParent(parent_id)
   if err != nil { return debug500(context, err) }
   return json200(context, metadata_list)
 }
 
 func adminMetadataCreate(context echo.Context) error {
+  lib := contextLibrary(context)
   metadata := library.Metadata{}
   if err := context.Bind(&metadata); err != nil { return json400(context, err) }
-  err := library.MetadataCreate(&metadata)
+  err := lib.MetadataCreate(&metadata)
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
   return context.JSON(http.StatusCreated, metadata)
 }
 
 func adminMetadataUpdate(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  original, err := library.MetadataRead(id)
+  original, err := lib.MetadataRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
 
@@ -171,61 +371,216 @@ func adminMetadataUpdate(context echo.Context) error {
   var new_parent       string = "" ; new_parent,       ok = changes["parent_id"]    ; if !ok { new_parent       = original.ParentId    }
   var new_name_display string = "" ; new_name_display, ok = changes["name_display"] ; if !ok { new_name_display = original.NameDisplay }
   var new_name_sort    string = "" ; new_name_sort,    ok = changes["name_sort"]    ; if !ok { new_name_sort    = original.NameSort    }
+  var new_plot         string = "" ; new_plot,         ok = changes["plot"]         ; if !ok { new_plot         = original.Plot        }
+  new_year,         err := adminChangeInt64(changes, "year",         original.Year       ) ; if err != nil { return json400(context, err) }
+  new_track_number, err := adminChangeInt64(changes, "track_number", original.TrackNumber) ; if err != nil { return json400(context, err) }
+  new_disc_number,  err := adminChangeInt64(changes, "disc_number",  original.DiscNumber ) ; if err != nil { return json400(context, err) }
+  tags_changed := false
 
   if new_parent != original.ParentId {
     err = original.Reparent(new_parent)
     if err == library.ErrQueryFailed { return debug500(context, err) }
     if err != nil { return json400(context, err) }
+    tags_changed = true
   }
   if (new_name_display != original.NameDisplay) || (new_name_sort != original.NameSort) {
     err = original.Rename(new_name_display, new_name_sort)
     if err == library.ErrQueryFailed { return debug500(context, err) }
     if err != nil { return json400(context, err) }
+    tags_changed = true
+  }
+  if (new_year != original.Year) || (new_track_number != original.TrackNumber) || (new_disc_number != original.DiscNumber) || (new_plot != original.Plot) {
+    err = original.SetDetails(new_year, new_track_number, new_disc_number, new_plot)
+    if err == library.ErrQueryFailed { return debug500(context, err) }
+    if err != nil { return json400(context, err) }
+    tags_changed = true
   }
 
-  resetMetadataPosterCache(id)
-  return json200(context, map[string]string{})
+  // audio files (of this item, or beneath a renamed album/artist) carry names & numbers in their tags;
+  // the update itself has already been saved, so a tagging failure is only a warning
+  response := map[string]string{}
+  if tags_changed {
+    err = original.WriteTagsRecursive()
+    if err != nil { response["warning"] = "unable to write tags: " + err.Error() }
+  }
+
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, response)
+}
+
+// Integer value from an update request, or default if not present.
+func adminChangeInt64(changes map[string]string, key string, default_value int64) (int64, error) {
+  value, ok := changes[key]
+  if !ok { return default_value, nil }
+  return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
 }
 
 func adminMetadataPoster(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  md, err := library.MetadataRead(id)
+  md, err := lib.MetadataRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
 
-  file_header, err := context.FormFile("poster")
+  img, err := adminFormImage(context, "poster")
   if err != nil { return json400(context, err) }
 
-  file_handle, err := file_header.Open()
+  err = md.SetPoster(img)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+
+  // embedded cover art, of this item or those beneath (poster is already saved; a tagging failure is only a warning)
+  response := map[string]string{}
+  err = md.WriteTagsRecursive()
+  if err != nil { response["warning"] = "unable to write tags: " + err.Error() }
+
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, response)
+}
+
+func adminMetadataArtworkSet(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
+
+  img, err := adminFormImage(context, "artwork")
+  if err != nil { return json400(context, err) }
+
+  err = md.SetArtwork(library.ArtworkType(context.Param("type")), img)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, map[string]string{})
+}
+
+func adminMetadataArtworkDelete(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  err = md.DeleteArtwork(library.ArtworkType(context.Param("type")))
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return json400(context, err) }
+
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, map[string]string{})
+}
+
+// Decode an uploaded image (jpeg, png, or webp) from a multipart form field.
+func adminFormImage(context echo.Context, field string) (image.Image, error) {
+  file_header, err := context.FormFile(field)
+  if err != nil { return nil, err }
+
+  file_handle, err := file_header.Open()
+  if err != nil { return nil, err }
   defer file_handle.Close()
 
   img, _, err := image.Decode(file_handle)
+  return img, err
+}
+
+func adminMetadataPosterRegenerate(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  err = md.PosterGenerate()
+  if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
 
-  err = md.SetPoster(img)
+  // embedded cover art, of this item or those beneath (poster is already saved; a tagging failure is only a warning)
+  response := map[string]string{}
+  err = md.WriteTagsRecursive()
+  if err != nil { response["warning"] = "unable to write tags: " + err.Error() }
+
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, response)
+}
+
+func adminMetadataChapters(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  chapters := []library.MetadataChapter{}
+  if err = context.Bind(&chapters); err != nil { return json400(context, err) }
+
+  err = md.SetChapters(chapters)
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
+  return json200(context, md)
+}
 
-  resetMetadataPosterCache(id)
-  return json200(context, map[string]string{})
+// Candidate matches from all providers; "title" & "year" query parameters override the record's own.
+func adminMetadataIdentifySearch(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  year := int64(0)
+  if context.QueryParam("year") != "" {
+    year, err = strconv.ParseInt(context.QueryParam("year"), 10, 64)
+    if err != nil { return json400(context, err) }
+  }
+
+  candidates, err := md.IdentifyCandidates(strings.TrimSpace(context.QueryParam("title")), year)
+  if err != nil { return debug500(context, err) }
+  return json200(context, candidates)
+}
+
+type MetadataIdentifyRequest struct {
+  Provider   string `json:"provider"`
+  ExternalId string `json:"external_id"`
+}
+func adminMetadataIdentifyApply(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  request := MetadataIdentifyRequest{}
+  if err = context.Bind(&request); err != nil { return json400(context, err) }
+
+  err = md.IdentifyApply(request.Provider, request.ExternalId)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+
+  resetArtworkCache(contextLibraryName(context), id)
+  return json200(context, md)
+}
+
+func adminProviderList(context echo.Context) error {
+  return json200(context, library.ProviderList())
 }
 
 type MetadataDeleteRequest struct {
   DeleteChildren bool `json:"delete_children"`
 }
 func adminMetadataDelete(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  md, err := library.MetadataRead(id)
+  md, err := lib.MetadataRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
   request := MetadataDeleteRequest{}
   if err := context.Bind(&request); err != nil { return json400(context, err) }
 
-  err = library.MetadataDelete(md, request.DeleteChildren)
+  err = lib.MetadataDelete(md, request.DeleteChildren)
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
-  resetMetadataPosterCache(id)
+  resetArtworkCache(contextLibraryName(context), id)
   return json200(context, map[string]string{})
 }
 
@@ -233,26 +588,29 @@ func adminMetadataDelete(context echo.Context) error {
 // InputFile
 
 func adminInputFileList(context echo.Context) error {
-  input_files, err := library.InputFileList()
+  lib := contextLibrary(context)
+  input_files, err := lib.InputFileList()
   if err != nil { return debug500(context, err) }
   return json200(context, input_files)
 }
 
 func adminInputFileDelete(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  inp, err := library.InputFileRead(id)
+  inp, err := lib.InputFileRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
 
-  err = library.InputFileDelete(inp)
+  err = lib.InputFileDelete(inp)
   if err == library.ErrQueryFailed { return debug500(context, err) }
   if err != nil { return json400(context, err) }
   return json200(context, map[string]string{})
 }
 
 func adminInputFileMap(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  inp, err := library.InputFileRead(id)
+  inp, err := lib.InputFileRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
 
@@ -266,8 +624,9 @@ func adminInputFileMap(context echo.Context) error {
 }
 
 func adminInputFileReset(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  inp, err := library.InputFileRead(id)
+  inp, err := lib.InputFileRead(id)
   if err == library.ErrNotFound { return json404(context) }
   if err != nil { return debug500(context, err) }
 
@@ -276,3 +635,94 @@ func adminInputFileReset(context echo.Context) error {
   if err != nil { return json400(context, err) }
   return json200(context, map[string]string{})
 }
+
+type InputFileVideoRequest struct {
+  DeinterlaceMode string `json:"deinterlace_mode"`
+  CropMode        string `json:"crop_mode"`
+}
+func adminInputFileVideo(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  inp, err := lib.InputFileRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  request := InputFileVideoRequest{}
+  if err = context.Bind(&request); err != nil { return json400(context, err) }
+
+  err = inp.SetVideoOverrides(strings.TrimSpace(request.DeinterlaceMode), strings.TrimSpace(request.CropMode))
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+  return json200(context, inp)
+}
+
+type InputFileTrimRequest struct {
+  TrimStart int64 `json:"trim_start"`
+  TrimEnd   int64 `json:"trim_end"`
+}
+func adminInputFileTrim(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  inp, err := lib.InputFileRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  request := InputFileTrimRequest{}
+  if err = context.Bind(&request); err != nil { return json400(context, err) }
+
+  err = inp.TrimSet(request.TrimStart, request.TrimEnd)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+  return json200(context, inp)
+}
+
+type InputFileCategoryRequest struct {
+  CategoryId string `json:"category_id"`
+}
+func adminInputFileCategorySet(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  inp, err := lib.InputFileRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  request := InputFileCategoryRequest{}
+  if err = context.Bind(&request); err != nil { return json400(context, err) }
+
+  err = inp.SetCategory(request.CategoryId)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+  return json200(context, inp)
+}
+
+func adminInputFileGroupGet(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  inp, err := lib.InputFileRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+  if inp.GroupId == "" { return json200(context, []library.InputFile { *inp }) }
+
+  members, err := lib.InputFileGroupMembers(inp.GroupId)
+  if err != nil { return debug500(context, err) }
+  return json200(context, members)
+}
+
+// Body is ordered list of input file ids to join after this one (empty list to ungroup).
+func adminInputFileGroupSet(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  inp, err := lib.InputFileRead(id)
+  if err == library.ErrNotFound { return json404(context) }
+  if err != nil { return debug500(context, err) }
+
+  part_ids := []string{}
+  if err = context.Bind(&part_ids); err != nil { return json400(context, err) }
+
+  err = inp.GroupSet(part_ids)
+  if err == library.ErrQueryFailed { return debug500(context, err) }
+  if err != nil { return json400(context, err) }
+
+  name_display, name_sort, _ := inp.OutputNames()
+  return json200(context, map[string]string { "name_display":name_display, "name_sort":name_sort })
+}
@@ -9,32 +9,43 @@ import (
   "github.com/daumiller/starkiss/library"
 )
 
-func startupClientRoutes(server *echo.Echo) {
+func startupClientRoutes(server *echo.Group) {
   server.GET("/client/ping",        clientServePing)
   server.GET("/client/categories",  clientServeCategories)
   server.GET("/client/listing/:id", clientServeListing)
+  server.GET("/client/item/:id",    clientServeItem)
+}
+
+type ClientPing struct {
+  Message   string   `json:"message"`
+  Library   string   `json:"library"`   // library this request was routed to
+  Libraries []string `json:"libraries"` // all libraries; each served under "/l/:library/..." (default also without prefix)
+  Default   string   `json:"default"`
 }
 
 func clientServePing(context echo.Context) error {
   //context.Response().Header().Set(echo.HeaderAccessControlAllowOrigin, "*")
-  return context.JSON(200, map[string]string{"message": "pong"})
+  ping := ClientPing { Message:"pong", Library:contextLibraryName(context), Libraries:library_names, Default:LIBRARY_DEFAULT }
+  return context.JSON(200, ping)
 }
 
 func clientServeCategories(context echo.Context) error {
+  lib := contextLibrary(context)
   //context.Response().Header().Set(echo.HeaderAccessControlAllowOrigin, "*")
-  categories, err := library.CategoryList()
+  categories, err := lib.CategoryList()
   if err != nil { return debug500(context, err) }
   return context.JSON(200, categories)
 }
 
 func clientServeListing(context echo.Context) error {
+  lib := contextLibrary(context)
   //context.Response().Header().Set(echo.HeaderAccessControlAllowOrigin, "*")
   id := context.Param("id")
-  cat, err := library.CategoryRead(id)
+  cat, err := lib.CategoryRead(id)
   if (err != nil) && (err != library.ErrNotFound) { return debug500(context, err) }
   if err == nil { return clientServeListing_Category(context, cat) }
 
-  md, err := library.MetadataRead(id)
+  md, err := lib.MetadataRead(id)
   if (err != nil) && (err != library.ErrNotFound) { return debug500(context, err) }
   if err == nil { return clientServeListing_Metadata(context, md) }
 
@@ -58,9 +69,11 @@ const (
   ClientListingTypeInvalid  ClientListingType = "invalid"
 )
 type ClientListingEntry struct {
-  Id        string `json:"id"`
-  Name      string `json:"name"`
-  EntryType string `json:"entry_type"`
+  Id             string `json:"id"`
+  Name           string `json:"name"`
+  EntryType      string `json:"entry_type"`
+  PosterBlurhash string `json:"poster_blurhash"` // "" if no poster
+  PosterColor    string `json:"poster_color"`    // "#rrggbb", or "" if no poster
 }
 type ClientListing struct {
   Id           string                       `json:"id"`
@@ -74,7 +87,8 @@ type ClientListing struct {
 }
 
 func clientServeListing_Category(context echo.Context, cat *library.Category) error {
-  metadata, err := library.MetadataForParent(cat.Id)
+  lib := contextLibrary(context)
+  metadata, err := lib.MetadataForParent(cat.Id)
   if err != nil { return debug500(context, err) }
 
   var listing ClientListing
@@ -105,19 +119,22 @@ func clientServeListing_Category(context echo.Context, cat *library.Category) er
   slices.SortFunc(md_ptr, sort_compare)
 
   for index, md := range md_ptr {
-    listing.Entries[index].Id        = md.Id
-    listing.Entries[index].Name      = md.NameDisplay
-    listing.Entries[index].EntryType = string(md.MediaType)
+    listing.Entries[index].Id             = md.Id
+    listing.Entries[index].Name           = md.NameDisplay
+    listing.Entries[index].EntryType      = string(md.MediaType)
+    listing.Entries[index].PosterBlurhash = md.PosterBlurhash
+    listing.Entries[index].PosterColor    = md.PosterColor
   }
 
   return context.JSON(200, listing)
 }
 
 func clientServeListing_Metadata(context echo.Context, md *library.Metadata) error {
-  children, err := library.MetadataForParent(md.Id)
+  lib := contextLibrary(context)
+  children, err := lib.MetadataForParent(md.Id)
   if err != nil { return debug500(context, err) }
 
-  path, err := library.PathForId(md.Id)
+  path, err := lib.PathForId(md.Id)
   if err != nil { return debug500(context, err) }
 
   var listing ClientListing
@@ -167,10 +184,69 @@ func clientServeListing_Metadata(context echo.Context, md *library.Metadata) err
   slices.SortFunc(md_ptr, sort_compare)
 
   for index, md := range md_ptr {
-    listing.Entries[index].Id        = md.Id
-    listing.Entries[index].Name      = md.NameDisplay
-    listing.Entries[index].EntryType = string(md.MediaType)
+    listing.Entries[index].Id             = md.Id
+    listing.Entries[index].Name           = md.NameDisplay
+    listing.Entries[index].EntryType      = string(md.MediaType)
+    listing.Entries[index].PosterBlurhash = md.PosterBlurhash
+    listing.Entries[index].PosterColor    = md.PosterColor
   }
 
   return context.JSON(200, listing)
 }
+
+type ClientItemIntro struct {
+  Start int64 `json:"start"` // milliseconds
+  End   int64 `json:"end"`   // milliseconds
+}
+type ClientItem struct {
+  Id          string                    `json:"id"`
+  Name        string                    `json:"name"`
+  ParentId    string                    `json:"parent_id"`
+  Path        []library.PathComponent   `json:"path"`
+  EntryType   string                    `json:"entry_type"`
+  Duration    int64                     `json:"duration"`
+  Size        int64                     `json:"size"`
+  Year        int64                     `json:"year"`         // 0 if unknown
+  TrackNumber int64                     `json:"track_number"` // 0 if unknown
+  DiscNumber  int64                     `json:"disc_number"`  // 0 if unknown
+  Plot        string                    `json:"plot"`
+  Chapters    []library.MetadataChapter `json:"chapters"`
+  Intro       *ClientItemIntro          `json:"intro"` // null if no intro chapter
+}
+
+func clientServeItem(context echo.Context) error {
+  lib := contextLibrary(context)
+  //context.Response().Header().Set(echo.HeaderAccessControlAllowOrigin, "*")
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return context.NoContent(404) }
+  if err != nil { return debug500(context, err) }
+
+  path, err := lib.PathForId(md.Id)
+  if err != nil { return debug500(context, err) }
+
+  var item ClientItem
+  item.Id          = md.Id
+  item.Name        = md.NameDisplay
+  item.ParentId    = md.ParentId
+  item.Path        = path
+  item.EntryType   = string(md.MediaType)
+  item.Duration    = md.Duration
+  item.Size        = md.Size
+  item.Year        = md.Year
+  item.TrackNumber = md.TrackNumber
+  item.DiscNumber  = md.DiscNumber
+  item.Plot        = md.Plot
+  item.Chapters    = md.Chapters
+  item.Intro       = nil
+  if item.Chapters == nil { item.Chapters = []library.MetadataChapter {} }
+
+  for index := range item.Chapters {
+    if item.Chapters[index].IsIntro() {
+      item.Intro = &ClientItemIntro { Start:item.Chapters[index].Start, End:item.Chapters[index].End }
+      break
+    }
+  }
+
+  return context.JSON(200, item)
+}
@@ -3,52 +3,240 @@ package main
 import (
   "os"
   "fmt"
+  "regexp"
+  "strings"
+  "time"
   "strconv"
+  "path/filepath"
   "github.com/chzyer/readline"
+  "github.com/labstack/echo/v4"
   "github.com/daumiller/starkiss/library"
 )
 
+// ============================================================================
+// Libraries
+
+const LIBRARY_DEFAULT string = "default" // name of library opened from DBFILE; served by routes without a "/l/:library" prefix
+
+var libraries     map[string]*library.Library = map[string]*library.Library {}
+var library_names []string                    = []string {} // in configured order (default first)
+
+var libraryNameValid *regexp.Regexp = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Open the default library (DBFILE), and any named in LIBRARIES ("name=dbfile,name=dbfile").
+func startupLibraries() error {
+  err := library.LibraryStartup(DBFILE)
+  if err != nil { return err }
+  libraries[LIBRARY_DEFAULT] = library.LibraryDefault()
+  library_names = append(library_names, LIBRARY_DEFAULT)
+
+  for _, entry := range strings.Split(LIBRARIES, ",") {
+    entry = strings.TrimSpace(entry)
+    if entry == "" { continue }
+    name, database_path, found := strings.Cut(entry, "=")
+    name = strings.TrimSpace(name)
+    if !found || !libraryNameValid.MatchString(name) { return fmt.Errorf("invalid library \"%s\" (expected name=dbfile, name of a-z, 0-9, _, -)", entry) }
+    if libraries[name] != nil { return fmt.Errorf("library \"%s\" defined more than once", name) }
+    lib, err := library.LibraryOpen(filepath.Clean(strings.TrimSpace(database_path)))
+    if err != nil { return fmt.Errorf("library \"%s\": %s", name, err.Error()) }
+    libraries[name] = lib
+    library_names = append(library_names, name)
+  }
+  return nil
+}
+
+func shutdownLibraries() {
+  for _, lib := range libraries { lib.Shutdown() }
+}
+
+// Library a request was routed to ("/l/:library/...", or default).
+func contextLibraryName(context echo.Context) string {
+  name := context.Param("library")
+  if name == "" { return LIBRARY_DEFAULT }
+  return name
+}
+func contextLibrary(context echo.Context) *library.Library {
+  return libraries[contextLibraryName(context)]
+}
+
+// Reject requests for libraries that don't exist.
+func libraryMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+  return func(context echo.Context) error {
+    if contextLibrary(context) == nil { return context.JSON(404, map[string]string{"error": "library not found"}) }
+    return next(context)
+  }
+}
+
+// ============================================================================
+// Startup
+
 // Perform database migration, either:
 // 1) if server started with "migration" command line argument, may specify level to migrate to (or "latest")
 // 2) automatically during server startup to "latest"
 // Returns 0 on success, -1 otherwise.
-func startupMigration(target string) (exit_code int) {
-  // Migrate database.
-  var err error = nil
-  if target == "latest" {
-    err = library.MigrateToLatest()
-  } else {
-    level := uint64(0)
+func startupMigration(name string, lib *library.Library, target string, dry_run bool) (exit_code int) {
+  level := uint64(library.MigrationLevelLatest())
+  if target != "latest" {
+    var err error
     level, err = strconv.ParseUint(target, 10, 32)
     if err != nil { fmt.Printf("Invalid migration level: \"%s\"\n", target); return -1 }
-    err = library.MigrateTo(uint32(level))
   }
+
+  // Report changes, against a copy of the database.
+  if dry_run {
+    result, err := lib.MigrateDryRun(uint32(level))
+    if err != nil { fmt.Printf("Migration dry run of library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+    fmt.Printf("Migrating library \"%s\" from %d to %d would make %d schema changes:\n", name, result.LevelFrom, result.LevelTo, len(result.Changes))
+    for _, change := range result.Changes {
+      fmt.Printf("  %s %s \"%s\"\n", change.Change, change.Type, change.Name)
+      if change.SqlBefore != "" { fmt.Printf("    before: %s\n", change.SqlBefore) }
+      if change.SqlAfter  != "" { fmt.Printf("    after:  %s\n", change.SqlAfter)  }
+    }
+    return 0
+  }
+
+  // Migrate database.
+  if lib.MigrationLevelGet() == uint32(level) { return 0 }
+  err := lib.MigrateTo(uint32(level))
   if err != nil {
-    fmt.Printf("Migration failed: \"%s\"\n", err.Error())
+    fmt.Printf("Migration of library \"%s\" failed: \"%s\"\n", name, err.Error())
     return -1
   }
 
-  fmt.Printf("Migrated database to \"%s\"...\n", target)
+  fmt.Printf("Migrated library \"%s\" to \"%s\"...\n", name, target)
   return 0
 }
 
+// Refuse databases newer than this program (or older, with SKIP_MIGRATE set); warn if schema differs from what was recorded when last migrated.
+// Returns 0 if usable, -1 otherwise.
+func startupMigrationVerify(name string, lib *library.Library) (exit_code int) {
+  err := lib.MigrationCheck()
+  if SKIP_MIGRATE { err = lib.MigrationCheckLatest() }
+  if err != nil { fmt.Printf("Cannot open library \"%s\": %s\n", name, err.Error()) ; return -1 }
+
+  level := lib.MigrationLevelGet()
+  history, err := lib.MigrationHistory()
+  if (err != nil) || (len(history) == 0) || (history[len(history) - 1].Level != level) { return 0 }
+  checksum, err := lib.SchemaChecksum()
+  if (err == nil) && (checksum != history[len(history) - 1].Checksum) {
+    fmt.Printf("Warning: library \"%s\" schema differs from migration level %d, as applied; it may have been changed outside of migrations.\n", name, level)
+  }
+  return 0
+}
+
+// Check library consistency (optionally repairing), printing issues found.
+// Returns 0 if no issues remain, -1 otherwise.
+func startupCheck(name string, lib *library.Library, repair bool) (exit_code int) {
+  report, err := lib.Check(repair)
+  if err != nil { fmt.Printf("Check of library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+
+  for _, issue := range report.Issues {
+    status := ""
+    if report.Repair && issue.Repaired  { status = " [repaired]" }
+    if report.Repair && !issue.Repaired { status = fmt.Sprintf(" [not repaired: %s]", issue.RepairError) }
+    location := issue.Path ; if location == "" { location = issue.RecordId }
+    fmt.Printf("%-17s %s: %s%s\n", issue.Type, location, issue.Detail, status)
+  }
+  fmt.Printf("Library \"%s\": %d issues found, %d unresolved.\n", name, len(report.Issues), report.Unresolved())
+  if report.Unresolved() > 0 { return -1 }
+  return 0
+}
+
+// Back up a library's database now.
+// Returns 0 on success, -1 otherwise.
+func startupBackup(name string, lib *library.Library) (exit_code int) {
+  backup, err := lib.BackupCreate()
+  if err != nil { fmt.Printf("Backup of library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+  fmt.Printf("Backed up library \"%s\" to \"%s\".\n", name, backup.Path)
+  return 0
+}
+
+// Replace a library's database with a backup file (after checking its integrity).
+// Returns 0 on success, -1 otherwise.
+func startupRestore(name string, lib *library.Library, backup_path string) (exit_code int) {
+  err := lib.BackupRestore(backup_path)
+  if err != nil { fmt.Printf("Restore of library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+  fmt.Printf("Restored library \"%s\" from \"%s\" (previous database kept with \".pre-restore\" suffix).\n", name, backup_path)
+  return 0
+}
+
+// Write a library's portable archive (records & artwork, no media) to a file.
+// Returns 0 on success, -1 otherwise.
+func startupExport(name string, lib *library.Library, archive_path string) (exit_code int) {
+  err := lib.Ready()
+  if err != nil { fmt.Printf("Export of library \"%s\" failed; not ready: \"%s\"\n", name, err.Error()) ; return -1 }
+  if _, err = os.Stat(archive_path); err == nil { fmt.Printf("Export of library \"%s\" failed: \"%s\" already exists\n", name, archive_path) ; return -1 }
+  file, err := os.Create(archive_path)
+  if err != nil { fmt.Printf("Export of library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+  manifest, err := lib.ArchiveExport(file)
+  if err == nil { err = file.Close() } else { file.Close() }
+  if err != nil { os.Remove(archive_path) ; fmt.Printf("Export of library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+  fmt.Printf("Exported library \"%s\" to \"%s\" (%d categories, %d metadata, %d input files).\n", name, archive_path, manifest.Categories, manifest.Metadata, manifest.InputFiles)
+  return 0
+}
+
+// Import a portable archive into an empty library (migrated to latest, and with a media path, first).
+// Returns 0 on success, -1 otherwise.
+func startupImport(name string, lib *library.Library, archive_path string, options library.ArchiveImportOptions) (exit_code int) {
+  exit_code = startupMigration(name, lib, "latest", false)
+  if exit_code != 0 { return exit_code }
+  startupMediaPath(name, lib)
+
+  file, err := os.Open(archive_path)
+  if err != nil { fmt.Printf("Import into library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+  defer file.Close()
+  manifest, err := lib.ArchiveImport(file, options)
+  if err != nil { fmt.Printf("Import into library \"%s\" failed: \"%s\"\n", name, err.Error()) ; return -1 }
+  fmt.Printf("Imported \"%s\" into library \"%s\" (%d categories, %d metadata, %d input files).\n", archive_path, name, manifest.Categories, manifest.Metadata, manifest.InputFiles)
+  fmt.Printf("Media files aren't included in archives; copy them into place, then run the \"check\" command.\n")
+  return 0
+}
+
+// Back up each library whenever due (per its backup_interval_hours property).
+func startupBackupSchedule() {
+  for _, name := range library_names {
+    go func(name string, lib *library.Library) {
+      for {
+        if lib.BackupDue() {
+          _, err := lib.BackupCreate()
+          if err != nil { fmt.Printf("Scheduled backup of library \"%s\" failed: %s\n", name, err.Error()) }
+        }
+        time.Sleep(time.Minute)
+      }
+    }(name, libraries[name])
+  }
+}
+
 // Read properties from database.
 func startupProperties() {
   var err error
   JWT_KEY, err = library.JwtKeyGet()
   if err != nil { fmt.Printf("JWT Error: %s\n", err.Error()) ; os.Exit(-1) }
 
-  // validate media_path
-  for library.MediaPathValid() == false {
-    media_path, _ := library.MediaPathGet()
+  // validate media_path, for each library
+  for _, name := range library_names { startupMediaPath(name, libraries[name]) }
+
+  // register a local fixture metadata provider, if configured
+  fixture_path, err := library.PropertyGet("provider_fixture_path")
+  if (err == nil) && (fixture_path != "") {
+    provider, err := library.NewFixtureProvider("fixture", fixture_path)
+    if err == nil { err = library.ProviderRegister(provider) }
+    if err != nil { fmt.Printf("Error loading fixture metadata provider from \"%s\": %s\n", fixture_path, err.Error()) }
+  }
+}
+
+// Validate a library's media path, prompting for one if not yet set.
+func startupMediaPath(name string, lib *library.Library) {
+  for lib.MediaPathValid() == false {
+    media_path, _ := lib.MediaPathGet()
     if media_path != "" {
-      fmt.Printf("Existing media library is set to \"%s\".\n", media_path)
+      fmt.Printf("Existing media library \"%s\" is set to \"%s\".\n", name, media_path)
       fmt.Printf("Currently unable to read from this location.\n")
       fmt.Printf("Use the \"edit-library-path\" command line argument to set a different location, if your library has moved.\n")
       fmt.Printf("Use the \"reset-library\" command line argument to start over with an empty library.\n")
       os.Exit(-1)
     }
-    fmt.Printf("Media library not found.\n")
+    fmt.Printf("Media library \"%s\" not found.\n", name)
     fmt.Printf("Enter the path you'd like to store your library at.\n")
 
     // if no path set, prompt to set one
@@ -57,7 +245,7 @@ func startupProperties() {
     defer line_reader.Close()
     line, err := line_reader.Readline()
     if err != nil { fmt.Printf("Error reading media path: \"%s\"\n", err.Error()); os.Exit(-1) }
-    err = library.MediaPathSet(line)
+    err = lib.MediaPathSet(line)
     if err != nil { fmt.Printf("Error setting media path: \"%s\"\n", err.Error()); os.Exit(-1) }
   }
 }
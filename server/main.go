@@ -8,10 +8,12 @@ import (
 )
 
 // globals & defaults
-var DBFILE     string  = "starkiss.db"
-var ADDRESS    string  = ":4331" // server binding address; can be overridden by environment variable
-var DEBUG      bool    = false   // debug mode; can be overridden by environment variable
-var JWT_KEY    []byte  = nil     // JWT key; created or read from DB in propertiesMain()
+var DBFILE       string = "starkiss.db"
+var LIBRARIES    string = ""      // additional named libraries ("name=dbfile,name=dbfile"); can be overridden by environment variable
+var ADDRESS      string = ":4331" // server binding address; can be overridden by environment variable
+var DEBUG        bool   = false   // debug mode; can be overridden by environment variable
+var SKIP_MIGRATE bool   = false   // don't migrate libraries to latest at startup; can be overridden by environment variable
+var JWT_KEY      []byte = nil     // JWT key; created or read from DB in propertiesMain()
 
 // simple debug handler for 500s
 func debug500(context echo.Context, err error) error {
@@ -32,42 +34,54 @@ func main() {
   // check for environment variables
   startupEnvironment()
 
-  // startup Library
-  err := library.LibraryStartup(DBFILE)
+  // startup Libraries
+  err := startupLibraries()
   if err != nil { fmt.Printf("Error starting library: %s\n", err.Error()) ; os.Exit(-1) }
-  defer library.LibraryShutdown()
+  defer shutdownLibraries()
 
   // check for command line arguments
   startupCommands()
 
-  // update database to latest migration (creating DB if necessary)
-  // TODO: need an option (environment variable?) to skip auto-migration
-  exit_code := startupMigration("latest")
-  if exit_code != 0 { os.Exit(exit_code) }
+  // update database to latest migration (creating DB if necessary), unless skipped
+  for _, name := range library_names {
+    exit_code := startupMigrationVerify(name, libraries[name])
+    if (exit_code == 0) && !SKIP_MIGRATE { exit_code = startupMigration(name, libraries[name], "latest", false) }
+    if exit_code != 0 { os.Exit(exit_code) }
+  }
 
   // get default properties
   startupProperties()
 
-  // ensure Library is ready
-  err = library.LibraryReady()
-  if err != nil { fmt.Printf("Error starting library; not ready: %s\n", err.Error()) ; os.Exit(-1) }
+  // ensure Libraries are ready
+  for _, name := range library_names {
+    err = libraries[name].Ready()
+    if err != nil { fmt.Printf("Error starting library \"%s\"; not ready: %s\n", name, err.Error()) ; os.Exit(-1) }
+  }
+
+  // scheduled backups
+  startupBackupSchedule()
 
   // startup server, and register routes
   server := echo.New()
   server.Static("/web-admin", "./../web-admin")
   server.Static("/web-client", "./../web-client")
-  startupMediaRoutes(server)
-  startupClientRoutes(server)
-  startupAdminRoutes(server)
+  // routes for default library, and for each library by name ("/l/:library/...")
+  for _, routes := range []*echo.Group { server.Group(""), server.Group("/l/:library", libraryMiddleware) } {
+    startupMediaRoutes(routes)
+    startupClientRoutes(routes)
+    startupAdminRoutes(routes)
+  }
 
   server.Logger.Fatal(server.Start(ADDRESS))
 }
 
 // Look for environment variables. If present, override defaults.
 func startupEnvironment() {
-  if os.Getenv("DEBUG")   == "true" { DEBUG   = true                 }
-  if os.Getenv("ADDRESS") != ""     { ADDRESS = os.Getenv("ADDRESS") }
-  if os.Getenv("DBFILE")  != ""     { DBFILE  = os.Getenv("DBFILE")  }
+  if os.Getenv("DEBUG")        == "true" { DEBUG        = true                   }
+  if os.Getenv("ADDRESS")      != ""     { ADDRESS      = os.Getenv("ADDRESS")   }
+  if os.Getenv("DBFILE")       != ""     { DBFILE       = os.Getenv("DBFILE")    }
+  if os.Getenv("LIBRARIES")    != ""     { LIBRARIES    = os.Getenv("LIBRARIES") }
+  if os.Getenv("SKIP_MIGRATE") == "true" { SKIP_MIGRATE = true                   }
 }
 
 // Look for command line arguments. If present, execute them and exit.
@@ -75,11 +89,44 @@ func startupCommands() {
   if len(os.Args) < 2 { return }
   switch os.Args[1] {
     case "migrate":
-      if len(os.Args) > 2 {
-        os.Exit(startupMigration(os.Args[2]))
-      } else {
-        os.Exit(startupMigration("latest"))
+      target, dry_run := "latest", false
+      for _, arg := range os.Args[2:] {
+        if arg == "--dry-run" { dry_run = true } else { target = arg }
+      }
+      for _, name := range library_names {
+        exit_code := startupMigration(name, libraries[name], target, dry_run)
+        if exit_code != 0 { os.Exit(exit_code) }
+      }
+      os.Exit(0)
+    case "backup":
+      exit_code := 0
+      for _, name := range library_names {
+        if startupBackup(name, libraries[name]) != 0 { exit_code = -1 }
+      }
+      os.Exit(exit_code)
+    case "restore":
+      if len(os.Args) < 3 { fmt.Printf("Usage: restore <backup file> [library]\n") ; os.Exit(-1) }
+      name := LIBRARY_DEFAULT ; if len(os.Args) > 3 { name = os.Args[3] }
+      if libraries[name] == nil { fmt.Printf("Unknown library: \"%s\"\n", name) ; os.Exit(-1) }
+      os.Exit(startupRestore(name, libraries[name], os.Args[2]))
+    case "export":
+      if len(os.Args) < 3 { fmt.Printf("Usage: export <archive file> [library]\n") ; os.Exit(-1) }
+      name := LIBRARY_DEFAULT ; if len(os.Args) > 3 { name = os.Args[3] }
+      if libraries[name] == nil { fmt.Printf("Unknown library: \"%s\"\n", name) ; os.Exit(-1) }
+      os.Exit(startupExport(name, libraries[name], os.Args[2]))
+    case "import":
+      if (len(os.Args) < 3) || (len(os.Args) == 5) { fmt.Printf("Usage: import <archive file> [library] [old source path] [new source path]\n") ; os.Exit(-1) }
+      name := LIBRARY_DEFAULT ; if len(os.Args) > 3 { name = os.Args[3] }
+      if libraries[name] == nil { fmt.Printf("Unknown library: \"%s\"\n", name) ; os.Exit(-1) }
+      options := library.ArchiveImportOptions {}
+      if len(os.Args) > 5 { options.SourcePathFrom, options.SourcePathTo = os.Args[4], os.Args[5] }
+      os.Exit(startupImport(name, libraries[name], os.Args[2], options))
+    case "check":
+      exit_code := 0
+      for _, name := range library_names {
+        if startupCheck(name, libraries[name], (len(os.Args) > 2) && (os.Args[2] == "repair")) != 0 { exit_code = -1 }
       }
+      os.Exit(exit_code)
     default:
       fmt.Printf("Unknown command: \"%s\"\n", os.Args[1])
       os.Exit(-1)
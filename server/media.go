@@ -2,6 +2,9 @@ package main
 
 import (
   "os"
+  "slices"
+  "strings"
+  "path/filepath"
   "github.com/labstack/echo/v4"
   "github.com/hashicorp/golang-lru/v2"
   "github.com/daumiller/starkiss/library"
@@ -9,16 +12,22 @@ import (
 
 var poster_cache *lru.Cache[string, string]
 
-func startupMediaRoutes(server *echo.Echo) {
-  poster_cache, _ = lru.New[string, string](1024)
+func startupMediaRoutes(server *echo.Group) {
+  if poster_cache == nil { poster_cache, _ = lru.New[string, string](1024) }
   server.GET("/media/:id", mediaServeMedia)
+  server.GET("/media/:id/bif", mediaServeBif)
+  server.GET("/media/:id/thumbs/:file", mediaServeThumbs)
   server.GET("/poster/:id/:size", mediaServePoster)
+  server.GET("/poster/:id/:size/:format", mediaServePoster)
   server.GET("/poster/reset-cache", mediaServePosterResetCache)
+  server.GET("/artwork/:id/:type/:size", mediaServeArtwork)
+  server.GET("/artwork/:id/:type/:size/:format", mediaServeArtwork)
 }
 
 func mediaServeMedia(context echo.Context) error {
+  lib := contextLibrary(context)
   id := context.Param("id")
-  md, err := library.MetadataRead(id)
+  md, err := lib.MetadataRead(id)
   if err == library.ErrNotFound { return context.NoContent(404) }
   if err != nil { return debug500(context, err) }
 
@@ -26,43 +35,135 @@ func mediaServeMedia(context echo.Context) error {
   if err != nil { return debug500(context, err) }
   if _, err := os.Stat(full_path); os.IsNotExist(err) { return context.NoContent(404) }
 
+  // not all audio extensions are known to the mime package
+  context.Response().Header().Set(echo.HeaderContentType, library.MediaContentType(filepath.Ext(full_path)))
   return context.File(full_path)
 }
 
-func resetMetadataPosterCache(id string) {
-  poster_cache.Remove(id + "/small")
-  poster_cache.Remove(id + "/large")
+func mediaServeBif(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return context.NoContent(404) }
+  if err != nil { return debug500(context, err) }
+
+  full_path, err := md.DiskPath(library.MetadataPathTypeBif)
+  if err != nil { return debug500(context, err) }
+  if _, err := os.Stat(full_path); os.IsNotExist(err) { return context.NoContent(404) }
+
+  return context.File(full_path)
+}
+
+// serves sprite sheets, and their layout as "index.json"
+func mediaServeThumbs(context echo.Context) error {
+  lib := contextLibrary(context)
+  id := context.Param("id")
+  md, err := lib.MetadataRead(id)
+  if err == library.ErrNotFound { return context.NoContent(404) }
+  if err != nil { return debug500(context, err) }
+
+  file_name := filepath.Base(context.Param("file"))
+  if (file_name == ".") || (file_name == "..") || (file_name == "/") { return context.NoContent(400) }
+  thumbs_path, err := md.DiskPath(library.MetadataPathTypeThumbs)
+  if err != nil { return debug500(context, err) }
+  full_path := filepath.Join(thumbs_path, file_name)
+  if _, err := os.Stat(full_path); os.IsNotExist(err) { return context.NoContent(404) }
+
+  return context.File(full_path)
+}
+
+// clears cached paths for all posters & artwork of a Metadata or Category
+func resetArtworkCache(library_name string, id string) {
+  for _, size := range library.ArtworkSizes {
+    for _, format := range library.ArtworkFormats {
+      poster_cache.Remove(library_name + "/" + id + "/" + string(size) + "/" + string(format))
+      for _, art_type := range library.ArtworkTypes {
+        poster_cache.Remove(library_name + "/artwork/" + id + "/" + string(art_type) + "/" + string(size) + "/" + string(format))
+      }
+    }
+  }
 }
 
+// size is one of library.ArtworkSizes; format (optional, default "jpg") is one of library.ArtworkFormats
 func mediaServePoster(context echo.Context) error {
-  size := context.Param("size")
-  if (size != "small") && (size != "large") { return context.NoContent(400) }
+  lib := contextLibrary(context)
+  size   := library.ArtworkSize(context.Param("size"))
+  format := library.ArtworkFormat(context.Param("format"))
+  if format == "" { format = library.ArtworkFormatJpeg }
+  if !slices.Contains(library.ArtworkSizes,   size  ) { return context.NoContent(400) }
+  if !slices.Contains(library.ArtworkFormats, format) { return context.NoContent(400) }
 
-  cache_path := context.Param("id") + "/" + size
+  cache_path := contextLibraryName(context) + "/" + context.Param("id") + "/" + string(size) + "/" + string(format)
   disk_path, ok := poster_cache.Get(cache_path)
   if ok { return context.File(disk_path) }
 
   id := context.Param("id")
-  md, err := library.MetadataRead(id)
+  md, err := lib.MetadataRead(id)
   if err == library.ErrNotFound { return context.NoContent(404) }
   if err != nil { return debug500(context, err) }
 
-  var full_path string = ""
-  if size == "small" { full_path, err = md.DiskPath(library.MetadataPathTypePosterSmall) }
-  if size == "large" { full_path, err = md.DiskPath(library.MetadataPathTypePosterLarge) }
-  if err != nil { return debug500(context, err) }
-
-  if _, err := os.Stat(full_path); os.IsNotExist(err) {
+  full_path, err := md.PosterFile(size, format)
+  if err == library.ErrNotFound {
     poster_aspect := "1x1"
     switch md.MediaType {
       case library.MetadataMediaTypeFileVideo : fallthrough
       case library.MetadataMediaTypeSeason    : fallthrough
       case library.MetadataMediaTypeSeries    : poster_aspect = "2x3"
     }
-    missing_location := "./static/missing." + size + "." + poster_aspect + ".png"
+    missing_size := "small" ; if strings.HasPrefix(string(size), "large") { missing_size = "large" }
+    missing_location := "./static/missing." + missing_size + "." + poster_aspect + ".png"
     poster_cache.Add(cache_path, missing_location)
     return context.File(missing_location)
   }
+  if err != nil { return debug500(context, err) }
+
+  poster_cache.Add(cache_path, full_path)
+  return context.File(full_path)
+}
+
+// type is one of library.ArtworkTypes; id may be a Metadata or Category
+func mediaServeArtwork(context echo.Context) error {
+  lib := contextLibrary(context)
+  art_type := library.ArtworkType(context.Param("type"))
+  size     := library.ArtworkSize(context.Param("size"))
+  format   := library.ArtworkFormat(context.Param("format"))
+  if format == "" { format = library.ArtworkFormatJpeg }
+  if !slices.Contains(library.ArtworkTypes,   art_type) { return context.NoContent(400) }
+  if !slices.Contains(library.ArtworkSizes,   size    ) { return context.NoContent(400) }
+  if !slices.Contains(library.ArtworkFormats, format  ) { return context.NoContent(400) }
+
+  id := context.Param("id")
+  cache_path := contextLibraryName(context) + "/artwork/" + id + "/" + string(art_type) + "/" + string(size) + "/" + string(format)
+  disk_path, ok := poster_cache.Get(cache_path)
+  if ok { return context.File(disk_path) }
+
+  full_path := ""
+  poster_aspect := "2x3"
+  md, err := lib.MetadataRead(id)
+  if err == nil {
+    full_path, err = md.ArtworkFile(art_type, size, format)
+    switch md.MediaType {
+      case library.MetadataMediaTypeFileAudio : fallthrough
+      case library.MetadataMediaTypeArtist    : fallthrough
+      case library.MetadataMediaTypeAlbum     : poster_aspect = "1x1"
+    }
+  } else if err == library.ErrNotFound {
+    cat, cat_err := lib.CategoryRead(id)
+    if cat_err == library.ErrNotFound { return context.NoContent(404) }
+    if cat_err != nil { return debug500(context, cat_err) }
+    full_path, err = cat.ArtworkFile(art_type, size, format)
+    if cat.MediaType == library.CategoryMediaTypeMusic { poster_aspect = "1x1" }
+  }
+
+  if err == library.ErrNotFound {
+    // placeholders are one image rendered at each type & size's dimensions; so backdrop.small and thumb.large (both 640x360) are identical
+    missing_size := "small" ; if strings.HasPrefix(string(size), "large") { missing_size = "large" }
+    missing_location := "./static/missing." + string(art_type) + "." + missing_size + ".png"
+    if art_type == library.ArtworkTypePoster { missing_location = "./static/missing." + missing_size + "." + poster_aspect + ".png" }
+    poster_cache.Add(cache_path, missing_location)
+    return context.File(missing_location)
+  }
+  if err != nil { return debug500(context, err) }
 
   poster_cache.Add(cache_path, full_path)
   return context.File(full_path)
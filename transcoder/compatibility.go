@@ -0,0 +1,56 @@
+package main
+
+import (
+  "slices"
+  "github.com/daumiller/starkiss/library"
+)
+
+// Rules for which source video streams can be stream-copied into our mp4 output (instead of re-encoded).
+// Level is ffprobe's level * 10 (41 == 4.1). Maximum level can be overridden with the "copy_video_max_level" property.
+type videoCopyRule struct {
+  Codec        string
+  Profiles     []string
+  MaxLevel     int64
+  BitDepth     int64
+  PixelFormats []string
+}
+
+var videoCopyRules = []videoCopyRule {
+  {
+    Codec        : "h264",
+    Profiles     : []string { "Constrained Baseline", "Baseline", "Main", "High" },
+    MaxLevel     : 41,
+    BitDepth     : 8,
+    PixelFormats : []string { "yuv420p", "yuvj420p" },
+  },
+}
+
+// Can this source stream be copied, as-is, into output of output_type?
+func streamCanCopy(inp *library.InputFile, stream *library.FileStream, output_type library.FileStreamType) bool {
+  switch stream.StreamType {
+    case library.FileStreamTypeVideo: {
+      if output_type != library.FileStreamTypeVideo { return false }
+      if stream.IsHdr() || videoNeedsFilters(inp, stream) { return false }
+      // copied video can only be cut at keyframes; trimmed output would run up to a GOP long, and fail verification
+      if (inp.TrimStart > 0) || (inp.TrimEnd > 0) { return false }
+      for _, rule := range videoCopyRules {
+        if stream.Codec != rule.Codec { continue }
+        if slices.Contains(rule.Profiles, stream.Profile) == false { continue }
+        if (stream.Level < 1) || (stream.Level > propertyInt64("copy_video_max_level", rule.MaxLevel)) { continue }
+        if stream.BitDepth != rule.BitDepth { continue }
+        if slices.Contains(rule.PixelFormats, stream.PixelFormat) == false { continue }
+        return true
+      }
+      return false
+    }
+    case library.FileStreamTypeAudio: {
+      if output_type == library.FileStreamTypeVideo { return (stream.Codec == "aac") && (stream.Channels <= 2) }
+      if output_type == library.FileStreamTypeAudio { return (stream.Codec == inp.OutputAudioFormat().Codec()) }
+      return false
+    }
+    case library.FileStreamTypeSubtitle: {
+      return (output_type == library.FileStreamTypeVideo) && (stream.Codec == "mov_text")
+    }
+  }
+  return false
+}
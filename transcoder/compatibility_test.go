@@ -0,0 +1,84 @@
+package main
+
+import (
+  "path/filepath"
+  "testing"
+  "github.com/daumiller/starkiss/library"
+)
+
+func TestStreamCanCopy(test *testing.T) {
+  // properties (copy_video_max_level, filters) and categories are read from the default library
+  err := library.LibraryStartup(filepath.Join(test.TempDir(), "test.database"))
+  if err != nil { test.Fatalf("TestStreamCanCopy: LibraryStartup failed: %s", err) }
+  defer library.LibraryShutdown()
+  err = library.MigrateToLatest()
+  if err != nil { test.Fatalf("TestStreamCanCopy: MigrateToLatest failed: %s", err) }
+  err = library.MediaPathSet(filepath.Join(test.TempDir(), "media"))
+  if err != nil { test.Fatalf("TestStreamCanCopy: MediaPathSet failed: %s", err) }
+  music, err := library.CategoryCreate("Music", library.CategoryMediaTypeMusic)
+  if err != nil { test.Fatalf("TestStreamCanCopy: CategoryCreate failed: %s", err) }
+  err = library.CategorySetAudioFormat(music, library.AudioFormatFlac)
+  if err != nil { test.Fatalf("TestStreamCanCopy: CategorySetAudioFormat failed: %s", err) }
+
+  h264 := library.FileStream { StreamType:library.FileStreamTypeVideo, Codec:"h264", Profile:"High", Level:41, BitDepth:8, PixelFormat:"yuv420p", Width:1920, Height:1080 }
+  video := func(modify func(stream *library.FileStream)) library.FileStream {
+    stream := h264
+    modify(&stream)
+    return stream
+  }
+  aac := library.FileStream { StreamType:library.FileStreamTypeAudio, Codec:"aac", Channels:2 }
+
+  plain       := library.InputFile {}
+  interlaced  := library.InputFile { VideoProcessing:library.VideoProcessing { Interlaced:true } }
+  deinterlace := library.InputFile { VideoProcessing:library.VideoProcessing { DeinterlaceMode:"yadif" } }
+  progressive := library.InputFile { VideoProcessing:library.VideoProcessing { Interlaced:true, DeinterlaceMode:"off" } }
+  cropped     := library.InputFile { VideoProcessing:library.VideoProcessing { Crop:"1920:800:0:140" } }
+  uncropped   := library.InputFile { VideoProcessing:library.VideoProcessing { Crop:"1920:800:0:140", CropMode:"off" } }
+  trimmed     := library.InputFile { TrimStart:10 }
+  into_music  := library.InputFile { CategoryId:music.Id }
+
+  cases := []struct {
+    Name     string
+    Input    *library.InputFile
+    Stream   library.FileStream
+    Output   library.FileStreamType
+    Expected bool
+  } {
+    { "h264 high 4.1 yuv420p",       &plain, h264,                                                                 library.FileStreamTypeVideo, true  },
+    { "h264 yuvj420p",               &plain, video(func(s *library.FileStream) { s.PixelFormat = "yuvj420p" }),    library.FileStreamTypeVideo, true  },
+    { "h264 constrained baseline",   &plain, video(func(s *library.FileStream) { s.Profile = "Constrained Baseline" ; s.Level = 30 }), library.FileStreamTypeVideo, true },
+    { "h264 high 10",                &plain, video(func(s *library.FileStream) { s.Profile = "High 10" ; s.BitDepth = 10 ; s.PixelFormat = "yuv420p10le" }), library.FileStreamTypeVideo, false },
+    { "h264 10-bit",                 &plain, video(func(s *library.FileStream) { s.BitDepth = 10 }),               library.FileStreamTypeVideo, false },
+    { "h264 level 5.1",              &plain, video(func(s *library.FileStream) { s.Level = 51 }),                  library.FileStreamTypeVideo, false },
+    { "h264 level unknown",          &plain, video(func(s *library.FileStream) { s.Level = 0 }),                   library.FileStreamTypeVideo, false },
+    { "h264 yuv444p",                &plain, video(func(s *library.FileStream) { s.Profile = "High 4:4:4 Predictive" ; s.PixelFormat = "yuv444p" }), library.FileStreamTypeVideo, false },
+    { "h264 yuv444p in high",        &plain, video(func(s *library.FileStream) { s.PixelFormat = "yuv444p" }),     library.FileStreamTypeVideo, false },
+    { "h264 hdr",                    &plain, video(func(s *library.FileStream) { s.HdrFormat = "hdr10" }),         library.FileStreamTypeVideo, false },
+    { "hevc",                        &plain, video(func(s *library.FileStream) { s.Codec = "hevc" ; s.Profile = "Main" }), library.FileStreamTypeVideo, false },
+    { "video into audio output",     &plain, h264,                                                                 library.FileStreamTypeAudio, false },
+    { "detected interlaced",         &interlaced,  h264,                                                           library.FileStreamTypeVideo, false },
+    { "deinterlace forced",          &deinterlace, h264,                                                           library.FileStreamTypeVideo, false },
+    { "deinterlace overridden off",  &progressive, h264,                                                           library.FileStreamTypeVideo, true  },
+    { "detected crop",               &cropped,     h264,                                                           library.FileStreamTypeVideo, false },
+    { "crop overridden off",         &uncropped,   h264,                                                           library.FileStreamTypeVideo, true  },
+    { "trimmed",                     &trimmed,     h264,                                                           library.FileStreamTypeVideo, false },
+    { "aac stereo into video",       &plain, aac,                                                                  library.FileStreamTypeVideo, true  },
+    { "aac 5.1 into video",          &plain, library.FileStream { StreamType:library.FileStreamTypeAudio, Codec:"aac", Channels:6 }, library.FileStreamTypeVideo, false },
+    { "ac3 into video",              &plain, library.FileStream { StreamType:library.FileStreamTypeAudio, Codec:"ac3", Channels:2 }, library.FileStreamTypeVideo, false },
+    { "mp3 into default category",   &plain, library.FileStream { StreamType:library.FileStreamTypeAudio, Codec:"mp3", Channels:2 }, library.FileStreamTypeAudio, true  },
+    { "aac into default category",   &plain, aac,                                                                  library.FileStreamTypeAudio, false },
+    { "flac into flac category",     &into_music, library.FileStream { StreamType:library.FileStreamTypeAudio, Codec:"flac", Channels:2 }, library.FileStreamTypeAudio, true  },
+    { "mp3 into flac category",      &into_music, library.FileStream { StreamType:library.FileStreamTypeAudio, Codec:"mp3", Channels:2 },  library.FileStreamTypeAudio, false },
+    { "mov_text into video",         &plain, library.FileStream { StreamType:library.FileStreamTypeSubtitle, Codec:"mov_text" }, library.FileStreamTypeVideo, true  },
+    { "subrip into video",           &plain, library.FileStream { StreamType:library.FileStreamTypeSubtitle, Codec:"subrip" },   library.FileStreamTypeVideo, false },
+  }
+  for _, check := range cases {
+    if streamCanCopy(check.Input, &check.Stream, check.Output) != check.Expected { test.Errorf("TestStreamCanCopy: %s: expected %v", check.Name, check.Expected) }
+  }
+
+  // maximum level can be raised by property
+  err = library.PropertySet("copy_video_max_level", "51")
+  if err != nil { test.Fatalf("TestStreamCanCopy: PropertySet failed: %s", err) }
+  level51 := video(func(s *library.FileStream) { s.Level = 51 })
+  if !streamCanCopy(&plain, &level51, library.FileStreamTypeVideo) { test.Errorf("TestStreamCanCopy: level 5.1 not copied with copy_video_max_level raised") }
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+  "fmt"
+  "github.com/daumiller/starkiss/library"
+)
+
+// Filter settings are read from library properties:
+//   output_max_height  : downscale video taller than this (default 0, no limit)
+//   tonemap_algorithm  : zscale/tonemap algorithm used when converting HDR to SDR (default "hable")
+//   deinterlace_filter : filter used for sources detected as interlaced (default "bwdif")
+// Deinterlacing and cropping are detected by the scanner, and can be overridden per InputFile.
+
+// Build video filter chain for a source video stream that is being re-encoded.
+func videoFilters(inp *library.InputFile, stream *library.FileStream) []string {
+  filters := []string {}
+
+  deinterlace := inp.VideoProcessing.DeinterlaceFilter(propertyString("deinterlace_filter", "bwdif"))
+  if deinterlace != "" { filters = append(filters, deinterlace) }
+
+  crop := inp.VideoProcessing.CropFilter()
+  stream_height := stream.Height
+  if crop != "" {
+    filters = append(filters, "crop=" + crop)
+    fmt.Sscanf(crop, "%d:%d:", new(int64), &stream_height)
+  }
+
+  max_height := propertyInt64("output_max_height", 0)
+  scale_down := (max_height > 0) && (stream_height > max_height)
+
+  if stream.IsHdr() {
+    // linearize, tonemap in float, then convert to bt709 8-bit
+    if scale_down { filters = append(filters, fmt.Sprintf("zscale=w=-2:h=%d:f=spline36", max_height)) }
+    filters = append(filters,
+      "zscale=t=linear:npl=100",
+      "format=gbrpf32le",
+      "zscale=p=bt709",
+      fmt.Sprintf("tonemap=tonemap=%s:desat=0", propertyString("tonemap_algorithm", "hable")),
+      "zscale=t=bt709:m=bt709:r=tv",
+      "format=yuv420p",
+    )
+    return filters
+  }
+
+  if scale_down { filters = append(filters, fmt.Sprintf("scale=-2:%d:flags=lanczos", max_height)) }
+  return filters
+}
+
+// Frame size of video stream, after cropping and scaling filters.
+func videoOutputSize(inp *library.InputFile, stream *library.FileStream) (width int64, height int64) {
+  width, height = stream.Width, stream.Height
+  if crop := inp.VideoProcessing.CropFilter(); crop != "" { fmt.Sscanf(crop, "%d:%d:", &width, &height) }
+
+  max_height := propertyInt64("output_max_height", 0)
+  if (max_height > 0) && (height > max_height) {
+    width  = ((width * max_height / height) / 2) * 2
+    height = max_height
+  }
+  return width, height
+}
+
+// Arguments tagging output color as SDR bt709 (only needed when tonemapping).
+func videoColorArguments(stream *library.FileStream, specifier string) []string {
+  if stream.IsHdr() == false { return []string {} }
+  return []string {
+    "-color_primaries:" + specifier, "bt709",
+    "-color_trc:"       + specifier, "bt709",
+    "-colorspace:"      + specifier, "bt709",
+  }
+}
+
+// Does this video stream need any filtering (and so can't be stream-copied)?
+func videoNeedsFilters(inp *library.InputFile, stream *library.FileStream) bool {
+  return len(videoFilters(inp, stream)) > 0
+}
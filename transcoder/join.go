@@ -0,0 +1,123 @@
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "github.com/daumiller/starkiss/library"
+)
+
+// Input options trimming a source to its in/out points.
+func getTrimArguments(inp *library.InputFile) []string {
+  arguments := []string {}
+  if inp.TrimStart > 0 { arguments = append(arguments, "-ss", strconv.FormatInt(inp.TrimStart, 10)) }
+  if inp.TrimEnd   > 0 { arguments = append(arguments, "-to", strconv.FormatInt(inp.TrimEnd,   10)) }
+  return arguments
+}
+
+// First mapped stream of stream_type, or nil.
+func getMappedStream(inp *library.InputFile, stream_type library.FileStreamType) *library.FileStream {
+  for _, stream_index := range inp.StreamMap {
+    for index := range inp.SourceStreams {
+      if inp.SourceStreams[index].Index != stream_index { continue }
+      if inp.SourceStreams[index].StreamType == stream_type { return &(inp.SourceStreams[index]) }
+    }
+  }
+  return nil
+}
+
+// Which stream types a joined output carries; every part needs matching streams, for concat.
+// Audio is dropped (rather than failing) if any part has none; verification expects the same.
+func getJoinStreams(members []library.InputFile, primary_type library.FileStreamType) (with_video bool, with_audio bool, err error) {
+  with_video = primary_type == library.FileStreamTypeVideo
+  with_audio = true
+  for index := range members {
+    if with_video && (getMappedStream(&(members[index]), library.FileStreamTypeVideo) == nil) { return false, false, fmt.Errorf("part %d has no mapped video stream", index) }
+    if getMappedStream(&(members[index]), library.FileStreamTypeAudio) == nil { with_audio = false }
+  }
+  if !with_video && !with_audio { return false, false, fmt.Errorf("parts have no common audio/video streams") }
+  return with_video, with_audio, nil
+}
+
+// Build arguments concatenating multiple parts (each trimmed & filtered) into a single output.
+// Joined outputs are always re-encoded, and carry only the first mapped video & audio stream of each part (no subtitles).
+func getJoinArguments(members []library.InputFile, primary_type library.FileStreamType) ([]string, error) {
+  arguments := []string {}
+  for index := range members {
+    arguments = append(arguments, getTrimArguments(&(members[index]))...)
+    arguments = append(arguments, "-i", members[index].SourceLocation)
+  }
+  arguments = append(arguments, "-progress", "pipe:1", "-map_metadata", "-1", "-map_chapters", "-1")
+
+  with_video, with_audio, err := getJoinStreams(members, primary_type)
+  if err != nil { return nil, err }
+
+  // all parts are scaled (and padded) to the first part's filtered size
+  width, height := int64(0), int64(0)
+  if with_video { width, height = videoOutputSize(&(members[0]), getMappedStream(&(members[0]), library.FileStreamTypeVideo)) }
+  graph := []string {}
+  concat_inputs := ""
+  audio_channels := "1"
+  for index := range members {
+    if with_video {
+      video   := getMappedStream(&(members[index]), library.FileStreamTypeVideo)
+      filters := videoFilters(&(members[index]), video)
+      filters  = append(filters,
+        fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", width, height),
+        fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", width, height),
+        "setsar=1",
+        "format=yuv420p",
+      )
+      graph = append(graph, fmt.Sprintf("[%d:%d]%s[v%d]", index, video.Index, strings.Join(filters, ","), index))
+      concat_inputs += fmt.Sprintf("[v%d]", index)
+    }
+    if with_audio {
+      audio := getMappedStream(&(members[index]), library.FileStreamTypeAudio)
+      if audio.Channels > 1 { audio_channels = "2" }
+      graph = append(graph, fmt.Sprintf("[%d:%d]aresample=async=1[a%d]", index, audio.Index, index))
+      concat_inputs += fmt.Sprintf("[a%d]", index)
+    }
+  }
+  concat_outputs := ""
+  if with_video { concat_outputs += "[vout]" }
+  if with_audio { concat_outputs += "[aout]" }
+  video_count := 0 ; if with_video { video_count = 1 }
+  audio_count := 0 ; if with_audio { audio_count = 1 }
+  graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=%d:a=%d%s", concat_inputs, len(members), video_count, audio_count, concat_outputs))
+  arguments = append(arguments, "-filter_complex", strings.Join(graph, ";"))
+
+  if with_video {
+    arguments = append(arguments,
+      "-map"      , "[vout]",
+      "-vcodec"   , "libx264",
+      "-preset"   , "slower",
+      "-crf"      , "21",
+      "-pix_fmt"  , "yuv420p",
+      "-profile:v", "high",
+      "-level"    , "4.0",
+    )
+  }
+  if with_audio {
+    arguments = append(arguments, "-map", "[aout]", "-ac", audio_channels)
+    if primary_type == library.FileStreamTypeVideo { arguments = append(arguments, "-acodec", "aac") }
+    if primary_type == library.FileStreamTypeAudio { arguments = append(arguments, audioCodecArguments(members[0].OutputAudioFormat(), "a")...) }
+  }
+  if with_video || (members[0].OutputAudioFormat().Extension() == ".m4a") { arguments = append(arguments, "-movflags", "+faststart") }
+
+  return arguments, nil
+}
+
+// A chapter for each joined part.
+func getJoinChapters(group_id string) ([]library.MetadataChapter, error) {
+  members, err := library.InputFileGroupMembers(group_id)
+  if err != nil { return nil, err }
+
+  chapters := []library.MetadataChapter {}
+  start := int64(0)
+  for index := range members {
+    end := start + (members[index].TrimmedDuration() * 1000)
+    chapters = append(chapters, library.MetadataChapter { Start:start, End:end, Title:fmt.Sprintf("Part %d", index + 1) })
+    start = end
+  }
+  return chapters, nil
+}
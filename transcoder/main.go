@@ -44,10 +44,14 @@ func main() {
   err := library.LibraryStartup(db_path)
   if err != nil { fmt.Printf("Error starting library: %s\n", err.Error()) ; os.Exit(-1) }
   defer library.LibraryShutdown()
-  if library.LibraryReady() != nil {
+  err = library.LibraryReady()
+  if err != nil {
     fmt.Printf("Library not ready: %s\n", err.Error())
     os.Exit(-1)
   }
+  // never migrates; server does, and a mismatched schema would be misread (or miswritten)
+  err = library.MigrationCheckLatest()
+  if err != nil { fmt.Printf("Cannot open library: %s\n", err.Error()) ; os.Exit(-1) }
 
   if stop {
     setStopValue()
@@ -89,109 +93,107 @@ func getTask() *library.InputFile {
 }
 
 func getArguments(inp *library.InputFile, primary_type library.FileStreamType) []string {
-  arguments := []string {
+  arguments := getTrimArguments(inp)
+  arguments = append(arguments,
     "-i", inp.SourceLocation,
     "-progress", "pipe:1",
-  }
+  )
   if primary_type == library.FileStreamTypeVideo {
     arguments = append(arguments,
-      "-map_metadata", "-1",
+      "-map_metadata:g", "-1", // drop global & stream tags; a bare "-map_metadata -1" would also drop chapter titles
+      "-map_metadata:s", "-1",
+      "-map_chapters"  , "0",  // keep chapters, with their titles
+    )
+  }
+  if primary_type == library.FileStreamTypeAudio {
+    arguments = append(arguments,
+      "-vn",  // disable video
     )
   }
 
-  has_video    := false
-  has_audio    := false ; audio_mp3 := false ; audio_mono := true
-  has_subtitle := false
-
-  for _, stream_index := range inp.StreamMap {
+  // decide, per stream, whether to copy or convert
+  codec_arguments := []string {}
+  for output_index, stream_index := range inp.StreamMap {
     var stream *(library.FileStream) = nil
     for _, s := range inp.SourceStreams { if s.Index == stream_index { stream = &s ; break } }
     if stream == nil { fmt.Printf("Error: stream index %d not found in input file %s\n", stream_index, inp.Id) ; os.Exit(-1) }
 
-    if stream.StreamType == library.FileStreamTypeVideo    { has_video    = true }
-    if stream.StreamType == library.FileStreamTypeSubtitle { has_subtitle = true }
-    if stream.StreamType == library.FileStreamTypeAudio {
-      has_audio = true
-      if stream.Codec == "mp3" { audio_mp3  = true  }
-      if stream.Channels > 1   { audio_mono = false }
-    }
     arguments = append(arguments, "-map", "0:" + strconv.Itoa(int(stream_index)))
-  }
+    specifier := strconv.Itoa(output_index)
 
-  if has_video {
-    arguments = append(arguments,
-      "-vcodec"   , "libx264",
-      "-preset"   , "slower",
-      "-crf"      , "21",
-      "-pix_fmt"  , "yuv420p",
-      "-profile:v", "high",
-      "-level"    , "4.0",
-      "-movflags" , "+faststart",
-    )
-  }
-  if has_audio {
-    if primary_type == library.FileStreamTypeVideo {
-      arguments = append(arguments, "-acodec", "aac")
-      if  audio_mono { arguments = append(arguments, "-ac", "1") }
-      if !audio_mono { arguments = append(arguments, "-ac", "2") }
+    if streamCanCopy(inp, stream, primary_type) {
+      codec_arguments = append(codec_arguments, "-codec:" + specifier, "copy")
+      continue
     }
-    if primary_type == library.FileStreamTypeAudio {
-      if audio_mp3 {
-        arguments = append(arguments,
-          "-vn",  // disable video
-          "-acodec", "copy",
-        )
-      } else {
-        arguments = append(arguments,
-          "-vn",  // disable video
-          "-acodec", "libmp3lame",
-          "-b:a"   , "320k",
+
+    channels := "2" ; if stream.Channels == 1 { channels = "1" }
+    switch stream.StreamType {
+      case library.FileStreamTypeVideo: {
+        codec_arguments = append(codec_arguments,
+          "-codec:"   + specifier, "libx264",
+          "-preset:"  + specifier, "slower",
+          "-crf:"     + specifier, "21",
+          "-pix_fmt:" + specifier, "yuv420p",
+          "-profile:" + specifier, "high",
+          "-level:"   + specifier, "4.0",
         )
-        if  audio_mono { arguments = append(arguments, "-ac", "1") }
-        if !audio_mono { arguments = append(arguments, "-ac", "2") }
+        filters := videoFilters(inp, stream)
+        if len(filters) > 0 { codec_arguments = append(codec_arguments, "-filter:" + specifier, strings.Join(filters, ",")) }
+        codec_arguments = append(codec_arguments, videoColorArguments(stream, specifier)...)
+      }
+      case library.FileStreamTypeAudio: {
+        if primary_type == library.FileStreamTypeVideo {
+          codec_arguments = append(codec_arguments, "-codec:" + specifier, "aac", "-ac:" + specifier, channels)
+        }
+        if primary_type == library.FileStreamTypeAudio {
+          codec_arguments = append(codec_arguments, audioCodecArguments(inp.OutputAudioFormat(), specifier)...)
+          codec_arguments = append(codec_arguments, "-ac:" + specifier, channels)
+        }
+      }
+      case library.FileStreamTypeSubtitle: {
+        codec_arguments = append(codec_arguments, "-codec:" + specifier, "mov_text")
       }
     }
   }
-  if has_subtitle {
+  arguments = append(arguments, codec_arguments...)
+
+  if (primary_type == library.FileStreamTypeVideo) || (inp.OutputAudioFormat().Extension() == ".m4a") {
     arguments = append(arguments,
-      "-scodec", "mov_text",
+      "-movflags", "+faststart",
     )
   }
 
   return arguments
 }
 
+// Encoder arguments for audio-only output in format, for output stream specifier ("0", "a", ...).
+func audioCodecArguments(format library.AudioFormat, specifier string) []string {
+  switch format {
+    case library.AudioFormatFlac : return []string { "-codec:" + specifier, "flac", "-compression_level:" + specifier, "8" }
+    case library.AudioFormatAlac : return []string { "-codec:" + specifier, "alac" }
+    case library.AudioFormatOpus : return []string { "-codec:" + specifier, "libopus", "-b:" + specifier, "192k" }
+    case library.AudioFormatAac  : return []string { "-codec:" + specifier, "aac", "-b:" + specifier, "256k" }
+  }
+  return []string { "-codec:" + specifier, "libmp3lame", "-b:" + specifier, "320k" }
+}
+
 func canCopyFile(inp *library.InputFile, output_type library.FileStreamType) bool {
   if len(inp.SourceStreams) != len(inp.StreamMap) { return false }
+  if (inp.TrimStart > 0) || (inp.TrimEnd > 0) || (inp.GroupId != "") { return false }
 
   // arguments already setup to do a stream copy for audio-only.
-  // the reason video is separate is because we never want to do mixed codec-transcode + codec-copy during a video transcode,
-  // because those always end up messed up, so either transcode the whole thing, or just copy the file.
+  // for video, a byte copy of a fully compatible mp4 skips ffmpeg entirely;
+  // otherwise getArguments will still stream-copy any individually compatible streams.
   if output_type == library.FileStreamTypeAudio { return false }
 
   is_mp4 := filepath.Ext(inp.SourceLocation) == ".mp4"
   if !is_mp4 { return false }
 
-  video_h264        := true
-  audio_aac_2ch     := true
-  subtitle_mov_text := true
-
-  for _, stream := range inp.SourceStreams {
-    if stream.StreamType == library.FileStreamTypeVideo {
-      if stream.Codec != "h264" { video_h264 = false ; break }
-    }
-    if stream.StreamType == library.FileStreamTypeAudio {
-      if stream.Codec != "aac" { audio_aac_2ch = false ; break }
-      if stream.Channels > 2   { audio_aac_2ch = false ; break }
-    }
-    if stream.StreamType == library.FileStreamTypeSubtitle {
-      if stream.Codec != "mov_text" { subtitle_mov_text = false ; break }
-    }
+  // every stream must be compatible (including HDR & size checks)
+  for index := range inp.SourceStreams {
+    if streamCanCopy(inp, &(inp.SourceStreams[index]), output_type) == false { return false }
   }
 
-  if !video_h264        { return false }
-  if !audio_aac_2ch     { return false }
-  if !subtitle_mov_text { return false }
   return true
 }
 
@@ -222,14 +224,23 @@ func setFailed(inp *library.InputFile, message string) {
 }
 
 func setComplete(inp *library.InputFile, output_path string, name_display string, name_sort string) {
+  // until it has a record, failed output is removed; left in media root, every retry would fail on it existing
+  fail := func(message string) { os.Remove(output_path) ; setFailed(inp, message) }
+
   // get ouput size
   output_stat, err := os.Stat(output_path)
-  if err != nil { setFailed(inp, fmt.Sprintf("Error getting output file size: %s\n", err.Error())) ; return }
+  if err != nil { fail(fmt.Sprintf("Error getting output file size: %s\n", err.Error())) ; return }
   output_size := output_stat.Size()
 
   // get streams from transcoded file
   output_streams, output_duration, err := library.FileStreamsList(output_path)
-  if err != nil { setFailed(inp, fmt.Sprintf("Error getting streams from transcoded file: %s\n", err.Error())) ; return }
+  if err != nil { fail(fmt.Sprintf("Error getting streams from transcoded file: %s\n", err.Error())) ; return }
+
+  // verify transcoded file
+  verification := verifyOutput(inp, output_path, output_streams, output_duration)
+  err = inp.StatusSetVerified(verification)
+  if err != nil { fail(fmt.Sprintf("Error updating verification results: %s\n", err.Error())) ; return }
+  if verification.Passed == false { fail(fmt.Sprintf("Verification failed: %s", verification.Failure)) ; return }
 
   // create metadata record
   file_type := library.MetadataMediaTypeFileAudio
@@ -245,12 +256,58 @@ func setComplete(inp *library.InputFile, output_path string, name_display string
   md.Streams     = output_streams
   md.Duration    = output_duration
   md.Size        = output_size
+  md.Extension   = filepath.Ext(output_path)
+  md.Chapters, err = library.FileChaptersList(output_path)
+  if err != nil { md.Chapters = []library.MetadataChapter {} }
   err = library.MetadataCreate(&md)
-  if err != nil { setFailed(inp, fmt.Sprintf("Error creating metadata record: %s\n", err.Error())) ; return }
+  if err != nil { fail(fmt.Sprintf("Error creating metadata record: %s\n", err.Error())) ; return }
+
+  // move into destination category (failure isn't fatal; left at media root, can be moved later)
+  if inp.CategoryId != "" {
+    err = md.Reparent(inp.CategoryId)
+    if err != nil { fmt.Printf("Error moving to destination category: %s\n", err.Error()) }
+  }
+
+  // joined parts get a chapter for each part
+  if inp.IsGroupLeader() && (file_type == library.MetadataMediaTypeFileVideo) {
+    chapters, err := getJoinChapters(inp.Id)
+    if err == nil { err = md.SetChapters(chapters) }
+    if err != nil { fmt.Printf("Error setting chapters for joined parts: %s\n", err.Error()) }
+  }
+
+  // apply imported sidecar metadata (failure isn't fatal; can be edited later)
+  err = md.ApplySidecar(&inp.Sidecar)
+  if err != nil { fmt.Printf("Error applying sidecar metadata: %s\n", err.Error()) }
+
+  // generate poster from cover art or video frame, if sidecar didn't provide one (failure isn't fatal; can be uploaded later)
+  if !md.HasPoster() {
+    err = md.PosterGenerate()
+    if err != nil { fmt.Printf("Error generating poster: %s\n", err.Error()) }
+  }
+
+  // tag audio with library's names, numbering & cover (failure isn't fatal; file still plays)
+  if file_type == library.MetadataMediaTypeFileAudio {
+    err = md.WriteTags()
+    if err == library.ErrTagsUnsupported {
+      fmt.Printf("Skipping tags: %s (%s)\n", err.Error(), md.Extension)
+    } else if err != nil {
+      fmt.Printf("Error writing tags: %s\n", err.Error())
+    }
+  }
+
+  // generate seek preview thumbnails (failure isn't fatal; video still plays)
+  if file_type == library.MetadataMediaTypeFileVideo {
+    err = md.SetTrickplay(propertyInt64("trickplay_interval", 10), propertyInt64("trickplay_width", 320))
+    if err != nil { fmt.Printf("Error generating trickplay thumbnails: %s\n", err.Error()) }
+  }
 
   // update InputFile record
   err = inp.StatusSetSucceeded(time.Now().Unix())
   if err != nil { setFailed(inp, fmt.Sprintf("Error updating unprocessed entry: %s\n", err.Error())) ; return }
+
+  // mark other joined parts as completed along with this one
+  err = inp.GroupSetSucceeded(time.Now().Unix())
+  if err != nil { fmt.Printf("Error updating grouped parts: %s\n", err.Error()) }
 }
 
 func runTask(inp *library.InputFile) {
@@ -269,15 +326,27 @@ func runTask(inp *library.InputFile) {
     return
   }
 
+  // get expected output length (after trimming/joining)
+  output_duration, err := inp.OutputDuration()
+  if err != nil { setFailed(inp, fmt.Sprintf("Error reading grouped parts: %s", err.Error())) ; return }
+
   // build arguments, mark task as started
-  arguments := getArguments(inp, output_primary_type)
+  arguments := []string {}
+  if inp.IsGroupLeader() {
+    members, err := library.InputFileGroupMembers(inp.Id)
+    if err != nil { setFailed(inp, fmt.Sprintf("Error reading grouped parts: %s", err.Error())) ; return }
+    arguments, err = getJoinArguments(members, output_primary_type)
+    if err != nil { setFailed(inp, fmt.Sprintf("Unable to join parts: %s", err.Error())) ; return }
+  } else {
+    arguments = getArguments(inp, output_primary_type)
+  }
   arguments = append(arguments, "-y", output_path)
   setReady(inp, arguments)
 
   // prep output display
   fmt.Printf("Processing \"%s\"...\n", inp.SourceLocation)
   progress_bar := progressbar.NewOptions64(
-    inp.SourceDuration * 1000,
+    output_duration * 1000,
     progressbar.OptionSetWidth(64),
     progressbar.OptionSetDescription("Transcoding"),
   )
@@ -316,6 +385,7 @@ func runTask(inp *library.InputFile) {
           if any_progress_lines == false {
             setFailed(inp, fmt.Sprintf("No progress from ffmpeg in %d seconds", failure_timeout_seconds))
             ffmpeg.Wait()
+            os.Remove(output_path)
             return
           }
         }
@@ -337,7 +407,7 @@ func runTask(inp *library.InputFile) {
   progress_bar.Finish()
   fmt.Printf("\n")
   err = ffmpeg.Wait()
-  if err != nil { setFailed(inp, fmt.Sprintf("Error waiting for ffmpeg to complete: %s", err.Error())) ; return }
+  if err != nil { os.Remove(output_path) ; setFailed(inp, fmt.Sprintf("Error waiting for ffmpeg to complete: %s", err.Error())) ; return }
 
   setComplete(inp, output_path, output_name_display, output_name_sort)
 }
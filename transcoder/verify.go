@@ -0,0 +1,180 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  "bytes"
+  "regexp"
+  "os/exec"
+  "strconv"
+  "strings"
+  "github.com/daumiller/starkiss/library"
+)
+
+// Verification settings are read from library properties:
+//   verify_duration_tolerance : seconds output duration may differ from source (default 2, or 1% of source if larger)
+//   verify_decode             : "true" to fully decode output, failing on any decode error
+//   verify_quality_metric     : "ssim" or "vmaf" to sample quality of video output against source ("" to skip)
+//   verify_quality_minimum    : minimum acceptable quality score (default 0.90 for ssim, 80 for vmaf)
+//   verify_quality_sample     : seconds of video to sample for quality score (default 30)
+
+var ssimScorePattern *regexp.Regexp = regexp.MustCompile(`SSIM .*All:([0-9.]+)`)
+var vmafScorePattern *regexp.Regexp = regexp.MustCompile(`VMAF score[:=]\s*([0-9.]+)`)
+
+func verifyOutput(inp *library.InputFile, output_path string, output_streams []library.FileStream, output_duration int64) *library.TranscodeVerification {
+  verification := library.TranscodeVerification {}
+  verification.TimeVerified   = time.Now().Unix()
+  verification.DurationOutput = output_duration
+
+  // compare durations (of trimmed/joined source)
+  expected_duration, err := inp.OutputDuration()
+  if err != nil { verification.Failure = fmt.Sprintf("error reading grouped parts: %s", err.Error()) ; return &verification }
+  verification.DurationSource = expected_duration
+  tolerance := propertyInt64("verify_duration_tolerance", 2)
+  if percent := expected_duration / 100; percent > tolerance { tolerance = percent }
+  difference := expected_duration - output_duration
+  if difference < 0 { difference = -difference }
+  if difference > tolerance {
+    verification.Failure = fmt.Sprintf("output duration %ds differs from source duration %ds", output_duration, expected_duration)
+    return &verification
+  }
+
+  // compare mapped streams to output streams (joined parts carry a single video & audio stream, if every part has one)
+  verification.StreamsExpected = verifyStreamCounts(inp.SourceStreams, inp.StreamMap)
+  if inp.GroupId != "" {
+    members, err := library.InputFileGroupMembers(inp.GroupId)
+    if err != nil { verification.Failure = fmt.Sprintf("error reading grouped parts: %s", err.Error()) ; return &verification }
+    with_video, with_audio, err := getJoinStreams(members, inp.OutputType())
+    if err != nil { verification.Failure = fmt.Sprintf("error joining parts: %s", err.Error()) ; return &verification }
+    verification.StreamsExpected = []int64 { 0, 0, 0 }
+    if with_video { verification.StreamsExpected[0] = 1 }
+    if with_audio { verification.StreamsExpected[1] = 1 }
+  }
+  verification.StreamsFound    = verifyStreamCounts(output_streams, nil)
+  stream_types := []string { "video", "audio", "subtitle" }
+  for index := range stream_types {
+    if verification.StreamsFound[index] < verification.StreamsExpected[index] {
+      verification.Failure = fmt.Sprintf("output has %d %s streams, expected %d", verification.StreamsFound[index], stream_types[index], verification.StreamsExpected[index])
+      return &verification
+    }
+  }
+
+  // optionally, decode entire output
+  if propertyString("verify_decode", "") == "true" {
+    verification.DecodeChecked = true
+    decode_errors, err := verifyDecode(output_path)
+    if err != nil { verification.Failure = fmt.Sprintf("error decoding output: %s", err.Error()) ; return &verification }
+    if decode_errors != "" {
+      verification.DecodeErrors = decode_errors
+      verification.Failure      = "output has decode errors"
+      return &verification
+    }
+  }
+
+  // optionally, sample quality of video output
+  metric := propertyString("verify_quality_metric", "")
+  if ((metric == "ssim") || (metric == "vmaf")) && (verification.StreamsExpected[0] > 0) && (inp.GroupId == "") {
+    minimum := 0.90 ; if metric == "vmaf" { minimum = 80.0 }
+    minimum = propertyFloat64("verify_quality_minimum", minimum)
+    score, err := verifyQuality(inp, output_path, metric)
+    if err != nil { verification.Failure = fmt.Sprintf("error sampling %s: %s", metric, err.Error()) ; return &verification }
+    verification.QualityMetric = metric
+    verification.QualityScore  = score
+    if score < minimum {
+      verification.Failure = fmt.Sprintf("%s score %.3f below minimum %.3f", metric, score, minimum)
+      return &verification
+    }
+  }
+
+  verification.Passed = true
+  return &verification
+}
+
+// Count video, audio, and subtitle streams (limited to stream_map, if not nil).
+func verifyStreamCounts(streams []library.FileStream, stream_map []int64) []int64 {
+  counts := []int64 { 0, 0, 0 }
+  for _, stream := range streams {
+    if stream_map != nil {
+      mapped := false
+      for _, stream_index := range stream_map { if stream_index == stream.Index { mapped = true ; break } }
+      if !mapped { continue }
+    }
+    switch stream.StreamType {
+      case library.FileStreamTypeVideo    : counts[0] += 1
+      case library.FileStreamTypeAudio    : counts[1] += 1
+      case library.FileStreamTypeSubtitle : counts[2] += 1
+    }
+  }
+  return counts
+}
+
+// Decode entire file, returning any errors reported by ffmpeg.
+func verifyDecode(path string) (decode_errors string, err error) {
+  var stderr bytes.Buffer
+  ffmpeg := exec.Command("ffmpeg", "-v", "error", "-i", path, "-f", "null", "-")
+  ffmpeg.Stderr = &stderr
+  err = ffmpeg.Run()
+  decode_errors = strings.TrimSpace(stderr.String())
+  if (err != nil) && (decode_errors == "") { return "", err }
+  return decode_errors, nil
+}
+
+// Compare a sample of video output against its source, returning ssim or vmaf score.
+func verifyQuality(inp *library.InputFile, output_path string, metric string) (score float64, err error) {
+  var source_video *(library.FileStream) = nil
+  for index := range inp.SourceStreams {
+    if inp.SourceStreams[index].StreamType != library.FileStreamTypeVideo { continue }
+    for _, stream_index := range inp.StreamMap { if stream_index == inp.SourceStreams[index].Index { source_video = &(inp.SourceStreams[index]) ; break } }
+    if source_video != nil { break }
+  }
+  if source_video == nil { return 0, fmt.Errorf("no mapped video stream") }
+
+  // sample from middle of file (source offset by any trimming)
+  sample_length := propertyInt64("verify_quality_sample", 30)
+  sample_start  := (inp.TrimmedDuration() - sample_length) / 2
+  if sample_start < 0 { sample_start = 0 }
+  source_start  := sample_start + inp.TrimStart
+
+  // process reference the same way as output (deinterlace, crop, tonemap), so they're comparable
+  reference := "null"
+  if streamCanCopy(inp, source_video, library.FileStreamTypeVideo) == false {
+    if filters := videoFilters(inp, source_video); len(filters) > 0 { reference = strings.Join(filters, ",") }
+  }
+  filter := fmt.Sprintf("[1:%d]%s[source];[0:v:0][source]scale2ref=flags=bicubic[main][ref];[main][ref]", source_video.Index, reference)
+  if metric == "ssim" { filter += "ssim"    }
+  if metric == "vmaf" { filter += "libvmaf" }
+
+  var stderr bytes.Buffer
+  ffmpeg := exec.Command("ffmpeg",
+    "-ss", strconv.FormatInt(sample_start, 10), "-t", strconv.FormatInt(sample_length, 10), "-i", output_path,
+    "-ss", strconv.FormatInt(source_start, 10), "-t", strconv.FormatInt(sample_length, 10), "-i", inp.SourceLocation,
+    "-lavfi", filter,
+    "-f", "null", "-",
+  )
+  ffmpeg.Stderr = &stderr
+  err = ffmpeg.Run()
+  if err != nil { return 0, err }
+
+  pattern := ssimScorePattern ; if metric == "vmaf" { pattern = vmafScorePattern }
+  match := pattern.FindStringSubmatch(stderr.String())
+  if match == nil { return 0, fmt.Errorf("score not found in ffmpeg output") }
+  return strconv.ParseFloat(match[1], 64)
+}
+
+func propertyString(key string, default_value string) string {
+  value, err := library.PropertyGet(key)
+  if (err != nil) || (value == "") { return default_value }
+  return value
+}
+
+func propertyInt64(key string, default_value int64) int64 {
+  value, err := strconv.ParseInt(propertyString(key, ""), 10, 64)
+  if err != nil { return default_value }
+  return value
+}
+
+func propertyFloat64(key string, default_value float64) float64 {
+  value, err := strconv.ParseFloat(propertyString(key, ""), 64)
+  if err != nil { return default_value }
+  return value
+}